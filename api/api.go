@@ -0,0 +1,13 @@
+// Package api embeds the machine-readable descriptions of the chat server's
+// protocols: openapi.json for the REST API and asyncapi.json for the frames
+// exchanged over /ws. The server serves both under /api, and the contract
+// tests in internal/e2e check the server's real traffic against them.
+package api
+
+import _ "embed"
+
+//go:embed openapi.json
+var OpenAPI []byte
+
+//go:embed asyncapi.json
+var AsyncAPI []byte
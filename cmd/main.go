@@ -2,48 +2,91 @@ package main
 
 import (
 	"context"
-	"log"
-	"net/http"
+	"errors"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
-	"websocket_try3/internal/delivery/http_delivery"
+	"websocket_try3/internal/app"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/logging"
+	"websocket_try3/internal/tracing"
 
 	"github.com/joho/godotenv"
-	// "github.com/redis/go-redis/v9"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+	// .env is optional; the environment may already be set by the platform
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("load .env file", "err", err)
 	}
 
-	mux := http_delivery.Handler()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fatal("migrate failed", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+	if err := setupLogging(cfg.Logging); err != nil {
+		fatal("set up logging", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("set up tracing", err)
+	}
+
+	server, err := app.Open(context.Background(), cfg)
+	if err != nil {
+		fatal("open app", err)
+	}
 
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+	if err := server.Start(context.Background()); err != nil {
+		fatal("start server", err)
 	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		log.Println("Starting server on: 8080")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe: %v", err)
-		}
-	}()
-
-	<-done
-	log.Println("Shutting down server...")
+	select {
+	case <-done:
+	case err := <-server.Err():
+		slog.Error("server failed", "err", err)
+	}
+	slog.Info("shutting down server")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Everything below shares one deadline, so the process exits within it
+	// even if clients or the database don't cooperate
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Server shutdown failed: %v", err)
+	err = server.Stop(ctx)
+	// Flush spans last, so those of the final writes are exported too
+	err = errors.Join(err, shutdownTracing(ctx))
+	if err != nil {
+		fatal("shutdown failed", err)
+	}
+	slog.Info("server exited properly")
+}
+
+// setupLogging installs the configured logger as the default, which the
+// standard log package writes through as well.
+func setupLogging(cfg config.LoggingConfig) error {
+	logger, err := logging.New(cfg, os.Stderr)
+	if err != nil {
+		return err
 	}
-	log.Println("Server exited properly")
+	slog.SetDefault(logger)
+	return nil
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
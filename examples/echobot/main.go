@@ -0,0 +1,121 @@
+// Echobot is an example bot built on pkg/chatclient. It joins the rooms in
+// BOT_ROOMS and answers two commands there and in private messages:
+//
+//	!echo <text>               repeats text
+//	!remind <duration> <text>  repeats text after duration, e.g. !remind 10m standup
+//
+// Create the bot and its API key through the REST API first, which takes the
+// server's admin token:
+//
+//	curl -X POST 'localhost:8080/api/bots?username=alice' -H "X-Admin-Token: $ADMIN_TOKEN" -d '{"username":"echobot"}'
+//
+// then run it with the key from the response:
+//
+//	BOT_API_KEY=bot_... BOT_ROOMS=1,2 go run ./examples/echobot
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"websocket_try3/pkg/chatclient"
+)
+
+// maxReminder bounds reminders, which only live as long as the process.
+const maxReminder = 24 * time.Hour
+
+func main() {
+	url := os.Getenv("CHAT_URL")
+	if url == "" {
+		url = "ws://localhost:8080/ws"
+	}
+	key := os.Getenv("BOT_API_KEY")
+	if key == "" {
+		slog.Error("BOT_API_KEY is required")
+		os.Exit(1)
+	}
+	var rooms []int
+	for _, field := range strings.Split(os.Getenv("BOT_ROOMS"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			slog.Error("invalid BOT_ROOMS", "room", field)
+			os.Exit(1)
+		}
+		rooms = append(rooms, id)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := chatclient.New(chatclient.Options{URL: url, APIKey: key})
+	client.OnConnect(func(session chatclient.Session) {
+		slog.Info("connected", "session", session.ID, "resumed", session.Resumed)
+		// Memberships outlive sessions, but joining again is harmless and
+		// picks up rooms added to BOT_ROOMS
+		if !session.Resumed {
+			for _, id := range rooms {
+				client.JoinRoom(id)
+			}
+		}
+	})
+	client.OnGroupMessage(func(m chatclient.Message) {
+		if reply, after := respond(m.Content); reply != "" {
+			later(ctx, after, func() { client.SendGroup(m.GroupID, reply) })
+		}
+	})
+	client.OnPrivateMessage(func(m chatclient.Message) {
+		if reply, after := respond(m.Content); reply != "" {
+			later(ctx, after, func() { client.SendPrivate(m.From, reply) })
+		}
+	})
+
+	if err := client.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("bot stopped", "err", err)
+		os.Exit(1)
+	}
+}
+
+// respond returns the reply to content and how long to wait before sending
+// it, or "" if content is not a command.
+func respond(content string) (string, time.Duration) {
+	command, args, _ := strings.Cut(strings.TrimSpace(content), " ")
+	switch command {
+	case "!echo":
+		// The prefix keeps the bot from answering itself
+		return "echo: " + args, 0
+	case "!remind":
+		when, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+		after, err := time.ParseDuration(when)
+		if err != nil || after <= 0 || after > maxReminder || text == "" {
+			return fmt.Sprintf("usage: !remind <duration up to %s> <text>", maxReminder), 0
+		}
+		return "reminder: " + text, after
+	default:
+		return "", 0
+	}
+}
+
+// later runs fn after d, unless ctx is done first.
+func later(ctx context.Context, d time.Duration, fn func()) {
+	if d == 0 {
+		fn()
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+			fn()
+		}
+	}()
+}
@@ -0,0 +1,382 @@
+// Package app assembles the chat server from its dependencies and owns the
+// lifecycle of everything it starts.
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"websocket_try3/internal/bot"
+	"websocket_try3/internal/command"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/delivery/http_delivery"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/intercept"
+	"websocket_try3/internal/metrics"
+	"websocket_try3/internal/repository"
+	"websocket_try3/internal/repository/memory"
+	"websocket_try3/internal/tracing"
+	"websocket_try3/internal/usecase"
+	"websocket_try3/internal/webhook"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrAlreadyStarted = errors.New("app already started")
+	ErrMissingDeps    = errors.New("users, messages and rooms repositories are required")
+)
+
+// Dependencies are the external resources an App is built from. The App
+// takes ownership of them: DB and Redis are closed by Stop.
+type Dependencies struct {
+	Users    domain.UserRepository
+	Messages domain.MessageRepository
+	Rooms    domain.RoomRepository
+	// Webhooks is optional; webhooks are kept in memory without it
+	Webhooks domain.WebhookRepository
+	// Bots is optional; there are no bots without it. It must share its store
+	// with Users, since bots are users too.
+	Bots domain.BotRepository
+
+	// DB is the pool behind the repositories, if any
+	DB io.Closer
+	// Redis is optional
+	Redis *redis.Client
+}
+
+type App struct {
+	config   *config.Config
+	deps     Dependencies
+	messages *repository.MessageWriter
+	hub      *websocket.Hub
+	webhooks *webhook.Dispatcher
+	server   *http.Server
+
+	mu       sync.Mutex
+	started  bool
+	listener net.Listener
+	errs     chan error
+}
+
+// Open connects to the storage and Redis named in cfg and builds an App on
+// top of them.
+func Open(ctx context.Context, cfg *config.Config) (*App, error) {
+	deps, err := openStorage(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	deps.Redis = config.NewRedisClient(cfg.Redis)
+
+	app, err := New(cfg, deps)
+	if err != nil {
+		closeDeps(deps)
+		return nil, err
+	}
+	return app, nil
+}
+
+// New builds an App from explicit dependencies. Nothing is started until
+// Start is called.
+func New(cfg *config.Config, deps Dependencies) (*App, error) {
+	if deps.Users == nil || deps.Messages == nil || deps.Rooms == nil {
+		return nil, ErrMissingDeps
+	}
+
+	policy, err := websocket.ParseSlowConsumerPolicy(cfg.Hub.SlowConsumerPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	system := dbSystem(cfg.Storage.Driver)
+	users := tracing.InstrumentUsers(deps.Users, system)
+	rooms := tracing.InstrumentRooms(deps.Rooms, system)
+	messageRepo := tracing.InstrumentMessages(deps.Messages, system)
+	if deps.Redis != nil {
+		deps.Redis.AddHook(tracing.RedisHook())
+	}
+
+	var m *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		m = metrics.New()
+		users = m.InstrumentUsers(users)
+		rooms = m.InstrumentRooms(rooms)
+		messageRepo = m.InstrumentMessages(messageRepo)
+		if deps.Redis != nil {
+			deps.Redis.AddHook(m.RedisHook())
+		}
+	}
+
+	messages := repository.NewMessageWriter(messageRepo, repository.MessageWriterConfig{
+		BatchSize:     cfg.Persistence.BatchSize,
+		FlushInterval: cfg.Persistence.FlushInterval,
+		QueueSize:     cfg.Persistence.QueueSize,
+		MaxRetries:    cfg.Persistence.MaxRetries,
+		RetryBackoff:  cfg.Persistence.RetryBackoff,
+		MaxBackoff:    cfg.Persistence.MaxBackoff,
+		WriteTimeout:  cfg.Persistence.WriteTimeout,
+	})
+
+	wsUsecase := usecase.NewWebSocketUsecase(users, messages, rooms, usecase.Config{
+		Timeout: cfg.Database.Timeout,
+	})
+
+	hubConfig := websocket.HubConfig{
+		SlowConsumerPolicy: policy,
+		SendBufferSize:     cfg.Hub.SendBufferSize,
+		OfflineQueueSize:   cfg.Hub.OfflineQueueSize,
+		ReconnectHint:      cfg.Hub.ReconnectHint,
+		ResumeWindow:       cfg.Hub.ResumeWindow,
+		ResumeBufferSize:   cfg.Hub.ResumeBufferSize,
+		WriteWait:          cfg.WebSocket.WriteWait,
+		PongWait:           cfg.WebSocket.PongWait,
+		MaxMessageSize:     cfg.WebSocket.MaxMessageSize,
+	}
+	if m != nil {
+		hubConfig.Metrics = m
+	}
+
+	webhookRepo := deps.Webhooks
+	if webhookRepo == nil {
+		webhookRepo = memory.NewWebhookRepository()
+	}
+	var webhooks *webhook.Dispatcher
+	if cfg.Webhooks.Enabled {
+		webhooks = webhook.New(webhookRepo, webhook.Config{
+			Workers:        cfg.Webhooks.Workers,
+			QueueSize:      cfg.Webhooks.QueueSize,
+			MaxAttempts:    cfg.Webhooks.MaxAttempts,
+			InitialBackoff: cfg.Webhooks.InitialBackoff,
+			MaxBackoff:     cfg.Webhooks.MaxBackoff,
+			Timeout:        cfg.Webhooks.Timeout,
+
+			AllowPrivateTargets: cfg.Webhooks.AllowPrivateTargets,
+		})
+		hubConfig.Events = webhooks
+	}
+	if cfg.Commands.Enabled {
+		commands := command.New(wsUsecase, command.Config{
+			Timeout: cfg.Commands.Timeout,
+			Admins:  cfg.HTTP.Admins,
+		})
+		for _, external := range cfg.Commands.External {
+			err := commands.Register(command.Command{
+				Name:        external.Name,
+				Usage:       external.Usage,
+				Description: external.Description,
+				Handler:     &command.HTTPHandler{URL: external.URL, Secret: external.Secret},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		hubConfig.Commands = commands
+	}
+	if cfg.Intercept.Audit {
+		hubConfig.Interceptors = append(hubConfig.Interceptors, intercept.Audit(nil))
+	}
+	if cfg.Intercept.TrimContent {
+		hubConfig.Interceptors = append(hubConfig.Interceptors, intercept.TrimSpace())
+	}
+	if len(cfg.Intercept.BlockedWords) > 0 {
+		hubConfig.Interceptors = append(hubConfig.Interceptors, intercept.BlockWords(cfg.Intercept.BlockedWords))
+	}
+	hub := websocket.NewHub(hubConfig, wsUsecase)
+
+	var incoming *webhook.Incoming
+	if cfg.Incoming.Enabled {
+		incoming = webhook.NewIncoming(webhookRepo, deps.Bots, hub, wsUsecase, webhook.IncomingConfig{
+			RateLimit:       cfg.Incoming.RateLimit,
+			Burst:           cfg.Incoming.Burst,
+			DefaultUsername: cfg.Incoming.DefaultUsername,
+		})
+	}
+
+	upgraderConfig := websocket.UpgraderConfig{
+		ReadBufferSize:  cfg.WebSocket.ReadBufferSize,
+		WriteBufferSize: cfg.WebSocket.WriteBufferSize,
+		AllowedOrigins:  cfg.WebSocket.AllowedOrigins,
+	}
+	var bots *bot.Service
+	if deps.Bots != nil {
+		bots = bot.New(deps.Bots, wsUsecase)
+		upgraderConfig.Authenticate = bots.Authenticate
+	}
+	wsHandler := websocket.NewWebSocketHandler(wsUsecase, upgraderConfig)
+
+	routes := http_delivery.Routes{
+		Hub:       hub,
+		WebSocket: wsHandler,
+		Usecase:   wsUsecase,
+		Health:    healthChecks(cfg, deps, hub),
+		API: http_delivery.APIOptions{
+			MaxContentSize: int(cfg.WebSocket.MaxMessageSize),
+			IdempotencyTTL: cfg.HTTP.IdempotencyTTL,
+			Admins:         cfg.HTTP.Admins,
+			AdminToken:     cfg.HTTP.AdminToken,
+		},
+		Webhooks: webhooks,
+		Incoming: incoming,
+		Bots:     bots,
+	}
+	if m != nil {
+		m.RegisterHub(hub)
+		m.RegisterMessageWriter(messages)
+		routes.Metrics = m.Handler()
+	}
+
+	return &App{
+		config:   cfg,
+		deps:     deps,
+		messages: messages,
+		hub:      hub,
+		webhooks: webhooks,
+		server: &http.Server{
+			Addr:    cfg.HTTP.Addr,
+			Handler: http_delivery.NewRouter(routes),
+		},
+		errs: make(chan error, 1),
+	}, nil
+}
+
+// Start starts the hub and begins serving HTTP. It returns once the listener
+// is bound; later serving errors are reported on Err.
+func (a *App) Start(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.started {
+		return ErrAlreadyStarted
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", a.config.HTTP.Addr)
+	if err != nil {
+		return err
+	}
+	if a.webhooks != nil {
+		if err := a.webhooks.Start(ctx); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	a.listener = listener
+	a.started = true
+
+	go a.hub.Run()
+
+	go func() {
+		if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			a.errs <- err
+		}
+	}()
+
+	slog.Info("server started", "addr", listener.Addr().String())
+	return nil
+}
+
+// Stop drains the hub, stops serving HTTP, flushes pending message writes
+// and webhook events and closes the database and Redis pools, all within
+// ctx. Every step is attempted even if an earlier one fails.
+func (a *App) Stop(ctx context.Context) error {
+	a.mu.Lock()
+	started := a.started
+	a.mu.Unlock()
+
+	var errs []error
+	if started {
+		// Drain the hub before closing the listener, so clients get
+		// server_going_away instead of a dropped connection
+		if err := a.hub.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		if err := a.server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		if a.webhooks != nil {
+			if err := a.webhooks.Close(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := a.messages.Close(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	stats := a.messages.Stats()
+	slog.Info("message writer drained", "written", stats.Written, "dropped", stats.Dropped, "failed", stats.Failed, "rejected", stats.Rejected)
+
+	if err := closeDeps(a.deps); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Err reports a failure to serve HTTP after Start.
+func (a *App) Err() <-chan error {
+	return a.errs
+}
+
+// Addr returns the address the App is listening on, or nil before Start.
+func (a *App) Addr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Addr()
+}
+
+func (a *App) Hub() *websocket.Hub {
+	return a.hub
+}
+
+// healthChecks probes the hub and whichever of the database and Redis the App
+// was given. Readiness fails while the hub drains; liveness doesn't, so a
+// draining replica isn't restarted before it is done, and only checks the
+// hub's heartbeat, so a slow database doesn't get it restarted either.
+func healthChecks(cfg *config.Config, deps Dependencies, hub *websocket.Hub) http_delivery.Health {
+	health := http_delivery.Health{
+		Live:    map[string]http_delivery.Check{"hub": hub.Ping},
+		Ready:   map[string]http_delivery.Check{"hub": hub.Ready},
+		Timeout: cfg.HTTP.HealthTimeout,
+	}
+	if db, ok := deps.DB.(interface{ PingContext(context.Context) error }); ok {
+		health.Ready["database"] = db.PingContext
+	}
+	if deps.Redis != nil {
+		health.Ready["redis"] = func(ctx context.Context) error {
+			return deps.Redis.Ping(ctx).Err()
+		}
+	}
+	return health
+}
+
+// dbSystem names the storage driver the way traces expect.
+func dbSystem(driver string) string {
+	if driver == config.StoragePostgres {
+		return "postgresql"
+	}
+	return driver
+}
+
+func closeDeps(deps Dependencies) error {
+	var errs []error
+	if deps.DB != nil {
+		if err := deps.DB.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if deps.Redis != nil {
+		if err := deps.Redis.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/repository/memory"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type closeRecorder struct {
+	closed int
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed++
+	return nil
+}
+
+func newTestDeps() (Dependencies, *closeRecorder) {
+	db := &closeRecorder{}
+	return Dependencies{
+		Users:    memory.NewUserRepository(),
+		Messages: memory.NewMessageRepository(),
+		Rooms:    memory.NewRoomRepository(),
+		DB:       db,
+		Redis:    redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"}),
+	}, db
+}
+
+func newTestConfig() *config.Config {
+	cfg := config.Default()
+	cfg.HTTP.Addr = "127.0.0.1:0"
+	cfg.Storage.Driver = config.StorageMemory
+	return cfg
+}
+
+func TestNewRequiresRepositories(t *testing.T) {
+	if _, err := New(newTestConfig(), Dependencies{}); !errors.Is(err, ErrMissingDeps) {
+		t.Fatalf("New without repositories = %v, want ErrMissingDeps", err)
+	}
+}
+
+func TestNewRejectsInvalidPolicy(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Hub.SlowConsumerPolicy = "shrug"
+	deps, _ := newTestDeps()
+
+	if _, err := New(cfg, deps); err == nil {
+		t.Fatal("New accepted an unknown slow consumer policy")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	deps, db := newTestDeps()
+	app, err := New(newTestConfig(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := app.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if app.Addr() == nil {
+		t.Fatal("Addr is nil after Start")
+	}
+	if err := app.Start(ctx); !errors.Is(err, ErrAlreadyStarted) {
+		t.Fatalf("second Start = %v, want ErrAlreadyStarted", err)
+	}
+
+	if err := app.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if app.Hub().Accepting() {
+		t.Error("hub still accepting after Stop")
+	}
+	if db.closed != 1 {
+		t.Errorf("DB closed %d times, want 1", db.closed)
+	}
+	if err := deps.Redis.Ping(ctx).Err(); !errors.Is(err, redis.ErrClosed) {
+		t.Errorf("Redis ping after Stop = %v, want redis.ErrClosed", err)
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	deps, db := newTestDeps()
+	app, err := New(newTestConfig(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := app.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if db.closed != 1 {
+		t.Errorf("DB closed %d times, want 1", db.closed)
+	}
+}
+
+func TestStartFailsOnBusyAddress(t *testing.T) {
+	deps, _ := newTestDeps()
+	first, err := New(newTestConfig(), deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := first.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer first.Stop(ctx)
+
+	cfg := newTestConfig()
+	cfg.HTTP.Addr = first.Addr().String()
+	deps, _ = newTestDeps()
+	second, err := New(cfg, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Start(ctx); err == nil {
+		second.Stop(ctx)
+		t.Fatal("Start succeeded on an address already in use")
+	}
+	second.Stop(ctx)
+}
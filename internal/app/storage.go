@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/repository"
+	"websocket_try3/internal/repository/memory"
+	"websocket_try3/internal/repository/sqlite"
+)
+
+// openStorage connects to the configured storage driver and returns its
+// repositories.
+func openStorage(ctx context.Context, cfg *config.Config) (Dependencies, error) {
+	switch cfg.Storage.Driver {
+	case config.StoragePostgres:
+		db, err := config.Connect(cfg.Database)
+		if err != nil {
+			return Dependencies{}, err
+		}
+
+		if cfg.Database.AutoMigrate {
+			migrator, err := config.NewMigrator(db)
+			if err != nil {
+				db.Close()
+				return Dependencies{}, err
+			}
+			if _, err := migrator.Up(ctx); err != nil {
+				db.Close()
+				return Dependencies{}, fmt.Errorf("migrate: %v", err)
+			}
+		}
+
+		return Dependencies{
+			Users:    repository.NewUserRepository(db),
+			Messages: repository.NewMessageRepository(db),
+			Rooms:    repository.NewRoomRepository(db),
+			Webhooks: repository.NewWebhookRepository(db),
+			Bots:     repository.NewBotRepository(db),
+			DB:       db,
+		}, nil
+
+	case config.StorageSQLite:
+		db, err := sqlite.Open(ctx, cfg.Storage.SQLitePath)
+		if err != nil {
+			return Dependencies{}, err
+		}
+		slog.Info("using sqlite storage", "path", cfg.Storage.SQLitePath)
+
+		return Dependencies{
+			Users:    sqlite.NewUserRepository(db),
+			Messages: sqlite.NewMessageRepository(db),
+			Rooms:    sqlite.NewRoomRepository(db),
+			Webhooks: sqlite.NewWebhookRepository(db),
+			Bots:     sqlite.NewBotRepository(db),
+			DB:       db,
+		}, nil
+
+	case config.StorageMemory:
+		slog.Warn("using in-memory storage, nothing will be persisted")
+
+		users := memory.NewUserRepository()
+		return Dependencies{
+			Users:    users,
+			Messages: memory.NewMessageRepository(),
+			Rooms:    memory.NewRoomRepository(),
+			Webhooks: memory.NewWebhookRepository(),
+			Bots:     memory.NewBotRepository(users),
+		}, nil
+
+	default:
+		return Dependencies{}, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
@@ -0,0 +1,219 @@
+// Package bot manages bot users and the API keys they authenticate with.
+//
+// A bot is created by a human, its owner, who gets the bot's first API key
+// back and can create and revoke more. Anyone else connects with just a
+// username, as before, but a bot's username is only accepted together with
+// one of its keys. Since a username proves nothing, the HTTP API only lets
+// bots and their keys be managed with the admin token as well; the owner
+// just scopes which bots a call sees.
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/usecase"
+)
+
+var (
+	ErrInvalidBot    = errors.New("invalid bot")
+	ErrBotNotFound   = errors.New("bot not found")
+	ErrKeyNotFound   = errors.New("api key not found")
+	ErrUsernameTaken = errors.New("username is taken")
+	// ErrNotHuman is returned when a bot tries to manage bots
+	ErrNotHuman = errors.New("bots cannot manage bots")
+	// ErrUnauthorized is returned by Authenticate for requests that name a
+	// bot without a valid key, or carry a key that is unknown or revoked
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+const (
+	// KeyPrefix starts every API key, so leaked keys are easy to spot
+	KeyPrefix = "bot_"
+	// displayPrefixLength is how much of a key is kept to tell keys apart
+	displayPrefixLength = 12
+	maxUsernameLength   = 64
+)
+
+type Service struct {
+	repo    domain.BotRepository
+	usecase *usecase.WebSocketUsecase
+}
+
+func New(repo domain.BotRepository, usecase *usecase.WebSocketUsecase) *Service {
+	return &Service{repo: repo, usecase: usecase}
+}
+
+// Create creates a bot called username owned by owner, along with its first
+// API key. The returned key is only available here; only its hash is kept.
+func (s *Service) Create(ctx context.Context, username, owner string) (*domain.Bot, *domain.APIKey, string, error) {
+	username = strings.TrimSpace(username)
+	switch {
+	case username == "":
+		return nil, nil, "", fmt.Errorf("%w: username is required", ErrInvalidBot)
+	case len(username) > maxUsernameLength:
+		return nil, nil, "", fmt.Errorf("%w: username exceeds %d bytes", ErrInvalidBot, maxUsernameLength)
+	}
+	if err := s.requireHuman(ctx, owner); err != nil {
+		return nil, nil, "", err
+	}
+
+	// SaveBot fails for a username that is taken, even by a user saved
+	// concurrently, so there is no point in looking it up first
+	bot := &domain.Bot{Username: username, Owner: owner, CreatedAt: time.Now()}
+	if err := s.repo.SaveBot(ctx, bot); err != nil {
+		if errors.Is(err, domain.ErrConstraint) {
+			return nil, nil, "", fmt.Errorf("%w: %s", ErrUsernameTaken, username)
+		}
+		return nil, nil, "", err
+	}
+	key, raw, err := s.newKey(ctx, username)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return bot, key, raw, nil
+}
+
+func (s *Service) List(ctx context.Context, owner string) ([]domain.Bot, error) {
+	return s.repo.GetOwnedBots(ctx, owner)
+}
+
+// CreateKey adds an API key to bot username, e.g. to rotate keys without
+// downtime: create a new one, switch the bot over, revoke the old one.
+func (s *Service) CreateKey(ctx context.Context, username, owner string) (*domain.APIKey, string, error) {
+	if err := s.requireOwner(ctx, username, owner); err != nil {
+		return nil, "", err
+	}
+	return s.newKey(ctx, username)
+}
+
+func (s *Service) Keys(ctx context.Context, username, owner string) ([]domain.APIKey, error) {
+	if err := s.requireOwner(ctx, username, owner); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAPIKeys(ctx, username)
+}
+
+// RevokeKey stops API key id of bot username from authenticating. Revoked
+// keys are kept, so they still show when they were created. Connections the
+// key already authenticated stay open.
+func (s *Service) RevokeKey(ctx context.Context, username, owner string, id int) error {
+	if err := s.requireOwner(ctx, username, owner); err != nil {
+		return err
+	}
+	keys, err := s.repo.GetAPIKeys(ctx, username)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.ID == id && key.RevokedAt == nil {
+			return s.repo.RevokeAPIKey(ctx, id, time.Now())
+		}
+	}
+	return ErrKeyNotFound
+}
+
+// Authenticate returns who r is made by. An API key, from an
+// "Authorization: Bearer" header or the api_key query parameter, stands for
+// its bot; a username query parameter sent along with it must name the same
+// bot. Without a key the username query parameter is taken at its word,
+// unless it names a bot. Authenticate returns "" if r names no one.
+func (s *Service) Authenticate(r *http.Request) (string, error) {
+	ctx := r.Context()
+	username := r.URL.Query().Get("username")
+
+	raw := apiKey(r)
+	if raw == "" {
+		if username == "" {
+			return "", nil
+		}
+		bot, err := s.repo.FindBot(ctx, username)
+		if err != nil {
+			return "", err
+		}
+		if bot != nil {
+			return "", fmt.Errorf("%w: %s is a bot and needs an API key", ErrUnauthorized, username)
+		}
+		return username, nil
+	}
+
+	key, err := s.repo.FindAPIKeyByHash(ctx, hashKey(raw))
+	if err != nil {
+		return "", err
+	}
+	if key == nil || key.RevokedAt != nil {
+		return "", fmt.Errorf("%w: invalid API key", ErrUnauthorized)
+	}
+	if username != "" && username != key.Username {
+		return "", fmt.Errorf("%w: API key does not belong to %s", ErrUnauthorized, username)
+	}
+	return key.Username, nil
+}
+
+func apiKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// requireOwner returns ErrBotNotFound unless username is a bot owned by
+// owner, so bots of other users can't be told apart from missing ones.
+func (s *Service) requireOwner(ctx context.Context, username, owner string) error {
+	bot, err := s.repo.FindBot(ctx, username)
+	if err != nil {
+		return err
+	}
+	if bot == nil || bot.Owner != owner {
+		return fmt.Errorf("%w: %s", ErrBotNotFound, username)
+	}
+	return nil
+}
+
+func (s *Service) requireHuman(ctx context.Context, username string) error {
+	user, err := s.usecase.GetUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return usecase.ErrUserNotFound
+	}
+	if user.Bot {
+		return ErrNotHuman
+	}
+	return nil
+}
+
+func (s *Service) newKey(ctx context.Context, username string) (*domain.APIKey, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	token := KeyPrefix + hex.EncodeToString(raw)
+
+	key := &domain.APIKey{
+		Username:  username,
+		Prefix:    token[:displayPrefixLength],
+		KeyHash:   hashKey(token),
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.SaveAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return key, token, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
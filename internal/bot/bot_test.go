@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/repository/memory"
+	"websocket_try3/internal/usecase"
+)
+
+func newService() (*Service, *memory.UserRepository) {
+	users := memory.NewUserRepository()
+	wsUsecase := usecase.NewWebSocketUsecase(users, memory.NewMessageRepository(), memory.NewRoomRepository(), usecase.Config{})
+	return New(memory.NewBotRepository(users), wsUsecase), users
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	s, users := newService()
+
+	if err := users.Save(ctx, &domain.User{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, key, err := s.Create(ctx, "standup", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, revoked, err := s.CreateKey(ctx, "standup", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := s.Keys(ctx, "standup", "alice")
+	if err := s.RevokeKey(ctx, "standup", "alice", keys[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, target, authorization string
+		want                        string
+		wantErr                     error
+	}{
+		{"nobody", "/ws", "", "", nil},
+		{"human", "/ws?username=alice", "", "alice", nil},
+		{"bot without key", "/ws?username=standup", "", "", ErrUnauthorized},
+		{"bearer key", "/ws", "Bearer " + key, "standup", nil},
+		{"query key", "/ws?api_key=" + key, "", "standup", nil},
+		{"key with its bot", "/ws?username=standup", "bearer " + key, "standup", nil},
+		{"key with another user", "/ws?username=alice", "Bearer " + key, "", ErrUnauthorized},
+		{"revoked key", "/ws", "Bearer " + revoked, "", ErrUnauthorized},
+		{"unknown key", "/ws?api_key=bot_unknown", "", "", ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			got, err := s.Authenticate(r)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Authenticate = %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateRefusesTakenUsernames(t *testing.T) {
+	ctx := context.Background()
+	s, users := newService()
+	users.Save(ctx, &domain.User{Username: "alice"})
+
+	if _, _, _, err := s.Create(ctx, "alice", "alice"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Create(alice) = %v, want ErrUsernameTaken", err)
+	}
+	if _, _, _, err := s.Create(ctx, " ", "alice"); !errors.Is(err, ErrInvalidBot) {
+		t.Errorf("Create(blank) = %v, want ErrInvalidBot", err)
+	}
+	if _, _, _, err := s.Create(ctx, "standup", "nobody"); !errors.Is(err, usecase.ErrUserNotFound) {
+		t.Errorf("Create for unknown owner = %v, want ErrUserNotFound", err)
+	}
+	if _, _, _, err := s.Create(ctx, "standup", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := s.Create(ctx, "minion", "standup"); !errors.Is(err, ErrNotHuman) {
+		t.Errorf("Create owned by a bot = %v, want ErrNotHuman", err)
+	}
+}
+
+func TestCreateConcurrently(t *testing.T) {
+	ctx := context.Background()
+	s, users := newService()
+	if err := users.Save(ctx, &domain.User{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := s.Create(ctx, "standup", "alice")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var created int
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrUsernameTaken):
+			t.Errorf("Create = %v, want ErrUsernameTaken for all but one", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("created %d bots, want 1", created)
+	}
+}
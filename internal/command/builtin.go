@@ -0,0 +1,230 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMute is how long /mute lasts without a duration
+	DefaultMute = 10 * time.Minute
+	MaxMute     = 24 * time.Hour
+	// maxTopicLength bounds /topic
+	maxTopicLength = 256
+)
+
+func (r *Registry) builtins() []Command {
+	return []Command{
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "list the commands",
+			Handler:     HandlerFunc(r.help),
+		},
+		{
+			Name:        "me",
+			Usage:       "/me <action>",
+			Description: "say what you're doing, e.g. /me waves",
+			Handler:     HandlerFunc(me),
+		},
+		{
+			Name:        "topic",
+			Usage:       "/topic [text]",
+			Description: "show or set the room's topic; /topic - clears it",
+			RoomOnly:    true,
+			Handler:     HandlerFunc(r.topic),
+		},
+		{
+			Name:        "invite",
+			Usage:       "/invite <user>",
+			Description: "add someone to the room",
+			RoomOnly:    true,
+			Handler:     HandlerFunc(r.invite),
+		},
+		{
+			Name:        "kick",
+			Usage:       "/kick <user>",
+			Description: "remove someone from the room",
+			RoomOnly:    true,
+			Handler:     HandlerFunc(r.kick),
+		},
+		{
+			Name:        "mute",
+			Usage:       "/mute <user> [duration]",
+			Description: fmt.Sprintf("stop someone from posting to the room, for %s unless told otherwise", DefaultMute),
+			RoomOnly:    true,
+			Handler:     HandlerFunc(r.mute),
+		},
+		{
+			Name:        "unmute",
+			Usage:       "/unmute <user>",
+			Description: "let someone post to the room again",
+			RoomOnly:    true,
+			Handler:     HandlerFunc(r.unmute),
+		},
+	}
+}
+
+func (r *Registry) help(ctx context.Context, call Call) (Response, error) {
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, cmd := range r.Commands() {
+		b.WriteString("\n" + cmd.Usage)
+		if cmd.Description != "" {
+			b.WriteString(" - " + cmd.Description)
+		}
+	}
+	return Response{Text: b.String()}, nil
+}
+
+func me(ctx context.Context, call Call) (Response, error) {
+	if call.Args == "" {
+		return Response{}, fmt.Errorf("%w: /me <action>", ErrUsage)
+	}
+	return Response{Text: "* " + call.From + " " + call.Args, Broadcast: true}, nil
+}
+
+func (r *Registry) topic(ctx context.Context, call Call) (Response, error) {
+	if call.Args == "" {
+		room, err := r.usecase.GetRoom(ctx, call.RoomID)
+		if err != nil {
+			return Response{}, err
+		}
+		if room.Topic == "" {
+			return Response{Text: "No topic is set"}, nil
+		}
+		return Response{Text: "Topic: " + room.Topic}, nil
+	}
+
+	topic := call.Args
+	if topic == "-" {
+		topic = ""
+	}
+	if len(topic) > maxTopicLength {
+		return Response{}, fmt.Errorf("%w: the topic exceeds %d bytes", ErrUsage, maxTopicLength)
+	}
+	if _, err := call.Hub.SetTopic(ctx, call.RoomID, topic); err != nil {
+		return Response{}, err
+	}
+
+	if topic == "" {
+		call.Hub.NotifyRoom(ctx, call.RoomID, call.From+" cleared the topic")
+	} else {
+		call.Hub.NotifyRoom(ctx, call.RoomID, call.From+" set the topic: "+topic)
+	}
+	return Response{}, nil
+}
+
+func (r *Registry) invite(ctx context.Context, call Call) (Response, error) {
+	username, ok := oneArg(call.Args)
+	if !ok {
+		return Response{}, fmt.Errorf("%w: /invite <user>", ErrUsage)
+	}
+	member, err := r.member(ctx, call.RoomID, username)
+	if err != nil {
+		return Response{}, err
+	}
+	if member {
+		return Response{Text: username + " is already in this room"}, nil
+	}
+
+	if err := r.usecase.AddRoomMember(ctx, call.RoomID, username); err != nil {
+		return Response{}, err
+	}
+	call.Hub.JoinRoom(ctx, username, call.RoomID)
+	call.Hub.NotifyRoom(ctx, call.RoomID, call.From+" invited "+username)
+	return Response{}, nil
+}
+
+func (r *Registry) kick(ctx context.Context, call Call) (Response, error) {
+	username, ok := oneArg(call.Args)
+	if !ok {
+		return Response{}, fmt.Errorf("%w: /kick <user>", ErrUsage)
+	}
+	room, err := r.moderator(ctx, call)
+	if err != nil {
+		return Response{}, err
+	}
+	if username == room.CreatedBy {
+		return Response{}, fmt.Errorf("%w: %s created this room", ErrForbidden, username)
+	}
+	if err := r.requireMember(ctx, call.RoomID, username); err != nil {
+		return Response{}, err
+	}
+
+	if err := call.Hub.RemoveMember(ctx, call.RoomID, username); err != nil {
+		return Response{}, err
+	}
+	call.Hub.Unmute(call.RoomID, username)
+	call.Hub.NotifyRoom(ctx, call.RoomID, username+" was removed by "+call.From)
+	return Response{}, nil
+}
+
+func (r *Registry) mute(ctx context.Context, call Call) (Response, error) {
+	usage := fmt.Errorf("%w: /mute <user> [duration up to %s]", ErrUsage, MaxMute)
+	fields := strings.Fields(call.Args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Response{}, usage
+	}
+	username, duration := fields[0], DefaultMute
+	if len(fields) == 2 {
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 || d > MaxMute {
+			return Response{}, usage
+		}
+		duration = d
+	}
+
+	room, err := r.moderator(ctx, call)
+	if err != nil {
+		return Response{}, err
+	}
+	if username == room.CreatedBy {
+		return Response{}, fmt.Errorf("%w: %s created this room", ErrForbidden, username)
+	}
+	if err := r.requireMember(ctx, call.RoomID, username); err != nil {
+		return Response{}, err
+	}
+
+	call.Hub.Mute(call.RoomID, username, time.Now().Add(duration))
+	call.Hub.NotifyRoom(ctx, call.RoomID, username+" was muted for "+duration.String()+" by "+call.From)
+	return Response{}, nil
+}
+
+func (r *Registry) unmute(ctx context.Context, call Call) (Response, error) {
+	username, ok := oneArg(call.Args)
+	if !ok {
+		return Response{}, fmt.Errorf("%w: /unmute <user>", ErrUsage)
+	}
+	if _, err := r.moderator(ctx, call); err != nil {
+		return Response{}, err
+	}
+
+	if !call.Hub.Unmute(call.RoomID, username) {
+		return Response{Text: username + " is not muted"}, nil
+	}
+	call.Hub.NotifyRoom(ctx, call.RoomID, username+" was unmuted by "+call.From)
+	return Response{}, nil
+}
+
+func (r *Registry) requireMember(ctx context.Context, roomID int, username string) error {
+	member, err := r.member(ctx, roomID, username)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return fmt.Errorf("%s is %w", username, ErrNotMember)
+	}
+	return nil
+}
+
+// oneArg returns args if it is a single word.
+func oneArg(args string) (string, bool) {
+	fields := strings.Fields(args)
+	if len(fields) != 1 {
+		return "", false
+	}
+	return fields[0], true
+}
@@ -0,0 +1,324 @@
+// Package command runs slash commands typed into the chat.
+//
+// A group_chat or private_chat frame whose content starts with "/" followed
+// by a letter is a command: "/kick bob" runs the kick command with the
+// arguments "bob" instead of being delivered. Content such as "/" or "/5"
+// is still delivered as a message. The Registry implements
+// websocket.Commands; it comes with the built-in commands and takes more
+// through Register, including commands forwarded to HTTP endpoints by
+// HTTPHandler.
+//
+// Responses are ephemeral: they reach the caller as a status frame and
+// nobody else sees them, unless the command asks for them to be posted.
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/tracing"
+	"websocket_try3/internal/usecase"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("websocket_try3/internal/command")
+
+var (
+	ErrInvalidCommand   = errors.New("invalid command")
+	ErrDuplicateCommand = errors.New("command already registered")
+	// ErrUsage and ErrForbidden are returned by handlers for invalid
+	// arguments and for callers that may not run them. Like the usecase's
+	// not found errors, their message is shown to the caller; other errors
+	// are logged and reported without details.
+	ErrUsage     = errors.New("usage")
+	ErrForbidden = errors.New("not allowed")
+
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrNotMember      = errors.New("not a member of this room")
+	errRoomOnly       = errors.New("only works in a room")
+)
+
+const maxNameLength = 32
+
+type Config struct {
+	// Timeout bounds every command, including calls to HTTP handlers
+	Timeout time.Duration
+	// Admins may kick and mute in every room, like the room's creator
+	Admins []string
+	// Logger defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout: 5 * time.Second,
+	}
+}
+
+// Call is one invocation of a command. RoomID is set for commands sent to a
+// room and To for those sent in a private chat.
+type Call struct {
+	Name   string `json:"command"`
+	Args   string `json:"args"`
+	From   string `json:"from"`
+	RoomID int    `json:"room_id,omitempty"`
+	To     string `json:"to,omitempty"`
+
+	// Hub is the hub the command was sent to
+	Hub *websocket.Hub `json:"-"`
+}
+
+// Response is a command's answer.
+type Response struct {
+	// Text is shown to the caller only, unless Broadcast is set
+	Text string `json:"text"`
+	// Broadcast posts Text as a message from the caller to the room or
+	// private chat the command was sent to
+	Broadcast bool `json:"broadcast"`
+}
+
+type Handler interface {
+	Run(ctx context.Context, call Call) (Response, error)
+}
+
+type HandlerFunc func(ctx context.Context, call Call) (Response, error)
+
+func (f HandlerFunc) Run(ctx context.Context, call Call) (Response, error) {
+	return f(ctx, call)
+}
+
+type Command struct {
+	// Name is what follows the slash, in lower case
+	Name string
+	// Usage and Description are listed by /help, e.g. "/kick <user>" and
+	// "remove someone from the room"
+	Usage       string
+	Description string
+	// RoomOnly commands can't be used in private chats
+	RoomOnly bool
+	Handler  Handler
+}
+
+// Registry holds the commands the server knows. Commands sent to a room are
+// only run for its members.
+type Registry struct {
+	usecase *usecase.WebSocketUsecase
+	config  Config
+	log     *slog.Logger
+	admins  map[string]bool
+
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// New returns a registry with the built-in commands.
+func New(usecase *usecase.WebSocketUsecase, config Config) *Registry {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig().Timeout
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	r := &Registry{
+		usecase:  usecase,
+		config:   config,
+		log:      config.Logger,
+		admins:   make(map[string]bool),
+		commands: make(map[string]Command),
+	}
+	for _, admin := range config.Admins {
+		r.admins[admin] = true
+	}
+	for _, cmd := range r.builtins() {
+		if err := r.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Register adds cmd. Names are unique, so built-in commands can't be
+// replaced.
+func (r *Registry) Register(cmd Command) error {
+	switch {
+	case !validName(cmd.Name):
+		return fmt.Errorf("%w: name %q must be lower case letters, digits, - and _, starting with a letter", ErrInvalidCommand, cmd.Name)
+	case cmd.Handler == nil:
+		return fmt.Errorf("%w: /%s has no handler", ErrInvalidCommand, cmd.Name)
+	}
+	if cmd.Usage == "" {
+		cmd.Usage = "/" + cmd.Name
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.commands[cmd.Name]; ok {
+		return fmt.Errorf("%w: /%s", ErrDuplicateCommand, cmd.Name)
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+// Commands returns the registered commands ordered by name.
+func (r *Registry) Commands() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	commands := make([]Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	slices.SortFunc(commands, func(a, b Command) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return commands
+}
+
+func (r *Registry) lookup(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// Command implements websocket.Commands.
+func (r *Registry) Command(ctx context.Context, hub *websocket.Hub, wc websocket.CommandCall) bool {
+	name, args, _ := strings.Cut(strings.TrimPrefix(wc.Content, "/"), " ")
+	if name == "" || !isLetter(name[0]) {
+		return false
+	}
+	call := Call{
+		Name:   strings.ToLower(name),
+		Args:   strings.TrimSpace(args),
+		From:   wc.From,
+		RoomID: wc.RoomID,
+		To:     wc.To,
+		Hub:    hub,
+	}
+
+	ctx, span := tracer.Start(ctx, "command "+call.Name, trace.WithAttributes(
+		attribute.String("chat.command", call.Name),
+	))
+	var err error
+	defer tracing.End(span, &err)
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	var resp Response
+	resp, err = r.run(ctx, call)
+	if err == nil {
+		err = r.respond(ctx, call, resp)
+	}
+	if err != nil {
+		hub.Notify(ctx, call.From, r.describe(ctx, call, err))
+	}
+	return true
+}
+
+func (r *Registry) run(ctx context.Context, call Call) (Response, error) {
+	cmd, ok := r.lookup(call.Name)
+	if !ok {
+		return Response{}, fmt.Errorf("%w /%s, try /help", ErrUnknownCommand, call.Name)
+	}
+	if call.RoomID == 0 && cmd.RoomOnly {
+		return Response{}, fmt.Errorf("/%s %w", call.Name, errRoomOnly)
+	}
+	if call.RoomID != 0 {
+		member, err := r.member(ctx, call.RoomID, call.From)
+		if err != nil {
+			return Response{}, err
+		}
+		if !member {
+			return Response{}, usecase.ErrRoomNotFound
+		}
+	}
+	return cmd.Handler.Run(ctx, call)
+}
+
+func (r *Registry) respond(ctx context.Context, call Call, resp Response) error {
+	switch {
+	case resp.Text == "":
+		return nil
+	case !resp.Broadcast:
+		call.Hub.Notify(ctx, call.From, resp.Text)
+		return nil
+	case call.RoomID != 0:
+		_, err := call.Hub.PostGroupMessage(ctx, call.From, call.RoomID, resp.Text)
+		return err
+	default:
+		_, err := call.Hub.PostPrivateMessage(ctx, call.From, call.To, resp.Text)
+		return err
+	}
+}
+
+// describe turns err into what the caller is told.
+func (r *Registry) describe(ctx context.Context, call Call, err error) string {
+	switch {
+	case errors.Is(err, ErrUsage), errors.Is(err, ErrForbidden),
+		errors.Is(err, ErrUnknownCommand), errors.Is(err, ErrNotMember), errors.Is(err, errRoomOnly),
+		errors.Is(err, usecase.ErrUserNotFound), errors.Is(err, usecase.ErrRoomNotFound),
+		errors.Is(err, websocket.ErrMuted):
+		return err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return "/" + call.Name + " timed out"
+	default:
+		r.log.ErrorContext(ctx, "command failed", "command", call.Name, "username", call.From, "err", err)
+		return "/" + call.Name + " failed"
+	}
+}
+
+func (r *Registry) member(ctx context.Context, roomID int, username string) (bool, error) {
+	members, err := r.usecase.GetRoomMembers(ctx, roomID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(members, func(m domain.RoomMember) bool {
+		return m.Username == username
+	}), nil
+}
+
+// moderator returns the room call was sent to if the caller may moderate it.
+func (r *Registry) moderator(ctx context.Context, call Call) (*domain.Room, error) {
+	room, err := r.usecase.GetRoom(ctx, call.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, usecase.ErrRoomNotFound
+	}
+	if room.CreatedBy != call.From && !r.admins[call.From] {
+		return nil, fmt.Errorf("%w: only the room's creator can /%s", ErrForbidden, call.Name)
+	}
+	return room, nil
+}
+
+func validName(name string) bool {
+	if name == "" || len(name) > maxNameLength || !isLetter(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !isLetter(c) && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return strings.ToLower(name) == name
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
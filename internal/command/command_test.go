@@ -0,0 +1,110 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"websocket_try3/internal/repository/memory"
+	"websocket_try3/internal/usecase"
+	"websocket_try3/internal/webhook"
+)
+
+func newRegistry() *Registry {
+	wsUsecase := usecase.NewWebSocketUsecase(memory.NewUserRepository(), memory.NewMessageRepository(), memory.NewRoomRepository(), usecase.Config{})
+	return New(wsUsecase, Config{})
+}
+
+func TestRegister(t *testing.T) {
+	r := newRegistry()
+	nop := HandlerFunc(func(context.Context, Call) (Response, error) { return Response{}, nil })
+
+	tests := []struct {
+		name    string
+		cmd     Command
+		wantErr error
+	}{
+		{"custom", Command{Name: "deploy", Handler: nop}, nil},
+		{"digits and dashes", Command{Name: "on-call2", Handler: nop}, nil},
+		{"builtin", Command{Name: "kick", Handler: nop}, ErrDuplicateCommand},
+		{"again", Command{Name: "deploy", Handler: nop}, ErrDuplicateCommand},
+		{"empty", Command{Handler: nop}, ErrInvalidCommand},
+		{"upper case", Command{Name: "Deploy", Handler: nop}, ErrInvalidCommand},
+		{"leading digit", Command{Name: "2fa", Handler: nop}, ErrInvalidCommand},
+		{"space", Command{Name: "de ploy", Handler: nop}, ErrInvalidCommand},
+		{"no handler", Command{Name: "noop"}, ErrInvalidCommand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.Register(tt.cmd); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Register(%q) = %v, want %v", tt.cmd.Name, err, tt.wantErr)
+			}
+		})
+	}
+
+	var names []string
+	for _, cmd := range r.Commands() {
+		names = append(names, cmd.Name)
+	}
+	want := []string{"deploy", "help", "invite", "kick", "me", "mute", "on-call2", "topic", "unmute"}
+	if len(names) != len(want) {
+		t.Fatalf("Commands() = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Commands() = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	var got struct {
+		call   Call
+		header http.Header
+		body   []byte
+	}
+	status, reply := http.StatusOK, `{"text":"deploying","broadcast":true}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.header = r.Header
+		got.body, _ = io.ReadAll(r.Body)
+		json.Unmarshal(got.body, &got.call)
+		w.WriteHeader(status)
+		io.WriteString(w, reply)
+	}))
+	defer srv.Close()
+
+	h := &HTTPHandler{URL: srv.URL, Secret: "s3cret"}
+	call := Call{Name: "deploy", Args: "api v2", From: "alice", RoomID: 3}
+	resp, err := h.Run(context.Background(), call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != (Response{Text: "deploying", Broadcast: true}) {
+		t.Errorf("Run = %+v, want the handler's response", resp)
+	}
+	if got.call != call {
+		t.Errorf("handler got %+v, want %+v", got.call, call)
+	}
+	err = webhook.Verify("s3cret", got.header.Get(webhook.HeaderTimestamp), got.header.Get(webhook.HeaderSignature), got.body, time.Minute)
+	if err != nil {
+		t.Errorf("signature: %v", err)
+	}
+
+	status, reply = http.StatusNoContent, ""
+	if resp, err := h.Run(context.Background(), call); err != nil || resp != (Response{}) {
+		t.Errorf("Run with 204 = %+v, %v; want an empty response", resp, err)
+	}
+
+	status, reply = http.StatusBadGateway, "oops"
+	if _, err := h.Run(context.Background(), call); !errors.Is(err, ErrHandlerFailed) {
+		t.Errorf("Run with 502 = %v, want ErrHandlerFailed", err)
+	}
+	status, reply = http.StatusOK, "not json"
+	if _, err := h.Run(context.Background(), call); !errors.Is(err, ErrHandlerFailed) {
+		t.Errorf("Run with an invalid body = %v, want ErrHandlerFailed", err)
+	}
+}
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"websocket_try3/internal/webhook"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ErrHandlerFailed is returned when an HTTP handler doesn't answer with a
+// 2xx status and a valid response.
+var ErrHandlerFailed = errors.New("command handler failed")
+
+// maxResponseSize bounds the responses read from HTTP handlers.
+const maxResponseSize = 64 << 10
+
+// HTTPHandler forwards calls to an HTTP endpoint. The Call is POSTed as JSON
+// and the endpoint answers with a JSON Response, or with 204 No Content to
+// stay silent. With a Secret, requests are signed like outgoing webhook
+// deliveries: see webhook.Sign for the X-Webhook-Timestamp and
+// X-Webhook-Signature headers.
+type HTTPHandler struct {
+	URL    string
+	Secret string
+	// Client defaults to http.DefaultClient; the registry's Timeout applies
+	// either way
+	Client *http.Client
+}
+
+func (h *HTTPHandler) Run(ctx context.Context, call Call) (Response, error) {
+	body, err := json.Marshal(call)
+	if err != nil {
+		return Response{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhook.HeaderTimestamp, timestamp)
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(h.Secret, timestamp, body))
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+		return Response{}, fmt.Errorf("%w: status %d", ErrHandlerFailed, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return Response{}, nil
+	}
+	var out Response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&out); err != nil {
+		return Response{}, fmt.Errorf("%w: %v", ErrHandlerFailed, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,616 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration. Values are resolved from, in
+// increasing order of precedence: defaults, the optional YAML file named by
+// -config or CONFIG_FILE, environment variables and command line flags.
+type Config struct {
+	HTTP        HTTPConfig        `yaml:"http"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Database    PostgresConfig    `yaml:"database"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Hub         HubConfig         `yaml:"hub"`
+	WebSocket   WebSocketConfig   `yaml:"websocket"`
+	Persistence PersistenceConfig `yaml:"persistence"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Webhooks    WebhooksConfig    `yaml:"webhooks"`
+	Incoming    IncomingConfig    `yaml:"incoming_webhooks"`
+	Commands    CommandsConfig    `yaml:"commands"`
+	Intercept   InterceptConfig   `yaml:"interceptors"`
+}
+
+type HTTPConfig struct {
+	Addr            string        `yaml:"addr"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// HealthTimeout bounds the checks behind /livez and /readyz
+	HealthTimeout time.Duration `yaml:"health_timeout"`
+	// IdempotencyTTL is how long responses to requests carrying an
+	// Idempotency-Key are kept for replay
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+	// Admins may moderate every room and manage its incoming webhooks
+	Admins []string `yaml:"admins"`
+	// AdminToken is the credential the /api/admin and /api/bots endpoints
+	// require. They are disabled without one.
+	AdminToken string `yaml:"admin_token"`
+}
+
+// minAdminTokenLength keeps admin tokens from being guessable
+const minAdminTokenLength = 32
+
+// Storage drivers. Only StoragePostgres keeps data across restarts of more
+// than one replica; the others let the server run without Postgres.
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+)
+
+type StorageConfig struct {
+	Driver string `yaml:"driver"`
+	// SQLitePath is the database file used by StorageSQLite
+	SQLitePath string `yaml:"sqlite_path"`
+}
+
+type RedisConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+type HubConfig struct {
+	SlowConsumerPolicy string        `yaml:"slow_consumer_policy"`
+	SendBufferSize     int           `yaml:"send_buffer_size"`
+	OfflineQueueSize   int           `yaml:"offline_queue_size"`
+	ReconnectHint      time.Duration `yaml:"reconnect_hint"`
+	ResumeWindow       time.Duration `yaml:"resume_window"`
+	ResumeBufferSize   int           `yaml:"resume_buffer_size"`
+}
+
+type WebSocketConfig struct {
+	ReadBufferSize  int           `yaml:"read_buffer_size"`
+	WriteBufferSize int           `yaml:"write_buffer_size"`
+	MaxMessageSize  int64         `yaml:"max_message_size"`
+	WriteWait       time.Duration `yaml:"write_wait"`
+	PongWait        time.Duration `yaml:"pong_wait"`
+	// AllowedOrigins restricts the Origin header on upgrades; empty allows all
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+type PersistenceConfig struct {
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	QueueSize     int           `yaml:"queue_size"`
+	MaxRetries    int           `yaml:"max_retries"`
+	RetryBackoff  time.Duration `yaml:"retry_backoff"`
+	MaxBackoff    time.Duration `yaml:"max_backoff"`
+	WriteTimeout  time.Duration `yaml:"write_timeout"`
+}
+
+type MetricsConfig struct {
+	// Enabled serves Prometheus metrics on /metrics
+	Enabled bool `yaml:"enabled"`
+}
+
+// Trace exporters. TracingNone keeps the no-op tracer provider, so spans cost
+// next to nothing.
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingOTLP   = "otlp"
+)
+
+type TracingConfig struct {
+	Exporter    string `yaml:"exporter"`
+	ServiceName string `yaml:"service_name"`
+	// Endpoint is the OTLP/HTTP collector URL; when empty the standard
+	// OTEL_EXPORTER_OTLP_* variables apply
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio is the fraction of new traces that are recorded
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+type LoggingConfig struct {
+	// Level is debug, info, warn or error
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	// RedactContent hides chat message bodies in logs
+	RedactContent bool `yaml:"redact_content"`
+}
+
+type WebhooksConfig struct {
+	// Enabled delivers room events to the webhooks rooms register
+	Enabled   bool `yaml:"enabled"`
+	Workers   int  `yaml:"workers"`
+	QueueSize int  `yaml:"queue_size"`
+	// MaxAttempts is how often a delivery is attempted before it is moved
+	// to the dead letters
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Timeout        time.Duration `yaml:"timeout"`
+	// AllowPrivateTargets lets webhooks target loopback, private and
+	// link-local addresses. Only for development: any room member can
+	// register a webhook.
+	AllowPrivateTargets bool `yaml:"allow_private_targets"`
+}
+
+type IncomingConfig struct {
+	// Enabled lets rooms create incoming webhooks that post into them
+	Enabled bool `yaml:"enabled"`
+	// RateLimit is how many messages a minute a webhook may post unless it
+	// was created with a limit of its own
+	RateLimit int `yaml:"rate_limit"`
+	// Burst is how many messages a webhook may post at once
+	Burst           int    `yaml:"burst"`
+	DefaultUsername string `yaml:"default_username"`
+}
+
+type CommandsConfig struct {
+	// Enabled runs chat messages starting with / as commands
+	Enabled bool `yaml:"enabled"`
+	// Timeout bounds every command, including calls to external handlers
+	Timeout time.Duration `yaml:"timeout"`
+	// External commands are forwarded to HTTP endpoints
+	External []ExternalCommandConfig `yaml:"external"`
+}
+
+// InterceptConfig selects the interceptors every inbound message passes
+// through, in the order of the fields.
+type InterceptConfig struct {
+	// Audit logs every inbound message and what became of it
+	Audit bool `yaml:"audit"`
+	// TrimContent removes leading and trailing white space
+	TrimContent bool `yaml:"trim_content"`
+	// BlockedWords are rejected in messages, ignoring case
+	BlockedWords []string `yaml:"blocked_words"`
+}
+
+type ExternalCommandConfig struct {
+	Name        string `yaml:"name"`
+	URL         string `yaml:"url"`
+	Usage       string `yaml:"usage"`
+	Description string `yaml:"description"`
+	// Secret signs the requests like outgoing webhook deliveries
+	Secret string `yaml:"secret"`
+}
+
+func Default() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:            ":8080",
+			ShutdownTimeout: 5 * time.Second,
+			HealthTimeout:   2 * time.Second,
+			IdempotencyTTL:  24 * time.Hour,
+		},
+		Storage: StorageConfig{
+			Driver:     StoragePostgres,
+			SQLitePath: "chat.db",
+		},
+		Database: PostgresConfig{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+			Timeout: 5 * time.Second,
+		},
+		Redis: RedisConfig{
+			Host: "localhost",
+			Port: 6379,
+		},
+		Hub: HubConfig{
+			SlowConsumerPolicy: "disconnect",
+			SendBufferSize:     256,
+			OfflineQueueSize:   1024,
+			ReconnectHint:      5 * time.Second,
+			ResumeWindow:       2 * time.Minute,
+			ResumeBufferSize:   512,
+		},
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			MaxMessageSize:  1024,
+			WriteWait:       10 * time.Second,
+			PongWait:        60 * time.Second,
+		},
+		Persistence: PersistenceConfig{
+			BatchSize:     500,
+			FlushInterval: 100 * time.Millisecond,
+			QueueSize:     10000,
+			MaxRetries:    5,
+			RetryBackoff:  100 * time.Millisecond,
+			MaxBackoff:    5 * time.Second,
+			WriteTimeout:  5 * time.Second,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingNone,
+			ServiceName: "chat",
+			SampleRatio: 1,
+		},
+		Logging: LoggingConfig{
+			Level:         "info",
+			Format:        LogFormatJSON,
+			RedactContent: true,
+		},
+		Webhooks: WebhooksConfig{
+			Enabled:        true,
+			Workers:        4,
+			QueueSize:      1024,
+			MaxAttempts:    8,
+			InitialBackoff: time.Second,
+			MaxBackoff:     10 * time.Minute,
+			Timeout:        10 * time.Second,
+		},
+		Incoming: IncomingConfig{
+			Enabled:         true,
+			RateLimit:       60,
+			Burst:           10,
+			DefaultUsername: "webhook",
+		},
+		Commands: CommandsConfig{
+			Enabled: true,
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// Load resolves the configuration from the config file, the environment and
+// args (usually os.Args[1:]) and validates it.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	addr := flags.String("addr", "", "HTTP listen address")
+	storage := flags.String("storage", "", "storage driver: postgres, sqlite or memory")
+	sqlitePath := flags.String("sqlite-path", "", "SQLite database file")
+	dbHost := flags.String("db-host", "", "Postgres host")
+	dbPort := flags.Int("db-port", 0, "Postgres port")
+	dbName := flags.String("db-name", "", "Postgres database name")
+	dbSSLMode := flags.String("db-sslmode", "", "Postgres sslmode")
+	autoMigrate := flags.Bool("auto-migrate", false, "apply pending migrations at startup")
+	redisHost := flags.String("redis-host", "", "Redis host")
+	redisPort := flags.Int("redis-port", 0, "Redis port")
+	policy := flags.String("slow-consumer-policy", "", "disconnect, drop_oldest, drop_newest or spill")
+	tracing := flags.String("tracing", "", "trace exporter: none, stdout or otlp")
+	logLevel := flags.String("log-level", "", "debug, info, warn or error")
+	logFormat := flags.String("log-format", "", "json or text")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 0, "graceful shutdown deadline")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Only flags that were actually passed override the file and environment
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.HTTP.Addr = *addr
+		case "storage":
+			cfg.Storage.Driver = *storage
+		case "sqlite-path":
+			cfg.Storage.SQLitePath = *sqlitePath
+		case "db-host":
+			cfg.Database.Host = *dbHost
+		case "db-port":
+			cfg.Database.Port = *dbPort
+		case "db-name":
+			cfg.Database.DBName = *dbName
+		case "db-sslmode":
+			cfg.Database.SSLMode = *dbSSLMode
+		case "auto-migrate":
+			cfg.Database.AutoMigrate = *autoMigrate
+		case "redis-host":
+			cfg.Redis.Host = *redisHost
+		case "redis-port":
+			cfg.Redis.Port = *redisPort
+		case "slow-consumer-policy":
+			cfg.Hub.SlowConsumerPolicy = *policy
+		case "tracing":
+			cfg.Tracing.Exporter = *tracing
+		case "log-level":
+			cfg.Logging.Level = *logLevel
+		case "log-format":
+			cfg.Logging.Format = *logFormat
+		case "shutdown-timeout":
+			cfg.HTTP.ShutdownTimeout = *shutdownTimeout
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %v", key, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	num := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %v", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	dur := func(key string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %v", key, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	list := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	ratio := func(key string, dst *float64) {
+		if v, ok := os.LookupEnv(key); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %v", key, err))
+				return
+			}
+			*dst = f
+		}
+	}
+
+	str("HTTP_ADDR", &c.HTTP.Addr)
+	dur("SHUTDOWN_TIMEOUT", &c.HTTP.ShutdownTimeout)
+	dur("HEALTH_TIMEOUT", &c.HTTP.HealthTimeout)
+	dur("IDEMPOTENCY_TTL", &c.HTTP.IdempotencyTTL)
+	list("ADMIN_USERS", &c.HTTP.Admins)
+	str("ADMIN_TOKEN", &c.HTTP.AdminToken)
+
+	str("STORAGE_DRIVER", &c.Storage.Driver)
+	str("SQLITE_PATH", &c.Storage.SQLitePath)
+
+	str("DB_HOST", &c.Database.Host)
+	num("DB_PORT", &c.Database.Port)
+	str("DB_USER", &c.Database.User)
+	str("DB_PASSWORD", &c.Database.Password)
+	str("DB_NAME", &c.Database.DBName)
+	str("DB_SSLMODE", &c.Database.SSLMode)
+	boolean("DB_AUTO_MIGRATE", &c.Database.AutoMigrate)
+	dur("DB_TIMEOUT", &c.Database.Timeout)
+
+	str("REDIS_HOST", &c.Redis.Host)
+	num("REDIS_PORT", &c.Redis.Port)
+	str("REDIS_PASSWORD", &c.Redis.Password)
+	num("REDIS_DB", &c.Redis.DB)
+
+	str("SLOW_CONSUMER_POLICY", &c.Hub.SlowConsumerPolicy)
+	num("SEND_BUFFER_SIZE", &c.Hub.SendBufferSize)
+	num("OFFLINE_QUEUE_SIZE", &c.Hub.OfflineQueueSize)
+	dur("RECONNECT_HINT", &c.Hub.ReconnectHint)
+	dur("RESUME_WINDOW", &c.Hub.ResumeWindow)
+	num("RESUME_BUFFER_SIZE", &c.Hub.ResumeBufferSize)
+
+	var maxMessageSize int
+	num("WS_MAX_MESSAGE_SIZE", &maxMessageSize)
+	if maxMessageSize != 0 {
+		c.WebSocket.MaxMessageSize = int64(maxMessageSize)
+	}
+	dur("WS_WRITE_WAIT", &c.WebSocket.WriteWait)
+	dur("WS_PONG_WAIT", &c.WebSocket.PongWait)
+
+	num("PERSIST_BATCH_SIZE", &c.Persistence.BatchSize)
+	dur("PERSIST_FLUSH_INTERVAL", &c.Persistence.FlushInterval)
+	num("PERSIST_QUEUE_SIZE", &c.Persistence.QueueSize)
+	num("PERSIST_MAX_RETRIES", &c.Persistence.MaxRetries)
+	dur("PERSIST_RETRY_BACKOFF", &c.Persistence.RetryBackoff)
+	dur("PERSIST_MAX_BACKOFF", &c.Persistence.MaxBackoff)
+	dur("PERSIST_WRITE_TIMEOUT", &c.Persistence.WriteTimeout)
+
+	boolean("METRICS_ENABLED", &c.Metrics.Enabled)
+
+	str("TRACING_EXPORTER", &c.Tracing.Exporter)
+	str("TRACING_SERVICE_NAME", &c.Tracing.ServiceName)
+	str("TRACING_ENDPOINT", &c.Tracing.Endpoint)
+	ratio("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+
+	str("LOG_LEVEL", &c.Logging.Level)
+	str("LOG_FORMAT", &c.Logging.Format)
+	boolean("LOG_REDACT_CONTENT", &c.Logging.RedactContent)
+
+	boolean("WEBHOOKS_ENABLED", &c.Webhooks.Enabled)
+	num("WEBHOOK_WORKERS", &c.Webhooks.Workers)
+	num("WEBHOOK_QUEUE_SIZE", &c.Webhooks.QueueSize)
+	num("WEBHOOK_MAX_ATTEMPTS", &c.Webhooks.MaxAttempts)
+	dur("WEBHOOK_INITIAL_BACKOFF", &c.Webhooks.InitialBackoff)
+	dur("WEBHOOK_MAX_BACKOFF", &c.Webhooks.MaxBackoff)
+	dur("WEBHOOK_TIMEOUT", &c.Webhooks.Timeout)
+	boolean("WEBHOOK_ALLOW_PRIVATE_TARGETS", &c.Webhooks.AllowPrivateTargets)
+
+	boolean("INCOMING_WEBHOOKS_ENABLED", &c.Incoming.Enabled)
+	num("INCOMING_WEBHOOK_RATE_LIMIT", &c.Incoming.RateLimit)
+	num("INCOMING_WEBHOOK_BURST", &c.Incoming.Burst)
+	str("INCOMING_WEBHOOK_USERNAME", &c.Incoming.DefaultUsername)
+
+	boolean("COMMANDS_ENABLED", &c.Commands.Enabled)
+	dur("COMMAND_TIMEOUT", &c.Commands.Timeout)
+
+	boolean("AUDIT_MESSAGES", &c.Intercept.Audit)
+	boolean("TRIM_CONTENT", &c.Intercept.TrimContent)
+	list("BLOCKED_WORDS", &c.Intercept.BlockedWords)
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Addr != "", "http.addr is required")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	check(c.HTTP.HealthTimeout > 0, "http.health_timeout must be positive")
+	check(c.HTTP.IdempotencyTTL > 0, "http.idempotency_ttl must be positive")
+	check(c.HTTP.AdminToken == "" || len(c.HTTP.AdminToken) >= minAdminTokenLength,
+		"http.admin_token must be at least %d characters", minAdminTokenLength)
+
+	switch c.Storage.Driver {
+	case StoragePostgres:
+		check(c.Database.Host != "", "database.host is required")
+		check(c.Database.Port > 0 && c.Database.Port < 65536, "database.port %d is out of range", c.Database.Port)
+		check(c.Database.User != "", "database.user is required")
+		check(c.Database.DBName != "", "database.dbname is required")
+		switch c.Database.SSLMode {
+		case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		default:
+			check(false, "database.sslmode %q is invalid", c.Database.SSLMode)
+		}
+	case StorageSQLite:
+		check(c.Storage.SQLitePath != "", "storage.sqlite_path is required")
+	case StorageMemory:
+	default:
+		check(false, "storage.driver %q is invalid", c.Storage.Driver)
+	}
+	check(c.Database.Timeout > 0, "database.timeout must be positive")
+
+	check(c.Redis.Host != "", "redis.host is required")
+	check(c.Redis.Port > 0 && c.Redis.Port < 65536, "redis.port %d is out of range", c.Redis.Port)
+
+	switch c.Hub.SlowConsumerPolicy {
+	case "disconnect", "drop_oldest", "drop_newest", "spill":
+	default:
+		check(false, "hub.slow_consumer_policy %q is invalid", c.Hub.SlowConsumerPolicy)
+	}
+	check(c.Hub.SendBufferSize > 0, "hub.send_buffer_size must be positive")
+	check(c.Hub.OfflineQueueSize > 0, "hub.offline_queue_size must be positive")
+	check(c.Hub.ReconnectHint > 0, "hub.reconnect_hint must be positive")
+	check(c.Hub.ResumeWindow > 0, "hub.resume_window must be positive")
+	// Every session keeps this many frames for replay
+	check(c.Hub.ResumeBufferSize > 0 && c.Hub.ResumeBufferSize <= 65536,
+		"hub.resume_buffer_size must be between 1 and 65536")
+
+	check(c.WebSocket.MaxMessageSize > 0, "websocket.max_message_size must be positive")
+	check(c.WebSocket.WriteWait > 0, "websocket.write_wait must be positive")
+	check(c.WebSocket.PongWait > time.Second, "websocket.pong_wait must be longer than a second")
+
+	// Postgres allows at most 65535 bind parameters, 6 per message
+	check(c.Persistence.BatchSize > 0 && c.Persistence.BatchSize <= 10000,
+		"persistence.batch_size must be between 1 and 10000")
+	check(c.Persistence.QueueSize >= c.Persistence.BatchSize,
+		"persistence.queue_size must be at least persistence.batch_size")
+	check(c.Persistence.MaxRetries >= 0, "persistence.max_retries must not be negative")
+	check(c.Persistence.RetryBackoff > 0, "persistence.retry_backoff must be positive")
+	check(c.Persistence.MaxBackoff >= c.Persistence.RetryBackoff,
+		"persistence.max_backoff must be at least persistence.retry_backoff")
+	check(c.Persistence.WriteTimeout > 0, "persistence.write_timeout must be positive")
+
+	switch c.Tracing.Exporter {
+	case TracingNone, TracingStdout, TracingOTLP:
+	default:
+		check(false, "tracing.exporter %q is invalid", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Logging.Level)) == nil, "logging.level %q is invalid", c.Logging.Level)
+	switch c.Logging.Format {
+	case LogFormatJSON, LogFormatText:
+	default:
+		check(false, "logging.format %q is invalid", c.Logging.Format)
+	}
+
+	if c.Webhooks.Enabled {
+		check(c.Webhooks.Workers > 0, "webhooks.workers must be positive")
+		check(c.Webhooks.QueueSize > 0, "webhooks.queue_size must be positive")
+		check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+		check(c.Webhooks.InitialBackoff > 0, "webhooks.initial_backoff must be positive")
+		check(c.Webhooks.MaxBackoff >= c.Webhooks.InitialBackoff,
+			"webhooks.max_backoff must be at least webhooks.initial_backoff")
+		check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	}
+	if c.Incoming.Enabled {
+		check(c.Incoming.RateLimit > 0, "incoming_webhooks.rate_limit must be positive")
+		check(c.Incoming.Burst > 0, "incoming_webhooks.burst must be positive")
+		check(c.Incoming.DefaultUsername != "", "incoming_webhooks.default_username is required")
+	}
+	if c.Commands.Enabled {
+		check(c.Commands.Timeout > 0, "commands.timeout must be positive")
+		for i, cmd := range c.Commands.External {
+			check(cmd.Name != "", "commands.external[%d].name is required", i)
+			check(strings.HasPrefix(cmd.URL, "http://") || strings.HasPrefix(cmd.URL, "https://"),
+				"commands.external[%d].url must be an http or https URL", i)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c RedisConfig) Addr() string {
+	return c.Host + ":" + strconv.Itoa(c.Port)
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsetEnv unsets keys for the duration of the test, so variables set in the
+// environment running the tests don't leak into them.
+func unsetEnv(t *testing.T, keys ...string) {
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const file = `
+http:
+  addr: ":9000"
+  shutdown_timeout: 7s
+logging:
+  level: debug
+`
+	cases := map[string]struct {
+		file string
+		env  map[string]string
+		args []string
+		// want are the resolved http.addr, http.shutdown_timeout and
+		// logging.level
+		addr     string
+		shutdown time.Duration
+		level    string
+	}{
+		"defaults": {
+			addr: ":8080", shutdown: 5 * time.Second, level: "info",
+		},
+		"file over defaults": {
+			file: file,
+			addr: ":9000", shutdown: 7 * time.Second, level: "debug",
+		},
+		"env over file": {
+			file: file,
+			env:  map[string]string{"HTTP_ADDR": ":9100", "LOG_LEVEL": "warn"},
+			addr: ":9100", shutdown: 7 * time.Second, level: "warn",
+		},
+		"flags over env": {
+			file: file,
+			env:  map[string]string{"HTTP_ADDR": ":9100", "SHUTDOWN_TIMEOUT": "8s"},
+			args: []string{"-addr", ":9200", "-shutdown-timeout", "9s"},
+			addr: ":9200", shutdown: 9 * time.Second, level: "debug",
+		},
+		"flags over defaults": {
+			args: []string{"-log-level", "error"},
+			addr: ":8080", shutdown: 5 * time.Second, level: "error",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			unsetEnv(t, "CONFIG_FILE", "HTTP_ADDR", "SHUTDOWN_TIMEOUT", "LOG_LEVEL")
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			args := append([]string{"-storage", StorageMemory}, tc.args...)
+			if tc.file != "" {
+				args = append(args, "-config", writeFile(t, tc.file))
+			}
+
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.HTTP.Addr != tc.addr {
+				t.Errorf("http.addr = %q, want %q", cfg.HTTP.Addr, tc.addr)
+			}
+			if cfg.HTTP.ShutdownTimeout != tc.shutdown {
+				t.Errorf("http.shutdown_timeout = %v, want %v", cfg.HTTP.ShutdownTimeout, tc.shutdown)
+			}
+			if cfg.Logging.Level != tc.level {
+				t.Errorf("logging.level = %q, want %q", cfg.Logging.Level, tc.level)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	unsetEnv(t, "CONFIG_FILE")
+
+	// The file is optional: without one the defaults apply
+	cfg, err := Load([]string{"-storage", StorageMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP.Addr != Default().HTTP.Addr {
+		t.Errorf("http.addr = %q without a config file", cfg.HTTP.Addr)
+	}
+
+	// but one that is named has to exist
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if _, err := Load([]string{"-storage", StorageMemory, "-config", missing}); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+	t.Setenv("CONFIG_FILE", missing)
+	if _, err := Load([]string{"-storage", StorageMemory}); err == nil {
+		t.Error("expected an error for a missing CONFIG_FILE")
+	}
+
+	t.Setenv("CONFIG_FILE", writeFile(t, "http: [not, a, map]"))
+	if _, err := Load([]string{"-storage", StorageMemory}); err == nil {
+		t.Error("expected an error for a malformed config file")
+	}
+}
+
+func TestLoadRejectsInvalidEnv(t *testing.T) {
+	cases := map[string]struct {
+		key, value string
+	}{
+		"duration without unit": {"SHUTDOWN_TIMEOUT", "5"},
+		"malformed duration":    {"WEBHOOK_MAX_BACKOFF", "ten minutes"},
+		"malformed backoff":     {"PERSIST_MAX_BACKOFF", "-"},
+		"malformed int":         {"DB_PORT", "postgres"},
+		"fractional int":        {"PERSIST_BATCH_SIZE", "1.5"},
+		"malformed bool":        {"METRICS_ENABLED", "maybe"},
+		"malformed ratio":       {"TRACING_SAMPLE_RATIO", "half"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			unsetEnv(t, "CONFIG_FILE")
+			t.Setenv(tc.key, tc.value)
+
+			_, err := Load([]string{"-storage", StorageMemory})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.key) {
+				t.Errorf("error %q does not name %s", err, tc.key)
+			}
+		})
+	}
+}
+
+// validConfig returns the defaults completed to pass Validate.
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Database.User = "chat"
+	cfg.Database.DBName = "chat"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+
+	// Each case breaks one setting; want is part of the error it causes
+	cases := map[string]struct {
+		change func(c *Config)
+		want   string
+	}{
+		"no http addr":           {func(c *Config) { c.HTTP.Addr = "" }, "http.addr"},
+		"zero shutdown timeout":  {func(c *Config) { c.HTTP.ShutdownTimeout = 0 }, "http.shutdown_timeout"},
+		"zero health timeout":    {func(c *Config) { c.HTTP.HealthTimeout = 0 }, "http.health_timeout"},
+		"zero idempotency ttl":   {func(c *Config) { c.HTTP.IdempotencyTTL = 0 }, "http.idempotency_ttl"},
+		"short admin token":      {func(c *Config) { c.HTTP.AdminToken = "secret" }, "http.admin_token"},
+		"unknown storage driver": {func(c *Config) { c.Storage.Driver = "mysql" }, "storage.driver"},
+		"no sqlite path": {func(c *Config) {
+			c.Storage.Driver, c.Storage.SQLitePath = StorageSQLite, ""
+		}, "storage.sqlite_path"},
+		"no database host":          {func(c *Config) { c.Database.Host = "" }, "database.host"},
+		"database port too large":   {func(c *Config) { c.Database.Port = 65536 }, "database.port"},
+		"no database user":          {func(c *Config) { c.Database.User = "" }, "database.user"},
+		"no database name":          {func(c *Config) { c.Database.DBName = "" }, "database.dbname"},
+		"unknown sslmode":           {func(c *Config) { c.Database.SSLMode = "on" }, "database.sslmode"},
+		"zero database timeout":     {func(c *Config) { c.Database.Timeout = 0 }, "database.timeout"},
+		"no redis host":             {func(c *Config) { c.Redis.Host = "" }, "redis.host"},
+		"zero redis port":           {func(c *Config) { c.Redis.Port = 0 }, "redis.port"},
+		"unknown slow consumer":     {func(c *Config) { c.Hub.SlowConsumerPolicy = "block" }, "hub.slow_consumer_policy"},
+		"zero send buffer":          {func(c *Config) { c.Hub.SendBufferSize = 0 }, "hub.send_buffer_size"},
+		"zero offline queue":        {func(c *Config) { c.Hub.OfflineQueueSize = 0 }, "hub.offline_queue_size"},
+		"zero reconnect hint":       {func(c *Config) { c.Hub.ReconnectHint = 0 }, "hub.reconnect_hint"},
+		"zero resume window":        {func(c *Config) { c.Hub.ResumeWindow = 0 }, "hub.resume_window"},
+		"resume buffer too large":   {func(c *Config) { c.Hub.ResumeBufferSize = 65537 }, "hub.resume_buffer_size"},
+		"zero max message size":     {func(c *Config) { c.WebSocket.MaxMessageSize = 0 }, "websocket.max_message_size"},
+		"zero write wait":           {func(c *Config) { c.WebSocket.WriteWait = 0 }, "websocket.write_wait"},
+		"pong wait too short":       {func(c *Config) { c.WebSocket.PongWait = time.Second }, "websocket.pong_wait"},
+		"batch too large":           {func(c *Config) { c.Persistence.BatchSize = 10001 }, "persistence.batch_size"},
+		"queue smaller than batch":  {func(c *Config) { c.Persistence.QueueSize = c.Persistence.BatchSize - 1 }, "persistence.queue_size"},
+		"negative retries":          {func(c *Config) { c.Persistence.MaxRetries = -1 }, "persistence.max_retries"},
+		"max below retry backoff":   {func(c *Config) { c.Persistence.MaxBackoff = c.Persistence.RetryBackoff / 2 }, "persistence.max_backoff"},
+		"zero retry backoff":        {func(c *Config) { c.Persistence.RetryBackoff = 0 }, "persistence.retry_backoff"},
+		"zero write timeout":        {func(c *Config) { c.Persistence.WriteTimeout = 0 }, "persistence.write_timeout"},
+		"unknown trace exporter":    {func(c *Config) { c.Tracing.Exporter = "jaeger" }, "tracing.exporter"},
+		"sample ratio above one":    {func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sample_ratio"},
+		"unknown log level":         {func(c *Config) { c.Logging.Level = "verbose" }, "logging.level"},
+		"unknown log format":        {func(c *Config) { c.Logging.Format = "xml" }, "logging.format"},
+		"zero webhook workers":      {func(c *Config) { c.Webhooks.Workers = 0 }, "webhooks.workers"},
+		"zero webhook queue":        {func(c *Config) { c.Webhooks.QueueSize = 0 }, "webhooks.queue_size"},
+		"zero webhook attempts":     {func(c *Config) { c.Webhooks.MaxAttempts = 0 }, "webhooks.max_attempts"},
+		"zero webhook backoff":      {func(c *Config) { c.Webhooks.InitialBackoff = 0 }, "webhooks.initial_backoff"},
+		"max below initial backoff": {func(c *Config) { c.Webhooks.MaxBackoff = c.Webhooks.InitialBackoff / 2 }, "webhooks.max_backoff"},
+		"zero webhook timeout":      {func(c *Config) { c.Webhooks.Timeout = 0 }, "webhooks.timeout"},
+		"zero incoming rate limit":  {func(c *Config) { c.Incoming.RateLimit = 0 }, "incoming_webhooks.rate_limit"},
+		"zero incoming burst":       {func(c *Config) { c.Incoming.Burst = 0 }, "incoming_webhooks.burst"},
+		"no incoming username":      {func(c *Config) { c.Incoming.DefaultUsername = "" }, "incoming_webhooks.default_username"},
+		"zero command timeout":      {func(c *Config) { c.Commands.Timeout = 0 }, "commands.timeout"},
+		"unnamed external command": {func(c *Config) {
+			c.Commands.External = []ExternalCommandConfig{{URL: "https://example.com/cmd"}}
+		}, "commands.external[0].name"},
+		"external command not http": {func(c *Config) {
+			c.Commands.External = []ExternalCommandConfig{{Name: "deploy", URL: "ftp://example.com/cmd"}}
+		}, "commands.external[0].url"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := validConfig()
+			tc.change(cfg)
+			err := cfg.Validate()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %q does not mention %s", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestValidateSkipsDisabled checks that settings of disabled features and
+// unused storage drivers are not validated.
+func TestValidateSkipsDisabled(t *testing.T) {
+	cfg := validConfig()
+	cfg.Storage.Driver = StorageMemory
+	cfg.Database.User, cfg.Database.DBName = "", ""
+	cfg.Webhooks.Enabled, cfg.Webhooks.Workers = false, 0
+	cfg.Incoming.Enabled, cfg.Incoming.RateLimit = false, 0
+	cfg.Commands.Enabled, cfg.Commands.Timeout = false, 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
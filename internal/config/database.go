@@ -5,42 +5,32 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
-	"strconv"
+	"log/slog"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 type PostgresConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	DBName   string `yaml:"dbname"`
+	SSLMode  string `yaml:"sslmode"`
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool `yaml:"auto_migrate"`
+	// Timeout bounds each operation against the database, however many
+	// queries it takes
+	Timeout time.Duration `yaml:"timeout"`
 }
 
-func Connect() (*sql.DB, error) {
-	DBPort, err := strconv.Atoi(os.Getenv("DB_PORT"))
-	if err != nil {
-		return nil, fmt.Errorf("invalid DB_PORT: %v", err)
-	}
-	dbConfig := PostgresConfig{
-		Host:     os.Getenv("DB_HOST"),
-		Port:     DBPort,
-		User:     os.Getenv("DB_USER"),
-		Password: os.Getenv("DB_PASSWORD"),
-		DBName:   os.Getenv("DB_NAME"),
-		SSLMode:  "disable",
-	}
-
+func Connect(dbConfig PostgresConfig) (*sql.DB, error) {
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.DBName, dbConfig.SSLMode,
 	)
 
-	log.Println("connecting to database...")
+	slog.Info("connecting to database", "host", dbConfig.Host, "port", dbConfig.Port, "dbname", dbConfig.DBName)
 	counter := 1
 	for {
 		db, err := sql.Open("pgx", dsn)
@@ -49,7 +39,7 @@ func Connect() (*sql.DB, error) {
 		}
 
 		if err != nil {
-			log.Printf("retrying connect database (%v/5)", counter)
+			slog.Warn("retrying database connection", "attempt", counter, "of", 5, "err", err)
 			counter++
 			time.Sleep(2 * time.Second)
 			continue
@@ -61,7 +51,7 @@ func Connect() (*sql.DB, error) {
 		if err := db.PingContext(ctx); err != nil {
 			return nil, err
 		}
-		log.Println("database connected")
+		slog.Info("database connected")
 		return db, nil
 	}
 }
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_add_index.up.sql":     {Data: []byte("CREATE INDEX i ON t(c);")},
+		"001_init_schema.up.sql":   {Data: []byte("CREATE TABLE t (c INT);")},
+		"001_init_schema.down.sql": {Data: []byte("DROP TABLE t;")},
+		"README.md":                {Data: []byte("ignored")},
+	}
+
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("got %d migrations, want 2", len(migrations))
+	}
+
+	first, second := migrations[0], migrations[1]
+	if first.Version != 1 || first.Name != "init_schema" || first.Down != "DROP TABLE t;" {
+		t.Errorf("unexpected first migration %+v", first)
+	}
+	if second.Version != 2 || second.Name != "add_index" || second.Down != "" {
+		t.Errorf("unexpected second migration %+v", second)
+	}
+}
+
+func TestLoadMigrationsRejectsInvalidFiles(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"no version":      {"init.up.sql": {Data: []byte("SELECT 1;")}},
+		"no direction":    {"001_init.sql": {Data: []byte("SELECT 1;")}},
+		"down only":       {"001_init.down.sql": {Data: []byte("SELECT 1;")}},
+		"version clashes": {"001_a.up.sql": {Data: []byte("SELECT 1;")}, "001_b.up.sql": {Data: []byte("SELECT 1;")}},
+	}
+
+	for name, fsys := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadMigrations(fsys); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := LoadMigrations(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %03d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_messages_group;
+DROP INDEX IF EXISTS idx_messages_from_to;
+
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS room_members;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS users;
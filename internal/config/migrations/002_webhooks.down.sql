@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS webhook_dead_letters;
+DROP INDEX IF EXISTS idx_webhook_deliveries_status;
+DROP INDEX IF EXISTS idx_webhook_deliveries_webhook;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP INDEX IF EXISTS idx_webhooks_room;
+DROP TABLE IF EXISTS webhooks;
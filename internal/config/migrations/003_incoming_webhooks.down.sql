@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_incoming_webhooks_room;
+DROP TABLE IF EXISTS incoming_webhooks;
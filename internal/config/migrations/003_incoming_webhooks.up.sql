@@ -0,0 +1,12 @@
+CREATE TABLE incoming_webhooks (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES rooms(id),
+    username VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    rate_limit INTEGER NOT NULL,
+    created_by VARCHAR(255) NOT NULL REFERENCES users(username),
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_incoming_webhooks_room ON incoming_webhooks(room_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_api_keys_username;
+DROP TABLE IF EXISTS api_keys;
+DROP INDEX IF EXISTS idx_bots_owner;
+DROP TABLE IF EXISTS bots;
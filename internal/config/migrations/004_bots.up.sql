@@ -0,0 +1,18 @@
+CREATE TABLE bots (
+    username VARCHAR(255) PRIMARY KEY REFERENCES users(username),
+    owner VARCHAR(255) NOT NULL REFERENCES users(username),
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_bots_owner ON bots(owner);
+
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(255) NOT NULL REFERENCES bots(username),
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_api_keys_username ON api_keys(username);
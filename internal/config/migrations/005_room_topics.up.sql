@@ -0,0 +1,4 @@
+CREATE TABLE room_topics (
+    room_id INTEGER PRIMARY KEY REFERENCES rooms(id),
+    topic TEXT NOT NULL
+);
@@ -0,0 +1,416 @@
+package http_delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	spec "websocket_try3/api"
+	"websocket_try3/internal/bot"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/usecase"
+	"websocket_try3/internal/webhook"
+)
+
+// maxBodySize bounds the JSON bodies the API accepts.
+const maxBodySize = 1 << 20
+
+// api is the JSON REST API under /api. Callers identify themselves the same
+// way as on /ws, with the username query parameter or a bot's API key, and
+// must be registered. The /api/admin endpoints take the admin token instead,
+// and managing bots takes it as well.
+type api struct {
+	usecase     *usecase.WebSocketUsecase
+	hub         *websocket.Hub
+	idempotency *idempotencyStore
+	// webhooks is nil when webhooks are disabled
+	webhooks *webhook.Dispatcher
+	// incoming is nil when incoming webhooks are disabled
+	incoming *webhook.Incoming
+	// bots is nil when there is no storage for bots
+	bots   *bot.Service
+	admins map[string]bool
+	// adminToken guards /api/admin, which is disabled when it is empty
+	adminToken string
+	// maxContentSize bounds the content of posted messages
+	maxContentSize int
+}
+
+// APIOptions configure the REST API.
+type APIOptions struct {
+	// MaxContentSize bounds the content of posted messages, like the frame
+	// size limit does on /ws
+	MaxContentSize int
+	// IdempotencyTTL is how long responses are kept for Idempotency-Key
+	// replays
+	IdempotencyTTL time.Duration
+	// Admins may manage the incoming webhooks of every room
+	Admins []string
+	// AdminToken is required in the X-Admin-Token header by /api/admin and
+	// /api/bots. Without one those endpoints are disabled.
+	AdminToken string
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// Code is set for messages rejected by an interceptor
+	Code string `json:"code,omitempty"`
+}
+
+type roomResponse struct {
+	domain.Room
+	Members []domain.RoomMember `json:"members"`
+}
+
+type userResponse struct {
+	domain.User
+	Online bool `json:"online"`
+}
+
+type createRoomRequest struct {
+	Name string `json:"name"`
+}
+
+type addMemberRequest struct {
+	// Username defaults to the caller
+	Username string `json:"username"`
+}
+
+type postMessageRequest struct {
+	Content string `json:"content"`
+}
+
+func (a *api) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/rooms", a.authenticated(a.listRooms))
+	mux.HandleFunc("POST /api/rooms", a.authenticated(a.idempotent(a.createRoom)))
+	mux.HandleFunc("GET /api/rooms/{id}", a.authenticated(a.getRoom))
+	mux.HandleFunc("GET /api/rooms/{id}/members", a.authenticated(a.listMembers))
+	mux.HandleFunc("POST /api/rooms/{id}/members", a.authenticated(a.idempotent(a.addMember)))
+	mux.HandleFunc("POST /api/rooms/{id}/messages", a.authenticated(a.idempotent(a.postRoomMessage)))
+	mux.HandleFunc("POST /api/dm/{username}/messages", a.authenticated(a.idempotent(a.postDirectMessage)))
+	mux.HandleFunc("GET /api/users/{username}", a.authenticated(a.getUser))
+	mux.HandleFunc("GET /api/users/{username}/rooms", a.authenticated(a.listUserRooms))
+	mux.HandleFunc("GET /api/openapi.json", serveDocument(spec.OpenAPI))
+	mux.HandleFunc("GET /api/asyncapi.json", serveDocument(spec.AsyncAPI))
+	if a.webhooks != nil {
+		a.registerWebhooks(mux)
+	}
+	if a.incoming != nil {
+		a.registerIncoming(mux)
+	}
+	if a.bots != nil {
+		a.registerBots(mux)
+	}
+}
+
+// serveDocument serves one of the embedded protocol descriptions.
+func serveDocument(doc []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, caller string)
+
+func (a *api) authenticated(next authenticatedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller := r.URL.Query().Get("username")
+		if a.bots != nil {
+			var err error
+			if caller, err = a.bots.Authenticate(r); err != nil {
+				writeBotError(w, r, err)
+				return
+			}
+		}
+		if caller == "" {
+			writeError(w, http.StatusUnauthorized, "username is required")
+			return
+		}
+		user, err := a.usecase.GetUser(r.Context(), caller)
+		if err != nil {
+			writeUsecaseError(w, r, err)
+			return
+		}
+		if user == nil {
+			writeError(w, http.StatusUnauthorized, "unknown user "+caller)
+			return
+		}
+		next(w, r, caller)
+	}
+}
+
+func (a *api) listRooms(w http.ResponseWriter, r *http.Request, caller string) {
+	rooms, err := a.usecase.ListAllRooms(r.Context())
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	if rooms == nil {
+		rooms = []*domain.Room{}
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func (a *api) createRoom(w http.ResponseWriter, r *http.Request, caller string) {
+	var req createRoomRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	room, err := a.usecase.CreateRoom(r.Context(), req.Name, caller)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	a.hub.JoinRoom(r.Context(), caller, room.ID)
+
+	w.Header().Set("Location", "/api/rooms/"+strconv.Itoa(room.ID))
+	writeJSON(w, http.StatusCreated, room)
+}
+
+func (a *api) getRoom(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+
+	room, members, err := a.usecase.GetRoomInfo(r.Context(), id)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	if members == nil {
+		members = []domain.RoomMember{}
+	}
+	writeJSON(w, http.StatusOK, roomResponse{Room: *room, Members: members})
+}
+
+func (a *api) listMembers(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+
+	_, members, err := a.usecase.GetRoomInfo(r.Context(), id)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	if members == nil {
+		members = []domain.RoomMember{}
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+func (a *api) addMember(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	var req addMemberRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Username == "" {
+		req.Username = caller
+	}
+
+	// Only the room's members and its creator can add members
+	room, members, err := a.usecase.GetRoomInfo(r.Context(), id)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	if room.CreatedBy != caller && !slices.ContainsFunc(members, func(m domain.RoomMember) bool { return m.Username == caller }) {
+		writeError(w, http.StatusForbidden, caller+" is not a member of room "+strconv.Itoa(id))
+		return
+	}
+	for _, member := range members {
+		if member.Username == req.Username {
+			writeJSON(w, http.StatusOK, member)
+			return
+		}
+	}
+
+	if err := a.usecase.AddRoomMember(r.Context(), id, req.Username); err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	a.hub.JoinRoom(r.Context(), req.Username, id)
+
+	writeJSON(w, http.StatusCreated, domain.RoomMember{
+		RoomID:   id,
+		Username: req.Username,
+		JoinedAt: time.Now().UTC(),
+	})
+}
+
+func (a *api) postRoomMessage(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	content, ok := a.readContent(w, r)
+	if !ok {
+		return
+	}
+
+	if !a.requireMember(w, r, caller, id) {
+		return
+	}
+
+	message, err := a.hub.PostGroupMessage(r.Context(), caller, id, content)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, message)
+}
+
+func (a *api) postDirectMessage(w http.ResponseWriter, r *http.Request, caller string) {
+	content, ok := a.readContent(w, r)
+	if !ok {
+		return
+	}
+
+	message, err := a.hub.PostPrivateMessage(r.Context(), caller, r.PathValue("username"), content)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, message)
+}
+
+// requireMember writes a 403 unless caller is a member of room id, or a 404
+// if there is no such room.
+func (a *api) requireMember(w http.ResponseWriter, r *http.Request, caller string, id int) bool {
+	_, members, err := a.usecase.GetRoomInfo(r.Context(), id)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return false
+	}
+	if !slices.ContainsFunc(members, func(m domain.RoomMember) bool { return m.Username == caller }) {
+		writeError(w, http.StatusForbidden, caller+" is not a member of room "+strconv.Itoa(id))
+		return false
+	}
+	return true
+}
+
+// readContent reads and validates a postMessageRequest.
+func (a *api) readContent(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req postMessageRequest
+	if !readJSON(w, r, &req) {
+		return "", false
+	}
+	switch {
+	case req.Content == "":
+		writeError(w, http.StatusBadRequest, "content is required")
+		return "", false
+	case len(req.Content) > a.maxContentSize:
+		writeError(w, http.StatusRequestEntityTooLarge, "content exceeds "+strconv.Itoa(a.maxContentSize)+" bytes")
+		return "", false
+	}
+	return req.Content, true
+}
+
+func (a *api) getUser(w http.ResponseWriter, r *http.Request, caller string) {
+	username := r.PathValue("username")
+	user, err := a.usecase.GetUser(r.Context(), username)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, usecase.ErrUserNotFound.Error())
+		return
+	}
+
+	_, online := a.hub.Client(username)
+	writeJSON(w, http.StatusOK, userResponse{User: *user, Online: online})
+}
+
+func (a *api) listUserRooms(w http.ResponseWriter, r *http.Request, caller string) {
+	username := r.PathValue("username")
+	user, err := a.usecase.GetUser(r.Context(), username)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, usecase.ErrUserNotFound.Error())
+		return
+	}
+
+	rooms, err := a.usecase.ListUserRooms(r.Context(), username)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return
+	}
+	if rooms == nil {
+		rooms = []domain.Room{}
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func roomID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid room id "+strconv.Quote(r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// readJSON decodes the request body into dst, writing a 400 if it can't. An
+// empty body leaves dst untouched.
+func readJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// writeUsecaseError maps usecase errors to status codes. Anything unexpected
+// is logged and reported without details.
+func writeUsecaseError(w http.ResponseWriter, r *http.Request, err error) {
+	var reject *websocket.RejectError
+	switch {
+	case errors.Is(err, usecase.ErrUserNotFound), errors.Is(err, usecase.ErrRoomNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, websocket.ErrMuted):
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: websocket.ErrMuted.Error(), Code: websocket.CodeMuted})
+	case errors.As(err, &reject):
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: reject.Message, Code: reject.Code})
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusServiceUnavailable, "request timed out")
+	default:
+		slog.ErrorContext(r.Context(), "api request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
@@ -0,0 +1,114 @@
+package http_delivery
+
+import (
+	"errors"
+	"net/http"
+	"websocket_try3/internal/bot"
+	"websocket_try3/internal/domain"
+)
+
+type createBotRequest struct {
+	Username string `json:"username"`
+}
+
+// createdAPIKeyResponse is the only response that carries the key.
+type createdAPIKeyResponse struct {
+	domain.APIKey
+	Key string `json:"key"`
+}
+
+type createdBotResponse struct {
+	domain.Bot
+	APIKey createdAPIKeyResponse `json:"api_key"`
+}
+
+func (a *api) registerBots(mux *http.ServeMux) {
+	// Keys let their holder act as the bot, and the username only names the
+	// owner, so managing bots takes the admin token too. Creating bots and
+	// keys isn't idempotent: the raw key must not be kept.
+	mux.HandleFunc("POST /api/bots", a.admin(a.authenticated(a.createBot)))
+	mux.HandleFunc("GET /api/bots", a.admin(a.authenticated(a.listBots)))
+	mux.HandleFunc("POST /api/bots/{username}/keys", a.admin(a.authenticated(a.createAPIKey)))
+	mux.HandleFunc("GET /api/bots/{username}/keys", a.admin(a.authenticated(a.listAPIKeys)))
+	mux.HandleFunc("DELETE /api/bots/{username}/keys/{key}", a.admin(a.authenticated(a.revokeAPIKey)))
+}
+
+func (a *api) createBot(w http.ResponseWriter, r *http.Request, caller string) {
+	var req createBotRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	created, key, token, err := a.bots.Create(r.Context(), req.Username, caller)
+	if err != nil {
+		writeBotError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/users/"+created.Username)
+	writeJSON(w, http.StatusCreated, createdBotResponse{
+		Bot:    *created,
+		APIKey: createdAPIKeyResponse{APIKey: *key, Key: token},
+	})
+}
+
+func (a *api) listBots(w http.ResponseWriter, r *http.Request, caller string) {
+	bots, err := a.bots.List(r.Context(), caller)
+	if err != nil {
+		writeBotError(w, r, err)
+		return
+	}
+	if bots == nil {
+		bots = []domain.Bot{}
+	}
+	writeJSON(w, http.StatusOK, bots)
+}
+
+func (a *api) createAPIKey(w http.ResponseWriter, r *http.Request, caller string) {
+	key, token, err := a.bots.CreateKey(r.Context(), r.PathValue("username"), caller)
+	if err != nil {
+		writeBotError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createdAPIKeyResponse{APIKey: *key, Key: token})
+}
+
+func (a *api) listAPIKeys(w http.ResponseWriter, r *http.Request, caller string) {
+	keys, err := a.bots.Keys(r.Context(), r.PathValue("username"), caller)
+	if err != nil {
+		writeBotError(w, r, err)
+		return
+	}
+	if keys == nil {
+		keys = []domain.APIKey{}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (a *api) revokeAPIKey(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := pathID(w, r, "key")
+	if !ok {
+		return
+	}
+	if err := a.bots.RevokeKey(r.Context(), r.PathValue("username"), caller, id); err != nil {
+		writeBotError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeBotError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, bot.ErrInvalidBot):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, bot.ErrUnauthorized):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, bot.ErrNotHuman):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, bot.ErrBotNotFound), errors.Is(err, bot.ErrKeyNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, bot.ErrUsernameTaken):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeUsecaseError(w, r, err)
+	}
+}
@@ -1,45 +1,59 @@
 package http_delivery
 
 import (
-	"log"
 	"net/http"
-	"os"
-	"websocket_try3/internal/config"
+	"time"
+	"websocket_try3/internal/bot"
 	"websocket_try3/internal/delivery/websocket"
-	"websocket_try3/internal/repository"
 	"websocket_try3/internal/usecase"
-
-	"github.com/redis/go-redis/v9"
+	"websocket_try3/internal/webhook"
 )
 
-func Handler() *http.ServeMux {
-	db, err := config.Connect()
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_HOST") + ":" + os.Getenv("REDIS_PORT"),
-		Password: "",
-		DB:       0, // use default DB
-	})
-
-	hub := websocket.NewHub()
-
-	go hub.Run(rdb)
-
-	userRepo := repository.NewUserRepository(db)
-	messageRepo := repository.NewMessageRepository(db)
-	roomRepo := repository.NewRoomRepository(db)
-
-	wsUsecase := usecase.NewWebSocketUsecase(userRepo, messageRepo, roomRepo)
+// Routes are the handlers served by the chat server. Metrics, Webhooks,
+// Incoming and Bots are optional.
+type Routes struct {
+	Hub       *websocket.Hub
+	WebSocket *websocket.WebSocketHandler
+	Usecase   *usecase.WebSocketUsecase
+	Metrics   http.Handler
+	Health    Health
+	API       APIOptions
+	Webhooks  *webhook.Dispatcher
+	Incoming  *webhook.Incoming
+	Bots      *bot.Service
+}
 
-	wsHandler := websocket.NewWebSocketHandler(wsUsecase)
+// NewRouter registers the HTTP routes served by the chat server.
+func NewRouter(routes Routes) *http.ServeMux {
+	if routes.API.MaxContentSize <= 0 {
+		routes.API.MaxContentSize = int(websocket.DefaultHubConfig().MaxMessageSize)
+	}
+	if routes.API.IdempotencyTTL <= 0 {
+		routes.API.IdempotencyTTL = 24 * time.Hour
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		wsHandler.ServeWS(w, r, hub)
+		routes.WebSocket.ServeWS(w, r, routes.Hub)
 	})
-
+	admins := make(map[string]bool)
+	for _, username := range routes.API.Admins {
+		admins[username] = true
+	}
+	(&api{
+		usecase:        routes.Usecase,
+		hub:            routes.Hub,
+		idempotency:    newIdempotencyStore(routes.API.IdempotencyTTL),
+		webhooks:       routes.Webhooks,
+		incoming:       routes.Incoming,
+		bots:           routes.Bots,
+		admins:         admins,
+		adminToken:     routes.API.AdminToken,
+		maxContentSize: routes.API.MaxContentSize,
+	}).register(mux)
+	routes.Health.register(mux)
+	if routes.Metrics != nil {
+		mux.Handle("GET /metrics", routes.Metrics)
+	}
 	return mux
 }
@@ -0,0 +1,81 @@
+package http_delivery
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Health configures the probe endpoints. /healthz only reports that the
+// process is serving HTTP; /livez runs the Live checks and /readyz the Ready
+// checks, each bounded by Timeout.
+type Health struct {
+	Live    map[string]Check
+	Ready   map[string]Check
+	Timeout time.Duration
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (h *Health) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+	})
+	mux.HandleFunc("GET /livez", h.handler(h.Live))
+	mux.HandleFunc("GET /readyz", h.handler(h.Ready))
+}
+
+func (h *Health) handler(checks map[string]Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if h.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+		}
+
+		resp := healthResponse{Status: "ok", Checks: runChecks(ctx, checks)}
+		status := http.StatusOK
+		for _, result := range resp.Checks {
+			if result != "ok" {
+				resp.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeHealth(w, status, resp)
+	}
+}
+
+// runChecks runs checks concurrently and returns "ok" or the error for each.
+func runChecks(ctx context.Context, checks map[string]Check) map[string]string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			if err := check(ctx); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,71 @@
+package http_delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, health Health, path string) (int, healthResponse) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	health.register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+	var resp healthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	failing := Health{Live: map[string]Check{"hub": func(ctx context.Context) error {
+		return errors.New("stuck")
+	}}}
+
+	if code, resp := probe(t, failing, "/healthz"); code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("healthz = %d %+v", code, resp)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	health := Health{Ready: map[string]Check{
+		"hub":      func(ctx context.Context) error { return nil },
+		"database": func(ctx context.Context) error { return errors.New("connection refused") },
+	}}
+
+	code, resp := probe(t, health, "/readyz")
+	if code != http.StatusServiceUnavailable || resp.Status != "unavailable" {
+		t.Errorf("readyz = %d %+v", code, resp)
+	}
+	if resp.Checks["hub"] != "ok" || resp.Checks["database"] != "connection refused" {
+		t.Errorf("checks = %v", resp.Checks)
+	}
+
+	delete(health.Ready, "database")
+	if code, _ := probe(t, health, "/readyz"); code != http.StatusOK {
+		t.Errorf("readyz = %d with passing checks", code)
+	}
+}
+
+func TestLivezTimeout(t *testing.T) {
+	health := Health{
+		Live: map[string]Check{"hub": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		Timeout: 10 * time.Millisecond,
+	}
+
+	code, resp := probe(t, health, "/livez")
+	if code != http.StatusServiceUnavailable || resp.Checks["hub"] != context.DeadlineExceeded.Error() {
+		t.Errorf("livez = %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,228 @@
+package http_delivery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed from the store
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLen     = 255
+	idempotencyPruneInterval = time.Minute
+	// maxIdempotencyKeysPerCaller bounds the responses kept for one caller;
+	// beyond it the oldest are forgotten
+	maxIdempotencyKeysPerCaller = 1000
+)
+
+// idempotencyStore remembers the responses to requests that carried an
+// Idempotency-Key, so that a client retrying after a timeout or a dropped
+// connection gets the original response instead of a duplicate side effect.
+// Keys are scoped to the caller, and only the newest
+// maxIdempotencyKeysPerCaller of them are kept. The store is in memory, so
+// retries must reach the same replica to be deduplicated.
+//
+// Responses are kept as they were sent, so endpoints whose responses carry
+// secrets, such as API keys and webhook tokens, must not be idempotent.
+type idempotencyStore struct {
+	ttl   time.Duration
+	limit int
+
+	mu      sync.Mutex
+	entries map[idempotencyKey]*idempotentResponse
+	// keys lists every caller's keys, oldest first
+	keys      map[string][]string
+	nextPrune time.Time
+}
+
+type idempotencyKey struct {
+	caller string
+	key    string
+}
+
+type idempotentResponse struct {
+	// fingerprint identifies the request the key was first used with
+	fingerprint [sha256.Size]byte
+	// expires is zero while the first request is still in flight
+	expires time.Time
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:     ttl,
+		limit:   maxIdempotencyKeysPerCaller,
+		entries: make(map[idempotencyKey]*idempotentResponse),
+		keys:    make(map[string][]string),
+	}
+}
+
+// begin claims key for a request with the given fingerprint. It returns the
+// stored response if the key has already been used, or nil if the caller now
+// owns the key and must call finish.
+func (s *idempotencyStore) begin(key idempotencyKey, fingerprint [sha256.Size]byte) (*idempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.nextPrune) {
+		for k, entry := range s.entries {
+			if !entry.expires.IsZero() && now.After(entry.expires) {
+				s.remove(k)
+			}
+		}
+		s.nextPrune = now.Add(idempotencyPruneInterval)
+	}
+
+	entry, ok := s.entries[key]
+	if ok && (entry.expires.IsZero() || now.Before(entry.expires)) {
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, errIdempotencyKeyReused
+		case entry.expires.IsZero():
+			return nil, errIdempotencyKeyInFlight
+		}
+		return entry, nil
+	}
+
+	if ok {
+		s.remove(key)
+	}
+	if keys := s.keys[key.caller]; len(keys) >= s.limit {
+		s.remove(idempotencyKey{caller: key.caller, key: keys[0]})
+	}
+	s.entries[key] = &idempotentResponse{fingerprint: fingerprint}
+	s.keys[key.caller] = append(s.keys[key.caller], key.key)
+	return nil, nil
+}
+
+// finish stores the response to the request that owns key. Server errors are
+// not stored, so the client can retry them.
+func (s *idempotencyStore) finish(key idempotencyKey, rec *recordingWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		// Forgotten to make room for newer keys while in flight
+		return
+	}
+	if rec.status >= http.StatusInternalServerError {
+		s.remove(key)
+		return
+	}
+	entry.status = rec.status
+	entry.header = rec.Header().Clone()
+	entry.body = rec.body.Bytes()
+	entry.expires = time.Now().Add(s.ttl)
+}
+
+// remove forgets key. The caller must hold s.mu.
+func (s *idempotencyStore) remove(key idempotencyKey) {
+	delete(s.entries, key)
+	keys := slices.DeleteFunc(s.keys[key.caller], func(k string) bool { return k == key.key })
+	if len(keys) == 0 {
+		delete(s.keys, key.caller)
+		return
+	}
+	s.keys[key.caller] = keys
+}
+
+var (
+	errIdempotencyKeyReused   = errors.New("Idempotency-Key was already used with a different request")
+	errIdempotencyKeyInFlight = errors.New("a request with this Idempotency-Key is still in progress")
+)
+
+// idempotent makes next safe to retry when the request carries an
+// Idempotency-Key header. Requests without one are passed through.
+func (a *api) idempotent(next authenticatedHandler) authenticatedHandler {
+	return func(w http.ResponseWriter, r *http.Request, caller string) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r, caller)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		h.Sum(fingerprint[:0])
+
+		id := idempotencyKey{caller: caller, key: key}
+		stored, err := a.idempotency.begin(id, fingerprint)
+		switch {
+		case errors.Is(err, errIdempotencyKeyReused):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		case errors.Is(err, errIdempotencyKeyInFlight):
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		case stored != nil:
+			for name, values := range stored.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		completed := false
+		defer func() {
+			// Release the key if next panicked
+			if !completed {
+				rec.status = http.StatusInternalServerError
+			}
+			a.idempotency.finish(id, rec)
+		}()
+		next(rec, r, caller)
+		completed = true
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
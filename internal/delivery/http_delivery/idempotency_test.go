@@ -0,0 +1,105 @@
+package http_delivery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func idempotentPost(handler authenticatedHandler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/things", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req, "svc")
+	return rec
+}
+
+func TestIdempotentRetriesServerErrors(t *testing.T) {
+	a := &api{idempotency: newIdempotencyStore(time.Hour)}
+	calls := 0
+	handler := a.idempotent(func(w http.ResponseWriter, r *http.Request, caller string) {
+		calls++
+		if calls == 1 {
+			writeError(w, http.StatusInternalServerError, "boom")
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]int{"call": calls})
+	})
+
+	if rec := idempotentPost(handler, "k", "{}"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("first = %d", rec.Code)
+	}
+	if rec := idempotentPost(handler, "k", "{}"); rec.Code != http.StatusCreated {
+		t.Fatalf("retry after 500 = %d, want 201", rec.Code)
+	}
+	rec := idempotentPost(handler, "k", "{}")
+	if rec.Code != http.StatusCreated || rec.Header().Get(idempotentReplayedHeader) != "true" || calls != 2 {
+		t.Errorf("replay = %d %v after %d calls", rec.Code, rec.Header(), calls)
+	}
+	if !strings.Contains(rec.Body.String(), `"call":2`) {
+		t.Errorf("replayed body = %s", rec.Body)
+	}
+
+	idempotentPost(handler, "", "{}")
+	idempotentPost(handler, "", "{}")
+	if calls != 4 {
+		t.Errorf("requests without a key ran %d times, want 4 in total", calls)
+	}
+}
+
+func TestIdempotentRejectsConcurrentDuplicate(t *testing.T) {
+	a := &api{idempotency: newIdempotencyStore(time.Hour)}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := a.idempotent(func(w http.ResponseWriter, r *http.Request, caller string) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	done := make(chan int)
+	go func() { done <- idempotentPost(handler, "k", "{}").Code }()
+	<-started
+
+	if rec := idempotentPost(handler, "k", "{}"); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate in flight = %d, want 409", rec.Code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("first = %d", code)
+	}
+	if rec := idempotentPost(handler, "k", "{}"); rec.Code != http.StatusNoContent {
+		t.Errorf("replay = %d, want 204", rec.Code)
+	}
+}
+
+func TestIdempotencyStoreBoundedPerCaller(t *testing.T) {
+	a := &api{idempotency: newIdempotencyStore(time.Hour)}
+	a.idempotency.limit = 2
+	calls := 0
+	handler := a.idempotent(func(w http.ResponseWriter, r *http.Request, caller string) {
+		calls++
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, key := range []string{"a", "b", "c"} {
+		idempotentPost(handler, key, "{}")
+	}
+	if n := len(a.idempotency.entries); n != 2 {
+		t.Fatalf("kept %d responses, want 2", n)
+	}
+
+	// The oldest key was forgotten, the newer ones are still replayed
+	idempotentPost(handler, "c", "{}")
+	if calls != 3 {
+		t.Errorf("replaying a kept key ran the handler again")
+	}
+	idempotentPost(handler, "a", "{}")
+	if calls != 4 {
+		t.Errorf("a forgotten key was replayed")
+	}
+}
@@ -0,0 +1,169 @@
+package http_delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/usecase"
+	"websocket_try3/internal/webhook"
+)
+
+type createIncomingWebhookRequest struct {
+	// Username defaults to the configured default username
+	Username string `json:"username"`
+	// RateLimit defaults to the configured rate limit
+	RateLimit int `json:"rate_limit"`
+}
+
+// createdIncomingWebhookResponse is the only response that carries the
+// token.
+type createdIncomingWebhookResponse struct {
+	domain.IncomingWebhook
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+type incomingMessageRequest struct {
+	Text string `json:"text"`
+	// Username, if given, must be the webhook's username
+	Username string `json:"username"`
+}
+
+func (a *api) registerIncoming(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/rooms/{id}/incoming-webhooks", a.authenticated(a.createIncomingWebhook))
+	mux.HandleFunc("GET /api/rooms/{id}/incoming-webhooks", a.authenticated(a.listIncomingWebhooks))
+	mux.HandleFunc("DELETE /api/rooms/{id}/incoming-webhooks/{webhook}", a.authenticated(a.revokeIncomingWebhook))
+	mux.HandleFunc("POST /hooks/{token}", a.postIncoming)
+}
+
+func (a *api) createIncomingWebhook(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	var req createIncomingWebhookRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !a.requireRoomAdmin(w, r, caller, id) {
+		return
+	}
+
+	created, token, err := a.incoming.Create(r.Context(), id, req.Username, req.RateLimit, caller)
+	if err != nil {
+		writeIncomingError(w, r, err)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	w.Header().Set("Location", "/api/rooms/"+strconv.Itoa(id)+"/incoming-webhooks/"+strconv.Itoa(created.ID))
+	writeJSON(w, http.StatusCreated, createdIncomingWebhookResponse{
+		IncomingWebhook: *created,
+		Token:           token,
+		URL:             scheme + "://" + r.Host + "/hooks/" + token,
+	})
+}
+
+func (a *api) listIncomingWebhooks(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	if !a.requireRoomAdmin(w, r, caller, id) {
+		return
+	}
+
+	webhooks, err := a.incoming.List(r.Context(), id)
+	if err != nil {
+		writeIncomingError(w, r, err)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []domain.IncomingWebhook{}
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+func (a *api) revokeIncomingWebhook(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	webhookID, ok := pathID(w, r, "webhook")
+	if !ok {
+		return
+	}
+	if !a.requireRoomAdmin(w, r, caller, id) {
+		return
+	}
+
+	if err := a.incoming.Revoke(r.Context(), id, webhookID); err != nil {
+		writeIncomingError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postIncoming is authenticated by the token in its path alone. Unknown
+// fields are ignored, so payloads written for other chat services work as
+// long as they carry text.
+func (a *api) postIncoming(w http.ResponseWriter, r *http.Request) {
+	var req incomingMessageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	switch {
+	case req.Text == "":
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	case len(req.Text) > a.maxContentSize:
+		writeError(w, http.StatusRequestEntityTooLarge, "text exceeds "+strconv.Itoa(a.maxContentSize)+" bytes")
+		return
+	}
+
+	message, err := a.incoming.Post(r.Context(), r.PathValue("token"), req.Username, req.Text)
+	if err != nil {
+		writeIncomingError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, message)
+}
+
+// requireRoomAdmin writes a 403 unless caller created room id or is an
+// admin, or a 404 if there is no such room.
+func (a *api) requireRoomAdmin(w http.ResponseWriter, r *http.Request, caller string, id int) bool {
+	room, err := a.usecase.GetRoom(r.Context(), id)
+	if err != nil {
+		writeUsecaseError(w, r, err)
+		return false
+	}
+	if room == nil {
+		writeError(w, http.StatusNotFound, usecase.ErrRoomNotFound.Error())
+		return false
+	}
+	if room.CreatedBy != caller && !a.admins[caller] {
+		writeError(w, http.StatusForbidden, caller+" is not an admin of room "+strconv.Itoa(id))
+		return false
+	}
+	return true
+}
+
+func writeIncomingError(w http.ResponseWriter, r *http.Request, err error) {
+	var limited *webhook.RateLimitError
+	switch {
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, webhook.ErrUsernameTaken), errors.Is(err, webhook.ErrUsernameNotAllowed):
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		writeWebhookError(w, r, err)
+	}
+}
@@ -0,0 +1,219 @@
+package http_delivery
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/webhook"
+)
+
+// HeaderAdminToken carries the admin token, see APIOptions.AdminToken.
+const HeaderAdminToken = "X-Admin-Token"
+
+// Deliveries and dead letters are listed newest first, at most maxListLimit
+// at a time.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type createWebhookRequest struct {
+	URL string `json:"url"`
+	// Events defaults to every room event
+	Events []string `json:"events"`
+}
+
+// createdWebhookResponse is the only response that carries the secret.
+type createdWebhookResponse struct {
+	domain.Webhook
+	Secret string `json:"secret"`
+}
+
+func (a *api) registerWebhooks(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/rooms/{id}/webhooks", a.authenticated(a.createWebhook))
+	mux.HandleFunc("GET /api/rooms/{id}/webhooks", a.authenticated(a.listWebhooks))
+	mux.HandleFunc("DELETE /api/rooms/{id}/webhooks/{webhook}", a.authenticated(a.deleteWebhook))
+	mux.HandleFunc("GET /api/admin/webhooks/deliveries", a.admin(a.listDeliveries))
+	mux.HandleFunc("GET /api/admin/webhooks/dead-letters", a.admin(a.listDeadLetters))
+	mux.HandleFunc("POST /api/admin/webhooks/deliveries/{delivery}/replay", a.admin(a.replayDelivery))
+}
+
+// admin lets through only requests carrying the admin token. A username is
+// no credential, so the admin endpoints don't look at one; bot management
+// looks at it only after the token, to tell whose bots are meant.
+func (a *api) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.adminToken == "" {
+			writeError(w, http.StatusForbidden, "the admin API is disabled")
+			return
+		}
+		token := r.Header.Get(HeaderAdminToken)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *api) createWebhook(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	var req createWebhookRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !a.requireMember(w, r, caller, id) {
+		return
+	}
+
+	created, err := a.webhooks.Register(r.Context(), id, req.URL, req.Events, caller)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/rooms/"+strconv.Itoa(id)+"/webhooks/"+strconv.Itoa(created.ID))
+	writeJSON(w, http.StatusCreated, createdWebhookResponse{Webhook: *created, Secret: created.Secret})
+}
+
+func (a *api) listWebhooks(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	if !a.requireMember(w, r, caller, id) {
+		return
+	}
+
+	webhooks, err := a.webhooks.List(r.Context(), id)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []domain.Webhook{}
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+func (a *api) deleteWebhook(w http.ResponseWriter, r *http.Request, caller string) {
+	id, ok := roomID(w, r)
+	if !ok {
+		return
+	}
+	webhookID, ok := pathID(w, r, "webhook")
+	if !ok {
+		return
+	}
+	if !a.requireMember(w, r, caller, id) {
+		return
+	}
+
+	if err := a.webhooks.Delete(r.Context(), id, webhookID); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := domain.DeliveryFilter{Status: query.Get("status")}
+	switch filter.Status {
+	case "", domain.DeliveryPending, domain.DeliveryDelivered, domain.DeliveryDead:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid status "+strconv.Quote(filter.Status))
+		return
+	}
+	if v := query.Get("webhook_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid webhook_id "+strconv.Quote(v))
+			return
+		}
+		filter.WebhookID = id
+	}
+	var ok bool
+	if filter.Limit, ok = listLimit(w, r); !ok {
+		return
+	}
+
+	deliveries, err := a.webhooks.Deliveries(r.Context(), filter)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []domain.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func (a *api) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, ok := listLimit(w, r)
+	if !ok {
+		return
+	}
+
+	letters, err := a.webhooks.DeadLetters(r.Context(), limit)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	if letters == nil {
+		letters = []domain.WebhookDeadLetter{}
+	}
+	writeJSON(w, http.StatusOK, letters)
+}
+
+func (a *api) replayDelivery(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "delivery")
+	if !ok {
+		return
+	}
+
+	delivery, err := a.webhooks.Replay(r.Context(), id)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid "+name+" id "+strconv.Quote(r.PathValue(name)))
+		return 0, false
+	}
+	return id, true
+}
+
+func listLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultListLimit, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 || limit > maxListLimit {
+		writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxListLimit))
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, webhook.ErrInvalidWebhook):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, webhook.ErrWebhookNotFound), errors.Is(err, webhook.ErrDeliveryNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		writeUsecaseError(w, r, err)
+	}
+}
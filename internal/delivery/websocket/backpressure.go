@@ -0,0 +1,269 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SlowConsumerPolicy decides what happens to a frame when a client's send
+// buffer is full.
+type SlowConsumerPolicy string
+
+const (
+	// PolicyDisconnect closes the connection with CloseSlowConsumer
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+	// PolicyDropOldest evicts the oldest buffered frame to make room
+	PolicyDropOldest SlowConsumerPolicy = "drop_oldest"
+	// PolicyDropNewest discards the frame and later tells the client how many
+	// frames it missed with a gap frame
+	PolicyDropNewest SlowConsumerPolicy = "drop_newest"
+	// PolicySpill moves the frame to the user's offline queue, which is
+	// drained as the client catches up or when it reconnects
+	PolicySpill SlowConsumerPolicy = "spill"
+)
+
+// CloseSlowConsumer is sent with PolicyDisconnect (and when the offline queue
+// overflows). Clients should reconnect after a short delay and resume their
+// session to replay what they missed.
+const (
+	CloseSlowConsumer       = websocket.CloseTryAgainLater
+	CloseReasonSlowConsumer = "slow_consumer"
+)
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(s); policy {
+	case PolicyDisconnect, PolicyDropOldest, PolicyDropNewest, PolicySpill:
+		return policy, nil
+	case "":
+		return PolicyDisconnect, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q", s)
+	}
+}
+
+type GapMessage struct {
+	Type    string `json:"type"`
+	Dropped int    `json:"dropped"`
+}
+
+type SlowConsumerStats struct {
+	DroppedOldest uint64
+	DroppedNewest uint64
+	Disconnected  uint64
+	Spilled       uint64
+}
+
+type slowConsumerCounters struct {
+	droppedOldest atomic.Uint64
+	droppedNewest atomic.Uint64
+	disconnected  atomic.Uint64
+	spilled       atomic.Uint64
+}
+
+func (u *Hub) SlowConsumerStats() SlowConsumerStats {
+	return SlowConsumerStats{
+		DroppedOldest: u.slow.droppedOldest.Load(),
+		DroppedNewest: u.slow.droppedNewest.Load(),
+		Disconnected:  u.slow.disconnected.Load(),
+		Spilled:       u.slow.spilled.Load(),
+	}
+}
+
+// send stamps frame with the next sequence number of client's session,
+// records it for replay and queues it on the attached connection without
+// blocking, applying the configured SlowConsumerPolicy when the buffer is
+// full. Frames for a detached session are only recorded. It reports false
+// once the session has ended. The write is traced under ctx's span.
+func (u *Hub) send(ctx context.Context, client *Client, frame []byte) bool {
+	client.sendMu.Lock()
+	defer client.sendMu.Unlock()
+
+	if client.ended {
+		return false
+	}
+
+	frame = client.journal.append(frame)
+	out := outbound{data: frame, span: trace.SpanContextFromContext(ctx)}
+
+	conn := client.conn
+	if conn == nil || conn.closed {
+		return true
+	}
+
+	// Once spilling, everything goes through the offline queue to keep order
+	if client.spilling {
+		u.spill(client, frame)
+		return true
+	}
+
+	// Leave room for the frame behind the gap notice
+	if client.gap > 0 && !u.sendGap(client, conn, 2) {
+		client.gap++
+		u.slow.droppedNewest.Add(1)
+		return true
+	}
+
+	select {
+	case conn.send <- out:
+		return true
+	default:
+	}
+
+	switch u.config.SlowConsumerPolicy {
+	case PolicyDropOldest:
+		select {
+		case <-conn.send:
+		default:
+		}
+		select {
+		case conn.send <- out:
+		default:
+		}
+		u.slow.droppedOldest.Add(1)
+
+	case PolicyDropNewest:
+		client.gap++
+		u.slow.droppedNewest.Add(1)
+
+	case PolicySpill:
+		client.spilling = true
+		u.spill(client, frame)
+
+	default:
+		// The session stays resumable, so the client can replay what it missed
+		conn.log.Warn("disconnecting slow consumer")
+		conn.close(CloseSlowConsumer, CloseReasonSlowConsumer)
+		u.slow.disconnected.Add(1)
+	}
+	return true
+}
+
+// sendGap queues the gap notice for the frames dropped since the last one,
+// if the send buffer has space for it and room frames in total. The caller
+// must hold client.sendMu.
+func (u *Hub) sendGap(client *Client, conn *connection, room int) bool {
+	if cap(conn.send)-len(conn.send) < room {
+		return false
+	}
+	notice, _ := json.Marshal(GapMessage{Type: "gap", Dropped: client.gap})
+	conn.send <- outbound{data: notice}
+	client.gap = 0
+	return true
+}
+
+// spill appends frame to the client's offline queue, disconnecting the client
+// if the queue is full. The caller must hold client.sendMu.
+func (u *Hub) spill(client *Client, frame []byte) {
+	if !u.offline.push(client.Username, client.sessionID, frame) {
+		client.conn.log.Warn("offline queue full, disconnecting")
+		client.conn.close(CloseSlowConsumer, CloseReasonSlowConsumer)
+		u.slow.disconnected.Add(1)
+		return
+	}
+	u.slow.spilled.Add(1)
+}
+
+// refill moves queued frames back into the send buffer as space frees up,
+// and sends the pending gap notice once there is space for it. It is called
+// by writePump after every write and when a user reconnects. Frames spilled
+// by an earlier session of the user are recorded in this session's journal
+// and stamped with its sequence numbers, so they can be resumed like any
+// other.
+func (u *Hub) refill(client *Client) {
+	client.sendMu.Lock()
+	defer client.sendMu.Unlock()
+
+	conn := client.conn
+	if client.ended || conn == nil || conn.closed {
+		return
+	}
+	if client.gap > 0 {
+		u.sendGap(client, conn, 1)
+	}
+	if !client.spilling && u.offline.len(client.Username) == 0 {
+		return
+	}
+
+	space := cap(conn.send) - len(conn.send)
+	frames, remaining := u.offline.pop(client.Username, space)
+	for _, frame := range frames {
+		data := frame.data
+		if frame.session != client.sessionID {
+			data = client.journal.append(unstampSeq(data))
+		}
+		conn.send <- outbound{data: data}
+	}
+	client.spilling = remaining > 0
+}
+
+// offlineQueue holds frames that could not be delivered to a user, bounded
+// per user. The frames of a user are dropped when its session ends, see
+// Hub.removeClient.
+type offlineQueue struct {
+	mu     sync.Mutex
+	limit  int
+	frames map[string][]spilledFrame
+}
+
+// spilledFrame is a frame as stamped by the session that spilled it.
+type spilledFrame struct {
+	session string
+	data    []byte
+}
+
+func newOfflineQueue(limit int) *offlineQueue {
+	return &offlineQueue{
+		limit:  limit,
+		frames: make(map[string][]spilledFrame),
+	}
+}
+
+func (q *offlineQueue) push(username, session string, frame []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.frames[username]) >= q.limit {
+		return false
+	}
+	q.frames[username] = append(q.frames[username], spilledFrame{session: session, data: frame})
+	return true
+}
+
+// pop removes up to n frames for username and reports how many are left.
+func (q *offlineQueue) pop(username string, n int) ([]spilledFrame, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := q.frames[username]
+	if n > len(queued) {
+		n = len(queued)
+	}
+	frames := queued[:n]
+	if n == len(queued) {
+		delete(q.frames, username)
+		return frames, 0
+	}
+	q.frames[username] = queued[n:]
+	return frames, len(queued) - n
+}
+
+func (q *offlineQueue) len(username string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.frames[username])
+}
+
+// clear drops every frame queued for username.
+func (q *offlineQueue) clear(username string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.frames, username)
+}
@@ -0,0 +1,386 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+	"websocket_try3/internal/logging"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Client struct {
+	Username string
+	Hub      *Hub
+
+	// events is the session's mailbox, see session.go
+	events chan any
+	// exited is closed when the session goroutine returns
+	exited chan struct{}
+	// rooms is owned by the session goroutine
+	rooms map[int]*Room
+	// sessionID is the resume token, immutable after NewClient
+	sessionID string
+	// resync is set by ServeWS before Register when a resume was requested
+	// but the session could not be found
+	resync bool
+	// ctx is cancelled when the session ends or the hub stops
+	ctx    context.Context
+	cancel context.CancelFunc
+	// log carries the username
+	log *slog.Logger
+
+	contactsMu sync.Mutex
+	contacts   map[string]bool
+
+	// sendMu guards the fields below and every send on conn.send, see
+	// backpressure.go
+	sendMu sync.Mutex
+	// conn is the attached connection, nil while the session is detached
+	conn     *connection
+	ended    bool
+	journal  *journal
+	gap      int
+	spilling bool
+}
+
+// connection is a single WebSocket attached to a session. A session outlives
+// its connections for up to HubConfig.ResumeWindow, see resume.go.
+type connection struct {
+	ws *websocket.Conn
+	// ctx scopes the work requested over this connection. It is derived from
+	// the session context and cancelled as soon as the connection is closed
+	// or stops reading.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// log adds the connection ID and remote address to the session's
+	log         *slog.Logger
+	send        chan outbound
+	closed      bool
+	closeCode   int
+	closeReason string
+}
+
+// outbound is a frame queued for writePump. span is the span that caused it
+// to be sent, if any, so the write can be traced as part of the same trace.
+type outbound struct {
+	data []byte
+	span trace.SpanContext
+}
+
+func NewClient(username string, conn *websocket.Conn, hub *Hub) *Client {
+	ctx, cancel := context.WithCancel(hub.ctx)
+	log := hub.config.Logger.With("user", username)
+	return &Client{
+		Username:  username,
+		Hub:       hub,
+		events:    make(chan any, 64),
+		exited:    make(chan struct{}),
+		rooms:     make(map[int]*Room),
+		sessionID: newSessionID(),
+		contacts:  make(map[string]bool),
+		ctx:       ctx,
+		cancel:    cancel,
+		log:       log,
+		conn:      newConnection(ctx, log, conn, hub.config.SendBufferSize),
+		journal:   newJournal(hub.config.ResumeBufferSize),
+	}
+}
+
+func newConnection(parent context.Context, log *slog.Logger, ws *websocket.Conn, size int) *connection {
+	ctx, cancel := context.WithCancel(parent)
+	log = log.With("conn_id", newConnID())
+	if ws != nil {
+		log = log.With("remote_addr", ws.RemoteAddr().String())
+	}
+	return &connection{
+		ws:     ws,
+		ctx:    ctx,
+		cancel: cancel,
+		log:    log,
+		send:   make(chan outbound, size),
+	}
+}
+
+func newConnID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// close closes the send buffer, which makes writePump send a close frame
+// with code and reason and close the socket. The caller must hold the
+// owning Client's sendMu. It is safe to call more than once.
+func (c *connection) close(code int, reason string) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeReason = reason
+	close(c.send)
+	c.cancel()
+}
+
+// closeConn closes the attached connection, if any, with code and reason.
+// The session itself stays resumable.
+func (u *Client) closeConn(code int, reason string) {
+	u.sendMu.Lock()
+	defer u.sendMu.Unlock()
+
+	if u.conn != nil {
+		u.conn.close(code, reason)
+	}
+}
+
+func (u *Client) addContact(username string) {
+	u.contactsMu.Lock()
+	defer u.contactsMu.Unlock()
+
+	u.contacts[username] = true
+}
+
+func (u *Client) contactList() []string {
+	u.contactsMu.Lock()
+	defer u.contactsMu.Unlock()
+
+	contacts := make([]string, 0, len(u.contacts))
+	for contact := range u.contacts {
+		contacts = append(contacts, contact)
+	}
+	return contacts
+}
+
+func (u *Client) readPump(c *connection) {
+	defer func() {
+		// Abandon whatever this connection asked for before telling the
+		// session, which may be busy with exactly that request
+		c.cancel()
+		u.post(disconnect{conn: c})
+		c.ws.Close()
+	}()
+
+	c.ws.SetReadLimit(u.Hub.config.MaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(u.Hub.config.PongWait))
+	c.ws.SetPongHandler(func(appData string) error {
+		c.ws.SetReadDeadline(time.Now().Add(u.Hub.config.PongWait))
+		return nil
+	})
+
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.log.Warn("read failed", "err", err)
+			} else {
+				c.log.Debug("connection closed", "err", err)
+			}
+			return
+		}
+		u.handleFrame(c, msg)
+	}
+}
+
+// handleFrame decodes one frame read from c and hands it to whoever handles
+// it. The span it starts is the root of the frame's trace.
+func (u *Client) handleFrame(c *connection, msg []byte) {
+	message := new(Message)
+	if err := json.Unmarshal(msg, message); err != nil {
+		c.log.Debug("invalid frame", "err", err)
+		u.Hub.config.Metrics.FrameReceived(FrameTypeInvalid)
+		return
+	}
+	frameType := frameTypeLabel(message.Type)
+	u.Hub.config.Metrics.FrameReceived(frameType)
+
+	ctx, span := tracer.Start(c.ctx, "ws.receive "+frameType,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("chat.frame.type", frameType)),
+	)
+	defer span.End()
+
+	c.log.DebugContext(ctx, "frame received", "type", frameType, "group_id", message.GroupID,
+		"to", message.To, logging.Content(message.Content))
+
+	message.From = u.Username
+	message.Meta = nil
+	if message.To == "" && message.Type == FrameTypeGroupChat {
+		message.To = "general"
+	}
+
+	in := &Inbound{Message: *message, Source: SourceSocket}
+	err := u.Hub.intercept(ctx, in, func(ctx context.Context, in *Inbound) error {
+		route, ok := routes[in.Type]
+		if !ok {
+			return nil
+		}
+		return route(ctx, u, &in.Message)
+	})
+	var reject *RejectError
+	if errors.As(err, &reject) {
+		u.Hub.send(ctx, u, rejectMessage(reject))
+	} else if err != nil {
+		c.log.ErrorContext(ctx, "handle frame", "type", frameType, "err", err)
+	}
+}
+
+// routes hands the frames that made it through the interceptors to whoever
+// handles their type. Frames of other types are ignored.
+var routes = map[string]func(ctx context.Context, u *Client, message *Message) error{
+	FrameTypeGroupChat:   routeGroupChat,
+	FrameTypePrivateChat: routePrivateChat,
+	FrameTypeCreateRoom:  routeCreateRoom,
+	FrameTypeJoinRoom:    routeJoinRoom,
+}
+
+func routeGroupChat(ctx context.Context, u *Client, message *Message) error {
+	if message.GroupID == 0 {
+		return nil
+	}
+	room, ok := u.Hub.room(message.GroupID)
+	if !ok {
+		u.Hub.send(ctx, u, statusMessage("Group not found"))
+		return nil
+	}
+	msg, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	room.broadcast(&GroupMessage{
+		From:    u,
+		Room:    room,
+		Content: msg,
+		ctx:     ctx,
+	})
+	return nil
+}
+
+func routePrivateChat(ctx context.Context, u *Client, message *Message) error {
+	if message.To == "" {
+		return nil
+	}
+	msg, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	u.post(&PrivateMessage{
+		From:    u,
+		To:      message.To,
+		Content: msg,
+		ctx:     ctx,
+	})
+	return nil
+}
+
+func routeCreateRoom(ctx context.Context, u *Client, message *Message) error {
+	u.post(&CreateRoomRequest{
+		Creator: u,
+		Name:    message.Content,
+		ctx:     ctx,
+	})
+	return nil
+}
+
+func routeJoinRoom(ctx context.Context, u *Client, message *Message) error {
+	if message.GroupID == 0 {
+		u.Hub.send(ctx, u, statusMessage("Group ID is required"))
+		return nil
+	}
+	u.post(&JoinRoomRequest{
+		Client:  u,
+		GroupID: message.GroupID,
+		ctx:     ctx,
+	})
+	return nil
+}
+
+var newline = []byte{'\n'}
+
+func (u *Client) writePump(c *connection) {
+	ticker := time.NewTicker(u.Hub.config.PongWait * 9 / 10)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	// traced collects the spans of the frames in the current write
+	var traced []trace.SpanContext
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(u.Hub.config.WriteWait))
+			if !ok {
+				// closeCode and closeReason are set before send is closed
+				closeMessage := []byte{}
+				if c.closeCode != 0 {
+					closeMessage = websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				}
+				c.ws.WriteMessage(websocket.CloseMessage, closeMessage)
+				return
+			}
+
+			start := time.Now()
+			traced = traced[:0]
+
+			writer, err := c.ws.NextWriter(websocket.TextMessage)
+			if err != nil {
+				c.log.Warn("write failed", "err", err)
+				return
+			}
+
+			if _, err := writer.Write(msg.data); err != nil {
+				c.log.Warn("write failed", "err", err)
+				return
+			}
+			if msg.span.IsValid() {
+				traced = append(traced, msg.span)
+			}
+
+			// Coalesce whatever else is buffered into the same frame, one
+			// message per line. The buffer may shrink concurrently under
+			// PolicyDropOldest, so never block here.
+			n := len(c.send)
+		coalesce:
+			for i := 0; i < n; i++ {
+				select {
+				case next, ok := <-c.send:
+					if !ok {
+						break coalesce
+					}
+					writer.Write(newline)
+					if _, err := writer.Write(next.data); err != nil {
+						c.log.Warn("write failed", "err", err)
+						return
+					}
+					if next.span.IsValid() {
+						traced = append(traced, next.span)
+					}
+				default:
+					break coalesce
+				}
+			}
+			err = writer.Close()
+			u.traceWrite(traced, start, err)
+			if err != nil {
+				return
+			}
+
+			u.Hub.refill(u)
+
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(u.Hub.config.WriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.log.Warn("ping failed", "err", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+// ErrMuted is returned for messages from users muted in the room.
+var ErrMuted = errors.New("muted in this room")
+
+// Commands runs slash commands: group_chat and private_chat frames whose
+// content starts with "/", once they made it through HubConfig.Interceptors.
+// It is called on the connection's read goroutine, so a slow command only
+// holds up the frames of the user who sent it, and implementations must only
+// use the Hub's exported methods.
+type Commands interface {
+	// Command reports whether it handled call; if not, the content is
+	// delivered as an ordinary message.
+	Command(ctx context.Context, hub *Hub, call CommandCall) bool
+}
+
+// CommandCall is a slash command read from a socket. Exactly one of RoomID
+// and To is set, depending on where the command was sent.
+type CommandCall struct {
+	From    string
+	RoomID  int
+	To      string
+	Content string
+}
+
+type nopCommands struct{}
+
+func (nopCommands) Command(context.Context, *Hub, CommandCall) bool { return false }
+
+// Notify sends a status frame to username's session, if it is online, and
+// reports whether it did.
+func (u *Hub) Notify(ctx context.Context, username, text string) bool {
+	client, ok := u.Client(username)
+	if !ok {
+		return false
+	}
+	return u.send(ctx, client, statusMessage(text))
+}
+
+// NotifyRoom sends a status frame to every online member of roomID.
+func (u *Hub) NotifyRoom(ctx context.Context, roomID int, text string) {
+	room, ok := u.room(roomID)
+	if !ok {
+		return
+	}
+	room.broadcast(&GroupMessage{
+		Room:    room,
+		Content: statusMessage(text),
+		ctx:     context.WithoutCancel(ctx),
+		stored:  true,
+	})
+}
+
+// RemoveMember removes username from roomID: the membership is deleted, the
+// user's session, if online, leaves the room and member.left is emitted.
+func (u *Hub) RemoveMember(ctx context.Context, roomID int, username string) error {
+	if err := u.usecase.RemoveRoomMember(ctx, roomID, username); err != nil {
+		return err
+	}
+	u.config.Events.RoomEvent(ctx, roomID, domain.EventMemberLeft, MemberEvent{Username: username})
+
+	if client, ok := u.Client(username); ok {
+		client.post(&leaveRoomRequest{
+			GroupID: roomID,
+			ctx:     context.WithoutCancel(ctx),
+		})
+	}
+	return nil
+}
+
+// SetTopic sets the topic of roomID and emits room.updated.
+func (u *Hub) SetTopic(ctx context.Context, roomID int, topic string) (*domain.Room, error) {
+	room, err := u.usecase.SetRoomTopic(ctx, roomID, topic)
+	if err != nil {
+		return nil, err
+	}
+	u.config.Events.RoomEvent(ctx, roomID, domain.EventRoomUpdated, room)
+	return room, nil
+}
+
+// Mutes keep users from posting to a room, over sockets and HTTP alike, but
+// not from reading it. They are kept in memory and end with a restart.
+
+type muteKey struct {
+	roomID   int
+	username string
+}
+
+type mutes struct {
+	mu    sync.Mutex
+	until map[muteKey]time.Time
+}
+
+// Mute keeps username from posting to roomID until until.
+func (u *Hub) Mute(roomID int, username string, until time.Time) {
+	u.mutes.mu.Lock()
+	defer u.mutes.mu.Unlock()
+
+	if u.mutes.until == nil {
+		u.mutes.until = make(map[muteKey]time.Time)
+	}
+	u.mutes.until[muteKey{roomID, username}] = until
+}
+
+// Unmute lifts a mute early and reports whether there was one.
+func (u *Hub) Unmute(roomID int, username string) bool {
+	u.mutes.mu.Lock()
+	defer u.mutes.mu.Unlock()
+
+	key := muteKey{roomID, username}
+	until, ok := u.mutes.until[key]
+	delete(u.mutes.until, key)
+	return ok && time.Now().Before(until)
+}
+
+// Muted reports whether username may not post to roomID right now.
+func (u *Hub) Muted(roomID int, username string) bool {
+	u.mutes.mu.Lock()
+	defer u.mutes.mu.Unlock()
+
+	key := muteKey{roomID, username}
+	until, ok := u.mutes.until[key]
+	if ok && !time.Now().Before(until) {
+		delete(u.mutes.until, key)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,48 @@
+// Package websocket implements the chat hub and its WebSocket transport.
+//
+// # Concurrency model
+//
+// Every piece of mutable hub state has exactly one owner, and everything else
+// reaches it either through the owner's mailbox or through a synchronized
+// accessor:
+//
+//   - Room (room.go) is an actor. Its member set is only read and written by
+//     the room's goroutine; other goroutines use join, leave, broadcast and
+//     Members, which talk to it over channels. A room's goroutine stops
+//     once its last member left, and the next join loads it again.
+//   - Each Client is a session with its own goroutine (session.go) that owns
+//     Client.rooms and performs the user's slow work: loading rooms from the
+//     database, creating and joining rooms and routing private messages. A
+//     session outlives its connection for HubConfig.ResumeWindow (resume.go);
+//     connections are attached and detached through its mailbox. readPump
+//     only decodes frames, runs them through the interceptors (intercept.go)
+//     and hands them to the session (post), to a room (broadcast) or to
+//     HubConfig.Commands. Interceptors and commands only use the Hub's
+//     exported methods; readPump never touches hub or room state directly.
+//   - The username and room registries on Hub are guarded by clientShard.mu
+//     and Hub.roomsMu. They are only held for map operations, never across a
+//     channel send or I/O.
+//   - Client.conn, the attached connection, and its send channel are guarded
+//     by Client.sendMu, which also guards the session journal and the
+//     backpressure state, so a frame is never sent on a closed channel.
+//     Frames are only queued through Hub.send. writePump is the only reader,
+//     apart from PolicyDropOldest evicting a frame under Client.sendMu.
+//   - Client.contacts is shared between sessions and guarded by
+//     Client.contactsMu, and room mutes by Hub.mutes.mu.
+//   - Hub.usecase, Hub.config and Hub.interceptors are set by NewHub and
+//     read-only afterwards.
+//
+// Database calls made for a session run under a context: the session's own
+// for loading its state, and the requesting connection's for frames, which
+// is cancelled as soon as that connection goes away. Writes queued on the
+// persister are deliberately detached from both, but keep their trace, see
+// tracing.go.
+//
+// Lock order, where more than one is held, is clientShard.mu, then
+// Client.sendMu, then offlineQueue.mu. No actor ever blocks on another
+// actor's mailbox while holding a lock, and rooms never wait on sessions, so
+// a session can safely make request/reply calls to rooms.
+//
+// Shutdown closes Hub.done, which every actor selects on, so no goroutine is
+// left blocked on a mailbox once Run returns.
+package websocket
@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Events receives room events, such as messages and joins, after the hub has
+// accepted them. event is one of the domain.Event constants and data is
+// marshalled to JSON as the event's payload. Implementations must be safe for
+// concurrent use and must not block.
+type Events interface {
+	RoomEvent(ctx context.Context, roomID int, event string, data any)
+}
+
+// MemberEvent is the payload of member events.
+type MemberEvent struct {
+	Username string `json:"username"`
+}
+
+type nopEvents struct{}
+
+func (nopEvents) RoomEvent(context.Context, int, string, any) {}
+
+// messageEvent decodes a stored group_chat frame into the payload of a
+// message.posted event.
+func messageEvent(frame []byte) any {
+	var message Message
+	if err := json.Unmarshal(frame, &message); err != nil {
+		return json.RawMessage(frame)
+	}
+	return message
+}
@@ -1,8 +1,11 @@
 package websocket
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"websocket_try3/internal/usecase"
 
@@ -10,53 +13,110 @@ import (
 )
 
 type WebSocketHandler struct {
-	upgrader *websocket.Upgrader
-	usecase  *usecase.WebSocketUsecase
+	upgrader     *websocket.Upgrader
+	usecase      *usecase.WebSocketUsecase
+	authenticate func(r *http.Request) (string, error)
 }
 
-func NewWebSocketHandler(usecase *usecase.WebSocketUsecase) *WebSocketHandler {
+type UpgraderConfig struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+	// AllowedOrigins restricts the Origin header; empty allows any origin
+	AllowedOrigins []string
+	// Authenticate returns who is connecting, or "" if the request names no
+	// one. It defaults to the username query parameter.
+	Authenticate func(r *http.Request) (string, error)
+}
+
+func NewWebSocketHandler(usecase *usecase.WebSocketUsecase, config UpgraderConfig) *WebSocketHandler {
+	if config.Authenticate == nil {
+		config.Authenticate = func(r *http.Request) (string, error) {
+			return r.URL.Query().Get("username"), nil
+		}
+	}
 	return &WebSocketHandler{
 		upgrader: &websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			ReadBufferSize:  config.ReadBufferSize,
+			WriteBufferSize: config.WriteBufferSize,
+			CheckOrigin:     checkOrigin(config.AllowedOrigins),
 		},
-		usecase: usecase,
+		usecase:      usecase,
+		authenticate: config.Authenticate,
+	}
+}
+
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		return func(r *http.Request) bool {
+			return true
+		}
+	}
+
+	origins := make(map[string]bool, len(allowed))
+	for _, origin := range allowed {
+		origins[origin] = true
+	}
+	return func(r *http.Request) bool {
+		return origins[r.Header.Get("Origin")]
 	}
 }
 
 func (h *WebSocketHandler) ServeWS(w http.ResponseWriter, r *http.Request, hubs *Hub) {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to register user: %v", err.Error()), http.StatusInternalServerError)
+	metrics := hubs.config.Metrics
+
+	if !hubs.Accepting() {
+		metrics.UpgradeFailed(UpgradeFailureDraining)
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
 
-	hubs.UseCase = h.usecase
-
-	username := r.URL.Query().Get("username")
+	username, err := h.authenticate(r)
+	if err != nil {
+		metrics.UpgradeFailed(UpgradeFailureUnauthorized)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	if username == "" {
+		metrics.UpgradeFailed(UpgradeFailureMissingUsername)
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
 
 	// Register user to database
-	if err := h.usecase.RegisterUser(username); err != nil {
+	if err := h.usecase.RegisterUser(r.Context(), username); err != nil {
+		metrics.UpgradeFailed(UpgradeFailureRegisterUser)
 		http.Error(w, fmt.Sprintf("Failed to register user: %v", err.Error()), http.StatusInternalServerError)
-		conn.Close()
 		return
 	}
 
-	client := &Client{
-		Username: username,
-		Conn:     conn,
-		Send:     make(chan []byte, 256),
-		Hub:      hubs,
+	// Upgrade writes its own error response on failure
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		metrics.UpgradeFailed(UpgradeFailureHandshake)
+		hubs.config.Logger.Debug("upgrade failed", "user", username, "remote_addr", r.RemoteAddr, "err", err)
+		return
+	}
+
+	// Reconnecting clients pass their session ID and last seen seq
+	var resync bool
+	if sessionID := r.URL.Query().Get("session_id"); sessionID != "" {
+		lastSeq, _ := strconv.ParseUint(r.URL.Query().Get("last_seq"), 10, 64)
+		err := hubs.Resume(username, sessionID, lastSeq, conn)
+		if err == nil {
+			return
+		}
+		resync = errors.Is(err, ErrSessionNotFound)
 	}
-	hubs.Registered <- client
 
-	go client.WritePump()
-	go client.ReadPump()
+	// Only a fresh session needs a client, whose context lives until it ends
+	client := NewClient(username, conn, hubs)
+	client.resync = resync
+	if err := hubs.Register(client); err != nil {
+		metrics.UpgradeFailed(UpgradeFailureHubClosed)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, CloseReasonGoingAway),
+			time.Now().Add(hubs.config.WriteWait))
+		conn.Close()
+		return
+	}
 }
@@ -0,0 +1,38 @@
+package websocket
+
+import "context"
+
+// Ping reports whether the hub is alive. It is answered by a goroutine that
+// does nothing else, so a slow database can't fail it, and keeps answering
+// while the hub drains. It fails with ErrHubClosed once Stop has drained
+// every connection, and with ctx.Err() if it isn't answered in time, for
+// example before Run has been called.
+func (u *Hub) Ping(ctx context.Context) error {
+	select {
+	case u.heartbeats <- struct{}{}:
+		return nil
+	case <-u.halted:
+		return ErrHubClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ready is Ping, except that it also fails with ErrHubClosed as soon as the
+// hub starts draining, so that load balancers stop sending it connections.
+func (u *Hub) Ready(ctx context.Context) error {
+	if !u.Accepting() {
+		return ErrHubClosed
+	}
+	return u.Ping(ctx)
+}
+
+func (u *Hub) heartbeat() {
+	for {
+		select {
+		case <-u.heartbeats:
+		case <-u.halted:
+			return
+		}
+	}
+}
@@ -1,71 +1,149 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
-	"log"
-	"strconv"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+	"websocket_try3/internal/domain"
 	"websocket_try3/internal/usecase"
-
-	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
 )
 
-var (
-	writeWait      = 10 * time.Second
-	maxMessageSize = 1024
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-)
+// clientShards is the number of independently locked partitions of the
+// username -> client registry.
+const clientShards = 32
 
+// Hub wires together the actors that make up the chat server. See doc.go for
+// the concurrency model.
 type Hub struct {
-	Clients        map[string]*Client
-	Room           map[int]*Room
-	NewRoom        chan *CreateRoomRequest
-	JoinRoom       chan *JoinRoomRequest
-	Registered     chan *Client
-	Unregistered   chan *Client
-	PrivateMessage chan *PrivateMessage
-	GroupMessage   chan *GroupMessage
-	UseCase        *usecase.WebSocketUsecase
-	Shutdown       chan struct{}
-	Mutex          *sync.Mutex
+	Shutdown chan struct{}
+
+	usecase *usecase.WebSocketUsecase
+
+	shards  [clientShards]*clientShard
+	roomsMu sync.RWMutex
+	rooms   map[int]*Room
+
+	config HubConfig
+	mutes  mutes
+	// interceptors are HubConfig.Interceptors followed by the built-in ones
+	interceptors []Interceptor
+	persister    *persister
+	offline      *offlineQueue
+	slow         slowConsumerCounters
+	done         chan struct{}
+	stopped      chan struct{}
+	// heartbeats are answered until halted is closed, once Stop has drained
+	// every connection, see Ping
+	heartbeats chan struct{}
+	halted     chan struct{}
+	haltOnce   sync.Once
+	// ctx is the parent of every session context and is cancelled with done,
+	// aborting in-flight database calls made on behalf of sessions
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	draining atomic.Bool
+	// conns counts connections whose writePump is still running. Add is only
+	// called under a shard lock before draining starts, so it can't race with
+	// the Wait in Stop.
+	conns sync.WaitGroup
+}
+
+type HubConfig struct {
+	// SlowConsumerPolicy is applied when a client's send buffer is full
+	SlowConsumerPolicy SlowConsumerPolicy
+	// SendBufferSize is the number of frames buffered per client
+	SendBufferSize int
+	// OfflineQueueSize bounds the frames spilled per user by PolicySpill
+	OfflineQueueSize int
+	// ReconnectHint is the upper bound of the randomized delay clients are
+	// asked to wait before reconnecting after a shutdown
+	ReconnectHint time.Duration
+	// ResumeWindow is how long a session outlives a dropped connection
+	ResumeWindow time.Duration
+	// ResumeBufferSize is the number of recent frames kept for replay
+	ResumeBufferSize int
+	// WriteWait is the time allowed to write a frame to the peer
+	WriteWait time.Duration
+	// PongWait is the time allowed to read the next pong; pings are sent at
+	// 9/10 of it
+	PongWait time.Duration
+	// MaxMessageSize is the largest frame accepted from the peer
+	MaxMessageSize int64
+	// Metrics receives instrumentation events; nil disables them
+	Metrics Metrics
+	// Events receives room events; nil disables them
+	Events Events
+	// Commands runs slash commands; nil delivers them as messages
+	Commands Commands
+	// Interceptors see every inbound message, in order, see intercept.go
+	Interceptors []Interceptor
+	// Logger defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+func DefaultHubConfig() HubConfig {
+	return HubConfig{
+		SlowConsumerPolicy: PolicyDisconnect,
+		SendBufferSize:     256,
+		OfflineQueueSize:   1024,
+		ReconnectHint:      5 * time.Second,
+		ResumeWindow:       2 * time.Minute,
+		ResumeBufferSize:   512,
+		WriteWait:          10 * time.Second,
+		PongWait:           60 * time.Second,
+		MaxMessageSize:     1024,
+	}
 }
 
-type Client struct {
-	Username string
-	Conn     *websocket.Conn
-	Send     chan []byte
-	Hub      *Hub
+type clientShard struct {
+	mu      sync.RWMutex
+	clients map[string]*Client
 }
 
 type PrivateMessage struct {
 	From    *Client
 	To      string
 	Content []byte
+	// ctx is the context of the frame that carried the message
+	ctx context.Context
 }
 
 type GroupMessage struct {
+	// From is nil for messages posted over HTTP, which every member receives
 	From    *Client
 	Room    *Room
 	Content []byte
-}
-
-type Room struct {
-	ID      int
-	Name    string
-	Clients map[*Client]bool
+	ctx     context.Context
+	// stored is set when the message is already persisted
+	stored bool
 }
 
 type CreateRoomRequest struct {
 	Creator *Client
 	Name    string
+	// ctx is the requesting connection's context
+	ctx context.Context
 }
 
 type JoinRoomRequest struct {
 	Client  *Client
 	GroupID int
+	ctx     context.Context
+	// stored is set when the membership is already in the database
+	stored bool
+}
+
+// leaveRoomRequest makes a session leave a room it was removed from.
+type leaveRoomRequest struct {
+	GroupID int
+	ctx     context.Context
 }
 
 type Message struct {
@@ -74,358 +152,262 @@ type Message struct {
 	Type    string `json:"type"`
 	Content string `json:"content"`
 	GroupID int    `json:"group_id"`
+	// Meta is set by interceptors; clients can't send it
+	Meta map[string]string `json:"meta,omitempty"`
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		Clients:        make(map[string]*Client),
-		NewRoom:        make(chan *CreateRoomRequest),
-		JoinRoom:       make(chan *JoinRoomRequest),
-		Room:           make(map[int]*Room),
-		Registered:     make(chan *Client),
-		Unregistered:   make(chan *Client),
-		PrivateMessage: make(chan *PrivateMessage),
-		GroupMessage:   make(chan *GroupMessage),
-		UseCase:        &usecase.WebSocketUsecase{},
-		Shutdown:       make(chan struct{}),
-		Mutex:          &sync.Mutex{},
-	}
+type StatusMessage struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	// Code is set when a message was rejected, see RejectError
+	Code string `json:"code,omitempty"`
 }
 
-func (u *Hub) Run(r *redis.Client) {
-	for {
-		select {
-		case client := <-u.Registered:
-			u.Mutex.Lock()
-			u.Clients[client.Username] = client
-
-			log.Printf("%s Is Connected", client.Username)
-			log.Printf("Total Connected Users: %d", len(u.Clients))
-
-			rooms, err := u.UseCase.ListUserRooms(client.Username)
-			if err != nil {
-				log.Printf("Failed to get user rooms: %v", err)
-				u.Mutex.Unlock()
-				continue
-			}
-			for _, room := range rooms {
-				existingRoom, exists := u.Room[room.ID]
-				if !exists {
-					existingRoom = &Room{
-						ID:      room.ID,
-						Name:    room.Name,
-						Clients: make(map[*Client]bool),
-					}
-					u.Room[room.ID] = existingRoom
-				}
-				existingRoom.Clients[client] = true
-			}
-			u.Mutex.Unlock()
-
-			u.broadcastOnlineUsers()
-
-		case client := <-u.Unregistered:
-			u.Mutex.Lock()
-			if _, ok := u.Clients[client.Username]; ok {
-				delete(u.Clients, client.Username)
-				close(client.Send)
-				log.Printf("%s Is Disconnected", client.Username)
-
-				rooms, err := u.UseCase.ListUserRooms(client.Username)
-				if err != nil {
-					log.Printf("Failed to get user rooms: %v", err)
-					u.Mutex.Unlock()
-					continue
-				}
-
-				for _, room := range rooms {
-					existingRoom := u.Room[room.ID]
-					existingRoom.Clients[client] = false
-					delete(existingRoom.Clients, client)
-					log.Printf("Logged Out user %s from room %s", client.Username, room.Name)
-				}
-				u.broadcastOnlineUsers()
-			}
-			u.Mutex.Unlock()
-
-		case msg := <-u.GroupMessage:
-			if err := u.UseCase.SendGroupMessage(msg.From.Username, msg.Room.ID, string(msg.Content)); err != nil {
-				log.Printf("Error saving group message: %v", err)
-			}
-			for client := range msg.Room.Clients {
-				if client == msg.From {
-					continue
-				}
-				select {
-				case client.Send <- msg.Content:
-				default:
-					delete(u.Clients, client.Username)
-					close(client.Send)
-				}
-			}
-
-		case msg := <-u.PrivateMessage:
-			found := false
-			u.Mutex.Lock()
-			client, ok := u.Clients[msg.To]
-			if !ok {
-				receipt := []byte(`{"type":"status","content":"User ` + msg.To + ` is not found or not connected"}`)
-				msg.From.Send <- receipt
-				u.Mutex.Unlock()
-				continue
-			}
-
-			select {
-			case client.Send <- msg.Content:
-				found = true
-				if err := u.UseCase.SendPrivateMessage(msg.From.Username, msg.To, string(msg.Content)); err != nil {
-					log.Printf("Error saving private message: %v", err)
-				}
-				if msg.From != nil || msg.To != "" {
-					receipt := []byte(`{"type":"status","content":"Message delivered to ` + msg.To + `"}`)
-					msg.From.Send <- receipt
-				}
-			default:
-				delete(u.Clients, client.Username)
-				close(client.Send)
-			}
-			u.Mutex.Unlock()
-
-			if !found || msg.To == "" {
-				receipt := []byte(`{"type":"status","content":"User ` + msg.To + ` is not found or not connected"}`)
-				msg.From.Send <- receipt
-			}
-
-		case req := <-u.NewRoom:
-			ID := len(u.Room) + 1
-
-			room := &Room{
-				ID:      ID,
-				Name:    req.Name,
-				Clients: make(map[*Client]bool),
-			}
-			room.Clients[req.Creator] = true
-			u.Room[ID] = room
-
-			if _, err := u.UseCase.CreateRoom(req.Name, req.Creator.Username); err != nil {
-				log.Printf("Error creating room: %v", err)
-				return
-			}
-
-			log.Printf("Room %s created by %s with ID %d", req.Name, req.Creator.Username, ID)
-
-			receipt := []byte(`{"type":"status","content":"Room ` + req.Name + ` created"}`)
-			req.Creator.Send <- receipt
-
-		case req := <-u.JoinRoom:
-			room, ok := u.Room[req.GroupID]
-			if !ok {
-				receipt := []byte(`{"type":"status","content":"Room not found"}`)
-				req.Client.Send <- receipt
-				continue
-			}
-
-			if _, ok := room.Clients[req.Client]; ok {
-				receipt := []byte(`{"type":"status","content":"You are already in this room"}`)
-				req.Client.Send <- receipt
-				continue
-			}
-
-			room.Clients[req.Client] = true
-
-			u.UseCase.AddRoomMember(room.ID, req.Client.Username)
-
-			receipt := []byte(`{"type":"status","content":"You're joining ` + room.Name + `"}`)
-			req.Client.Send <- receipt
-
-			member := len(room.Clients)
-			broadcastMsg := []byte(`{"type":"status","content":"` + req.Client.Username + ` Connected. Total Members: ` + strconv.Itoa(member) + `"}`)
-
-			for client := range room.Clients {
-				if client == req.Client {
-					continue
-				}
-				select {
-				case client.Send <- broadcastMsg:
-				default:
-					delete(u.Clients, client.Username)
-					delete(room.Clients, client)
-					close(client.Send)
-				}
-			}
-
-		case <-u.Shutdown:
-			for _, client := range u.Clients {
-				close(client.Send)
-				delete(u.Clients, client.Username)
-			}
-			return
-		}
+func NewHub(config HubConfig, usecase *usecase.WebSocketUsecase) *Hub {
+	defaults := DefaultHubConfig()
+	if config.SlowConsumerPolicy == "" {
+		config.SlowConsumerPolicy = defaults.SlowConsumerPolicy
+	}
+	if config.SendBufferSize <= 0 {
+		config.SendBufferSize = defaults.SendBufferSize
+	}
+	if config.OfflineQueueSize <= 0 {
+		config.OfflineQueueSize = defaults.OfflineQueueSize
+	}
+	if config.ReconnectHint <= 0 {
+		config.ReconnectHint = defaults.ReconnectHint
+	}
+	if config.ResumeWindow <= 0 {
+		config.ResumeWindow = defaults.ResumeWindow
+	}
+	if config.ResumeBufferSize <= 0 {
+		config.ResumeBufferSize = defaults.ResumeBufferSize
+	}
+	if config.WriteWait <= 0 {
+		config.WriteWait = defaults.WriteWait
+	}
+	if config.PongWait <= 0 {
+		config.PongWait = defaults.PongWait
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if config.Metrics == nil {
+		config.Metrics = nopMetrics{}
+	}
+	if config.Events == nil {
+		config.Events = nopEvents{}
+	}
+	if config.Commands == nil {
+		config.Commands = nopCommands{}
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := &Hub{
+		Shutdown:   make(chan struct{}),
+		usecase:    usecase,
+		rooms:      make(map[int]*Room),
+		config:     config,
+		persister:  newPersister(config.Logger, usecase.StoreMessages),
+		offline:    newOfflineQueue(config.OfflineQueueSize),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		heartbeats: make(chan struct{}),
+		halted:     make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for i := range hub.shards {
+		hub.shards[i] = &clientShard{clients: make(map[string]*Client)}
 	}
+	hub.interceptors = append(slices.Clip(config.Interceptors), hub.builtinInterceptors()...)
+	return hub
 }
 
-func (u *Hub) broadcastOnlineUsers() {
-	var clients []string
+// Run starts the background workers and blocks until Shutdown is signalled,
+// after which every actor is stopped, every client is disconnected and the
+// persistence queue is drained.
+func (u *Hub) Run() {
+	defer close(u.stopped)
 
-	for username := range u.Clients {
-		clients = append(clients, username)
-	}
+	go u.heartbeat()
 
-	userList, _ := json.Marshal(map[string]any{
-		"type":         "userList",
-		"online_users": clients,
-	})
+	persisted := make(chan struct{})
+	go func() {
+		u.persister.run(u.done)
+		close(persisted)
+	}()
 
-	log.Println("Broadcasting online users:", string(userList))
+	<-u.Shutdown
+	u.goAway()
+	close(u.done)
+	u.cancel()
 
-	for username, client := range u.Clients {
-		select {
-		case client.Send <- userList:
-		default:
-			close(client.Send)
-			delete(u.Clients, username)
-		}
+	<-persisted
+}
+
+// Register adds a new session to the registry, replacing any previous
+// session for the same user, and starts its session and pump goroutines. It
+// fails with ErrHubClosed once the hub has started draining.
+func (u *Hub) Register(client *Client) error {
+	shard := u.shard(client.Username)
+	shard.mu.Lock()
+	// Checked under the shard lock so goAway can't miss a late registration
+	if u.draining.Load() {
+		shard.mu.Unlock()
+		return ErrHubClosed
 	}
+	previous := shard.clients[client.Username]
+	shard.clients[client.Username] = client
+	u.conns.Add(1)
+	shard.mu.Unlock()
+
+	if previous != nil {
+		previous.post(superseded{})
+	}
+
+	client.conn.log.Info("client connected", "clients", u.countClients(), "resync", client.resync)
+
+	client.sendMu.Lock()
+	conn := client.conn
+	conn.send <- outbound{data: client.sessionMessage(false, client.resync)}
+	client.sendMu.Unlock()
+
+	// Deliver anything spilled while the user was away before new frames
+	u.refill(client)
+
+	go client.run()
+	u.startPumps(client, conn)
+	return nil
 }
 
-func (u *Client) ReadPump() {
-	defer func() {
-		u.Hub.Unregistered <- u
-		u.Conn.Close()
+// startPumps starts the goroutines serving conn. The caller must already
+// have added conn to u.conns.
+func (u *Hub) startPumps(client *Client, conn *connection) {
+	go func() {
+		defer u.conns.Done()
+		client.writePump(conn)
 	}()
+	go client.readPump(conn)
+}
+
+// Client returns the connected client for username, if any.
+func (u *Hub) Client(username string) (*Client, bool) {
+	shard := u.shard(username)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	client, ok := shard.clients[username]
+	return client, ok
+}
+
+// JoinRoom makes username's session, if it is online, join the room as if it
+// had sent join_room. It is for memberships that are already stored, such as
+// those added over the REST API.
+func (u *Hub) JoinRoom(ctx context.Context, username string, roomID int) {
+	u.config.Events.RoomEvent(ctx, roomID, domain.EventMemberJoined, MemberEvent{Username: username})
 
-	u.Conn.SetReadLimit(int64(maxMessageSize))
-	u.Conn.SetReadDeadline(time.Now().Add(pongWait))
-	u.Conn.SetPongHandler(func(appData string) error {
-		u.Conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
+	client, ok := u.Client(username)
+	if !ok {
+		return
+	}
+	client.post(&JoinRoomRequest{
+		Client:  client,
+		GroupID: roomID,
+		ctx:     context.WithoutCancel(ctx),
+		stored:  true,
 	})
+}
 
-	for {
-		_, msg, err := u.Conn.ReadMessage()
-		if err != nil {
-			log.Println(err.Error())
-			return
-		}
+// removeClient removes client from the registry, together with anything
+// still spilled for it, unless it has already been replaced by a newer
+// connection for the same username. What a replaced session spilled is left
+// for its successor.
+func (u *Hub) removeClient(client *Client) bool {
+	shard := u.shard(client.Username)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if current, ok := shard.clients[client.Username]; !ok || current != client {
+		return false
+	}
+	delete(shard.clients, client.Username)
+	u.offline.clear(client.Username)
+	return true
+}
 
-		message := new(Message)
-		if err := json.Unmarshal(msg, message); err != nil {
-			log.Printf("Error unmarshalling message: %v", err)
-			continue
-		}
+func (u *Hub) countClients() int {
+	total := 0
+	for _, shard := range u.shards {
+		shard.mu.RLock()
+		total += len(shard.clients)
+		shard.mu.RUnlock()
+	}
+	return total
+}
 
-		message.From = u.Username
+func (u *Hub) shard(username string) *clientShard {
+	h := fnv.New32a()
+	h.Write([]byte(username))
+	return u.shards[h.Sum32()%clientShards]
+}
 
-		if message.To == "" && message.Type == "group_chat" {
-			message.To = "general"
-		}
+// room returns the loaded room actor for id, if any.
+func (u *Hub) room(id int) (*Room, bool) {
+	u.roomsMu.RLock()
+	defer u.roomsMu.RUnlock()
 
-		if message.Content == "" {
-			receipt := []byte(`{"type":"status","content":"Message content is required"}`)
-			u.Send <- receipt
-			continue
-		}
+	room, ok := u.rooms[id]
+	return room, ok
+}
 
-		msg, err = json.Marshal(message)
-		if err != nil {
-			log.Printf("Error marshalling message: %v", err)
-			continue
-		}
+// loadRoom returns the room actor for id, starting it if it isn't running yet.
+func (u *Hub) loadRoom(id int, name string) *Room {
+	u.roomsMu.Lock()
+	defer u.roomsMu.Unlock()
 
-		if message.Type == "group_chat" {
-			if message.GroupID == 0 {
-				continue
-			}
-
-			room, ok := u.Hub.Room[message.GroupID]
-			if !ok || !room.Clients[u] {
-				receipt := []byte(`{"type":"status","content":"Group not found"}`)
-				u.Send <- receipt
-				continue
-			}
-
-			u.Hub.GroupMessage <- &GroupMessage{
-				From:    u,
-				Room:    room,
-				Content: msg,
-			}
-		} else if message.Type == "private_chat" {
-			if message.To == "" {
-				continue
-			}
-			u.Hub.PrivateMessage <- &PrivateMessage{
-				From:    u,
-				To:      message.To,
-				Content: msg,
-			}
-		} else if message.Type == "create_room" {
-			if message.Content == "" {
-				continue
-			}
-			newRoom := &CreateRoomRequest{
-				Creator: u,
-				Name:    message.Content,
-			}
-			u.Hub.NewRoom <- newRoom
-		} else if message.Type == "join_room" {
-			if message.GroupID == 0 {
-				receipt := []byte(`{"type":"status","content":"Group ID is required"}`)
-				u.Send <- receipt
-				continue
-			}
-			u.Hub.JoinRoom <- &JoinRoomRequest{
-				Client:  u,
-				GroupID: message.GroupID,
-			}
-		}
+	if room, ok := u.rooms[id]; ok {
+		return room
 	}
-}
 
-func (u *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
-	defer func() {
-		ticker.Stop()
-		u.Conn.Close()
-	}()
+	room := newRoom(u, id, name)
+	u.rooms[id] = room
+	go room.run()
+	return room
+}
 
+// joinRoom adds client to room id, loading the room if needed, and returns
+// the room joined. A room actor stops once it is empty, so a join that finds
+// it stopped retries with a fresh one. ok is false if the hub has stopped.
+func (u *Hub) joinRoom(ctx context.Context, id int, name string, client *Client, announce bool) (*Room, roomJoinResult, bool) {
 	for {
-		select {
-		case msg, ok := <-u.Send:
-			u.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				u.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			writer, err := u.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				log.Printf("Error getting writer: %v", err)
-				return
-			}
-
-			if _, err := writer.Write(msg); err != nil {
-				log.Printf("Error writing message: %v", err)
-				return
-			}
-
-			n := len(u.Send)
-			for i := 0; i < n; i++ {
-				if _, err := writer.Write(<-u.Send); err != nil {
-					log.Printf("Error writing message: %v", err)
-					return
-				}
-			}
-			if err := writer.Close(); err != nil {
-				return
-			}
-
-		case <-ticker.C:
-			u.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := u.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Error writing ping message: %v", err)
-				return
-			}
+		room := u.loadRoom(id, name)
+		result, err := room.join(ctx, client, announce)
+		if errors.Is(err, errRoomStopped) {
+			continue
 		}
+		return room, result, err == nil
 	}
 }
+
+func statusMessage(content string) []byte {
+	msg, _ := json.Marshal(StatusMessage{
+		Type:    "status",
+		Content: content,
+	})
+	return msg
+}
+
+// rejectMessage is the status frame telling a client its message was
+// rejected.
+func rejectMessage(reject *RejectError) []byte {
+	msg, _ := json.Marshal(StatusMessage{
+		Type:    "status",
+		Content: reject.Message,
+		Code:    reject.Code,
+	})
+	return msg
+}
@@ -0,0 +1,1164 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/usecase"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeStore is a minimal thread-safe stand-in for the Postgres repositories.
+type fakeStore struct {
+	// stall, if set, is called before SaveRoom to simulate a stuck database.
+	// It must be set before the store is shared.
+	stall func(ctx context.Context, room *domain.Room) error
+
+	mu       sync.Mutex
+	users    map[string]*domain.User
+	rooms    map[int]*domain.Room
+	members  map[int]map[string]bool
+	messages []domain.Message
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		users:   make(map[string]*domain.User),
+		rooms:   make(map[int]*domain.Room),
+		members: make(map[int]map[string]bool),
+	}
+}
+
+type fakeUserRepo struct{ *fakeStore }
+type fakeMessageRepo struct{ *fakeStore }
+type fakeRoomRepo struct{ *fakeStore }
+
+func (s fakeUserRepo) Save(ctx context.Context, user *domain.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := *user
+	s.users[user.Username] = &u
+	return nil
+}
+
+func (s fakeUserRepo) FindByUsername(ctx context.Context, username string) (*domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[username], nil
+}
+
+func (s fakeUserRepo) FindAll(ctx context.Context) ([]domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []domain.User
+	for _, u := range s.users {
+		users = append(users, *u)
+	}
+	return users, nil
+}
+
+func (s fakeMessageRepo) SavePrivateMessage(ctx context.Context, msg *domain.Message) error {
+	return s.SaveMessages(ctx, []*domain.Message{msg})
+}
+
+func (s fakeMessageRepo) SaveGroupMessage(ctx context.Context, msg *domain.Message) error {
+	return s.SaveMessages(ctx, []*domain.Message{msg})
+}
+
+func (s fakeMessageRepo) SaveMessages(ctx context.Context, msgs []*domain.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range msgs {
+		s.messages = append(s.messages, *msg)
+	}
+	return nil
+}
+
+func (s fakeMessageRepo) GetPrivateMessages(ctx context.Context, from, to string, limit int) ([]domain.Message, error) {
+	return nil, nil
+}
+
+func (s fakeMessageRepo) GetGroupMessages(ctx context.Context, roomID int, limit int) ([]domain.Message, error) {
+	return nil, nil
+}
+
+func (s fakeMessageRepo) GetContacts(ctx context.Context, username string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	var contacts []string
+	for _, msg := range s.messages {
+		if msg.Type != "private" {
+			continue
+		}
+		other := ""
+		if msg.From == username {
+			other = msg.To
+		} else if msg.To == username {
+			other = msg.From
+		}
+		if other != "" && !seen[other] {
+			seen[other] = true
+			contacts = append(contacts, other)
+		}
+	}
+	return contacts, nil
+}
+
+func (s fakeRoomRepo) SaveRoom(ctx context.Context, room *domain.Room) error {
+	if s.stall != nil {
+		if err := s.stall(ctx, room); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room.ID = len(s.rooms) + 1
+	r := *room
+	s.rooms[room.ID] = &r
+	return nil
+}
+
+func (s fakeRoomRepo) FindRoomByID(ctx context.Context, id int) (*domain.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rooms[id], nil
+}
+
+func (s fakeRoomRepo) AddMember(ctx context.Context, member *domain.RoomMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members[member.RoomID] == nil {
+		s.members[member.RoomID] = make(map[string]bool)
+	}
+	s.members[member.RoomID][member.Username] = true
+	return nil
+}
+
+func (s fakeRoomRepo) RemoveMember(ctx context.Context, roomID int, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[roomID], username)
+	return nil
+}
+
+func (s fakeRoomRepo) SetTopic(ctx context.Context, roomID int, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room, ok := s.rooms[roomID]; ok {
+		room.Topic = topic
+	}
+	return nil
+}
+
+func (s fakeRoomRepo) GetAllRooms(ctx context.Context) ([]*domain.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rooms []*domain.Room
+	for _, room := range s.rooms {
+		r := *room
+		rooms = append(rooms, &r)
+	}
+	return rooms, nil
+}
+
+func (s fakeRoomRepo) GetRoomMembers(ctx context.Context, roomID int) ([]domain.RoomMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []domain.RoomMember
+	for username := range s.members[roomID] {
+		members = append(members, domain.RoomMember{RoomID: roomID, Username: username})
+	}
+	return members, nil
+}
+
+func (s fakeRoomRepo) GetUserRooms(ctx context.Context, username string) ([]domain.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rooms []domain.Room
+	for id, members := range s.members {
+		if members[username] {
+			rooms = append(rooms, *s.rooms[id])
+		}
+	}
+	return rooms, nil
+}
+
+func newTestServer(t testing.TB, config HubConfig) (*Hub, *httptest.Server) {
+	t.Helper()
+	return newTestServerWithStore(t, config, newFakeStore())
+}
+
+func newTestServerWithStore(t testing.TB, config HubConfig, store *fakeStore) (*Hub, *httptest.Server) {
+	t.Helper()
+
+	uc := usecase.NewWebSocketUsecase(fakeUserRepo{store}, fakeMessageRepo{store}, fakeRoomRepo{store}, usecase.Config{})
+
+	// Seed a few rooms everyone can join
+	ctx := context.Background()
+	uc.RegisterUser(ctx, "seed")
+	for i := 1; i <= 3; i++ {
+		if _, err := uc.CreateRoom(ctx, fmt.Sprintf("room-%d", i), "seed"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hub := NewHub(config, uc)
+	go hub.Run()
+
+	handler := NewWebSocketHandler(uc, UpgraderConfig{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeWS(w, r, hub)
+	}))
+	t.Cleanup(server.Close)
+
+	return hub, server
+}
+
+func dial(t testing.TB, server *httptest.Server, username string) *testConn {
+	t.Helper()
+	return dialQuery(t, server, "username="+username)
+}
+
+func dialQuery(t testing.TB, server *httptest.Server, query string) *testConn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", query, err)
+	}
+	return &testConn{Conn: conn}
+}
+
+// TestHubConcurrentTraffic hammers connect, join, message and disconnect from
+// many goroutines at once. It is meant to be run with -race.
+func TestHubConcurrentTraffic(t *testing.T) {
+	config := DefaultHubConfig()
+	config.ResumeWindow = 10 * time.Millisecond
+	hub, server := newTestServer(t, config)
+
+	const (
+		users  = 20
+		rounds = 3
+		frames = 20
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(i)))
+			username := fmt.Sprintf("user-%d", i)
+
+			for round := 0; round < rounds; round++ {
+				conn := dial(t, server, username)
+
+				// Drain everything the hub sends until the connection closes
+				drained := make(chan struct{})
+				go func() {
+					defer close(drained)
+					for {
+						if _, _, err := conn.ReadMessage(); err != nil {
+							return
+						}
+					}
+				}()
+
+				for f := 0; f < frames; f++ {
+					var msg Message
+					switch rnd.Intn(4) {
+					case 0:
+						msg = Message{Type: "join_room", GroupID: 1 + rnd.Intn(3), Content: "join"}
+					case 1:
+						msg = Message{Type: "group_chat", GroupID: 1 + rnd.Intn(3), Content: "hello room"}
+					case 2:
+						msg = Message{Type: "private_chat", To: fmt.Sprintf("user-%d", rnd.Intn(users)), Content: "hello you"}
+					case 3:
+						msg = Message{Type: "create_room", Content: username + "'s room"}
+					}
+					if err := conn.WriteJSON(msg); err != nil {
+						break
+					}
+				}
+
+				conn.Close()
+				<-drained
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every session should eventually unregister
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.countClients() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients still registered after all connections closed", hub.countClients())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+}
+
+// TestHubShutdownWithLiveClients stops the hub while clients are still
+// sending, which must neither deadlock nor panic on a closed channel.
+func TestHubShutdownWithLiveClients(t *testing.T) {
+	hub, server := newTestServer(t, DefaultHubConfig())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		conn := dial(t, server, fmt.Sprintf("user-%d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			for {
+				msg := Message{Type: "group_chat", GroupID: 1, Content: "hello"}
+				if err := conn.WriteJSON(msg); err != nil {
+					return
+				}
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	wg.Wait()
+}
+
+// TestSlowConsumerPolicies fills a client's buffer without a WritePump
+// draining it and checks the configured policy is applied.
+func TestSlowConsumerPolicies(t *testing.T) {
+	tests := []struct {
+		policy    SlowConsumerPolicy
+		connected bool
+		check     func(SlowConsumerStats) bool
+	}{
+		{PolicyDisconnect, false, func(s SlowConsumerStats) bool { return s.Disconnected == 1 }},
+		{PolicyDropOldest, true, func(s SlowConsumerStats) bool { return s.DroppedOldest == 2 }},
+		{PolicyDropNewest, true, func(s SlowConsumerStats) bool { return s.DroppedNewest == 2 }},
+		{PolicySpill, true, func(s SlowConsumerStats) bool { return s.Spilled == 2 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			config := DefaultHubConfig()
+			config.SlowConsumerPolicy = tt.policy
+			config.SendBufferSize = 2
+			hub := NewHub(config, nil)
+			client := NewClient("slow", nil, hub)
+
+			for i := 0; i < 4; i++ {
+				hub.send(context.Background(), client, []byte(`{"type":"status"}`))
+			}
+
+			if stats := hub.SlowConsumerStats(); !tt.check(stats) {
+				t.Fatalf("unexpected stats: %+v", stats)
+			}
+			client.sendMu.Lock()
+			closed, code := client.conn.closed, client.conn.closeCode
+			client.sendMu.Unlock()
+			if closed == tt.connected {
+				t.Fatalf("closed = %v, want %v", closed, !tt.connected)
+			}
+			if closed && code != CloseSlowConsumer {
+				t.Fatalf("close code = %d, want %d", code, CloseSlowConsumer)
+			}
+		})
+	}
+}
+
+// drainSend empties client's send buffer as writePump would and returns the
+// frames, decoded.
+func drainSend(t *testing.T, client *Client) []map[string]any {
+	t.Helper()
+
+	client.sendMu.Lock()
+	defer client.sendMu.Unlock()
+
+	var frames []map[string]any
+	for {
+		select {
+		case out := <-client.conn.send:
+			var frame map[string]any
+			if err := json.Unmarshal(out.data, &frame); err != nil {
+				t.Fatalf("invalid frame %q: %v", out.data, err)
+			}
+			frames = append(frames, frame)
+		default:
+			return frames
+		}
+	}
+}
+
+// TestGapSentWhenDrained checks that the gap notice doesn't wait for a later
+// frame, but is sent once the client catches up.
+func TestGapSentWhenDrained(t *testing.T) {
+	config := DefaultHubConfig()
+	config.SlowConsumerPolicy = PolicyDropNewest
+	config.SendBufferSize = 2
+	hub := NewHub(config, nil)
+	client := NewClient("slow", nil, hub)
+
+	for i := 0; i < 4; i++ {
+		hub.send(context.Background(), client, []byte(`{"type":"status"}`))
+	}
+	if frames := drainSend(t, client); len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+
+	hub.refill(client)
+	frames := drainSend(t, client)
+	if len(frames) != 1 || frames[0]["type"] != "gap" || frames[0]["dropped"] != float64(2) {
+		t.Fatalf("after draining got %v, want a gap of 2", frames)
+	}
+}
+
+// TestSpillRestampedForNextSession checks that frames spilled by a session
+// are delivered to the user's next session with that session's sequence
+// numbers.
+func TestSpillRestampedForNextSession(t *testing.T) {
+	config := DefaultHubConfig()
+	config.SlowConsumerPolicy = PolicySpill
+	config.SendBufferSize = 2
+	hub := NewHub(config, nil)
+	previous := NewClient("slow", nil, hub)
+
+	for i := 0; i < 4; i++ {
+		hub.send(context.Background(), previous, []byte(`{"type":"status","content":"`+strconv.Itoa(i)+`"}`))
+	}
+	if n := hub.offline.len("slow"); n != 2 {
+		t.Fatalf("spilled %d frames, want 2", n)
+	}
+
+	next := NewClient("slow", nil, hub)
+	hub.send(context.Background(), next, []byte(`{"type":"status","content":"new"}`))
+	var frames []map[string]any
+	for i := 0; i < 3; i++ {
+		hub.refill(next)
+		frames = append(frames, drainSend(t, next)...)
+	}
+	want := []string{"new", "2", "3"}
+	if len(frames) != len(want) {
+		t.Fatalf("got %v, want %d frames", frames, len(want))
+	}
+	for i, frame := range frames {
+		if frame["content"] != want[i] || frame["seq"] != float64(i+1) {
+			t.Errorf("frame %d = %v, want content %s with seq %d", i, frame, want[i], i+1)
+		}
+	}
+
+	// The refilled frames can be replayed on resume like any other
+	next.sendMu.Lock()
+	replay, ok := next.journal.since(1)
+	next.sendMu.Unlock()
+	if !ok || len(replay) != 2 {
+		t.Fatalf("journal has %d frames after seq 1, want 2", len(replay))
+	}
+}
+
+// TestSpillClearedOnSessionEnd checks that a user's offline queue doesn't
+// outlive its session.
+func TestSpillClearedOnSessionEnd(t *testing.T) {
+	config := DefaultHubConfig()
+	config.SlowConsumerPolicy = PolicySpill
+	config.SendBufferSize = 1
+	hub := NewHub(config, nil)
+	client := NewClient("slow", nil, hub)
+
+	shard := hub.shard("slow")
+	shard.mu.Lock()
+	shard.clients["slow"] = client
+	shard.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		hub.send(context.Background(), client, []byte(`{"type":"status"}`))
+	}
+	if hub.offline.len("slow") == 0 {
+		t.Fatal("nothing spilled")
+	}
+
+	// A session that was already replaced leaves the queue to its successor
+	if hub.removeClient(NewClient("slow", nil, hub)) || hub.offline.len("slow") == 0 {
+		t.Fatal("a stale session cleared the queue")
+	}
+	if !hub.removeClient(client) {
+		t.Fatal("session was not registered")
+	}
+	if n := hub.offline.len("slow"); n != 0 {
+		t.Fatalf("%d frames still queued after the session ended", n)
+	}
+}
+
+func TestHubStopSendsGoingAway(t *testing.T) {
+	hub, server := newTestServer(t, DefaultHubConfig())
+	conn := dial(t, server, "alice")
+	defer conn.Close()
+
+	// Wait for the presence snapshot so the session is fully set up
+	conn.readUntil(t, PresenceSnapshot)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	var goingAway bool
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Fatalf("expected close 1001, got %v", err)
+			}
+			break
+		}
+		goingAway = goingAway || strings.Contains(string(msg), CloseReasonGoingAway)
+	}
+	if !goingAway {
+		t.Fatal("no server_going_away frame received")
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?username=bob"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while draining, got %v", err)
+	}
+}
+
+// testConn is a WebSocket client connection that understands coalesced
+// frames.
+type testConn struct {
+	*websocket.Conn
+	pending []map[string]any
+}
+
+// readUntil reads frames until one of the given type arrives and returns it.
+func (c *testConn) readUntil(t testing.TB, frameType string) map[string]any {
+	t.Helper()
+	return c.readMatching(t, frameType, func(frame map[string]any) bool {
+		return frame["type"] == frameType
+	})
+}
+
+// readMatching reads frames until one satisfies match and returns it.
+func (c *testConn) readMatching(t testing.TB, what string, match func(frame map[string]any) bool) map[string]any {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer c.SetReadDeadline(time.Time{})
+	for {
+		for len(c.pending) > 0 {
+			frame := c.pending[0]
+			c.pending = c.pending[1:]
+			if match(frame) {
+				return frame
+			}
+		}
+
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", what, err)
+		}
+		// Buffered frames are coalesced one per line
+		for _, line := range strings.Split(string(msg), "\n") {
+			var frame map[string]any
+			if err := json.Unmarshal([]byte(line), &frame); err != nil {
+				t.Fatalf("invalid frame %q: %v", line, err)
+			}
+			c.pending = append(c.pending, frame)
+		}
+	}
+}
+
+// isPresenceDelta matches presence_joined and presence_left frames.
+func isPresenceDelta(frame map[string]any) bool {
+	return frame["type"] == PresenceJoined || frame["type"] == PresenceLeft
+}
+
+// TestPresenceIsScoped checks that presence deltas only reach users sharing
+// a room or a private conversation with the user they are about.
+func TestPresenceIsScoped(t *testing.T) {
+	config := DefaultHubConfig()
+	config.ResumeWindow = 10 * time.Millisecond
+	_, server := newTestServer(t, config)
+
+	alice := dial(t, server, "alice")
+	defer alice.Close()
+	alice.readUntil(t, PresenceSnapshot)
+	alice.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+	alice.readUntil(t, "status")
+
+	carol := dial(t, server, "carol")
+	defer carol.Close()
+	if snapshot := carol.readUntil(t, PresenceSnapshot); len(snapshot["online_users"].([]any)) != 0 {
+		t.Fatalf("carol shares nothing with anyone but got %v", snapshot)
+	}
+
+	bob := dial(t, server, "bob")
+	bob.readUntil(t, PresenceSnapshot)
+	bob.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+	if f := bob.readMatching(t, "presence delta", isPresenceDelta); f["type"] != PresenceJoined || f["username"] != "alice" {
+		t.Errorf("bob got %v, want alice joined", f)
+	}
+	if f := alice.readMatching(t, "presence delta", isPresenceDelta); f["type"] != PresenceJoined || f["username"] != "bob" {
+		t.Errorf("alice got %v, want bob joined", f)
+	}
+
+	// A private message makes carol and bob contacts
+	carol.WriteJSON(Message{Type: "private_chat", To: "bob", Content: "hi"})
+	carol.readUntil(t, "status")
+	bob.Close()
+
+	if f := alice.readMatching(t, "presence delta", isPresenceDelta); f["type"] != PresenceLeft || f["username"] != "bob" {
+		t.Errorf("alice got %v, want bob left", f)
+	}
+	// carol never shared a room with bob, so she learns nothing before
+	// becoming his contact
+	if f := carol.readMatching(t, "presence delta", isPresenceDelta); f["type"] != PresenceLeft || f["username"] != "bob" {
+		t.Errorf("carol got %v, want bob left", f)
+	}
+}
+
+// TestSupersededSessionLeavesRooms connects the same user twice and checks
+// the first session is closed and no longer a member of its rooms.
+func TestSupersededSessionLeavesRooms(t *testing.T) {
+	hub, server := newTestServer(t, DefaultHubConfig())
+
+	first := dial(t, server, "alice")
+	defer first.Close()
+	first.readUntil(t, PresenceSnapshot)
+	first.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+	first.readUntil(t, "status")
+	old, _ := hub.Client("alice")
+
+	second := dial(t, server, "alice")
+	defer second.Close()
+	second.readUntil(t, "session")
+
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := first.ReadMessage(); err != nil {
+			break
+		}
+	}
+	<-old.exited
+
+	room, ok := hub.room(1)
+	if !ok {
+		t.Fatal("room 1 is not loaded")
+	}
+	current, _ := hub.Client("alice")
+	for _, member := range room.Members() {
+		if member == old {
+			t.Fatal("the superseded session is still a member of room 1")
+		}
+		if member.Username == "alice" && member != current {
+			t.Fatalf("unexpected alice session in room 1")
+		}
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	_, server := newTestServer(t, DefaultHubConfig())
+
+	alice := dial(t, server, "alice")
+	session := alice.readUntil(t, "session")
+	sessionID := session["session_id"].(string)
+	snapshot := alice.readUntil(t, PresenceSnapshot)
+	lastSeq := uint64(snapshot["seq"].(float64))
+
+	bob := dial(t, server, "bob")
+	defer bob.Close()
+	bob.readUntil(t, PresenceSnapshot)
+
+	alice.Close()
+
+	// alice's session outlives the connection, so this is recorded for replay
+	// whether or not the hub has noticed the connection is gone
+	bob.WriteJSON(Message{Type: "private_chat", To: "alice", Content: "while you were away"})
+	bob.readUntil(t, "status")
+
+	alice = dialQuery(t, server, fmt.Sprintf("username=alice&session_id=%s&last_seq=%d", sessionID, lastSeq))
+	defer alice.Close()
+
+	resumed := alice.readUntil(t, "session")
+	if resumed["resumed"] != true || resumed["resync"] != false || resumed["session_id"] != sessionID {
+		t.Fatalf("unexpected session frame: %v", resumed)
+	}
+	replayed := alice.readUntil(t, "private_chat")
+	if replayed["content"] != "while you were away" || uint64(replayed["seq"].(float64)) <= lastSeq {
+		t.Fatalf("unexpected replay: %v", replayed)
+	}
+}
+
+func TestSessionResumeUnknownRequiresResync(t *testing.T) {
+	_, server := newTestServer(t, DefaultHubConfig())
+
+	conn := dialQuery(t, server, "username=alice&session_id=stale&last_seq=10")
+	defer conn.Close()
+
+	session := conn.readUntil(t, "session")
+	if session["resumed"] != false || session["resync"] != true {
+		t.Fatalf("unexpected session frame: %v", session)
+	}
+}
+
+func TestJournalEviction(t *testing.T) {
+	j := newJournal(3)
+	for i := 0; i < 5; i++ {
+		j.append([]byte(`{"type":"status"}`))
+	}
+
+	frames, ok := j.since(2)
+	if !ok || len(frames) != 3 || string(frames[0]) != `{"seq":3,"type":"status"}` {
+		t.Fatalf("since(2) = %q, %v", frames, ok)
+	}
+	if _, ok := j.since(1); ok {
+		t.Fatal("since(1) should require a resync after eviction")
+	}
+	if _, ok := j.since(6); ok {
+		t.Fatal("since(6) is ahead of the journal")
+	}
+}
+
+// TestDisconnectCancelsPendingRequests checks that a request stuck in the
+// database is abandoned as soon as the connection that made it goes away.
+func TestDisconnectCancelsPendingRequests(t *testing.T) {
+	started := make(chan struct{})
+	abandoned := make(chan error, 1)
+
+	store := newFakeStore()
+	store.stall = func(ctx context.Context, room *domain.Room) error {
+		if room.Name != "stuck" {
+			return nil
+		}
+		close(started)
+		<-ctx.Done()
+		abandoned <- ctx.Err()
+		return ctx.Err()
+	}
+	_, server := newTestServerWithStore(t, DefaultHubConfig(), store)
+
+	alice := dial(t, server, "alice")
+	alice.readUntil(t, "session")
+	if err := alice.WriteJSON(Message{Type: "create_room", Content: "stuck"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("create_room never reached the repository")
+	}
+	alice.Close()
+
+	// Well before the usecase's own timeout
+	select {
+	case err := <-abandoned:
+		if err != context.Canceled {
+			t.Fatalf("repository context ended with %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not cancelled after the client disconnected")
+	}
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	hub, _ := newTestServer(t, HubConfig{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := hub.Ready(ctx); err != nil {
+		t.Fatalf("Ready = %v", err)
+	}
+
+	// A connection that is still finishing its writes keeps Stop draining
+	hub.conns.Add(1)
+	stopped := make(chan error, 1)
+	go func() { stopped <- hub.Stop(ctx) }()
+	<-hub.stopped
+
+	// Draining but not yet stopped: not ready, still alive
+	if err := hub.Ready(ctx); err != ErrHubClosed {
+		t.Errorf("Ready while draining = %v, want ErrHubClosed", err)
+	}
+	if err := hub.Ping(ctx); err != nil {
+		t.Errorf("Ping while draining = %v", err)
+	}
+
+	hub.conns.Done()
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Ping(ctx); err != ErrHubClosed {
+		t.Errorf("Ping after Stop = %v, want ErrHubClosed", err)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	record := func(name string) Interceptor {
+		return InterceptorFunc(func(ctx context.Context, in *Inbound, next InboundHandler) error {
+			mu.Lock()
+			seen = append(seen, name+" "+in.Source)
+			mu.Unlock()
+			return next(ctx, in)
+		})
+	}
+	config := DefaultHubConfig()
+	config.Interceptors = []Interceptor{
+		record("first"),
+		InterceptorFunc(func(ctx context.Context, in *Inbound, next InboundHandler) error {
+			switch in.Content {
+			case "drop":
+				return nil
+			case "reject":
+				return Reject("nope", "Not that")
+			}
+			in.Content = strings.ToUpper(in.Content)
+			in.Meta = map[string]string{"checked": "yes"}
+			// Interceptors can't redirect messages
+			in.To = "mallory"
+			return next(ctx, in)
+		}),
+		record("second"),
+	}
+	hub, server := newTestServer(t, config)
+
+	alice := dial(t, server, "alice")
+	defer alice.Close()
+	alice.readUntil(t, PresenceSnapshot)
+	bob := dial(t, server, "bob")
+	defer bob.Close()
+	bob.readUntil(t, PresenceSnapshot)
+
+	alice.WriteJSON(Message{Type: "private_chat", To: "bob", Content: "drop"})
+	alice.WriteJSON(Message{Type: "private_chat", To: "bob", Content: "reject"})
+	if status := alice.readUntil(t, "status"); status["code"] != "nope" || status["content"] != "Not that" {
+		t.Errorf("rejection = %v", status)
+	}
+	alice.WriteJSON(Message{Type: "private_chat", To: "bob", Content: "hello", Meta: map[string]string{"forged": "yes"}})
+	got := bob.readUntil(t, "private_chat")
+	if got["content"] != "HELLO" || got["to"] != "bob" || fmt.Sprint(got["meta"]) != "map[checked:yes]" {
+		t.Errorf("bob got %v, want the transformed and enriched message", got)
+	}
+
+	ctx := context.Background()
+	var reject *RejectError
+	if _, err := hub.PostPrivateMessage(ctx, "bob", "alice", "reject"); !errors.As(err, &reject) || reject.Code != "nope" {
+		t.Errorf("PostPrivateMessage = %v, want the rejection", err)
+	}
+	message, err := hub.PostPrivateMessage(ctx, "bob", "alice", "hi")
+	if err != nil || message.Content != "HI" || message.To != "alice" || message.Meta["checked"] != "yes" {
+		t.Errorf("PostPrivateMessage = %+v, %v; want the message as delivered", message, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"first socket", "first socket", "first socket", "second socket", "first post", "first post", "second post"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("interceptors saw %q, want %q", seen, want)
+	}
+}
+
+// TestActorsAreIsolated stalls one user's database call and checks that
+// other users' sessions and rooms keep working meanwhile.
+func TestActorsAreIsolated(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	store := newFakeStore()
+	store.stall = func(ctx context.Context, room *domain.Room) error {
+		if room.Name == "stuck" {
+			close(started)
+			<-release
+		}
+		return nil
+	}
+	_, server := newTestServerWithStore(t, DefaultHubConfig(), store)
+	defer close(release)
+
+	alice := dial(t, server, "alice")
+	defer alice.Close()
+	alice.readUntil(t, PresenceSnapshot)
+	alice.WriteJSON(Message{Type: "create_room", Content: "stuck"})
+	<-started
+
+	bob := dial(t, server, "bob")
+	defer bob.Close()
+	bob.readUntil(t, PresenceSnapshot)
+	carol := dial(t, server, "carol")
+	defer carol.Close()
+	carol.readUntil(t, PresenceSnapshot)
+	for _, conn := range []*testConn{bob, carol} {
+		conn.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+		conn.readUntil(t, "status")
+	}
+
+	bob.WriteJSON(Message{Type: "group_chat", GroupID: 1, Content: "while alice waits"})
+	if got := carol.readUntil(t, "group_chat"); got["content"] != "while alice waits" {
+		t.Errorf("carol got %v", got)
+	}
+	carol.WriteJSON(Message{Type: "private_chat", To: "bob", Content: "me too"})
+	if got := bob.readUntil(t, "private_chat"); got["content"] != "me too" {
+		t.Errorf("bob got %v", got)
+	}
+}
+
+// TestRoomPreservesOrder checks that a room delivers and persists one
+// sender's messages in the order they were sent.
+func TestRoomPreservesOrder(t *testing.T) {
+	store := newFakeStore()
+	_, server := newTestServerWithStore(t, DefaultHubConfig(), store)
+
+	alice, bob := dial(t, server, "alice"), dial(t, server, "bob")
+	defer alice.Close()
+	defer bob.Close()
+	for _, conn := range []*testConn{alice, bob} {
+		conn.readUntil(t, PresenceSnapshot)
+		conn.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+		conn.readUntil(t, "status")
+	}
+
+	const count = 50
+	for i := 0; i < count; i++ {
+		alice.WriteJSON(Message{Type: "group_chat", GroupID: 1, Content: strconv.Itoa(i)})
+	}
+	for i := 0; i < count; i++ {
+		if got := bob.readUntil(t, "group_chat"); got["content"] != strconv.Itoa(i) {
+			t.Fatalf("message %d: bob got %v", i, got["content"])
+		}
+	}
+
+	// Persistence goes through the usecase the hub was given
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mu.Lock()
+		persisted := append([]domain.Message(nil), store.messages...)
+		store.mu.Unlock()
+		if len(persisted) == count {
+			for i, m := range persisted {
+				var frame Message
+				json.Unmarshal([]byte(m.Content), &frame)
+				if frame.Content != strconv.Itoa(i) || m.GroupID != 1 {
+					t.Fatalf("persisted message %d = %+v", i, m)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("persisted %d messages, want %d", len(persisted), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestEmptyRoomStops checks that a room's actor stops and is unloaded once
+// its last member leaves, and that the room is loaded again on the next join.
+func TestEmptyRoomStops(t *testing.T) {
+	config := DefaultHubConfig()
+	config.ResumeWindow = 10 * time.Millisecond
+	hub, server := newTestServer(t, config)
+
+	alice := dial(t, server, "alice")
+	defer alice.Close()
+	alice.readUntil(t, PresenceSnapshot)
+	alice.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+	alice.readUntil(t, "status")
+	room, ok := hub.room(1)
+	if !ok {
+		t.Fatal("room 1 is not loaded")
+	}
+
+	if err := hub.RemoveMember(context.Background(), 1, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-room.stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("room 1 still running after its last member left")
+	}
+	if n := hub.RoomCount(); n != 0 {
+		t.Fatalf("%d rooms loaded after the last member left, want 0", n)
+	}
+
+	bob := dial(t, server, "bob")
+	defer bob.Close()
+	bob.readUntil(t, PresenceSnapshot)
+	for _, conn := range []*testConn{alice, bob} {
+		conn.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+		conn.readUntil(t, "status")
+	}
+	bob.WriteJSON(Message{Type: "group_chat", GroupID: 1, Content: "back again"})
+	if got := alice.readUntil(t, "group_chat"); got["content"] != "back again" {
+		t.Errorf("alice got %v", got)
+	}
+	reloaded, ok := hub.room(1)
+	if !ok || reloaded == room {
+		t.Fatal("room 1 was not loaded afresh")
+	}
+
+	alice.Close()
+	bob.Close()
+	select {
+	case <-reloaded.stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("room 1 still running after its members disconnected")
+	}
+	if n := hub.RoomCount(); n != 0 {
+		t.Errorf("%d rooms loaded after the members disconnected, want 0", n)
+	}
+}
+
+// TestStopEndsActors checks that Stop ends every session, and that rooms
+// answer nothing afterwards instead of blocking their callers.
+func TestStopEndsActors(t *testing.T) {
+	hub, server := newTestServer(t, DefaultHubConfig())
+
+	var clients []*Client
+	for i := 0; i < 5; i++ {
+		username := fmt.Sprintf("user-%d", i)
+		conn := dial(t, server, username)
+		defer conn.Close()
+		conn.readUntil(t, PresenceSnapshot)
+		conn.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+		conn.readUntil(t, "status")
+		client, _ := hub.Client(username)
+		clients = append(clients, client)
+	}
+	room, _ := hub.room(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, client := range clients {
+		select {
+		case <-client.exited:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("session of %s still running after Stop", client.Username)
+		}
+	}
+	if members := room.Members(); members != nil {
+		t.Errorf("room answered after Stop: %d members", len(members))
+	}
+}
+
+// BenchmarkHubGroupMessage measures a group message end to end: routed by the
+// hub, fanned out by the room and persisted through the usecase.
+func BenchmarkHubGroupMessage(b *testing.B) {
+	store := newFakeStore()
+	hub, server := newTestServerWithStore(b, DefaultHubConfig(), store)
+
+	alice, bob := dial(b, server, "alice"), dial(b, server, "bob")
+	defer alice.Close()
+	defer bob.Close()
+	for _, conn := range []*testConn{alice, bob} {
+		conn.readUntil(b, PresenceSnapshot)
+		conn.WriteJSON(Message{Type: "join_room", GroupID: 1, Content: "join"})
+		conn.readUntil(b, "status")
+	}
+
+	// Keep a bounded window in flight so bob never becomes a slow consumer
+	const window = 64
+	b.ResetTimer()
+	for sent := 0; sent < b.N; {
+		n := min(window, b.N-sent)
+		for i := 0; i < n; i++ {
+			alice.WriteJSON(Message{Type: "group_chat", GroupID: 1, Content: "hello"})
+		}
+		for i := 0; i < n; i++ {
+			bob.readUntil(b, "group_chat")
+		}
+		sent += n
+	}
+	for {
+		store.mu.Lock()
+		persisted := len(store.messages)
+		store.mu.Unlock()
+		if persisted == b.N {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+
+	if stats := hub.PersistStats(); stats.Dropped != 0 || stats.Failed != 0 {
+		b.Fatalf("lost writes: %+v", stats)
+	}
+}
+
+// TestPersisterNeverBlocks checks that queueing a write neither blocks on a
+// stuck database nor on a stopped hub, and that lost writes are counted.
+func TestPersisterNeverBlocks(t *testing.T) {
+	release := make(chan struct{})
+	var stored atomic.Uint64
+	p := newPersister(slog.New(slog.NewTextHandler(io.Discard, nil)), func(ctx context.Context, msgs []*domain.Message) error {
+		<-release
+		stored.Add(uint64(len(msgs)))
+		return nil
+	})
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		p.run(done)
+	}()
+
+	// More than fit in the queue, even if one batch is already stuck
+	ctx := context.Background()
+	const queued = persistQueueSize + maxPersistBatch + 10
+	for i := 0; i < queued; i++ {
+		p.enqueueMessage(ctx, &domain.Message{Content: strconv.Itoa(i)})
+	}
+	if p.dropped.Load() == 0 {
+		t.Error("nothing dropped with the queue full")
+	}
+
+	// Stopping flushes whatever was queued
+	close(done)
+	close(release)
+	<-exited
+	if got := stored.Load() + p.dropped.Load(); got != queued {
+		t.Errorf("stored %d and dropped %d of %d messages", stored.Load(), p.dropped.Load(), queued)
+	}
+
+	before := p.dropped.Load()
+	p.enqueue(ctx, func(ctx context.Context) error {
+		t.Error("job ran after the persister stopped")
+		return nil
+	})
+	if p.dropped.Load() != before+1 {
+		t.Error("job queued after the persister stopped was not counted as dropped")
+	}
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+)
+
+// Every inbound message, whether read from a socket or posted through
+// PostGroupMessage and PostPrivateMessage, passes through the hub's
+// interceptors before it is routed and persisted. HubConfig.Interceptors run
+// first, in order, followed by the built-in ones: requireContent, commands
+// and mutes. Whatever the last of them passes on is routed by type, see
+// routes in client.go.
+
+// Sources of inbound messages
+const (
+	// SourceSocket is a frame read from a client's connection
+	SourceSocket = "socket"
+	// SourcePost is a message posted through PostGroupMessage or
+	// PostPrivateMessage: over the REST API, by an incoming webhook or by a
+	// command
+	SourcePost = "post"
+)
+
+// Codes of the rejections made by the built-in interceptors
+const (
+	CodeContentRequired = "content_required"
+	CodeMuted           = "muted"
+)
+
+// Inbound is a message on its way from a client into the hub.
+type Inbound struct {
+	Message
+	Source string
+}
+
+type InboundHandler func(ctx context.Context, in *Inbound) error
+
+// Interceptor sees inbound messages before the hub does. It may validate
+// them and reject them by returning an error, usually a *RejectError;
+// transform or enrich them by changing Content and Meta before calling
+// next; or drop them by returning nil without calling next. The envelope,
+// From, Type, To and GroupID, is restored after the interceptors ran, so
+// they can't redirect a message.
+type Interceptor interface {
+	Intercept(ctx context.Context, in *Inbound, next InboundHandler) error
+}
+
+type InterceptorFunc func(ctx context.Context, in *Inbound, next InboundHandler) error
+
+func (f InterceptorFunc) Intercept(ctx context.Context, in *Inbound, next InboundHandler) error {
+	return f(ctx, in, next)
+}
+
+// RejectError rejects an inbound message. Socket clients are sent a status
+// frame with Code and Message; the REST API answers 422 with both.
+type RejectError struct {
+	Code    string
+	Message string
+	// Err, if set, is what the rejection unwraps to
+	Err error
+}
+
+func (e *RejectError) Error() string { return e.Message }
+
+func (e *RejectError) Unwrap() error { return e.Err }
+
+// Reject returns a *RejectError with code and message.
+func Reject(code, message string) error {
+	return &RejectError{Code: code, Message: message}
+}
+
+// intercept runs in through the interceptors and then handler.
+func (u *Hub) intercept(ctx context.Context, in *Inbound, handler InboundHandler) error {
+	envelope := in.Message
+	var next func(i int) InboundHandler
+	next = func(i int) InboundHandler {
+		if i == len(u.interceptors) {
+			return func(ctx context.Context, in *Inbound) error {
+				in.From, in.Type, in.To, in.GroupID = envelope.From, envelope.Type, envelope.To, envelope.GroupID
+				return handler(ctx, in)
+			}
+		}
+		return func(ctx context.Context, in *Inbound) error {
+			return u.interceptors[i].Intercept(ctx, in, next(i+1))
+		}
+	}
+	return next(0)(ctx, in)
+}
+
+func (u *Hub) builtinInterceptors() []Interceptor {
+	return []Interceptor{
+		InterceptorFunc(requireContent),
+		InterceptorFunc(u.commands),
+		InterceptorFunc(u.checkMute),
+	}
+}
+
+func requireContent(ctx context.Context, in *Inbound, next InboundHandler) error {
+	if in.Content == "" {
+		return Reject(CodeContentRequired, "Message content is required")
+	}
+	return next(ctx, in)
+}
+
+// commands hands chat frames starting with "/" to HubConfig.Commands.
+// Messages posted through the hub are taken as they are.
+func (u *Hub) commands(ctx context.Context, in *Inbound, next InboundHandler) error {
+	if in.Source != SourceSocket || !strings.HasPrefix(in.Content, "/") {
+		return next(ctx, in)
+	}
+	call := CommandCall{From: in.From, Content: in.Content}
+	switch {
+	case in.Type == FrameTypeGroupChat && in.GroupID != 0:
+		call.RoomID = in.GroupID
+	case in.Type == FrameTypePrivateChat && in.To != "":
+		call.To = in.To
+	default:
+		return next(ctx, in)
+	}
+	if u.config.Commands.Command(ctx, u, call) {
+		return nil
+	}
+	return next(ctx, in)
+}
+
+func (u *Hub) checkMute(ctx context.Context, in *Inbound, next InboundHandler) error {
+	if in.Type == FrameTypeGroupChat && u.Muted(in.GroupID, in.From) {
+		return &RejectError{Code: CodeMuted, Message: "You are muted in this room", Err: ErrMuted}
+	}
+	return next(ctx, in)
+}
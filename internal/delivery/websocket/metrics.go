@@ -0,0 +1,67 @@
+package websocket
+
+import "time"
+
+// Metrics receives instrumentation events from the hub and the WebSocket
+// handler. Implementations must be safe for concurrent use and must not
+// block. State the hub already tracks, such as connected clients, loaded
+// rooms and SlowConsumerStats, is exposed through accessors instead.
+type Metrics interface {
+	// FrameReceived is called for every frame read from a client. frameType
+	// is one of the FrameType constants.
+	FrameReceived(frameType string)
+	// UpgradeFailed is called when a connection attempt is rejected. reason
+	// is one of the UpgradeFailure constants.
+	UpgradeFailed(reason string)
+	// EventHandled reports how long a room or session loop took to handle
+	// one event. actor is "room" or "session".
+	EventHandled(actor string, d time.Duration)
+}
+
+// Frame types as reported to Metrics. Anything else a client sends is
+// reported as FrameTypeUnknown, so clients can't inflate label cardinality.
+const (
+	FrameTypeGroupChat   = "group_chat"
+	FrameTypePrivateChat = "private_chat"
+	FrameTypeCreateRoom  = "create_room"
+	FrameTypeJoinRoom    = "join_room"
+	FrameTypeUnknown     = "unknown"
+	FrameTypeInvalid     = "invalid"
+)
+
+const (
+	UpgradeFailureDraining        = "draining"
+	UpgradeFailureMissingUsername = "missing_username"
+	UpgradeFailureUnauthorized    = "unauthorized"
+	UpgradeFailureRegisterUser    = "register_user"
+	UpgradeFailureHandshake       = "handshake"
+	UpgradeFailureHubClosed       = "hub_closed"
+)
+
+func frameTypeLabel(frameType string) string {
+	switch frameType {
+	case FrameTypeGroupChat, FrameTypePrivateChat, FrameTypeCreateRoom, FrameTypeJoinRoom:
+		return frameType
+	default:
+		return FrameTypeUnknown
+	}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) FrameReceived(string)               {}
+func (nopMetrics) UpgradeFailed(string)               {}
+func (nopMetrics) EventHandled(string, time.Duration) {}
+
+// ClientCount returns the number of registered sessions, attached or not.
+func (u *Hub) ClientCount() int {
+	return u.countClients()
+}
+
+// RoomCount returns the number of room actors loaded.
+func (u *Hub) RoomCount() int {
+	u.roomsMu.RLock()
+	defer u.roomsMu.RUnlock()
+
+	return len(u.rooms)
+}
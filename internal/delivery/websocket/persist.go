@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"websocket_try3/internal/domain"
+)
+
+const (
+	persistQueueSize = 1024
+	// maxPersistBatch bounds the messages stored with one StoreMessages call
+	maxPersistBatch = 256
+)
+
+// persister runs database writes in order on a single background goroutine so
+// that fan-out never waits on Postgres. Jobs run detached from the session
+// that queued them: a message that was delivered is persisted even if its
+// sender has disconnected since, and the usecase bounds every job with its
+// own timeout. A job does keep the values of the context it was queued
+// under, so it stays part of the same trace.
+//
+// Messages are stored without the usecase's lookups: the hub only routes
+// messages from registered users to loaded rooms and connected users, so
+// they are already valid, and consecutive ones are stored together.
+//
+// Queueing never blocks an actor. Jobs that don't fit in the queue, or that
+// arrive after the hub stopped and the queue was flushed, are dropped and
+// counted, see PersistStats.
+//
+// When the app stores messages through a repository.MessageWriter, this
+// queue sits in front of the writer's own: the persister hands each batch to
+// the writer, which queues, batches and retries the messages again. The two
+// queues have separate limits and drop paths. Writes lost here are counted
+// in chat_hub_writes_lost_total, messages the writer loses in
+// chat_persistence_messages_total; a message the writer refuses because its
+// queue is full shows up in both, as failed here.
+type persister struct {
+	jobs chan persistJob
+	// store saves a batch of messages, see WebSocketUsecase.StoreMessages
+	store func(ctx context.Context, msgs []*domain.Message) error
+	log   *slog.Logger
+
+	// mu guards closed, so nothing is queued once run has flushed the queue
+	mu     sync.RWMutex
+	closed bool
+
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// persistJob is either a message to store or another write to run.
+type persistJob struct {
+	ctx     context.Context
+	message *domain.Message
+	run     func(ctx context.Context) error
+}
+
+// PersistStats counts the writes the hub lost.
+type PersistStats struct {
+	// Dropped writes were never attempted: the queue was full or the hub
+	// had stopped
+	Dropped uint64
+	// Failed writes were attempted but returned an error
+	Failed uint64
+}
+
+func newPersister(log *slog.Logger, store func(ctx context.Context, msgs []*domain.Message) error) *persister {
+	return &persister{
+		jobs:  make(chan persistJob, persistQueueSize),
+		store: store,
+		log:   log,
+	}
+}
+
+// PersistStats returns the writes lost so far.
+func (u *Hub) PersistStats() PersistStats {
+	return PersistStats{
+		Dropped: u.persister.dropped.Load(),
+		Failed:  u.persister.failed.Load(),
+	}
+}
+
+func (p *persister) enqueue(ctx context.Context, job func(ctx context.Context) error) {
+	p.push(persistJob{ctx: context.WithoutCancel(ctx), run: job})
+}
+
+func (p *persister) enqueueMessage(ctx context.Context, msg *domain.Message) {
+	p.push(persistJob{ctx: context.WithoutCancel(ctx), message: msg})
+}
+
+func (p *persister) push(job persistJob) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.drop(job, "hub stopped")
+		return
+	}
+	select {
+	case p.jobs <- job:
+	default:
+		p.drop(job, "queue full")
+	}
+}
+
+func (p *persister) drop(job persistJob, reason string) {
+	p.dropped.Add(1)
+	p.log.WarnContext(job.ctx, "persist dropped", "reason", reason)
+}
+
+func (p *persister) run(done <-chan struct{}) {
+	for {
+		select {
+		case job := <-p.jobs:
+			p.exec(job)
+		case <-done:
+			p.mu.Lock()
+			p.closed = true
+			p.mu.Unlock()
+
+			// Nothing can be queued any more, so draining until empty is final
+			for {
+				select {
+				case job := <-p.jobs:
+					p.exec(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// exec runs job. A message is stored together with the messages queued
+// right behind it.
+func (p *persister) exec(job persistJob) {
+	if job.message == nil {
+		if err := job.run(job.ctx); err != nil {
+			p.failed.Add(1)
+			p.log.ErrorContext(job.ctx, "persist failed", "err", err)
+		}
+		return
+	}
+
+	batch := []*domain.Message{job.message}
+	var next *persistJob
+collect:
+	for len(batch) < maxPersistBatch {
+		select {
+		case queued := <-p.jobs:
+			if queued.message == nil {
+				next = &queued
+				break collect
+			}
+			batch = append(batch, queued.message)
+		default:
+			break collect
+		}
+	}
+
+	if err := p.store(job.ctx, batch); err != nil {
+		p.failed.Add(uint64(len(batch)))
+		p.log.ErrorContext(job.ctx, "persist failed", "messages", len(batch), "err", err)
+	}
+	if next != nil {
+		p.exec(*next)
+	}
+}
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"websocket_try3/internal/domain"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Messages posted over HTTP are validated synchronously, so the caller learns
+// about unknown rooms and users, rejections and a full write queue, and then
+// delivered like frames read from a socket. They are written behind, through
+// the usecase's message repository: an accepted message can still be lost if
+// its write fails for good, which only the message writer's stats show. They
+// have no originating connection, so unlike socket frames they are also
+// delivered to the sender's own session.
+
+// PostGroupMessage queues a group message from from for storage and
+// delivers it to every online member of roomID, once it made it through the
+// interceptors, and returns it as delivered. The caller is responsible for checking that from
+// is a member. It fails with a *RejectError if the message was rejected,
+// which unwraps to ErrMuted if from is muted in the room.
+func (u *Hub) PostGroupMessage(ctx context.Context, from string, roomID int, content string) (Message, error) {
+	ctx, span := tracer.Start(ctx, "hub.post_group_message", trace.WithAttributes(
+		attribute.Int("chat.room.id", roomID),
+	))
+	defer span.End()
+
+	in := &Inbound{
+		Message: Message{
+			From:    from,
+			To:      "general",
+			Type:    FrameTypeGroupChat,
+			Content: content,
+			GroupID: roomID,
+		},
+		Source: SourcePost,
+	}
+	err := u.intercept(ctx, in, func(ctx context.Context, in *Inbound) error {
+		frame, err := json.Marshal(in.Message)
+		if err != nil {
+			return err
+		}
+		if err := u.usecase.SendGroupMessage(ctx, from, roomID, string(frame)); err != nil {
+			return err
+		}
+		u.config.Events.RoomEvent(ctx, roomID, domain.EventMessagePosted, in.Message)
+
+		// Online members load all their rooms, so an unloaded room has no one
+		// to deliver to
+		if room, ok := u.room(roomID); ok {
+			room.broadcast(&GroupMessage{
+				Room:    room,
+				Content: frame,
+				ctx:     context.WithoutCancel(ctx),
+				stored:  true,
+			})
+		}
+		return nil
+	})
+	return in.Message, err
+}
+
+// PostPrivateMessage queues a private message from from to to for storage
+// and delivers
+// it to both of their sessions, if online, like PostGroupMessage.
+func (u *Hub) PostPrivateMessage(ctx context.Context, from, to, content string) (Message, error) {
+	ctx, span := tracer.Start(ctx, "hub.post_private_message")
+	defer span.End()
+
+	in := &Inbound{
+		Message: Message{
+			From:    from,
+			To:      to,
+			Type:    FrameTypePrivateChat,
+			Content: content,
+		},
+		Source: SourcePost,
+	}
+	err := u.intercept(ctx, in, func(ctx context.Context, in *Inbound) error {
+		frame, err := json.Marshal(in.Message)
+		if err != nil {
+			return err
+		}
+		if err := u.usecase.SendPrivateMessage(ctx, from, to, string(frame)); err != nil {
+			return err
+		}
+
+		if recipient, ok := u.Client(to); ok {
+			recipient.addContact(from)
+			u.send(ctx, recipient, frame)
+		}
+		if sender, ok := u.Client(from); ok && from != to {
+			sender.addContact(to)
+			u.send(ctx, sender, frame)
+		}
+		return nil
+	})
+	return in.Message, err
+}
@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Presence frames. Clients get one snapshot on connect and then only deltas,
+// scoped to the users they share a room with or have private contact with.
+// presence_joined may be delivered more than once for the same user and must
+// be handled idempotently.
+const (
+	PresenceSnapshot = "presence_snapshot"
+	PresenceJoined   = "presence_joined"
+	PresenceLeft     = "presence_left"
+)
+
+type PresenceEvent struct {
+	Type     string `json:"type"`
+	Username string `json:"username"`
+}
+
+type PresenceSnapshotEvent struct {
+	Type        string   `json:"type"`
+	OnlineUsers []string `json:"online_users"`
+}
+
+// presenceAudience returns the online clients that are interested in the
+// presence of u: members of its rooms and its private contacts. It must be
+// called from u's session goroutine.
+func (u *Client) presenceAudience() map[*Client]bool {
+	audience := make(map[*Client]bool)
+
+	for _, room := range u.rooms {
+		for _, member := range room.Members() {
+			if member != u {
+				audience[member] = true
+			}
+		}
+	}
+
+	for _, contact := range u.contactList() {
+		if other, ok := u.Hub.Client(contact); ok && other != u {
+			audience[other] = true
+		}
+	}
+
+	return audience
+}
+
+// sendPresenceSnapshot sends u the list of online users in its audience.
+func (u *Client) sendPresenceSnapshot(ctx context.Context, audience map[*Client]bool) {
+	onlineUsers := []string{}
+	for member := range audience {
+		onlineUsers = append(onlineUsers, member.Username)
+	}
+
+	snapshot, err := json.Marshal(PresenceSnapshotEvent{
+		Type:        PresenceSnapshot,
+		OnlineUsers: onlineUsers,
+	})
+	if err != nil {
+		u.log.ErrorContext(ctx, "marshal presence snapshot", "err", err)
+		return
+	}
+
+	u.Hub.send(ctx, u, snapshot)
+}
+
+// broadcastPresence sends a single presence delta about username to audience.
+func (u *Hub) broadcastPresence(ctx context.Context, eventType, username string, audience map[*Client]bool) {
+	event, err := json.Marshal(PresenceEvent{
+		Type:     eventType,
+		Username: username,
+	})
+	if err != nil {
+		u.config.Logger.ErrorContext(ctx, "marshal presence event", "err", err)
+		return
+	}
+
+	for client := range audience {
+		u.send(ctx, client, event)
+	}
+}
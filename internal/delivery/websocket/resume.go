@@ -0,0 +1,249 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session resumption
+//
+// Every frame the hub sends to a user goes through Hub.send, which stamps it
+// with a per-session, monotonically increasing "seq" field and keeps the last
+// HubConfig.ResumeBufferSize frames in the session's journal. Sequence
+// numbers are not per user: a new session, announced by a session frame with
+// a new session ID, starts again from 1. Keeping them per user would mean
+// remembering every user that ever connected. When the
+// connection drops, the session is detached rather than ended: it stays in
+// its rooms and keeps recording frames for HubConfig.ResumeWindow.
+//
+// The first frame on every connection is a session frame carrying the
+// session ID and the last sequence number assigned. A client that reconnects
+// with /ws?username=..&session_id=..&last_seq=N gets every frame after N
+// replayed with its original seq. If the session has expired or the frames
+// after N have been evicted from the journal, the session frame has resync
+// set and the client should reload its state from scratch.
+
+var ErrSessionNotFound = errors.New("session not found")
+
+const CloseReasonSessionResumed = "session_resumed"
+
+type SessionMessage struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	Seq       uint64 `json:"seq"`
+	Resumed   bool   `json:"resumed"`
+	Resync    bool   `json:"resync"`
+}
+
+type journalEntry struct {
+	seq   uint64
+	frame []byte
+}
+
+// journal is a bounded ring of the most recent frames sent to a session. It
+// is guarded by the owning Client's sendMu.
+type journal struct {
+	seq     uint64
+	entries []journalEntry
+	start   int
+	count   int
+}
+
+func newJournal(size int) *journal {
+	return &journal{entries: make([]journalEntry, size)}
+}
+
+// append assigns the next sequence number to frame, records it and returns
+// the stamped frame.
+func (j *journal) append(frame []byte) []byte {
+	j.seq++
+	frame = stampSeq(j.seq, frame)
+
+	if len(j.entries) == 0 {
+		return frame
+	}
+	if j.count == len(j.entries) {
+		j.start = (j.start + 1) % len(j.entries)
+		j.count--
+	}
+	j.entries[(j.start+j.count)%len(j.entries)] = journalEntry{seq: j.seq, frame: frame}
+	j.count++
+	return frame
+}
+
+// since returns the frames after seq. It reports false if some of them have
+// already been evicted, or seq is ahead of the journal.
+func (j *journal) since(seq uint64) ([][]byte, bool) {
+	if seq > j.seq {
+		return nil, false
+	}
+	oldest := j.seq - uint64(j.count) + 1
+	if seq+1 < oldest {
+		return nil, false
+	}
+
+	frames := make([][]byte, 0, j.seq-seq)
+	for i := 0; i < j.count; i++ {
+		entry := j.entries[(j.start+i)%len(j.entries)]
+		if entry.seq > seq {
+			frames = append(frames, entry.frame)
+		}
+	}
+	return frames, true
+}
+
+// stampSeq adds a "seq" field to a JSON object frame.
+func stampSeq(seq uint64, frame []byte) []byte {
+	if len(frame) < 2 || frame[0] != '{' {
+		return frame
+	}
+
+	stamped := make([]byte, 0, len(frame)+24)
+	stamped = append(stamped, `{"seq":`...)
+	stamped = strconv.AppendUint(stamped, seq, 10)
+	if frame[1] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, frame[1:]...)
+}
+
+// unstampSeq removes the "seq" field stampSeq added, so the frame can be
+// stamped again by another session.
+func unstampSeq(frame []byte) []byte {
+	const prefix = `{"seq":`
+	if !bytes.HasPrefix(frame, []byte(prefix)) {
+		return frame
+	}
+	i := len(prefix)
+	for i < len(frame) && frame[i] >= '0' && frame[i] <= '9' {
+		i++
+	}
+	if i < len(frame) && frame[i] == ',' {
+		i++
+	}
+	return append([]byte{'{'}, frame[i:]...)
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionMessage is the unsequenced frame that opens every connection. The
+// caller must hold u.sendMu.
+func (u *Client) sessionMessage(resumed, resync bool) []byte {
+	msg, _ := json.Marshal(SessionMessage{
+		Type:      "session",
+		SessionID: u.sessionID,
+		Seq:       u.journal.seq,
+		Resumed:   resumed,
+		Resync:    resync,
+	})
+	return msg
+}
+
+type attachRequest struct {
+	ws      *websocket.Conn
+	lastSeq uint64
+	result  chan bool
+}
+
+// Resume attaches ws to the existing session for username if sessionID
+// matches, replaying every frame after lastSeq. It returns
+// ErrSessionNotFound if the session is unknown or has ended, in which case
+// the caller should start a fresh session.
+func (u *Hub) Resume(username, sessionID string, lastSeq uint64, ws *websocket.Conn) error {
+	shard := u.shard(username)
+	shard.mu.Lock()
+	if u.draining.Load() {
+		shard.mu.Unlock()
+		return ErrHubClosed
+	}
+	client, ok := shard.clients[username]
+	if !ok || client.sessionID != sessionID {
+		shard.mu.Unlock()
+		return ErrSessionNotFound
+	}
+	u.conns.Add(1)
+	shard.mu.Unlock()
+
+	req := &attachRequest{
+		ws:      ws,
+		lastSeq: lastSeq,
+		result:  make(chan bool, 1),
+	}
+	if !client.post(req) {
+		u.conns.Done()
+		return ErrSessionNotFound
+	}
+
+	select {
+	case ok = <-req.result:
+	case <-client.exited:
+		// The session may have attached just before it exited
+		select {
+		case ok = <-req.result:
+		default:
+			ok = false
+		}
+	}
+	if !ok {
+		u.conns.Done()
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// detach drops conn from the session if it is still the attached one.
+func (u *Client) detach(conn *connection) bool {
+	u.sendMu.Lock()
+	defer u.sendMu.Unlock()
+
+	if u.conn != conn {
+		return false
+	}
+	conn.close(0, "")
+	u.conn = nil
+	return true
+}
+
+// handleAttach swaps in the new connection and replays what it missed. Any
+// connection still attached is closed first.
+func (u *Client) handleAttach(req *attachRequest) {
+	u.sendMu.Lock()
+
+	if u.ended {
+		u.sendMu.Unlock()
+		req.result <- false
+		return
+	}
+	if u.conn != nil {
+		u.conn.close(websocket.CloseNormalClosure, CloseReasonSessionResumed)
+	}
+
+	frames, ok := u.journal.since(req.lastSeq)
+	conn := newConnection(u.ctx, u.log, req.ws, u.Hub.config.SendBufferSize+len(frames)+1)
+	conn.send <- outbound{data: u.sessionMessage(true, !ok)}
+	for _, frame := range frames {
+		conn.send <- outbound{data: frame}
+	}
+
+	u.conn = conn
+	u.gap = 0
+	u.spilling = false
+	// Anything spilled is either in the replay or covered by the resync
+	u.Hub.offline.pop(u.Username, u.Hub.config.OfflineQueueSize)
+	u.sendMu.Unlock()
+
+	conn.log.Info("session resumed", "replayed", len(frames), "resync", !ok)
+
+	req.result <- true
+	u.Hub.startPumps(u, conn)
+}
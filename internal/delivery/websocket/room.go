@@ -0,0 +1,235 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+	"websocket_try3/internal/domain"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errRoomStopped is returned by join on a room actor that has stopped.
+var errRoomStopped = errors.New("room stopped")
+
+// Room is an actor: its member set is only touched by the room's own
+// goroutine, and everything else talks to it through its mailboxes. The actor
+// stops once its last member left, see retire; the room is loaded again by
+// the next join.
+type Room struct {
+	ID   int
+	Name string
+
+	hub      *Hub
+	clients  map[*Client]bool
+	joins    chan *roomJoin
+	leaves   chan *Client
+	messages chan *GroupMessage
+	queries  chan chan []*Client
+	// stopped is closed when the actor stops
+	stopped chan struct{}
+}
+
+type roomJoin struct {
+	ctx      context.Context
+	client   *Client
+	announce bool
+	result   chan roomJoinResult
+}
+
+type roomJoinResult struct {
+	alreadyMember bool
+	members       []*Client
+}
+
+func newRoom(hub *Hub, id int, name string) *Room {
+	return &Room{
+		ID:       id,
+		Name:     name,
+		hub:      hub,
+		clients:  make(map[*Client]bool),
+		joins:    make(chan *roomJoin),
+		leaves:   make(chan *Client),
+		messages: make(chan *GroupMessage, 256),
+		queries:  make(chan chan []*Client),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func (r *Room) run() {
+	metrics := r.hub.config.Metrics
+	for {
+		select {
+		case req := <-r.joins:
+			start := time.Now()
+			r.handleJoin(req)
+			metrics.EventHandled("room", time.Since(start))
+
+		case client := <-r.leaves:
+			if _, ok := r.clients[client]; ok {
+				delete(r.clients, client)
+				client.log.Debug("left room", "room_id", r.ID)
+			}
+
+		case msg := <-r.messages:
+			start := time.Now()
+			r.handleMessage(msg)
+			metrics.EventHandled("room", time.Since(start))
+
+		case reply := <-r.queries:
+			reply <- r.members()
+
+		case <-r.hub.done:
+			return
+		}
+
+		if len(r.clients) == 0 && r.retire() {
+			return
+		}
+	}
+}
+
+// retire stops the empty room, unless messages are still queued for it. It
+// holds the hub's room lock, so no loadRoom can hand the room out any more
+// once it is removed; callers that already hold it see stopped closed and
+// load the room afresh.
+func (r *Room) retire() bool {
+	r.hub.roomsMu.Lock()
+	defer r.hub.roomsMu.Unlock()
+
+	if len(r.messages) > 0 {
+		return false
+	}
+	if r.hub.rooms[r.ID] == r {
+		delete(r.hub.rooms, r.ID)
+	}
+	close(r.stopped)
+	return true
+}
+
+// join adds client to the room and returns the members that were already in
+// it. When announce is set the other members are told about the newcomer.
+// It fails with errRoomStopped if the actor has stopped, see Hub.joinRoom,
+// and with ErrHubClosed if the hub has.
+func (r *Room) join(ctx context.Context, client *Client, announce bool) (roomJoinResult, error) {
+	req := &roomJoin{
+		ctx:      ctx,
+		client:   client,
+		announce: announce,
+		result:   make(chan roomJoinResult, 1),
+	}
+	select {
+	case r.joins <- req:
+	case <-r.stopped:
+		return roomJoinResult{}, errRoomStopped
+	case <-r.hub.done:
+		return roomJoinResult{}, ErrHubClosed
+	}
+	return <-req.result, nil
+}
+
+// leave removes client from the room. A stopped room has no members left to
+// remove.
+func (r *Room) leave(client *Client) {
+	select {
+	case r.leaves <- client:
+	case <-r.stopped:
+	case <-r.hub.done:
+	}
+}
+
+// broadcast queues msg for fan-out to the room. It never waits on the room
+// goroutine for longer than it takes to enqueue. A stopped room has no one
+// to deliver to, so msg is dropped.
+func (r *Room) broadcast(msg *GroupMessage) {
+	select {
+	case <-r.stopped:
+		return
+	default:
+	}
+	select {
+	case r.messages <- msg:
+	case <-r.stopped:
+	case <-r.hub.done:
+	}
+}
+
+// Members returns a snapshot of the clients currently in the room.
+func (r *Room) Members() []*Client {
+	reply := make(chan []*Client, 1)
+	select {
+	case r.queries <- reply:
+	case <-r.stopped:
+		return nil
+	case <-r.hub.done:
+		return nil
+	}
+	return <-reply
+}
+
+func (r *Room) members() []*Client {
+	members := make([]*Client, 0, len(r.clients))
+	for client := range r.clients {
+		members = append(members, client)
+	}
+	return members
+}
+
+func (r *Room) handleJoin(req *roomJoin) {
+	if _, ok := r.clients[req.client]; ok {
+		req.result <- roomJoinResult{alreadyMember: true}
+		return
+	}
+
+	members := r.members()
+	r.clients[req.client] = true
+	req.result <- roomJoinResult{members: members}
+
+	if !req.announce {
+		return
+	}
+
+	notice := statusMessage(req.client.Username + " Connected. Total Members: " + strconv.Itoa(len(r.clients)))
+	for _, client := range members {
+		if !r.hub.send(req.ctx, client, notice) {
+			delete(r.clients, client)
+		}
+	}
+}
+
+func (r *Room) handleMessage(msg *GroupMessage) {
+	ctx, span := tracer.Start(msg.ctx, "room.fanout", trace.WithAttributes(
+		attribute.Int("chat.room.id", r.ID),
+		attribute.Int("chat.room.members", len(r.clients)),
+	))
+	defer span.End()
+
+	if msg.From != nil && !r.clients[msg.From] {
+		r.hub.send(ctx, msg.From, statusMessage("Group not found"))
+		return
+	}
+
+	for client := range r.clients {
+		if client == msg.From {
+			continue
+		}
+		if !r.hub.send(ctx, client, msg.Content) {
+			delete(r.clients, client)
+		}
+	}
+
+	if msg.stored {
+		return
+	}
+	r.hub.config.Events.RoomEvent(ctx, r.ID, domain.EventMessagePosted, messageEvent(msg.Content))
+
+	r.hub.persister.enqueueMessage(ctx, &domain.Message{
+		From:      msg.From.Username,
+		Content:   string(msg.Content),
+		Type:      "group",
+		GroupID:   r.ID,
+		CreatedAt: time.Now(),
+	})
+}
@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	"context"
+	"time"
+	"websocket_try3/internal/domain"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A session is the per-user actor. readPump decodes frames and posts them to
+// the session's mailbox; the session goroutine performs the slow work (loading
+// rooms, creating rooms, looking up recipients) so that one user's requests
+// never hold up anybody else. A session survives its connection for
+// HubConfig.ResumeWindow, see resume.go.
+
+// disconnect is posted by readPump when its connection goes away.
+type disconnect struct {
+	conn *connection
+}
+
+// superseded is posted when a fresh session for the same user registers.
+type superseded struct{}
+
+// post queues an event for the session goroutine. It reports false if the
+// session has already exited.
+func (u *Client) post(event any) bool {
+	select {
+	case u.events <- event:
+		return true
+	case <-u.exited:
+		return false
+	case <-u.Hub.done:
+		return false
+	}
+}
+
+func (u *Client) run() {
+	defer close(u.exited)
+
+	u.load()
+
+	// expiry is armed while the session is detached
+	var expiry <-chan time.Time
+	var timer *time.Timer
+
+	for {
+		select {
+		case event := <-u.events:
+			start := time.Now()
+			switch event := event.(type) {
+			case *PrivateMessage:
+				u.handlePrivateMessage(event)
+			case *CreateRoomRequest:
+				u.handleCreateRoom(event)
+			case *JoinRoomRequest:
+				u.handleJoinRoom(event)
+			case *leaveRoomRequest:
+				u.handleLeaveRoom(event)
+			case *attachRequest:
+				if timer != nil {
+					timer.Stop()
+					timer, expiry = nil, nil
+				}
+				u.handleAttach(event)
+			case disconnect:
+				if u.detach(event.conn) && timer == nil {
+					u.log.Info("client detached", "resumable_for", u.Hub.config.ResumeWindow)
+					timer = time.NewTimer(u.Hub.config.ResumeWindow)
+					expiry = timer.C
+				}
+			case superseded:
+				u.handleDisconnect()
+				return
+			}
+			u.Hub.config.Metrics.EventHandled("session", time.Since(start))
+
+		case <-expiry:
+			u.handleDisconnect()
+			return
+
+		case <-u.Hub.done:
+			return
+		}
+	}
+}
+
+// load joins the user to the rooms it is a member of and announces it online.
+// It runs under the session context, so it is abandoned if the session ends
+// before the database answers.
+func (u *Client) load() {
+	ctx, span := tracer.Start(u.ctx, "session.load")
+	defer span.End()
+
+	rooms, err := u.Hub.usecase.ListUserRooms(ctx, u.Username)
+	if err != nil {
+		u.log.ErrorContext(ctx, "load rooms", "err", err)
+	}
+	for _, info := range rooms {
+		if room, _, ok := u.Hub.joinRoom(ctx, info.ID, info.Name, u, false); ok {
+			u.rooms[room.ID] = room
+		}
+	}
+
+	contacts, err := u.Hub.usecase.ListContacts(ctx, u.Username)
+	if err != nil {
+		u.log.ErrorContext(ctx, "load contacts", "err", err)
+	}
+	for _, contact := range contacts {
+		u.addContact(contact)
+	}
+
+	audience := u.presenceAudience()
+	u.sendPresenceSnapshot(ctx, audience)
+	u.Hub.broadcastPresence(ctx, PresenceJoined, u.Username, audience)
+}
+
+// handleDisconnect ends the session.
+func (u *Client) handleDisconnect() {
+	u.sendMu.Lock()
+	u.ended = true
+	if u.conn != nil {
+		u.conn.close(0, "")
+	}
+	u.sendMu.Unlock()
+	u.cancel()
+
+	audience := u.presenceAudience()
+
+	for id, room := range u.rooms {
+		room.leave(u)
+		delete(u.rooms, id)
+	}
+
+	if u.Hub.removeClient(u) {
+		u.log.Info("client disconnected")
+		u.Hub.broadcastPresence(context.Background(), PresenceLeft, u.Username, audience)
+	}
+}
+
+func (u *Client) handlePrivateMessage(msg *PrivateMessage) {
+	ctx, span := tracer.Start(msg.ctx, "session.private_chat")
+	defer span.End()
+
+	recipient, ok := u.Hub.Client(msg.To)
+	if !ok || !u.Hub.send(ctx, recipient, msg.Content) {
+		u.Hub.send(ctx, u, statusMessage("User "+msg.To+" is not found or not connected"))
+		return
+	}
+
+	u.addContact(msg.To)
+	recipient.addContact(u.Username)
+
+	u.Hub.persister.enqueueMessage(ctx, &domain.Message{
+		From:      u.Username,
+		To:        msg.To,
+		Content:   string(msg.Content),
+		Type:      "private",
+		CreatedAt: time.Now(),
+	})
+
+	u.Hub.send(ctx, u, statusMessage("Message delivered to "+msg.To))
+}
+
+func (u *Client) handleCreateRoom(req *CreateRoomRequest) {
+	ctx, span := tracer.Start(req.ctx, "session.create_room")
+	defer span.End()
+
+	info, err := u.Hub.usecase.CreateRoom(ctx, req.Name, u.Username)
+	if err != nil {
+		u.log.ErrorContext(ctx, "create room", "room_name", req.Name, "err", err)
+		u.Hub.send(ctx, u, statusMessage("Failed to create room "+req.Name))
+		return
+	}
+
+	if room, _, ok := u.Hub.joinRoom(ctx, info.ID, info.Name, u, false); ok {
+		u.rooms[room.ID] = room
+	}
+
+	u.log.InfoContext(ctx, "room created", "room_id", info.ID, "room_name", req.Name)
+
+	u.Hub.send(ctx, u, statusMessage("Room "+req.Name+" created"))
+}
+
+func (u *Client) handleJoinRoom(req *JoinRoomRequest) {
+	ctx, span := tracer.Start(req.ctx, "session.join_room", trace.WithAttributes(
+		attribute.Int("chat.room.id", req.GroupID),
+	))
+	defer span.End()
+
+	var name string
+	if room, ok := u.Hub.room(req.GroupID); ok {
+		name = room.Name
+	} else {
+		info, err := u.Hub.usecase.GetRoom(ctx, req.GroupID)
+		if err != nil || info == nil {
+			u.Hub.send(ctx, u, statusMessage("Room not found"))
+			return
+		}
+		name = info.Name
+	}
+
+	// Only members that could not already see each other get a presence delta
+	audience := u.presenceAudience()
+
+	room, result, ok := u.Hub.joinRoom(ctx, req.GroupID, name, u, true)
+	if !ok {
+		return
+	}
+	if result.alreadyMember {
+		u.Hub.send(ctx, u, statusMessage("You are already in this room"))
+		return
+	}
+	u.rooms[room.ID] = room
+
+	if !req.stored {
+		roomID, username := room.ID, u.Username
+		u.Hub.persister.enqueue(ctx, func(ctx context.Context) error {
+			return u.Hub.usecase.AddRoomMember(ctx, roomID, username)
+		})
+		u.Hub.config.Events.RoomEvent(ctx, room.ID, domain.EventMemberJoined, MemberEvent{Username: u.Username})
+	}
+
+	u.Hub.send(ctx, u, statusMessage("You're joining "+room.Name))
+
+	newAudience := make(map[*Client]bool)
+	for _, member := range result.members {
+		if !audience[member] {
+			newAudience[member] = true
+		}
+	}
+	u.Hub.broadcastPresence(ctx, PresenceJoined, u.Username, newAudience)
+	for member := range newAudience {
+		u.Hub.broadcastPresence(ctx, PresenceJoined, member.Username, map[*Client]bool{u: true})
+	}
+}
+
+func (u *Client) handleLeaveRoom(req *leaveRoomRequest) {
+	ctx, span := tracer.Start(req.ctx, "session.leave_room", trace.WithAttributes(
+		attribute.Int("chat.room.id", req.GroupID),
+	))
+	defer span.End()
+
+	room, ok := u.rooms[req.GroupID]
+	if !ok {
+		return
+	}
+	room.leave(u)
+	delete(u.rooms, room.ID)
+
+	u.Hub.send(ctx, u, statusMessage("You were removed from "+room.Name))
+}
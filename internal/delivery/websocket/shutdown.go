@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrHubClosed = errors.New("hub is shutting down")
+
+const CloseReasonGoingAway = "server_going_away"
+
+// GoingAwayMessage is the last frame a client receives before the server
+// closes the connection with websocket.CloseGoingAway during a shutdown.
+type GoingAwayMessage struct {
+	Type             string `json:"type"`
+	ReconnectAfterMs int64  `json:"reconnect_after_ms"`
+}
+
+// Accepting reports whether the hub still accepts new connections.
+func (u *Hub) Accepting() bool {
+	return !u.draining.Load()
+}
+
+// Stop drains the hub: new registrations are refused, every client is sent a
+// server_going_away frame and closed with 1001, queued database writes are
+// flushed and Stop waits for every connection to finish writing, after which
+// Ping fails. It returns ctx.Err() if that doesn't happen before ctx expires.
+func (u *Hub) Stop(ctx context.Context) error {
+	u.stopOnce.Do(func() {
+		u.draining.Store(true)
+		close(u.Shutdown)
+	})
+
+	select {
+	case <-u.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	conns := make(chan struct{})
+	go func() {
+		u.conns.Wait()
+		u.haltOnce.Do(func() { close(u.halted) })
+		close(conns)
+	}()
+
+	select {
+	case <-conns:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// goAway tells every registered client to reconnect elsewhere and closes it.
+func (u *Hub) goAway() {
+	u.draining.Store(true)
+
+	total := 0
+	for _, shard := range u.shards {
+		shard.mu.Lock()
+		for username, client := range shard.clients {
+			u.sendGoingAway(client)
+			delete(shard.clients, username)
+			total++
+		}
+		shard.mu.Unlock()
+	}
+
+	u.config.Logger.Info("sent "+CloseReasonGoingAway, "clients", total)
+}
+
+func (u *Hub) sendGoingAway(client *Client) {
+	// Spread reconnects out so replicas aren't hit by every client at once
+	hint := rand.Int63n(u.config.ReconnectHint.Milliseconds() + 1)
+	frame, _ := json.Marshal(GoingAwayMessage{
+		Type:             CloseReasonGoingAway,
+		ReconnectAfterMs: hint,
+	})
+
+	client.sendMu.Lock()
+	defer client.sendMu.Unlock()
+
+	client.ended = true
+	conn := client.conn
+	if conn == nil || conn.closed {
+		return
+	}
+	// Bypass the slow-consumer policy: if there is no room the close frame
+	// alone still carries the reason
+	select {
+	case conn.send <- outbound{data: frame}:
+	default:
+	}
+	conn.close(websocket.CloseGoingAway, CloseReasonGoingAway)
+}
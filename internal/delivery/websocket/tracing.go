@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A frame's trace starts in handleFrame and follows it through the session
+// or room that handles it, the usecase and the persister. Every frame queued
+// by Hub.send carries the span of the context it was sent under, and
+// writePump records the write as a child of that span.
+
+var tracer = otel.Tracer("websocket_try3/internal/delivery/websocket")
+
+// traceWrite records one write, started at start, of frames sent under the
+// given spans.
+func (u *Client) traceWrite(spans []trace.SpanContext, start time.Time, err error) {
+	for _, parent := range spans {
+		_, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), parent), "ws.write",
+			trace.WithTimestamp(start),
+			trace.WithSpanKind(trace.SpanKindServer),
+		)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
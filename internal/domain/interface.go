@@ -1,23 +1,85 @@
 package domain
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConstraint is wrapped by the errors repositories return for writes that
+// violate a constraint, such as a duplicate key or a reference to a row that
+// doesn't exist. Retrying such a write cannot succeed.
+var ErrConstraint = errors.New("constraint violation")
+
 type UserRepository interface {
-	Save(user *User) error
-	FindByUsername(username string) (*User, error)
-	FindAll() ([]User, error)
+	Save(ctx context.Context, user *User) error
+	FindByUsername(ctx context.Context, username string) (*User, error)
+	FindAll(ctx context.Context) ([]User, error)
+}
+
+// BotRepository stores bots and their API keys. Users found through
+// UserRepository have Bot set if they were saved with SaveBot.
+type BotRepository interface {
+	// SaveBot saves bot.Username as a new user that is a bot. If the
+	// username is taken the error wraps ErrConstraint.
+	SaveBot(ctx context.Context, bot *Bot) error
+	FindBot(ctx context.Context, username string) (*Bot, error)
+	GetOwnedBots(ctx context.Context, owner string) ([]Bot, error)
+
+	SaveAPIKey(ctx context.Context, key *APIKey) error
+	// FindAPIKeyByHash finds revoked keys too
+	FindAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context, username string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int, revokedAt time.Time) error
 }
 
 type MessageRepository interface {
-	SavePrivateMessage(msg *Message) error
-	SaveGroupMessage(msg *Message) error
-	GetPrivateMessages(from, to string, limit int) ([]Message, error)
-	GetGroupMessages(roomID int, limit int) ([]Message, error)
+	SavePrivateMessage(ctx context.Context, msg *Message) error
+	SaveGroupMessage(ctx context.Context, msg *Message) error
+	SaveMessages(ctx context.Context, msgs []*Message) error
+	GetPrivateMessages(ctx context.Context, from, to string, limit int) ([]Message, error)
+	GetGroupMessages(ctx context.Context, roomID int, limit int) ([]Message, error)
+	GetContacts(ctx context.Context, username string) ([]string, error)
 }
 
 type RoomRepository interface {
-	SaveRoom(room *Room) error
-	FindRoomByID(id int) (*Room, error)
-	AddMember(member *RoomMember) error
-	GetAllRooms() ([]*Room, error)
-	GetRoomMembers(roomID int) ([]RoomMember, error)
-	GetUserRooms(username string) ([]Room, error)
+	SaveRoom(ctx context.Context, room *Room) error
+	FindRoomByID(ctx context.Context, id int) (*Room, error)
+	AddMember(ctx context.Context, member *RoomMember) error
+	RemoveMember(ctx context.Context, roomID int, username string) error
+	// SetTopic sets the topic of room roomID; an empty topic clears it
+	SetTopic(ctx context.Context, roomID int, topic string) error
+	GetAllRooms(ctx context.Context) ([]*Room, error)
+	GetRoomMembers(ctx context.Context, roomID int) ([]RoomMember, error)
+	GetUserRooms(ctx context.Context, username string) ([]Room, error)
+}
+
+type WebhookRepository interface {
+	SaveWebhook(ctx context.Context, webhook *Webhook) error
+	FindWebhook(ctx context.Context, id int) (*Webhook, error)
+	GetRoomWebhooks(ctx context.Context, roomID int) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+
+	SaveDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	FindDelivery(ctx context.Context, id int) (*WebhookDelivery, error)
+	GetDeliveries(ctx context.Context, filter DeliveryFilter) ([]WebhookDelivery, error)
+	// DeadLetter marks delivery dead and records it in the dead letters
+	DeadLetter(ctx context.Context, delivery *WebhookDelivery, failedAt time.Time) error
+	GetDeadLetters(ctx context.Context, limit int) ([]WebhookDeadLetter, error)
+
+	SaveIncomingWebhook(ctx context.Context, webhook *IncomingWebhook) error
+	FindIncomingWebhook(ctx context.Context, id int) (*IncomingWebhook, error)
+	// FindIncomingWebhookByToken finds revoked webhooks too
+	FindIncomingWebhookByToken(ctx context.Context, tokenHash string) (*IncomingWebhook, error)
+	GetRoomIncomingWebhooks(ctx context.Context, roomID int) ([]IncomingWebhook, error)
+	RevokeIncomingWebhook(ctx context.Context, id int, revokedAt time.Time) error
+}
+
+// DeliveryFilter selects deliveries, newest first. Zero fields match
+// everything.
+type DeliveryFilter struct {
+	WebhookID int
+	Status    string
+	Limit     int
 }
@@ -1,13 +1,39 @@
 package domain
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
-	Username  string    `json:"username"`
+	Username string `json:"username"`
+	// Bot is set for users run by a program; see Bot
+	Bot       bool      `json:"bot"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Bot is a user run by a program. Bots authenticate with API keys instead of
+// just a username, and are managed by the user who created them.
+type Bot struct {
+	Username  string    `json:"username"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APIKey struct {
+	ID int `json:"id"`
+	// Username is the bot the key authenticates
+	Username string `json:"username"`
+	// Prefix is the start of the key, to tell keys apart
+	Prefix string `json:"prefix"`
+	// KeyHash is the hex SHA-256 of the key; the key itself is only shown
+	// when it is created
+	KeyHash   string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
 type Message struct {
 	ID        int       `json:"id"`
 	From      string    `json:"from"`
@@ -23,6 +49,8 @@ type Room struct {
 	Name      string    `json:"name"`
 	CreatedBy string    `json:"created_by"`
 	CreatedAt time.Time `json:"created_at"`
+	// Topic is set with the /topic command
+	Topic string `json:"topic"`
 }
 
 type RoomMember struct {
@@ -30,3 +58,75 @@ type RoomMember struct {
 	Username string    `json:"username"`
 	JoinedAt time.Time `json:"joined_at"`
 }
+
+// Room events delivered to webhooks
+const (
+	EventMessagePosted = "message.posted"
+	EventMemberJoined  = "member.joined"
+	EventMemberLeft    = "member.left"
+	EventRoomUpdated   = "room.updated"
+)
+
+// RoomEvents lists every room event a webhook can subscribe to.
+var RoomEvents = []string{EventMessagePosted, EventMemberJoined, EventMemberLeft, EventRoomUpdated}
+
+type Webhook struct {
+	ID     int    `json:"id"`
+	RoomID int    `json:"room_id"`
+	URL    string `json:"url"`
+	// Secret signs deliveries; it is only shown when the webhook is created
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IncomingWebhook lets anyone holding its token post into a room, like the
+// room's members do.
+type IncomingWebhook struct {
+	ID     int `json:"id"`
+	RoomID int `json:"room_id"`
+	// Username is who messages are posted as when a post names no one
+	Username string `json:"username"`
+	// TokenHash is the hex SHA-256 of the token in the webhook's URL; the
+	// token itself is only shown when the webhook is created
+	TokenHash string `json:"-"`
+	// RateLimit is how many messages a minute the webhook may post
+	RateLimit int        `json:"rate_limit"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Webhook delivery statuses. A delivery is pending until it succeeds or runs
+// out of attempts, when it is moved to the dead letters.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error"`
+	ResponseStatus int             `json:"response_status"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+type WebhookDeadLetter struct {
+	ID         int             `json:"id"`
+	DeliveryID int             `json:"delivery_id"`
+	WebhookID  int             `json:"webhook_id"`
+	Event      string          `json:"event"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"last_error"`
+	FailedAt   time.Time       `json:"failed_at"`
+}
@@ -0,0 +1,293 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"websocket_try3/internal/domain"
+)
+
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func registerUser(t *testing.T, srv *Server, username string) {
+	t.Helper()
+	if err := srv.Users.Save(context.Background(), &domain.User{Username: username}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIRequiresKnownUser(t *testing.T) {
+	srv := NewServer(t, nil)
+
+	var body apiError
+	if status := srv.API("GET", "/api/rooms", "", nil, &body); status != http.StatusUnauthorized || body.Error == "" {
+		t.Errorf("anonymous = %d %+v, want 401 with an error", status, body)
+	}
+	if status := srv.API("GET", "/api/rooms", "mallory", nil, &body); status != http.StatusUnauthorized {
+		t.Errorf("unknown user = %d, want 401", status)
+	}
+}
+
+func TestAPIRooms(t *testing.T) {
+	srv := NewServer(t, nil)
+	registerUser(t, srv, "svc")
+
+	var room domain.Room
+	if status := srv.API("POST", "/api/rooms", "svc", map[string]string{"name": "ops"}, &room); status != http.StatusCreated {
+		t.Fatalf("create = %d", status)
+	}
+	if room.ID == 0 || room.Name != "ops" || room.CreatedBy != "svc" {
+		t.Errorf("created %+v", room)
+	}
+
+	var got struct {
+		domain.Room
+		Members []domain.RoomMember `json:"members"`
+	}
+	if status := srv.API("GET", "/api/rooms/"+strconv.Itoa(room.ID), "svc", nil, &got); status != http.StatusOK {
+		t.Fatalf("get = %d", status)
+	}
+	if got.Name != "ops" || len(got.Members) != 1 || got.Members[0].Username != "svc" {
+		t.Errorf("got %+v", got)
+	}
+
+	var rooms []domain.Room
+	if status := srv.API("GET", "/api/rooms", "svc", nil, &rooms); status != http.StatusOK || len(rooms) != 1 {
+		t.Errorf("list = %d %+v", status, rooms)
+	}
+
+	var body apiError
+	if status := srv.API("POST", "/api/rooms", "svc", map[string]string{"name": " "}, &body); status != http.StatusBadRequest {
+		t.Errorf("blank name = %d, want 400", status)
+	}
+	if status := srv.API("GET", "/api/rooms/999", "svc", nil, &body); status != http.StatusNotFound {
+		t.Errorf("unknown room = %d, want 404", status)
+	}
+	if status := srv.API("GET", "/api/rooms/abc", "svc", nil, &body); status != http.StatusBadRequest {
+		t.Errorf("bad id = %d, want 400", status)
+	}
+}
+
+func TestAPIAddMemberJoinsLiveSession(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	var room domain.Room
+	if status := srv.API("POST", "/api/rooms", "alice", map[string]string{"name": "ops"}, &room); status != http.StatusCreated {
+		t.Fatalf("create = %d", status)
+	}
+	alice.ExpectStatus("You're joining ops")
+
+	var member domain.RoomMember
+	path := "/api/rooms/" + strconv.Itoa(room.ID) + "/members"
+	if status := srv.API("POST", path, "alice", map[string]string{"username": "bob"}, &member); status != http.StatusCreated {
+		t.Fatalf("add = %d", status)
+	}
+	if member.Username != "bob" || member.RoomID != room.ID {
+		t.Errorf("added %+v", member)
+	}
+	bob.ExpectStatus("You're joining ops")
+
+	// Both sessions are in the room actor without reconnecting
+	alice.GroupChat(room.ID, "hello ops")
+	bob.ExpectChat("group_chat", "alice", "hello ops")
+
+	if status := srv.API("POST", path, "alice", map[string]string{"username": "bob"}, &member); status != http.StatusOK {
+		t.Errorf("re-add = %d, want 200", status)
+	}
+
+	var members []domain.RoomMember
+	if status := srv.API("GET", path, "bob", nil, &members); status != http.StatusOK || len(members) != 2 {
+		t.Errorf("members = %d %+v", status, members)
+	}
+
+	var body apiError
+	if status := srv.API("POST", path, "alice", map[string]string{"username": "nobody"}, &body); status != http.StatusNotFound {
+		t.Errorf("unknown member = %d, want 404", status)
+	}
+
+	// Outsiders can neither join nor add others
+	registerUser(t, srv, "carol")
+	if status := srv.API("POST", path, "carol", nil, &body); status != http.StatusForbidden {
+		t.Errorf("self-add by an outsider = %d, want 403", status)
+	}
+	if status := srv.API("POST", path, "carol", map[string]string{"username": "alice"}, &body); status != http.StatusForbidden {
+		t.Errorf("add by an outsider = %d, want 403", status)
+	}
+}
+
+func TestAPIUsers(t *testing.T) {
+	srv := NewServer(t, nil)
+	srv.Connect("alice")
+	registerUser(t, srv, "svc")
+	roomID := seedRoom(t, srv, "ops", "alice")
+
+	var user struct {
+		domain.User
+		Online bool `json:"online"`
+	}
+	if status := srv.API("GET", "/api/users/alice", "svc", nil, &user); status != http.StatusOK {
+		t.Fatalf("get = %d", status)
+	}
+	if user.Username != "alice" || !user.Online {
+		t.Errorf("got %+v", user)
+	}
+
+	var rooms []domain.Room
+	if status := srv.API("GET", "/api/users/alice/rooms", "svc", nil, &rooms); status != http.StatusOK {
+		t.Fatalf("rooms = %d", status)
+	}
+	if len(rooms) != 1 || rooms[0].ID != roomID {
+		t.Errorf("rooms = %+v", rooms)
+	}
+
+	var body apiError
+	if status := srv.API("GET", "/api/users/nobody", "svc", nil, &body); status != http.StatusNotFound {
+		t.Errorf("unknown user = %d, want 404", status)
+	}
+	if status := srv.API("GET", "/api/users/nobody/rooms", "svc", nil, &body); status != http.StatusNotFound {
+		t.Errorf("unknown user rooms = %d, want 404", status)
+	}
+}
+
+type postedMessage struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	GroupID int    `json:"group_id"`
+}
+
+func TestAPIPostRoomMessage(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "ops", "ci", "alice", "bob")
+	registerUser(t, srv, "outsider")
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+	path := "/api/rooms/" + strconv.Itoa(roomID) + "/messages"
+
+	var msg postedMessage
+	if status := srv.API("POST", path, "ci", map[string]string{"content": "build passed"}, &msg); status != http.StatusAccepted {
+		t.Fatalf("post = %d", status)
+	}
+	if msg.Type != "group_chat" || msg.From != "ci" || msg.GroupID != roomID {
+		t.Errorf("posted %+v", msg)
+	}
+	for _, client := range []*Client{alice, bob} {
+		frame := client.ExpectChat("group_chat", "ci", "build passed")
+		if frame.Int("group_id") != roomID {
+			t.Errorf("group_id = %d, want %d", frame.Int("group_id"), roomID)
+		}
+	}
+
+	// Posting over HTTP also reaches the sender's own session
+	if status := srv.API("POST", path, "alice", map[string]string{"content": "from a script"}, nil); status != http.StatusAccepted {
+		t.Fatalf("post as alice = %d", status)
+	}
+	alice.ExpectChat("group_chat", "alice", "from a script")
+	bob.ExpectChat("group_chat", "alice", "from a script")
+
+	var body apiError
+	if status := srv.API("POST", path, "outsider", map[string]string{"content": "hi"}, &body); status != http.StatusForbidden {
+		t.Errorf("non-member = %d, want 403", status)
+	}
+	if status := srv.API("POST", path, "ci", map[string]string{"content": ""}, &body); status != http.StatusBadRequest {
+		t.Errorf("empty content = %d, want 400", status)
+	}
+	if status := srv.API("POST", "/api/rooms/999/messages", "ci", map[string]string{"content": "hi"}, &body); status != http.StatusNotFound {
+		t.Errorf("unknown room = %d, want 404", status)
+	}
+
+	srv.Shutdown()
+	messages, err := srv.Messages.GetGroupMessages(context.Background(), roomID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("persisted %d group messages, want 2", len(messages))
+	}
+}
+
+func TestAPIPostDirectMessage(t *testing.T) {
+	srv := NewServer(t, nil)
+	registerUser(t, srv, "alerts")
+	registerUser(t, srv, "carol")
+	bob := srv.Connect("bob")
+
+	var msg postedMessage
+	if status := srv.API("POST", "/api/dm/bob/messages", "alerts", map[string]string{"content": "disk full"}, &msg); status != http.StatusAccepted {
+		t.Fatalf("post = %d", status)
+	}
+	if msg.Type != "private_chat" || msg.From != "alerts" || msg.To != "bob" {
+		t.Errorf("posted %+v", msg)
+	}
+	bob.ExpectChat("private_chat", "alerts", "disk full")
+
+	// Offline recipients find the message in their history
+	if status := srv.API("POST", "/api/dm/carol/messages", "alerts", map[string]string{"content": "disk full"}, nil); status != http.StatusAccepted {
+		t.Errorf("post to offline user = %d, want 202", status)
+	}
+
+	var body apiError
+	if status := srv.API("POST", "/api/dm/nobody/messages", "alerts", map[string]string{"content": "hi"}, &body); status != http.StatusNotFound {
+		t.Errorf("unknown recipient = %d, want 404", status)
+	}
+
+	srv.Shutdown()
+	for _, to := range []string{"bob", "carol"} {
+		messages, err := srv.Messages.GetPrivateMessages(context.Background(), "alerts", to, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 1 {
+			t.Errorf("persisted %d messages to %s, want 1", len(messages), to)
+		}
+	}
+}
+
+func TestAPIIdempotencyKey(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "ops", "ci", "alice")
+	alice := srv.Connect("alice")
+	path := "/api/rooms/" + strconv.Itoa(roomID) + "/messages"
+	header := http.Header{"Idempotency-Key": {"deploy-42"}}
+	body := map[string]string{"content": "deployed"}
+
+	status, first := srv.APIWithHeader("POST", path, "ci", header, body, nil)
+	if status != http.StatusAccepted || first.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first = %d %v", status, first)
+	}
+	alice.ExpectChat("group_chat", "ci", "deployed")
+
+	var msg postedMessage
+	status, retry := srv.APIWithHeader("POST", path, "ci", header, body, &msg)
+	if status != http.StatusAccepted || retry.Get("Idempotent-Replayed") != "true" || msg.Content != "deployed" {
+		t.Errorf("retry = %d %v %+v, want a replay", status, retry, msg)
+	}
+	alice.ExpectNone("duplicate", quiet, func(f Frame) bool {
+		return f.Type() == "group_chat"
+	})
+
+	var apiErr apiError
+	if status, _ := srv.APIWithHeader("POST", path, "ci", header, map[string]string{"content": "other"}, &apiErr); status != http.StatusUnprocessableEntity {
+		t.Errorf("reused key = %d, want 422", status)
+	}
+
+	// Keys are scoped to the caller
+	if status, _ := srv.APIWithHeader("POST", path, "alice", header, body, nil); status != http.StatusAccepted {
+		t.Errorf("other caller = %d, want 202", status)
+	}
+	alice.ExpectChat("group_chat", "alice", "deployed")
+
+	srv.Shutdown()
+	messages, err := srv.Messages.GetGroupMessages(context.Background(), roomID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("persisted %d group messages, want 2", len(messages))
+	}
+}
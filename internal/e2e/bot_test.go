@@ -0,0 +1,256 @@
+package e2e
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"websocket_try3/internal/delivery/http_delivery"
+	"websocket_try3/internal/domain"
+	"websocket_try3/pkg/chatclient"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+type createdAPIKey struct {
+	domain.APIKey
+	Key string `json:"key"`
+}
+
+type createdBot struct {
+	domain.Bot
+	APIKey createdAPIKey `json:"api_key"`
+}
+
+func createBot(t *testing.T, srv *Server, owner, username string) createdBot {
+	t.Helper()
+	var bot createdBot
+	if status := botAPI(srv, "POST", "/api/bots", owner, map[string]string{"username": username}, &bot); status != http.StatusCreated {
+		t.Fatalf("create bot %s = %d", username, status)
+	}
+	return bot
+}
+
+// botAPI calls the bot management API as owner, with the admin token.
+func botAPI(srv *Server, method, path, owner string, body, out any) int {
+	status, _ := srv.APIWithHeader(method, path, owner, http.Header{http_delivery.HeaderAdminToken: {AdminToken}}, body, out)
+	return status
+}
+
+func bearer(key string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + key}}
+}
+
+// dialStatus tries to connect with query and returns the status of the
+// rejected handshake, or 101 if it succeeded.
+func dialStatus(t *testing.T, srv *Server, query url.Values) int {
+	t.Helper()
+	ws, resp, err := gorilla.DefaultDialer.Dial(srv.WebSocketURL(query), nil)
+	if err == nil {
+		ws.Close()
+		return http.StatusSwitchingProtocols
+	}
+	if resp == nil {
+		t.Fatalf("dial %s: %v", query.Encode(), err)
+	}
+	return resp.StatusCode
+}
+
+func TestBotAuthenticatesWithAPIKey(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "standups", "alice")
+	bot := createBot(t, srv, "alice", "standup")
+	key := bot.APIKey.Key
+	if bot.Owner != "alice" || bot.APIKey.Username != "standup" || bot.APIKey.Prefix != key[:12] {
+		t.Fatalf("created bot = %+v, want standup owned by alice with a key", bot)
+	}
+
+	var user struct {
+		domain.User
+	}
+	srv.API("GET", "/api/users/standup", "alice", nil, &user)
+	if !user.Bot {
+		t.Errorf("GET /api/users/standup = %+v, want a bot", user)
+	}
+
+	// The bot's username alone doesn't do
+	if status := srv.API("GET", "/api/rooms", "standup", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("API as standup without key = %d, want 401", status)
+	}
+	if status := dialStatus(t, srv, url.Values{"username": {"standup"}}); status != http.StatusUnauthorized {
+		t.Errorf("connect as standup without key = %d, want 401", status)
+	}
+	// Nor does a key with someone else's username
+	if status := dialStatus(t, srv, url.Values{"username": {"alice"}, "api_key": {key}}); status != http.StatusUnauthorized {
+		t.Errorf("connect as alice with standup's key = %d, want 401", status)
+	}
+	// Nor can incoming webhooks post as the bot
+	hooks := "/api/rooms/" + strconv.Itoa(roomID) + "/incoming-webhooks"
+	if status := srv.API("POST", hooks, "alice", map[string]string{"username": "standup"}, nil); status != http.StatusForbidden {
+		t.Errorf("incoming webhook as standup = %d, want 403", status)
+	}
+
+	// A member adds the bot to the room; with the key, the bot sees its
+	// messages and can use the API
+	members := "/api/rooms/" + strconv.Itoa(roomID) + "/members"
+	if status := srv.API("POST", members, "alice", map[string]string{"username": "standup"}, nil); status != http.StatusCreated {
+		t.Fatalf("add standup = %d, want 201", status)
+	}
+	status, _ := srv.APIWithHeader("POST", members, "", bearer(key), nil, nil)
+	if status != http.StatusOK {
+		t.Fatalf("join as standup = %d, want 200 as already a member", status)
+	}
+	client := srv.ConnectQuery(url.Values{"api_key": {key}})
+	srv.API("POST", "/api/rooms/"+strconv.Itoa(roomID)+"/messages", "alice", map[string]string{"content": "standup time"}, nil)
+	client.ExpectChat("group_chat", "alice", "standup time")
+
+	// A revoked key stops working, for new connections and API calls alike
+	if status := botAPI(srv, "DELETE", "/api/bots/standup/keys/"+strconv.Itoa(bot.APIKey.ID), "alice", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoke key = %d, want 204", status)
+	}
+	if status := dialStatus(t, srv, url.Values{"api_key": {key}}); status != http.StatusUnauthorized {
+		t.Errorf("connect with revoked key = %d, want 401", status)
+	}
+	if status, _ := srv.APIWithHeader("GET", "/api/rooms", "", bearer(key), nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("API with revoked key = %d, want 401", status)
+	}
+}
+
+func TestBotKeysBelongToTheOwner(t *testing.T) {
+	srv := NewServer(t, nil)
+	registerUser(t, srv, "alice")
+	registerUser(t, srv, "bob")
+	bot := createBot(t, srv, "alice", "oncall")
+
+	var key createdAPIKey
+	if status := botAPI(srv, "POST", "/api/bots/oncall/keys", "alice", nil, &key); status != http.StatusCreated {
+		t.Fatalf("create key = %d, want 201", status)
+	}
+	var keys []domain.APIKey
+	botAPI(srv, "GET", "/api/bots/oncall/keys", "alice", nil, &keys)
+	if len(keys) != 2 || keys[0].ID != bot.APIKey.ID || keys[1].ID != key.ID {
+		t.Errorf("keys = %+v, want the first key and the new one", keys)
+	}
+
+	for _, call := range []struct{ method, path string }{
+		{"GET", "/api/bots/oncall/keys"},
+		{"POST", "/api/bots/oncall/keys"},
+		{"DELETE", "/api/bots/oncall/keys/" + strconv.Itoa(key.ID)},
+	} {
+		if status := botAPI(srv, call.method, call.path, "bob", nil, nil); status != http.StatusNotFound {
+			t.Errorf("%s %s as bob = %d, want 404", call.method, call.path, status)
+		}
+		// A username alone is no credential, even the owner's
+		if status := srv.API(call.method, call.path, "alice", nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("%s %s without the admin token = %d, want 401", call.method, call.path, status)
+		}
+	}
+	var bots []domain.Bot
+	botAPI(srv, "GET", "/api/bots", "bob", nil, &bots)
+	if len(bots) != 0 {
+		t.Errorf("bob's bots = %+v, want none", bots)
+	}
+
+	// Bots don't create bots
+	header := bearer(key.Key)
+	header.Set(http_delivery.HeaderAdminToken, AdminToken)
+	status, _ := srv.APIWithHeader("POST", "/api/bots", "", header, map[string]string{"username": "minion"}, nil)
+	if status != http.StatusForbidden {
+		t.Errorf("create bot as bot = %d, want 403", status)
+	}
+}
+
+// droppingDialer dials through net.Conns the test can cut, like a network
+// failure would.
+type droppingDialer struct {
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (d *droppingDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err == nil {
+		d.mu.Lock()
+		d.conns = append(d.conns, conn)
+		d.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (d *droppingDialer) drop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+}
+
+func TestChatClientEchoesAcrossReconnects(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "echo", "alice")
+	bot := createBot(t, srv, "alice", "echobot")
+	alice := srv.Connect("alice")
+
+	dialer := &droppingDialer{}
+	client := chatclient.New(chatclient.Options{
+		URL:        srv.WebSocketURL(nil),
+		APIKey:     bot.APIKey.Key,
+		Dialer:     &gorilla.Dialer{NetDialContext: dialer.dial},
+		MinBackoff: 10 * time.Millisecond,
+	})
+	sessions := make(chan chatclient.Session, 4)
+	client.OnConnect(func(session chatclient.Session) {
+		if !session.Resumed {
+			client.JoinRoom(roomID)
+		}
+		sessions <- session
+	})
+	client.OnStatus(func(text string) {
+		if strings.Contains(text, "joining") {
+			client.SendGroup(roomID, "ready")
+		}
+	})
+	client.OnGroupMessage(func(m chatclient.Message) {
+		if m.From != "echobot" {
+			client.SendGroup(m.GroupID, "echo: "+m.Content)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	expectSession := func() chatclient.Session {
+		t.Helper()
+		select {
+		case session := <-sessions:
+			return session
+		case <-time.After(DefaultTimeout):
+			t.Fatal("chat client did not connect")
+			return chatclient.Session{}
+		}
+	}
+	first := expectSession()
+	alice.ExpectChat("group_chat", "echobot", "ready")
+	alice.GroupChat(roomID, "one")
+	alice.ExpectChat("group_chat", "echobot", "echo: one")
+
+	// After the connection drops the client resumes its session, so the
+	// message sent meanwhile still reaches it
+	dialer.drop()
+	alice.GroupChat(roomID, "two")
+	second := expectSession()
+	if !second.Resumed || second.ID != first.ID {
+		t.Errorf("session after reconnect = %+v, want %s resumed", second, first.ID)
+	}
+	alice.ExpectChat("group_chat", "echobot", "echo: two")
+}
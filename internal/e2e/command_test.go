@@ -0,0 +1,192 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"websocket_try3/internal/command"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/domain"
+)
+
+func isChat(f Frame) bool {
+	return f.Type() == "group_chat" || f.Type() == "private_chat"
+}
+
+func TestCommandResponsesAreEphemeral(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "ops", "alice", "bob")
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	alice.GroupChat(roomID, "/help")
+	alice.ExpectStatus("/kick <user>")
+	alice.GroupChat(roomID, "/shrug")
+	alice.ExpectStatus("unknown command /shrug")
+	alice.PrivateChat("bob", "/topic")
+	alice.ExpectStatus("/topic only works in a room")
+	bob.ExpectNone("command or its response", quiet, func(f Frame) bool {
+		return isChat(f) || f.Type() == "status"
+	})
+
+	// /me is posted for everyone, including the sender
+	alice.GroupChat(roomID, "/me waves")
+	bob.ExpectChat("group_chat", "alice", "* alice waves")
+	alice.ExpectChat("group_chat", "alice", "* alice waves")
+	alice.PrivateChat("bob", "/ME nods")
+	bob.ExpectChat("private_chat", "alice", "* alice nods")
+
+	// A slash without a command name is just a message
+	alice.GroupChat(roomID, "/ 2")
+	bob.ExpectChat("group_chat", "alice", "/ 2")
+
+	// Commands sent to rooms need membership like messages do
+	outsider := srv.Connect("outsider")
+	outsider.GroupChat(roomID, "/me lurks")
+	outsider.ExpectStatus("room not found")
+	bob.ExpectNone("message from outsider", quiet, isChat)
+}
+
+func TestModerationCommands(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "ops", "alice", "bob", "carol")
+	alice, bob, carol := srv.Connect("alice"), srv.Connect("bob"), srv.Connect("carol")
+
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	receiver, deliveries := newHookReceiver(t, &status)
+	hooks := "/api/rooms/" + strconv.Itoa(roomID) + "/webhooks"
+	events := []string{domain.EventMemberJoined, domain.EventMemberLeft, domain.EventRoomUpdated}
+	if code := srv.API("POST", hooks, "alice", map[string]any{"url": receiver.URL, "events": events}, nil); code != http.StatusCreated {
+		t.Fatalf("create webhook = %d", code)
+	}
+
+	// Only the room's creator moderates
+	bob.GroupChat(roomID, "/kick carol")
+	bob.ExpectStatus("not allowed")
+	bob.GroupChat(roomID, "/mute carol")
+	bob.ExpectStatus("not allowed")
+	alice.GroupChat(roomID, "/kick dave")
+	alice.ExpectStatus("dave is not a member of this room")
+
+	// Anyone can set the topic
+	bob.GroupChat(roomID, "/topic release day")
+	carol.ExpectStatus("bob set the topic: release day")
+	d := expectHook(t, deliveries, domain.EventRoomUpdated)
+	var updated domain.Room
+	json.Unmarshal(d.event.Data, &updated)
+	if updated.ID != roomID || updated.Topic != "release day" {
+		t.Errorf("room.updated data = %s", d.event.Data)
+	}
+	var room domain.Room
+	srv.API("GET", "/api/rooms/"+strconv.Itoa(roomID), "carol", nil, &room)
+	if room.Topic != "release day" {
+		t.Errorf("GET room = %+v, want the topic", room)
+	}
+	carol.GroupChat(roomID, "/topic")
+	carol.ExpectStatus("Topic: release day")
+
+	// A muted member can still read, but not post, over the socket or HTTP
+	alice.GroupChat(roomID, "/mute carol 1h")
+	bob.ExpectStatus("carol was muted for 1h0m0s by alice")
+	carol.GroupChat(roomID, "let me speak")
+	if f := carol.ExpectStatus("You are muted in this room"); f.String("code") != "muted" {
+		t.Errorf("muted status code = %q", f.String("code"))
+	}
+	carol.GroupChat(roomID, "/me protests")
+	carol.ExpectStatus("muted in this room")
+	messages := "/api/rooms/" + strconv.Itoa(roomID) + "/messages"
+	if code := srv.API("POST", messages, "carol", map[string]string{"content": "over http"}, nil); code != http.StatusForbidden {
+		t.Errorf("post while muted = %d, want 403", code)
+	}
+	bob.ExpectNone("message from carol", quiet, isChat)
+	alice.GroupChat(roomID, "still here")
+	carol.ExpectChat("group_chat", "alice", "still here")
+
+	alice.GroupChat(roomID, "/unmute carol")
+	carol.ExpectStatus("carol was unmuted by alice")
+	carol.GroupChat(roomID, "thanks")
+	bob.ExpectChat("group_chat", "carol", "thanks")
+
+	// A kicked member leaves the room for good
+	alice.GroupChat(roomID, "/kick carol")
+	carol.ExpectStatus("You were removed from ops")
+	d = expectHook(t, deliveries, domain.EventMemberLeft)
+	if string(d.event.Data) != `{"username":"carol"}` {
+		t.Errorf("member.left data = %s", d.event.Data)
+	}
+	alice.GroupChat(roomID, "carol is gone")
+	bob.ExpectChat("group_chat", "alice", "carol is gone")
+	carol.ExpectNone("message to a room carol left", quiet, isChat)
+	carol.GroupChat(roomID, "hello?")
+	carol.ExpectStatus("Group not found")
+
+	carol.Close()
+	carol = srv.Connect("carol")
+	alice.GroupChat(roomID, "after reconnecting")
+	carol.ExpectNone("message to a room carol left", quiet, isChat)
+
+	// and can be invited back
+	bob.GroupChat(roomID, "/invite carol")
+	carol.ExpectStatus("You're joining ops")
+	expectHook(t, deliveries, domain.EventMemberJoined)
+	bob.GroupChat(roomID, "welcome back")
+	carol.ExpectChat("group_chat", "bob", "welcome back")
+	bob.GroupChat(roomID, "/invite carol")
+	bob.ExpectStatus("carol is already in this room")
+}
+
+func TestExternalCommand(t *testing.T) {
+	calls := make(chan command.Call, 1)
+	handler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call command.Call
+		json.NewDecoder(r.Body).Decode(&call)
+		calls <- call
+		json.NewEncoder(w).Encode(command.Response{
+			Text:      "deploying " + call.Args,
+			Broadcast: strings.HasSuffix(call.Args, "--announce"),
+		})
+	}))
+	t.Cleanup(handler.Close)
+
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Commands.External = []config.ExternalCommandConfig{{
+			Name:        "deploy",
+			URL:         handler.URL,
+			Usage:       "/deploy <service>",
+			Description: "deploy a service",
+		}}
+	})
+	roomID := seedRoom(t, srv, "ops", "alice", "bob")
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	alice.GroupChat(roomID, "/help")
+	alice.ExpectStatus("/deploy <service> - deploy a service")
+
+	alice.GroupChat(roomID, "/deploy api")
+	alice.ExpectStatus("deploying api")
+	if call := <-calls; call.Name != "deploy" || call.Args != "api" || call.From != "alice" || call.RoomID != roomID {
+		t.Errorf("handler got %+v", call)
+	}
+	bob.ExpectNone("ephemeral response", quiet, func(f Frame) bool {
+		return strings.Contains(f.String("content"), "deploying")
+	})
+
+	alice.GroupChat(roomID, "/deploy api --announce")
+	<-calls
+	bob.ExpectChat("group_chat", "alice", "deploying api --announce")
+}
+
+func TestCommandsDisabled(t *testing.T) {
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Commands.Enabled = false
+	})
+	roomID := seedRoom(t, srv, "ops", "alice", "bob")
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	alice.GroupChat(roomID, "/me waves")
+	bob.ExpectChat("group_chat", "alice", "/me waves")
+}
@@ -0,0 +1,396 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	spec "websocket_try3/api"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/delivery/http_delivery"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/webhook"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// contract checks traffic against the documents in the api package.
+type contract struct {
+	t        *testing.T
+	compiler *jsonschema.Compiler
+	openAPI  map[string]any
+	asyncAPI map[string]any
+	schemas  map[string]*jsonschema.Schema
+}
+
+func newContract(t *testing.T) *contract {
+	t.Helper()
+
+	c := &contract{
+		t:        t,
+		compiler: jsonschema.NewCompiler(),
+		schemas:  make(map[string]*jsonschema.Schema),
+	}
+	c.compiler.Draft = jsonschema.Draft2020
+	c.compiler.AssertFormat = true
+	for name, doc := range map[string][]byte{"openapi.json": spec.OpenAPI, "asyncapi.json": spec.AsyncAPI} {
+		if err := c.compiler.AddResource(name, bytes.NewReader(doc)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if err := json.Unmarshal(spec.OpenAPI, &c.openAPI); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(spec.AsyncAPI, &c.asyncAPI); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// schema compiles the schema at pointer, a JSON pointer into doc.
+func (c *contract) schema(doc, pointer string) (*jsonschema.Schema, error) {
+	url := doc + "#" + pointer
+	if schema, ok := c.schemas[url]; ok {
+		return schema, nil
+	}
+	schema, err := c.compiler.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+	c.schemas[url] = schema
+	return schema, nil
+}
+
+// lookup follows pointer into doc, resolving a $ref at the end of it.
+func lookup(doc map[string]any, pointer string) (map[string]any, string, bool) {
+	node := any(doc)
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil, "", false
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		if node, ok = obj[token]; !ok {
+			return nil, "", false
+		}
+	}
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return nil, "", false
+	}
+	if ref, ok := obj["$ref"].(string); ok {
+		return lookup(doc, strings.TrimPrefix(ref, "#"))
+	}
+	return obj, pointer, true
+}
+
+func escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// checkResponse validates a response to method on the OpenAPI path template.
+func (c *contract) checkResponse(method, template string, status int, body []byte) error {
+	operation := "/paths/" + escape(template) + "/" + strings.ToLower(method)
+	if _, _, ok := lookup(c.openAPI, operation); !ok {
+		return fmt.Errorf("%s %s is not documented", method, template)
+	}
+	response, pointer, ok := lookup(c.openAPI, operation+"/responses/"+strconv.Itoa(status))
+	if !ok {
+		return fmt.Errorf("%s %s: status %d is not documented", method, template, status)
+	}
+	if _, ok := response["content"]; !ok {
+		if len(body) > 0 {
+			return fmt.Errorf("%s %s: undocumented body for %d", method, template, status)
+		}
+		return nil
+	}
+
+	schema, err := c.schema("openapi.json", pointer+"/content/application~1json/schema")
+	if err != nil {
+		return err
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return err
+	}
+	return schema.Validate(v)
+}
+
+// frameSchemas maps frame types to their payload schemas for the messages of
+// an AsyncAPI operation.
+func (c *contract) frameSchemas(operation string) map[string]*jsonschema.Schema {
+	c.t.Helper()
+
+	op, _, ok := lookup(c.asyncAPI, "/operations/"+operation)
+	if !ok {
+		c.t.Fatalf("operation %s is not documented", operation)
+	}
+	schemas := make(map[string]*jsonschema.Schema)
+	for _, ref := range op["messages"].([]any) {
+		_, pointer, ok := lookup(c.asyncAPI, strings.TrimPrefix(ref.(map[string]any)["$ref"].(string), "#"))
+		if !ok {
+			c.t.Fatalf("%s: dangling message %v", operation, ref)
+		}
+		message, pointer, _ := lookup(c.asyncAPI, pointer)
+		if _, _, ok := lookup(c.asyncAPI, pointer+"/payload"); !ok {
+			c.t.Fatalf("%s has no payload", pointer)
+		}
+		schema, err := c.schema("asyncapi.json", pointer+"/payload")
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		schemas[message["name"].(string)] = schema
+	}
+	return schemas
+}
+
+// frameChecker returns a check for Server.CheckFrame or Server.CheckSent that
+// validates frames against schemas and records their types in seen.
+func frameChecker(schemas map[string]*jsonschema.Schema, seen *sync.Map) func(frame []byte) error {
+	return func(frame []byte) error {
+		var v any
+		if err := json.Unmarshal(frame, &v); err != nil {
+			return err
+		}
+		frameType, _ := v.(map[string]any)["type"].(string)
+		schema, ok := schemas[frameType]
+		if !ok {
+			return fmt.Errorf("frame type %q is not documented", frameType)
+		}
+		seen.Store(frameType, true)
+		return schema.Validate(v)
+	}
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	c := newContract(t)
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.HTTP.Admins = []string{"ci"}
+		cfg.Incoming.Burst = 2
+		cfg.Intercept.BlockedWords = []string{"darn"}
+	})
+	roomID := seedRoom(t, srv, "ops", "ci", "alice")
+	registerUser(t, srv, "outsider")
+	registerUser(t, srv, "stranger")
+	srv.Connect("alice")
+	room := "/api/rooms/" + strconv.Itoa(roomID)
+	key := http.Header{"Idempotency-Key": {"contract"}}
+	admin := http.Header{http_delivery.HeaderAdminToken: {AdminToken}}
+
+	// A delivered event, for the admin endpoints to list and replay
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	receiver, deliveries := newHookReceiver(t, &status)
+	var hook createdWebhook
+	srv.API("POST", room+"/webhooks", "ci", map[string]any{"url": receiver.URL}, &hook)
+	srv.API("POST", room+"/messages", "ci", map[string]string{"content": "hook"}, nil)
+	delivered := expectHook(t, deliveries, "message.posted")
+	replay := "/api/admin/webhooks/deliveries/" + delivered.header.Get(webhook.HeaderDelivery) + "/replay"
+	otherHook := room + "/webhooks/" + strconv.Itoa(hook.ID+1)
+
+	// An incoming webhook to post through, and the one the calls create
+	var incoming createdIncomingWebhook
+	srv.API("POST", room+"/incoming-webhooks", "ci", nil, &incoming)
+	hookURL := "/hooks/" + incoming.Token
+	otherIncoming := room + "/incoming-webhooks/" + strconv.Itoa(incoming.ID+1)
+
+	// A bot to call as, and a key of it to revoke
+	bot := createBot(t, srv, "ci", "ci-bot")
+	asBot := bearer(bot.APIKey.Key)
+	asBotAdmin := bearer(bot.APIKey.Key)
+	asBotAdmin.Set(http_delivery.HeaderAdminToken, AdminToken)
+	var spare createdAPIKey
+	srv.APIWithHeader("POST", "/api/bots/ci-bot/keys", "ci", admin, nil, &spare)
+	spareKey := "/api/bots/ci-bot/keys/" + strconv.Itoa(spare.ID)
+
+	calls := []struct {
+		method, template, path, user string
+		header                       http.Header
+		body                         any
+		status                       int
+	}{
+		{"GET", "/api/rooms", "/api/rooms", "ci", nil, nil, 200},
+		{"GET", "/api/rooms", "/api/rooms", "", nil, nil, 401},
+		{"POST", "/api/rooms", "/api/rooms", "ci", nil, map[string]string{"name": "alerts"}, 201},
+		{"POST", "/api/rooms", "/api/rooms", "ci", nil, map[string]string{"name": ""}, 400},
+		{"POST", "/api/rooms", "/api/rooms", "ci", nil, map[string]string{"title": "x"}, 400},
+		{"GET", "/api/rooms/{id}", room, "ci", nil, nil, 200},
+		{"GET", "/api/rooms/{id}", "/api/rooms/999", "ci", nil, nil, 404},
+		{"GET", "/api/rooms/{id}", "/api/rooms/x", "ci", nil, nil, 400},
+		{"GET", "/api/rooms/{id}/members", room + "/members", "ci", nil, nil, 200},
+		{"POST", "/api/rooms/{id}/members", room + "/members", "ci", nil, map[string]string{"username": "outsider"}, 201},
+		{"POST", "/api/rooms/{id}/members", room + "/members", "ci", nil, nil, 200},
+		{"POST", "/api/rooms/{id}/members", room + "/members", "ci", nil, map[string]string{"username": "nobody"}, 404},
+		{"POST", "/api/rooms/{id}/members", room + "/members", "stranger", nil, nil, 403},
+		{"POST", "/api/rooms/{id}/messages", room + "/messages", "ci", key, map[string]string{"content": "hi"}, 202},
+		{"POST", "/api/rooms/{id}/messages", room + "/messages", "ci", key, map[string]string{"content": "hi"}, 202},
+		{"POST", "/api/rooms/{id}/messages", room + "/messages", "ci", key, map[string]string{"content": "other"}, 422},
+		{"POST", "/api/rooms/{id}/messages", room + "/messages", "ci", nil, map[string]string{"content": strings.Repeat("x", 4096)}, 413},
+		{"POST", "/api/rooms/{id}/messages", "/api/rooms/999/messages", "ci", nil, map[string]string{"content": "hi"}, 404},
+		{"POST", "/api/rooms/{id}/messages", room + "/messages", "ci", nil, map[string]string{"content": "darn"}, 422},
+		{"POST", "/api/dm/{username}/messages", "/api/dm/alice/messages", "ci", nil, map[string]string{"content": "hi"}, 202},
+		{"POST", "/api/dm/{username}/messages", "/api/dm/alice/messages", "ci", nil, map[string]string{}, 400},
+		{"POST", "/api/dm/{username}/messages", "/api/dm/nobody/messages", "ci", nil, map[string]string{"content": "hi"}, 404},
+		{"POST", "/api/dm/{username}/messages", "/api/dm/alice/messages", "ci", nil, map[string]string{"content": "darn"}, 422},
+		{"GET", "/api/users/{username}", "/api/users/alice", "ci", nil, nil, 200},
+		{"GET", "/api/users/{username}", "/api/users/nobody", "ci", nil, nil, 404},
+		{"GET", "/api/users/{username}/rooms", "/api/users/alice/rooms", "ci", nil, nil, 200},
+		{"GET", "/api/users/{username}/rooms", "/api/users/nobody/rooms", "ci", nil, nil, 404},
+		{"POST", "/api/rooms/{id}/webhooks", room + "/webhooks", "alice", nil, map[string]any{"url": receiver.URL, "events": []string{"member.joined"}}, 201},
+		{"POST", "/api/rooms/{id}/webhooks", room + "/webhooks", "alice", nil, map[string]any{"url": "not a url"}, 400},
+		{"POST", "/api/rooms/{id}/webhooks", room + "/webhooks", "stranger", nil, map[string]any{"url": receiver.URL}, 403},
+		{"GET", "/api/rooms/{id}/webhooks", room + "/webhooks", "alice", nil, nil, 200},
+		{"GET", "/api/rooms/{id}/webhooks", "/api/rooms/999/webhooks", "alice", nil, nil, 404},
+		{"DELETE", "/api/rooms/{id}/webhooks/{webhook}", otherHook, "alice", nil, nil, 204},
+		{"DELETE", "/api/rooms/{id}/webhooks/{webhook}", otherHook, "alice", nil, nil, 404},
+		{"DELETE", "/api/rooms/{id}/webhooks/{webhook}", room + "/webhooks/x", "alice", nil, nil, 400},
+		{"GET", "/api/admin/webhooks/deliveries", "/api/admin/webhooks/deliveries", "", admin, nil, 200},
+		{"GET", "/api/admin/webhooks/deliveries", "/api/admin/webhooks/deliveries?limit=0", "", admin, nil, 400},
+		{"GET", "/api/admin/webhooks/deliveries", "/api/admin/webhooks/deliveries", "ci", nil, nil, 401},
+		{"GET", "/api/admin/webhooks/dead-letters", "/api/admin/webhooks/dead-letters", "", admin, nil, 200},
+		{"POST", "/api/admin/webhooks/deliveries/{delivery}/replay", replay, "", admin, nil, 202},
+		{"POST", "/api/admin/webhooks/deliveries/{delivery}/replay", "/api/admin/webhooks/deliveries/999/replay", "", admin, nil, 404},
+		{"POST", "/api/rooms/{id}/incoming-webhooks", room + "/incoming-webhooks", "ci", nil, map[string]any{"username": "deploy-bot"}, 201},
+		{"POST", "/api/rooms/{id}/incoming-webhooks", room + "/incoming-webhooks", "ci", nil, map[string]any{"rate_limit": -1}, 400},
+		{"POST", "/api/rooms/{id}/incoming-webhooks", room + "/incoming-webhooks", "ci", nil, map[string]any{"username": "alice"}, 403},
+		{"POST", "/api/rooms/{id}/incoming-webhooks", room + "/incoming-webhooks", "alice", nil, nil, 403},
+		{"GET", "/api/rooms/{id}/incoming-webhooks", room + "/incoming-webhooks", "ci", nil, nil, 200},
+		{"GET", "/api/rooms/{id}/incoming-webhooks", "/api/rooms/999/incoming-webhooks", "ci", nil, nil, 404},
+		{"DELETE", "/api/rooms/{id}/incoming-webhooks/{webhook}", otherIncoming, "ci", nil, nil, 204},
+		{"DELETE", "/api/rooms/{id}/incoming-webhooks/{webhook}", otherIncoming, "ci", nil, nil, 404},
+		{"DELETE", "/api/rooms/{id}/incoming-webhooks/{webhook}", otherIncoming, "alice", nil, nil, 403},
+		{"POST", "/hooks/{token}", hookURL, "", nil, map[string]any{"text": "deployed", "username": "webhook"}, 202},
+		{"POST", "/hooks/{token}", hookURL, "", nil, map[string]any{"username": "deploy-bot"}, 400},
+		{"POST", "/hooks/{token}", hookURL, "", nil, map[string]any{"text": strings.Repeat("x", 4096)}, 413},
+		{"POST", "/hooks/{token}", hookURL, "", nil, map[string]any{"text": "hi", "username": "alice"}, 403},
+		{"POST", "/hooks/{token}", hookURL, "", nil, map[string]any{"text": "darn"}, 422},
+		{"POST", "/hooks/{token}", hookURL, "", nil, map[string]any{"text": "hi"}, 429},
+		{"POST", "/hooks/{token}", "/hooks/unknown", "", nil, map[string]any{"text": "hi"}, 404},
+		{"POST", "/api/bots", "/api/bots", "ci", admin, map[string]string{"username": "standup"}, 201},
+		{"POST", "/api/bots", "/api/bots", "ci", admin, map[string]string{"username": ""}, 400},
+		{"POST", "/api/bots", "/api/bots", "ci", admin, map[string]string{"username": "alice"}, 409},
+		{"POST", "/api/bots", "/api/bots", "", asBotAdmin, map[string]string{"username": "minion"}, 403},
+		{"GET", "/api/bots", "/api/bots", "ci", admin, nil, 200},
+		{"GET", "/api/bots", "/api/bots", "ci", nil, nil, 401},
+		{"GET", "/api/bots", "/api/bots", "ci-bot", admin, nil, 401},
+		{"GET", "/api/users/{username}", "/api/users/ci-bot", "", asBot, nil, 200},
+		{"POST", "/api/bots/{username}/keys", "/api/bots/ci-bot/keys", "ci", admin, nil, 201},
+		{"POST", "/api/bots/{username}/keys", "/api/bots/ci-bot/keys", "alice", admin, nil, 404},
+		{"GET", "/api/bots/{username}/keys", "/api/bots/ci-bot/keys", "ci", admin, nil, 200},
+		{"GET", "/api/bots/{username}/keys", "/api/bots/nobody/keys", "ci", admin, nil, 404},
+		{"DELETE", "/api/bots/{username}/keys/{key}", spareKey, "ci", admin, nil, 204},
+		{"DELETE", "/api/bots/{username}/keys/{key}", spareKey, "ci", admin, nil, 404},
+		{"DELETE", "/api/bots/{username}/keys/{key}", "/api/bots/ci-bot/keys/x", "ci", admin, nil, 400},
+		{"GET", "/api/openapi.json", "/api/openapi.json", "", nil, nil, 200},
+		{"GET", "/api/asyncapi.json", "/api/asyncapi.json", "", nil, nil, 200},
+		{"GET", "/healthz", "/healthz", "", nil, nil, 200},
+		{"GET", "/livez", "/livez", "", nil, nil, 200},
+		{"GET", "/readyz", "/readyz", "", nil, nil, 200},
+	}
+
+	// The room members may be added by the calls above, so run them in order
+	covered := make(map[string]bool)
+	for _, call := range calls {
+		var body json.RawMessage
+		status, _ := srv.APIWithHeader(call.method, call.path, call.user, call.header, call.body, &body)
+		if status != call.status {
+			t.Errorf("%s %s = %d, want %d: %s", call.method, call.path, status, call.status, body)
+			continue
+		}
+		if err := c.checkResponse(call.method, call.template, status, body); err != nil {
+			t.Errorf("%s %s: %v", call.method, call.path, err)
+		}
+		covered[call.method+" "+call.template] = true
+	}
+
+	// Every documented operation is exercised
+	paths, _, _ := lookup(c.openAPI, "/paths")
+	for template, item := range paths {
+		for method := range item.(map[string]any) {
+			if method == "parameters" {
+				continue
+			}
+			if !covered[strings.ToUpper(method)+" "+template] {
+				t.Errorf("%s %s is documented but not covered", strings.ToUpper(method), template)
+			}
+		}
+	}
+
+	// The served documents are the embedded ones
+	var served map[string]any
+	srv.API("GET", "/api/openapi.json", "", nil, &served)
+	if served["openapi"] != c.openAPI["openapi"] || len(served["paths"].(map[string]any)) != len(paths) {
+		t.Error("served OpenAPI document differs from the embedded one")
+	}
+}
+
+func TestAsyncAPIContract(t *testing.T) {
+	c := newContract(t)
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Hub.ResumeWindow = 10 * time.Millisecond
+		cfg.Intercept.BlockedWords = []string{"darn"}
+	})
+	received, sent := c.frameSchemas("sendServerFrames"), c.frameSchemas("receiveClientFrames")
+	var seen, seenSent sync.Map
+	srv.CheckFrame = frameChecker(received, &seen)
+	srv.CheckSent = frameChecker(sent, &seenSent)
+
+	registerUser(t, srv, "carol")
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	alice.CreateRoom("contract")
+	alice.ExpectStatus("contract")
+	room := roomByName(t, srv, "contract")
+	bob.JoinRoom(room.ID)
+	bob.ExpectStatus("You're joining contract")
+
+	alice.GroupChat(room.ID, "hello")
+	bob.ExpectChat("group_chat", "alice", "hello")
+	bob.PrivateChat("alice", "hi")
+	alice.ExpectChat("private_chat", "bob", "hi")
+	bob.ExpectStatus("Message delivered to alice")
+	bob.PrivateChat("alice", "darn")
+	bob.ExpectStatus("blocked word")
+
+	// HTTP-posted messages produce the same frames
+	srv.API("POST", "/api/rooms/"+strconv.Itoa(room.ID)+"/members", "alice", map[string]string{"username": "carol"}, nil)
+	carol := srv.Connect("carol")
+	alice.ExpectType("presence_joined")
+	srv.API("POST", "/api/dm/alice/messages", "carol", map[string]string{"content": "over http"}, nil)
+	alice.ExpectChat("private_chat", "carol", "over http")
+
+	carol.Close()
+	alice.ExpectType("presence_left")
+
+	srv.Shutdown()
+	alice.ExpectType("server_going_away")
+
+	// gap frames need a slow consumer, which the harness client can't be
+	// made into reliably
+	for frameType := range received {
+		if _, ok := seen.Load(frameType); !ok && frameType != "gap" {
+			t.Errorf("server frame %s is documented but not covered", frameType)
+		}
+	}
+	for frameType := range sent {
+		if _, ok := seenSent.Load(frameType); !ok {
+			t.Errorf("client frame %s is documented but not covered", frameType)
+		}
+	}
+
+	// Every frame type readPump handles is documented
+	for _, frameType := range []string{
+		websocket.FrameTypeGroupChat, websocket.FrameTypePrivateChat,
+		websocket.FrameTypeCreateRoom, websocket.FrameTypeJoinRoom,
+	} {
+		if _, ok := sent[frameType]; !ok {
+			t.Errorf("client frame %s is not documented", frameType)
+		}
+	}
+}
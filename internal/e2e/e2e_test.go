@@ -0,0 +1,319 @@
+package e2e
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/domain"
+
+	"github.com/gorilla/websocket"
+)
+
+// quiet is how long a client listens to make sure a frame does not arrive.
+const quiet = 100 * time.Millisecond
+
+// seedRoom creates a room with the given members directly in the store.
+func seedRoom(t *testing.T, srv *Server, name string, members ...string) int {
+	t.Helper()
+	ctx := context.Background()
+
+	now := time.Now()
+	for _, username := range members {
+		srv.Users.Save(ctx, &domain.User{Username: username, CreatedAt: now, UpdatedAt: now})
+	}
+
+	room := &domain.Room{Name: name, CreatedBy: members[0], CreatedAt: now}
+	if err := srv.Rooms.SaveRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range members {
+		srv.Rooms.AddMember(ctx, &domain.RoomMember{RoomID: room.ID, Username: username, JoinedAt: now})
+	}
+	return room.ID
+}
+
+func roomByName(t *testing.T, srv *Server, name string) *domain.Room {
+	t.Helper()
+
+	rooms, err := srv.Rooms.GetAllRooms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, room := range rooms {
+		if room.Name == name {
+			return room
+		}
+	}
+	t.Fatalf("room %s does not exist", name)
+	return nil
+}
+
+func TestRejectsMissingUsername(t *testing.T) {
+	srv := NewServer(t, nil)
+
+	if status := srv.Status(url.Values{}); status != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestSessionFrame(t *testing.T) {
+	srv := NewServer(t, nil)
+
+	alice := srv.Connect("alice")
+	if alice.SessionID == "" {
+		t.Fatal("session frame has no session_id")
+	}
+
+	user, err := srv.Users.FindByUsername(context.Background(), "alice")
+	if err != nil || user == nil {
+		t.Fatalf("alice was not registered: %v", err)
+	}
+}
+
+func TestPrivateChat(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	alice.PrivateChat("bob", "hi bob")
+
+	frame := bob.ExpectChat("private_chat", "alice", "hi bob")
+	if frame.String("to") != "bob" {
+		t.Errorf("to = %q, want bob", frame.String("to"))
+	}
+	alice.ExpectStatus("Message delivered to bob")
+
+	// The sender doesn't get its own message back
+	alice.ExpectNone("echo", quiet, func(f Frame) bool {
+		return f.Type() == "private_chat"
+	})
+
+	srv.Shutdown()
+	messages, err := srv.Messages.GetPrivateMessages(context.Background(), "alice", "bob", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("persisted %d private messages, want 1", len(messages))
+	}
+}
+
+func TestPrivateChatToOfflineUser(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice := srv.Connect("alice")
+
+	alice.PrivateChat("nobody", "hello?")
+	alice.ExpectStatus("User nobody is not found or not connected")
+}
+
+func TestRejectsEmptyContent(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice := srv.Connect("alice")
+
+	alice.Send(map[string]any{"type": "private_chat", "to": "bob"})
+	alice.ExpectStatus("Message content is required")
+}
+
+func TestCreateAndJoinRoom(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	alice.CreateRoom("lobby")
+	alice.ExpectStatus("Room lobby created")
+	lobby := roomByName(t, srv, "lobby")
+
+	bob.JoinRoom(lobby.ID)
+	bob.ExpectStatus("You're joining lobby")
+	alice.ExpectStatus("bob Connected. Total Members: 2")
+
+	bob.JoinRoom(lobby.ID)
+	bob.ExpectStatus("You are already in this room")
+
+	bob.GroupChat(lobby.ID, "hello lobby")
+	frame := alice.ExpectChat("group_chat", "bob", "hello lobby")
+	if frame.Int("group_id") != lobby.ID {
+		t.Errorf("group_id = %d, want %d", frame.Int("group_id"), lobby.ID)
+	}
+	bob.ExpectNone("echo", quiet, func(f Frame) bool {
+		return f.Type() == "group_chat"
+	})
+
+	srv.Shutdown()
+	ctx := context.Background()
+	members, err := srv.Rooms.GetRoomMembers(ctx, lobby.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("lobby has %d members in the store, want 2", len(members))
+	}
+	messages, err := srv.Messages.GetGroupMessages(ctx, lobby.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("persisted %d group messages, want 1", len(messages))
+	}
+}
+
+func TestJoinUnknownRoom(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice := srv.Connect("alice")
+
+	alice.JoinRoom(42)
+	alice.ExpectStatus("Room not found")
+}
+
+func TestGroupChatRequiresMembership(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "private", "alice")
+	alice, mallory := srv.Connect("alice"), srv.Connect("mallory")
+
+	mallory.GroupChat(roomID, "let me in")
+	mallory.ExpectStatus("Group not found")
+	alice.ExpectNone("message from a non-member", quiet, func(f Frame) bool {
+		return f.Type() == "group_chat"
+	})
+}
+
+func TestRoomsRestoredOnConnect(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "team", "alice", "bob")
+
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+	// Both sessions load their rooms before announcing themselves
+	alice.ExpectType("presence_snapshot")
+	bob.ExpectType("presence_snapshot")
+
+	bob.GroupChat(roomID, "morning")
+	alice.ExpectChat("group_chat", "bob", "morning")
+}
+
+func TestPresence(t *testing.T) {
+	// A dropped session only counts as gone once it can't be resumed
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Hub.ResumeWindow = 10 * time.Millisecond
+	})
+	seedRoom(t, srv, "team", "alice", "bob")
+
+	alice := srv.Connect("alice")
+	alice.ExpectType("presence_snapshot")
+
+	bob := srv.Connect("bob")
+	alice.Expect("bob joined", func(f Frame) bool {
+		return f.Type() == "presence_joined" && f.String("username") == "bob"
+	})
+
+	bob.Close()
+	alice.Expect("bob left", func(f Frame) bool {
+		return f.Type() == "presence_left" && f.String("username") == "bob"
+	})
+}
+
+func TestShutdownSendsGoingAway(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice := srv.Connect("alice")
+
+	srv.Shutdown()
+
+	alice.ExpectType("server_going_away")
+	code, reason := alice.ExpectClose()
+	if code != websocket.CloseGoingAway || reason != "server_going_away" {
+		t.Fatalf("closed with %d %q, want %d server_going_away", code, reason, websocket.CloseGoingAway)
+	}
+
+	// Stop closes the listener once the hub has drained
+	if resp, err := http.Get(srv.URL + "/ws?username=bob"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("server still accepting requests after shutdown: %s", resp.Status)
+	}
+}
+
+func TestResumeAfterDrop(t *testing.T) {
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Hub.ResumeWindow = time.Minute
+	})
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+
+	alice.PrivateChat("bob", "first")
+	last := bob.ExpectChat("private_chat", "alice", "first")
+	bob.Close()
+
+	// Sent while bob is away
+	alice.PrivateChat("bob", "second")
+	alice.ExpectStatus("Message delivered to bob")
+
+	bob = srv.ConnectQuery(url.Values{
+		"username":   {"bob"},
+		"session_id": {bob.SessionID},
+		"last_seq":   {strconv.Itoa(last.Int("seq"))},
+	})
+
+	// Only what bob missed is replayed
+	replayed := bob.ExpectType("private_chat")
+	if replayed.String("content") != "second" || replayed.Int("seq") != last.Int("seq")+1 {
+		t.Fatalf("replayed %v, want the second message with seq %d", replayed, last.Int("seq")+1)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := NewServer(t, nil)
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+	alice.PrivateChat("bob", "hi")
+	bob.ExpectChat("private_chat", "alice", "hi")
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`chat_frames_received_total{type="private_chat"} 1`,
+		`chat_connected_clients 2`,
+		`chat_db_query_duration_seconds_count{method="Save",repository="user"} 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Metrics.Enabled = false
+	})
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	srv := NewServer(t, nil)
+
+	for _, path := range []string{"/healthz", "/livez", "/readyz"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s = %d, want 200", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+// Package e2e boots the whole server on a loopback port and drives it with
+// scripted WebSocket clients, for end-to-end tests of the chat protocol.
+//
+//	srv := e2e.NewServer(t, nil)
+//	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+//	alice.PrivateChat("bob", "hi")
+//	bob.ExpectChat("private_chat", "alice", "hi")
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"websocket_try3/internal/app"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/repository/memory"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// DefaultTimeout is how long a client waits for an expected frame.
+const DefaultTimeout = 2 * time.Second
+
+// AdminToken is the admin token servers are configured with.
+const AdminToken = "e2e-admin-token-0123456789abcdef"
+
+var errTimeout = errors.New("timed out")
+
+// Server is a running server backed by in-memory repositories, which tests
+// can use to seed or inspect state.
+type Server struct {
+	URL      string
+	Hub      *websocket.Hub
+	Users    *memory.UserRepository
+	Messages *memory.MessageRepository
+	Rooms    *memory.RoomRepository
+	Webhooks *memory.WebhookRepository
+	Bots     *memory.BotRepository
+	// Timeout is the default wait for Client.Expect
+	Timeout time.Duration
+	// CheckFrame, if set, is applied to every frame clients connected
+	// afterwards receive. A frame it rejects fails the connection like a
+	// malformed one.
+	CheckFrame func(frame []byte) error
+	// CheckSent, if set, is applied to every frame clients connected
+	// afterwards send.
+	CheckSent func(frame []byte) error
+
+	App *app.App
+
+	t       testing.TB
+	stopped bool
+}
+
+// NewServer builds and starts an app.App. configure, if given, can adjust
+// the configuration first. The server is shut down when the test ends.
+func NewServer(t testing.TB, configure func(cfg *config.Config)) *Server {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.HTTP.Addr = "127.0.0.1:0"
+	cfg.Storage.Driver = config.StorageMemory
+	// Keep persistence snappy so tests can observe it
+	cfg.Persistence.FlushInterval = 5 * time.Millisecond
+	cfg.HTTP.AdminToken = AdminToken
+	// Webhook receivers run on localhost
+	cfg.Webhooks.AllowPrivateTargets = true
+	if configure != nil {
+		configure(cfg)
+	}
+
+	users := memory.NewUserRepository()
+	s := &Server{
+		Users:    users,
+		Messages: memory.NewMessageRepository(),
+		Rooms:    memory.NewRoomRepository(),
+		Webhooks: memory.NewWebhookRepository(),
+		Bots:     memory.NewBotRepository(users),
+		Timeout:  DefaultTimeout,
+		t:        t,
+	}
+
+	var err error
+	s.App, err = app.New(cfg, app.Dependencies{
+		Users:    s.Users,
+		Messages: s.Messages,
+		Rooms:    s.Rooms,
+		Webhooks: s.Webhooks,
+		Bots:     s.Bots,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.App.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Hub = s.App.Hub()
+	s.URL = "http://" + s.App.Addr().String()
+
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// Shutdown drains the server the way a SIGTERM would. Anything the clients
+// sent has been persisted once it returns.
+func (s *Server) Shutdown() {
+	s.t.Helper()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+
+	// http.Server.Shutdown waits for connections the API client dialed but
+	// never used, so don't leave any
+	http.DefaultClient.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.App.Stop(ctx); err != nil {
+		s.t.Errorf("shutdown: %v", err)
+	}
+}
+
+// Connect opens a connection for username and waits for its session frame.
+func (s *Server) Connect(username string) *Client {
+	s.t.Helper()
+	return s.ConnectQuery(url.Values{"username": {username}})
+}
+
+// ConnectQuery opens a connection with an arbitrary query string, e.g. to
+// resume a session, and waits for its session frame.
+func (s *Server) ConnectQuery(query url.Values) *Client {
+	s.t.Helper()
+
+	ws, _, err := gorilla.DefaultDialer.Dial(s.WebSocketURL(query), nil)
+	if err != nil {
+		s.t.Fatalf("connect %s: %v", query.Encode(), err)
+	}
+
+	c := &Client{
+		Username: query.Get("username"),
+		Timeout:  s.Timeout,
+		t:        s.t,
+		ws:       ws,
+		frames:   make(chan Frame, 256),
+		done:     make(chan struct{}),
+		check:    s.CheckFrame,
+		checkOut: s.CheckSent,
+	}
+	go c.read()
+	s.t.Cleanup(c.Close)
+
+	session := c.ExpectType("session")
+	c.SessionID = session.String("session_id")
+	return c
+}
+
+// WebSocketURL returns the ws:// URL of the chat endpoint with query.
+func (s *Server) WebSocketURL(query url.Values) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws?" + query.Encode()
+}
+
+// Frame is a decoded server frame.
+type Frame map[string]any
+
+func (f Frame) Type() string {
+	return f.String("type")
+}
+
+func (f Frame) String(key string) string {
+	s, _ := f[key].(string)
+	return s
+}
+
+func (f Frame) Int(key string) int {
+	n, _ := f[key].(float64)
+	return int(n)
+}
+
+// Client is a scripted WebSocket client. Its methods fail the test instead
+// of returning errors and must be called from the test goroutine.
+type Client struct {
+	Username  string
+	SessionID string
+	// Timeout is the default wait for Expect
+	Timeout time.Duration
+
+	t  testing.TB
+	ws *gorilla.Conn
+	// frames is fed by read and closed when the connection fails, after
+	// which readErr holds the reason
+	frames   chan Frame
+	readErr  error
+	done     chan struct{}
+	closed   bool
+	check    func(frame []byte) error
+	checkOut func(frame []byte) error
+}
+
+// Send writes frame as JSON.
+func (c *Client) Send(frame any) {
+	c.t.Helper()
+	data, err := json.Marshal(frame)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.checkOut != nil {
+		if err := c.checkOut(data); err != nil {
+			c.t.Fatalf("%s: sending %s: %v", c.Username, data, err)
+		}
+	}
+	if err := c.ws.WriteMessage(gorilla.TextMessage, data); err != nil {
+		c.t.Fatalf("%s: send: %v", c.Username, err)
+	}
+}
+
+func (c *Client) PrivateChat(to, content string) {
+	c.t.Helper()
+	c.Send(websocket.Message{Type: "private_chat", To: to, Content: content})
+}
+
+func (c *Client) GroupChat(roomID int, content string) {
+	c.t.Helper()
+	c.Send(websocket.Message{Type: "group_chat", GroupID: roomID, Content: content})
+}
+
+func (c *Client) CreateRoom(name string) {
+	c.t.Helper()
+	c.Send(websocket.Message{Type: "create_room", Content: name})
+}
+
+func (c *Client) JoinRoom(roomID int) {
+	c.t.Helper()
+	// The server insists on content even for joins
+	c.Send(websocket.Message{Type: "join_room", GroupID: roomID, Content: "join"})
+}
+
+// Expect skips frames until one satisfies match and returns it, failing the
+// test if none arrives within c.Timeout. Skipped frames are discarded.
+func (c *Client) Expect(what string, match func(f Frame) bool) Frame {
+	c.t.Helper()
+
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		frame, err := c.next(deadline)
+		if err != nil {
+			c.t.Fatalf("%s: waiting for %s: %v", c.Username, what, err)
+		}
+		if match(frame) {
+			return frame
+		}
+	}
+}
+
+// ExpectType waits for the next frame of type frameType.
+func (c *Client) ExpectType(frameType string) Frame {
+	c.t.Helper()
+	return c.Expect(frameType+" frame", func(f Frame) bool {
+		return f.Type() == frameType
+	})
+}
+
+// ExpectStatus waits for a status frame containing text.
+func (c *Client) ExpectStatus(text string) Frame {
+	c.t.Helper()
+	return c.Expect("status "+quote(text), func(f Frame) bool {
+		return f.Type() == "status" && strings.Contains(f.String("content"), text)
+	})
+}
+
+// ExpectChat waits for a chat frame of chatType from sender with content.
+func (c *Client) ExpectChat(chatType, from, content string) Frame {
+	c.t.Helper()
+	return c.Expect(chatType+" from "+from+" with "+quote(content), func(f Frame) bool {
+		return f.Type() == chatType && f.String("from") == from && f.String("content") == content
+	})
+}
+
+// ExpectNone fails the test if a frame satisfying match arrives within d.
+// Frames that don't match are discarded.
+func (c *Client) ExpectNone(what string, d time.Duration, match func(f Frame) bool) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(d)
+	for {
+		frame, err := c.next(deadline)
+		if err != nil {
+			if errors.Is(err, errTimeout) {
+				return
+			}
+			c.t.Fatalf("%s: while checking for no %s: %v", c.Username, what, err)
+		}
+		if match(frame) {
+			c.t.Fatalf("%s: got unexpected %s: %v", c.Username, what, frame)
+		}
+	}
+}
+
+// ExpectClose waits for the server to close the connection and returns the
+// close code and reason.
+func (c *Client) ExpectClose() (int, string) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		_, err := c.next(deadline)
+		if err == nil {
+			continue
+		}
+		if closeErr, ok := err.(*gorilla.CloseError); ok {
+			return closeErr.Code, closeErr.Text
+		}
+		c.t.Fatalf("%s: waiting for close: %v", c.Username, err)
+	}
+}
+
+// Close closes the connection without a close handshake, like a dropped
+// network connection.
+func (c *Client) Close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.ws.Close()
+}
+
+// read decodes incoming messages until the connection fails. A read deadline
+// would break a gorilla connection for good, so waiting with a timeout
+// happens in next instead.
+func (c *Client) read() {
+	defer close(c.frames)
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+
+		// The server coalesces frames into one message, one per line
+		for _, line := range bytes.Split(data, []byte{'\n'}) {
+			var frame Frame
+			if err := json.Unmarshal(line, &frame); err != nil {
+				c.readErr = fmt.Errorf("bad frame %q: %v", line, err)
+				return
+			}
+			if c.check != nil {
+				if err := c.check(line); err != nil {
+					c.readErr = fmt.Errorf("frame %s: %v", line, err)
+					return
+				}
+			}
+			select {
+			case c.frames <- frame:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) next(deadline time.Time) (Frame, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case frame, ok := <-c.frames:
+		if !ok {
+			return nil, c.readErr
+		}
+		return frame, nil
+	case <-timer.C:
+		return nil, errTimeout
+	}
+}
+
+func quote(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// Status returns the HTTP status of a plain GET to the chat endpoint with
+// query, for checking requests that are rejected before the upgrade.
+func (s *Server) Status(query url.Values) int {
+	s.t.Helper()
+
+	resp, err := http.Get(s.URL + "/ws?" + query.Encode())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// API makes a REST call as username, which may be empty for anonymous calls.
+// body, if not nil, is sent as JSON, and the response is decoded into out if
+// out is not nil. It returns the HTTP status.
+func (s *Server) API(method, path, username string, body, out any) int {
+	s.t.Helper()
+
+	status, _ := s.APIWithHeader(method, path, username, nil, body, out)
+	return status
+}
+
+// APIWithHeader is API with extra request headers. It also returns the
+// response headers.
+func (s *Server) APIWithHeader(method, path, username string, header http.Header, body, out any) (int, http.Header) {
+	s.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := s.URL + path
+	if username != "" {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		target += sep + url.Values{"username": {username}}.Encode()
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			s.t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, resp.Header
+}
@@ -0,0 +1,78 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"websocket_try3/internal/config"
+)
+
+func TestInterceptors(t *testing.T) {
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Intercept.Audit = true
+		cfg.Intercept.TrimContent = true
+		cfg.Intercept.BlockedWords = []string{"darn"}
+	})
+	roomID := seedRoom(t, srv, "ops", "alice", "bob")
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+	nextChat := func() string {
+		t.Helper()
+		return bob.Expect("chat", isChat).String("content")
+	}
+
+	alice.GroupChat(roomID, "  hello  ")
+	if got := nextChat(); got != "hello" {
+		t.Errorf("bob got %q, want the trimmed content", got)
+	}
+
+	// Trimming runs before the built-in check for empty content
+	alice.GroupChat(roomID, "   ")
+	if f := alice.ExpectStatus("Message content is required"); f.String("code") != "content_required" {
+		t.Errorf("empty status code = %q", f.String("code"))
+	}
+
+	// Only whole words are blocked
+	alice.GroupChat(roomID, "well, DARN it")
+	if f := alice.ExpectStatus("blocked word"); f.String("code") != "blocked" {
+		t.Errorf("blocked status code = %q", f.String("code"))
+	}
+	alice.GroupChat(roomID, "darning socks")
+	if got := nextChat(); got != "darning socks" {
+		t.Errorf("bob got %q after a blocked message", got)
+	}
+
+	// Messages posted over HTTP pass through the same interceptors
+	path := "/api/rooms/" + strconv.Itoa(roomID) + "/messages"
+	var msg postedMessage
+	if status := srv.API("POST", path, "alice", map[string]string{"content": " over http\n"}, &msg); status != http.StatusAccepted || msg.Content != "over http" {
+		t.Errorf("post = %d %+v, want the trimmed message", status, msg)
+	}
+	if got := nextChat(); got != "over http" {
+		t.Errorf("bob got %q, want the trimmed content", got)
+	}
+	var body apiError
+	if status := srv.API("POST", path, "alice", map[string]string{"content": "darn"}, &body); status != http.StatusUnprocessableEntity || body.Code != "blocked" {
+		t.Errorf("blocked post = %d %+v, want 422 with code blocked", status, body)
+	}
+	if status := srv.API("POST", "/api/dm/bob/messages", "alice", map[string]string{"content": "darn"}, &body); status != http.StatusUnprocessableEntity {
+		t.Errorf("blocked direct message = %d, want 422", status)
+	}
+	bob.ExpectNone("blocked message", quiet, isChat)
+
+	// What is stored is what was delivered
+	srv.Shutdown()
+	messages, err := srv.Messages.GetGroupMessages(context.Background(), roomID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("persisted %d group messages, want 3", len(messages))
+	}
+	for _, m := range messages {
+		if strings.Contains(m.Content, "  hello  ") || strings.Contains(strings.ToLower(m.Content), "darn it") {
+			t.Errorf("persisted %s", m.Content)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package e2e
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracePrivateChat(t *testing.T) {
+	// Tracers obtained from the global provider before this delegate to the
+	// first provider installed, so this is the only test that installs one
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	srv := NewServer(t, nil)
+	alice, bob := srv.Connect("alice"), srv.Connect("bob")
+	alice.PrivateChat("bob", "hi")
+	bob.ExpectChat("private_chat", "alice", "hi")
+	alice.ExpectStatus("Message delivered to bob")
+	srv.Shutdown()
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	one := func(name string) sdktrace.ReadOnlySpan {
+		t.Helper()
+		if len(spans[name]) != 1 {
+			t.Fatalf("got %d %q spans, want 1", len(spans[name]), name)
+		}
+		return spans[name][0]
+	}
+
+	receive := one("ws.receive private_chat")
+	traceID := receive.SpanContext().TraceID()
+
+	route := one("session.private_chat")
+	if route.Parent().SpanID() != receive.SpanContext().SpanID() {
+		t.Error("routing span is not a child of the receive span")
+	}
+	if usecase := one("WebSocketUsecase.StoreMessages"); usecase.SpanContext().TraceID() != traceID {
+		t.Error("usecase span is not part of the message's trace")
+	}
+
+	// The recipient's copy and the sender's delivery status
+	writes := 0
+	for _, span := range spans["ws.write"] {
+		if span.Parent().SpanID() == route.SpanContext().SpanID() {
+			writes++
+		}
+	}
+	if writes != 2 {
+		t.Errorf("got %d writes under the routing span, want 2", writes)
+	}
+
+	var flush sdktrace.ReadOnlySpan
+	for _, span := range spans["MessageWriter.flush"] {
+		for _, link := range span.Links() {
+			if link.SpanContext.TraceID() == traceID {
+				flush = span
+			}
+		}
+	}
+	if flush == nil {
+		t.Fatal("no batch write links to the message's trace")
+	}
+	save := one("MessageRepository.SaveMessages")
+	if save.Parent().SpanID() != flush.SpanContext().SpanID() {
+		t.Error("insert is not a child of the batch write")
+	}
+}
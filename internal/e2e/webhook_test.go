@@ -0,0 +1,369 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/delivery/http_delivery"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/webhook"
+)
+
+// hookDelivery is a webhook POST as a receiver saw it.
+type hookDelivery struct {
+	header http.Header
+	body   []byte
+	event  struct {
+		Event  string          `json:"event"`
+		RoomID int             `json:"room_id"`
+		Data   json.RawMessage `json:"data"`
+	}
+}
+
+// newHookReceiver starts a webhook receiver that answers with *status and
+// hands every delivery to the returned channel.
+func newHookReceiver(t *testing.T, status *atomic.Int32) (*httptest.Server, <-chan hookDelivery) {
+	deliveries := make(chan hookDelivery, 64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var d hookDelivery
+		d.header = r.Header.Clone()
+		d.body, _ = io.ReadAll(r.Body)
+		json.Unmarshal(d.body, &d.event)
+		deliveries <- d
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, deliveries
+}
+
+func expectHook(t *testing.T, deliveries <-chan hookDelivery, event string) hookDelivery {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		if d.event.Event != event {
+			t.Fatalf("got %s webhook %s, want %s", d.event.Event, d.body, event)
+		}
+		return d
+	case <-time.After(DefaultTimeout):
+		t.Fatalf("timed out waiting for %s webhook", event)
+		return hookDelivery{}
+	}
+}
+
+type createdWebhook struct {
+	domain.Webhook
+	Secret string `json:"secret"`
+}
+
+func TestWebhookDeliversRoomEvents(t *testing.T) {
+	srv := NewServer(t, nil)
+	roomID := seedRoom(t, srv, "ops", "alice", "bob")
+	registerUser(t, srv, "carol")
+	alice := srv.Connect("alice")
+
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	receiver, deliveries := newHookReceiver(t, &status)
+
+	path := "/api/rooms/" + strconv.Itoa(roomID) + "/webhooks"
+	var hook createdWebhook
+	if code := srv.API("POST", path, "alice", map[string]any{"url": receiver.URL}, &hook); code != http.StatusCreated {
+		t.Fatalf("create = %d", code)
+	}
+	if hook.ID == 0 || hook.Secret == "" || len(hook.Events) != len(domain.RoomEvents) || hook.CreatedBy != "alice" {
+		t.Fatalf("created %+v", hook)
+	}
+
+	verify := func(d hookDelivery) {
+		t.Helper()
+		err := webhook.Verify(hook.Secret, d.header.Get(webhook.HeaderTimestamp), d.header.Get(webhook.HeaderSignature), d.body, time.Minute)
+		if err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+		if d.header.Get(webhook.HeaderEvent) != d.event.Event || d.event.RoomID != roomID {
+			t.Errorf("delivery %s with event header %s", d.body, d.header.Get(webhook.HeaderEvent))
+		}
+	}
+	message := func(d hookDelivery) postedMessage {
+		t.Helper()
+		var msg postedMessage
+		if err := json.Unmarshal(d.event.Data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	// From a socket
+	alice.GroupChat(roomID, "hello hooks")
+	d := expectHook(t, deliveries, domain.EventMessagePosted)
+	verify(d)
+	if msg := message(d); msg.From != "alice" || msg.Content != "hello hooks" || msg.GroupID != roomID {
+		t.Errorf("message.posted data = %+v", msg)
+	}
+
+	// From the API
+	if code := srv.API("POST", "/api/rooms/"+strconv.Itoa(roomID)+"/messages", "bob", map[string]string{"content": "over http"}, nil); code != http.StatusAccepted {
+		t.Fatalf("post = %d", code)
+	}
+	d = expectHook(t, deliveries, domain.EventMessagePosted)
+	verify(d)
+	if msg := message(d); msg.From != "bob" || msg.Content != "over http" {
+		t.Errorf("message.posted data = %+v", msg)
+	}
+
+	members := "/api/rooms/" + strconv.Itoa(roomID) + "/members"
+	if code := srv.API("POST", members, "alice", map[string]string{"username": "carol"}, nil); code != http.StatusCreated {
+		t.Fatalf("add member = %d", code)
+	}
+	d = expectHook(t, deliveries, domain.EventMemberJoined)
+	verify(d)
+	if string(d.event.Data) != `{"username":"carol"}` {
+		t.Errorf("member.joined data = %s", d.event.Data)
+	}
+
+	var hooks []map[string]any
+	if code := srv.API("GET", path, "bob", nil, &hooks); code != http.StatusOK || len(hooks) != 1 {
+		t.Fatalf("list = %d %+v", code, hooks)
+	}
+	if _, ok := hooks[0]["secret"]; ok {
+		t.Error("listed webhook exposes its secret")
+	}
+
+	registerUser(t, srv, "outsider")
+	var body apiError
+	if code := srv.API("GET", path, "outsider", nil, &body); code != http.StatusForbidden {
+		t.Errorf("non-member list = %d, want 403", code)
+	}
+	if code := srv.API("POST", path, "alice", map[string]any{"url": "ftp://example.com"}, &body); code != http.StatusBadRequest {
+		t.Errorf("bad url = %d, want 400", code)
+	}
+	if code := srv.API("POST", path, "alice", map[string]any{"url": receiver.URL, "events": []string{"nope"}}, &body); code != http.StatusBadRequest {
+		t.Errorf("bad event = %d, want 400", code)
+	}
+
+	hookPath := path + "/" + strconv.Itoa(hook.ID)
+	if code := srv.API("DELETE", hookPath, "alice", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("delete = %d", code)
+	}
+	if code := srv.API("DELETE", hookPath, "alice", nil, &body); code != http.StatusNotFound {
+		t.Errorf("delete again = %d, want 404", code)
+	}
+
+	alice.GroupChat(roomID, "nobody listens")
+	select {
+	case d := <-deliveries:
+		t.Errorf("deleted webhook got %s", d.body)
+	case <-time.After(quiet):
+	}
+}
+
+func TestWebhookDeadLettersAndAdminReplay(t *testing.T) {
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Webhooks.MaxAttempts = 2
+		cfg.Webhooks.InitialBackoff = time.Millisecond
+	})
+	roomID := seedRoom(t, srv, "ops", "alice")
+	admin := http.Header{http_delivery.HeaderAdminToken: {AdminToken}}
+
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	receiver, deliveries := newHookReceiver(t, &status)
+
+	var hook createdWebhook
+	body := map[string]any{"url": receiver.URL, "events": []string{domain.EventMessagePosted}}
+	if code := srv.API("POST", "/api/rooms/"+strconv.Itoa(roomID)+"/webhooks", "alice", body, &hook); code != http.StatusCreated {
+		t.Fatalf("create = %d", code)
+	}
+
+	if code := srv.API("POST", "/api/rooms/"+strconv.Itoa(roomID)+"/messages", "alice", map[string]string{"content": "hi"}, nil); code != http.StatusAccepted {
+		t.Fatalf("post = %d", code)
+	}
+	first := expectHook(t, deliveries, domain.EventMessagePosted)
+	retry := expectHook(t, deliveries, domain.EventMessagePosted)
+	if first.header.Get(webhook.HeaderDelivery) != retry.header.Get(webhook.HeaderDelivery) {
+		t.Error("retry was sent as another delivery")
+	}
+
+	var letters []domain.WebhookDeadLetter
+	deadline := time.Now().Add(DefaultTimeout)
+	for len(letters) == 0 && time.Now().Before(deadline) {
+		if code, _ := srv.APIWithHeader("GET", "/api/admin/webhooks/dead-letters", "", admin, nil, &letters); code != http.StatusOK {
+			t.Fatalf("dead letters = %d", code)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(letters) != 1 || letters[0].WebhookID != hook.ID || letters[0].Attempts != 2 || letters[0].LastError != "status 500" {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+
+	var apiErr apiError
+	if code := srv.API("GET", "/api/admin/webhooks/dead-letters", "alice", nil, &apiErr); code != http.StatusUnauthorized {
+		t.Errorf("without the admin token = %d, want 401", code)
+	}
+	wrong := http.Header{http_delivery.HeaderAdminToken: {"guess"}}
+	if code, _ := srv.APIWithHeader("GET", "/api/admin/webhooks/dead-letters", "", wrong, nil, &apiErr); code != http.StatusUnauthorized {
+		t.Errorf("with a wrong admin token = %d, want 401", code)
+	}
+
+	status.Store(http.StatusOK)
+	var replay domain.WebhookDelivery
+	replayPath := "/api/admin/webhooks/deliveries/" + strconv.Itoa(letters[0].DeliveryID) + "/replay"
+	if code, _ := srv.APIWithHeader("POST", replayPath, "", admin, nil, &replay); code != http.StatusAccepted {
+		t.Fatalf("replay = %d", code)
+	}
+	d := expectHook(t, deliveries, domain.EventMessagePosted)
+	if string(d.body) != string(first.body) {
+		t.Errorf("replayed %s, want %s", d.body, first.body)
+	}
+	if d.header.Get(webhook.HeaderDelivery) != strconv.Itoa(replay.ID) {
+		t.Errorf("replay delivery header = %s, want %d", d.header.Get(webhook.HeaderDelivery), replay.ID)
+	}
+
+	var delivered []domain.WebhookDelivery
+	deadline = time.Now().Add(DefaultTimeout)
+	for len(delivered) == 0 && time.Now().Before(deadline) {
+		path := "/api/admin/webhooks/deliveries?status=delivered&webhook_id=" + strconv.Itoa(hook.ID)
+		if code, _ := srv.APIWithHeader("GET", path, "", admin, nil, &delivered); code != http.StatusOK {
+			t.Fatalf("deliveries = %d", code)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(delivered) != 1 || delivered[0].ID != replay.ID || delivered[0].ResponseStatus != http.StatusOK {
+		t.Errorf("delivered = %+v, want the replay", delivered)
+	}
+
+	if code, _ := srv.APIWithHeader("POST", "/api/admin/webhooks/deliveries/999/replay", "", admin, nil, &apiErr); code != http.StatusNotFound {
+		t.Errorf("replay unknown = %d, want 404", code)
+	}
+	if code, _ := srv.APIWithHeader("GET", "/api/admin/webhooks/deliveries?status=lost", "", admin, nil, &apiErr); code != http.StatusBadRequest {
+		t.Errorf("bad status = %d, want 400", code)
+	}
+}
+
+type createdIncomingWebhook struct {
+	domain.IncomingWebhook
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+func TestIncomingWebhookPostsIntoRoom(t *testing.T) {
+	srv := NewServer(t, func(cfg *config.Config) {
+		cfg.Incoming.Burst = 2
+	})
+	roomID := seedRoom(t, srv, "ops", "alice", "bob")
+	bob := srv.Connect("bob")
+
+	path := "/api/rooms/" + strconv.Itoa(roomID) + "/incoming-webhooks"
+	var body apiError
+	if code := srv.API("POST", path, "bob", map[string]any{}, &body); code != http.StatusForbidden {
+		t.Errorf("create by non-admin = %d, want 403", code)
+	}
+	if code := srv.API("POST", path, "alice", map[string]any{"username": "bob"}, &body); code != http.StatusForbidden {
+		t.Errorf("create as a member = %d, want 403", code)
+	}
+	registerUser(t, srv, "carol")
+	if code := srv.API("POST", path, "alice", map[string]any{"username": "carol"}, &body); code != http.StatusForbidden {
+		t.Errorf("create as a user in no room = %d, want 403", code)
+	}
+
+	var deploys createdIncomingWebhook
+	req := map[string]any{"username": "deploy-bot", "rate_limit": 1}
+	if code := srv.API("POST", path, "alice", req, &deploys); code != http.StatusCreated {
+		t.Fatalf("create = %d", code)
+	}
+	if deploys.Token == "" || deploys.URL != srv.URL+"/hooks/"+deploys.Token ||
+		deploys.Username != "deploy-bot" || deploys.RateLimit != 1 || deploys.CreatedBy != "alice" {
+		t.Fatalf("created %+v", deploys)
+	}
+	hook := "/hooks/" + deploys.Token
+	// The webhook's identity is a bot, so its name alone doesn't connect
+	if status := dialStatus(t, srv, url.Values{"username": {"deploy-bot"}}); status != http.StatusUnauthorized {
+		t.Errorf("connect as deploy-bot = %d, want 401", status)
+	}
+
+	var msg postedMessage
+	if code := srv.API("POST", hook, "", map[string]any{"text": "build passed"}, &msg); code != http.StatusAccepted {
+		t.Fatalf("post = %d", code)
+	}
+	if msg.From != "deploy-bot" || msg.Content != "build passed" || msg.GroupID != roomID {
+		t.Errorf("posted %+v", msg)
+	}
+	bob.ExpectChat("group_chat", "deploy-bot", "build passed")
+
+	// Fields other chat services send are ignored, but a post can't name
+	// someone else to post as
+	payload := map[string]any{"text": "release tagged", "username": "deploy-bot", "icon_emoji": ":ship:"}
+	if code := srv.API("POST", hook, "", payload, &msg); code != http.StatusAccepted {
+		t.Fatalf("post with other fields = %d", code)
+	}
+	bob.ExpectChat("group_chat", "deploy-bot", "release tagged")
+	payload["username"] = "release-bot"
+	if code := srv.API("POST", hook, "", payload, &body); code != http.StatusForbidden {
+		t.Errorf("post as release-bot = %d, want 403", code)
+	}
+	if user, _ := srv.Users.FindByUsername(context.Background(), "release-bot"); user != nil {
+		t.Error("post registered release-bot")
+	}
+
+	code, header := srv.APIWithHeader("POST", hook, "", nil, map[string]any{"text": "too soon"}, &body)
+	if code != http.StatusTooManyRequests || header.Get("Retry-After") != "60" {
+		t.Errorf("post beyond the burst = %d with Retry-After %q, want 429 after 60s", code, header.Get("Retry-After"))
+	}
+
+	// The rate limit is per webhook
+	var alerts createdIncomingWebhook
+	if code := srv.API("POST", path, "alice", nil, &alerts); code != http.StatusCreated {
+		t.Fatalf("create = %d", code)
+	}
+	if alerts.Username != "webhook" || alerts.RateLimit != 60 {
+		t.Errorf("created with defaults %+v", alerts)
+	}
+	if code := srv.API("POST", "/hooks/"+alerts.Token, "", map[string]any{"text": "disk full"}, &msg); code != http.StatusAccepted {
+		t.Errorf("post to another webhook = %d", code)
+	}
+	bob.ExpectChat("group_chat", "webhook", "disk full")
+	if code := srv.API("POST", "/hooks/"+alerts.Token, "", map[string]any{"text": "hi", "username": "alice"}, &body); code != http.StatusForbidden {
+		t.Errorf("post as a member = %d, want 403", code)
+	}
+	if code := srv.API("POST", "/hooks/"+alerts.Token, "", map[string]any{"username": "ci"}, &body); code != http.StatusBadRequest {
+		t.Errorf("post without text = %d, want 400", code)
+	}
+	if code := srv.API("POST", "/hooks/nope", "", map[string]any{"text": "hi"}, &body); code != http.StatusNotFound {
+		t.Errorf("post to unknown token = %d, want 404", code)
+	}
+
+	var hooks []map[string]any
+	if code := srv.API("GET", path, "alice", nil, &hooks); code != http.StatusOK || len(hooks) != 2 {
+		t.Fatalf("list = %d %+v", code, hooks)
+	}
+	if _, ok := hooks[0]["token"]; ok {
+		t.Error("listed webhook exposes its token")
+	}
+
+	hookPath := path + "/" + strconv.Itoa(alerts.ID)
+	if code := srv.API("DELETE", hookPath, "alice", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("revoke = %d", code)
+	}
+	if code := srv.API("DELETE", hookPath, "alice", nil, &body); code != http.StatusNotFound {
+		t.Errorf("revoke again = %d, want 404", code)
+	}
+	if code := srv.API("POST", "/hooks/"+alerts.Token, "", map[string]any{"text": "still there?"}, &body); code != http.StatusNotFound {
+		t.Errorf("post to revoked webhook = %d, want 404", code)
+	}
+
+	var listed []domain.IncomingWebhook
+	if code := srv.API("GET", path, "alice", nil, &listed); code != http.StatusOK || len(listed) != 2 {
+		t.Fatalf("list = %d %+v", code, listed)
+	}
+	if listed[0].RevokedAt != nil || listed[1].RevokedAt == nil {
+		t.Errorf("listed %+v, want only the second revoked", listed)
+	}
+}
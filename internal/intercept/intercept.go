@@ -0,0 +1,73 @@
+// Package intercept provides the interceptors the server can be configured
+// with, see websocket.Interceptor.
+package intercept
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"unicode"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/logging"
+)
+
+// CodeBlocked rejects messages containing a blocked word.
+const CodeBlocked = "blocked"
+
+// TrimSpace removes leading and trailing white space from the content, so
+// messages of nothing but white space are rejected as empty.
+func TrimSpace() websocket.Interceptor {
+	return websocket.InterceptorFunc(func(ctx context.Context, in *websocket.Inbound, next websocket.InboundHandler) error {
+		in.Content = strings.TrimSpace(in.Content)
+		return next(ctx, in)
+	})
+}
+
+// BlockWords rejects messages containing any of words, ignoring case. Only
+// whole words match, so blocking "ass" lets "class" through.
+func BlockWords(words []string) websocket.Interceptor {
+	blocked := make(map[string]bool, len(words))
+	for _, word := range words {
+		blocked[strings.ToLower(word)] = true
+	}
+	return websocket.InterceptorFunc(func(ctx context.Context, in *websocket.Inbound, next websocket.InboundHandler) error {
+		words := strings.FieldsFunc(strings.ToLower(in.Content), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			if blocked[word] {
+				return websocket.Reject(CodeBlocked, "Message contains a blocked word")
+			}
+		}
+		return next(ctx, in)
+	})
+}
+
+// Audit logs every inbound message and what became of it. Place it first to
+// see rejections by the interceptors after it. The content is logged with
+// logging.Content, so it is redacted unless configured otherwise.
+func Audit(logger *slog.Logger) websocket.Interceptor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return websocket.InterceptorFunc(func(ctx context.Context, in *websocket.Inbound, next websocket.InboundHandler) error {
+		err := next(ctx, in)
+
+		attrs := []any{"source", in.Source, "type", in.Type, "from", in.From}
+		if in.GroupID != 0 {
+			attrs = append(attrs, "group_id", in.GroupID)
+		} else if in.To != "" {
+			attrs = append(attrs, "to", in.To)
+		}
+		var reject *websocket.RejectError
+		switch {
+		case errors.As(err, &reject):
+			attrs = append(attrs, "rejected", reject.Code)
+		case err != nil:
+			attrs = append(attrs, "err", err)
+		}
+		logger.InfoContext(ctx, "inbound message", append(attrs, logging.Content(in.Content))...)
+		return err
+	})
+}
@@ -0,0 +1,80 @@
+package intercept
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"websocket_try3/internal/delivery/websocket"
+)
+
+// run passes content through interceptor and returns what reached the end,
+// if anything, and the error.
+func run(interceptor websocket.Interceptor, content string) (string, bool, error) {
+	in := &websocket.Inbound{
+		Message: websocket.Message{Type: websocket.FrameTypeGroupChat, From: "alice", GroupID: 1, Content: content},
+		Source:  websocket.SourceSocket,
+	}
+	var reached bool
+	err := interceptor.Intercept(context.Background(), in, func(ctx context.Context, in *websocket.Inbound) error {
+		reached = true
+		return nil
+	})
+	return in.Content, reached, err
+}
+
+func TestTrimSpace(t *testing.T) {
+	if got, reached, err := run(TrimSpace(), " \thi there\n"); got != "hi there" || !reached || err != nil {
+		t.Errorf("TrimSpace = %q, %v, %v", got, reached, err)
+	}
+}
+
+func TestBlockWords(t *testing.T) {
+	block := BlockWords([]string{"Darn", "heck"})
+	tests := []struct {
+		content string
+		blocked bool
+	}{
+		{"darn", true},
+		{"well, DARN it", true},
+		{"what the heck?", true},
+		{"darning socks", false},
+		{"check", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		_, reached, err := run(block, tt.content)
+		var reject *websocket.RejectError
+		if blocked := errors.As(err, &reject) && reject.Code == CodeBlocked; blocked != tt.blocked || reached == tt.blocked {
+			t.Errorf("%q: reached = %v, err = %v; want blocked %v", tt.content, reached, err, tt.blocked)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	audit := Audit(logger)
+	reject := websocket.InterceptorFunc(func(ctx context.Context, in *websocket.Inbound, next websocket.InboundHandler) error {
+		return audit.Intercept(ctx, in, func(ctx context.Context, in *websocket.Inbound) error {
+			return websocket.Reject("nope", "Not that")
+		})
+	})
+
+	if _, reached, err := run(audit, "hello"); !reached || err != nil {
+		t.Errorf("Audit = %v, %v; want the message passed on", reached, err)
+	}
+	if !strings.Contains(buf.String(), "source=socket type=group_chat from=alice group_id=1 content=hello") {
+		t.Errorf("logged %q", buf.String())
+	}
+
+	buf.Reset()
+	if _, _, err := run(reject, "hello"); err == nil {
+		t.Error("Audit swallowed the rejection")
+	}
+	if !strings.Contains(buf.String(), "rejected=nope") {
+		t.Errorf("logged %q", buf.String())
+	}
+}
@@ -0,0 +1,80 @@
+// Package logging builds the server's structured logger.
+//
+// Chat bodies are logged under ContentKey, with Content, and are redacted by
+// the handler unless LoggingConfig.RedactContent is turned off, so code can
+// log frames freely at debug level without leaking them into production
+// logs.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"websocket_try3/internal/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContentKey is the attribute key for chat message bodies.
+const ContentKey = "content"
+
+// Content is the attribute for a chat message body.
+func Content(content string) slog.Attr {
+	return slog.String(ContentKey, content)
+}
+
+// New returns a logger writing to w as described by cfg.
+func New(cfg config.LoggingConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.RedactContent {
+		opts.ReplaceAttr = redactContent
+	}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case config.LogFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	case config.LogFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	return slog.New(traceHandler{handler}), nil
+}
+
+func redactContent(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == ContentKey {
+		return slog.String(ContentKey, fmt.Sprintf("[redacted %d bytes]", len(a.Value.String())))
+	}
+	return a
+}
+
+// traceHandler adds the trace and span ID of the record's context, if any,
+// so logs can be correlated with traces.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"websocket_try3/internal/config"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func newLogger(t *testing.T, cfg config.LoggingConfig) (*bytes.Buffer, func() map[string]any) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger, err := New(cfg, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("frame received", "user", "alice", Content("hello bob"))
+
+	return &buf, func() map[string]any {
+		var record map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("log line is not JSON: %v: %s", err, buf.String())
+		}
+		return record
+	}
+}
+
+func TestRedactsContent(t *testing.T) {
+	buf, record := newLogger(t, config.LoggingConfig{Level: "info", Format: "json", RedactContent: true})
+
+	if strings.Contains(buf.String(), "hello bob") {
+		t.Fatalf("content leaked: %s", buf.String())
+	}
+	if got := record()["content"]; got != "[redacted 9 bytes]" {
+		t.Errorf("content = %v", got)
+	}
+	if got := record()["user"]; got != "alice" {
+		t.Errorf("user = %v", got)
+	}
+}
+
+func TestContentCanBeLogged(t *testing.T) {
+	_, record := newLogger(t, config.LoggingConfig{Level: "info", Format: "json"})
+
+	if got := record()["content"]; got != "hello bob" {
+		t.Errorf("content = %v", got)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	buf, _ := newLogger(t, config.LoggingConfig{Level: "warn", Format: "json"})
+
+	if buf.Len() != 0 {
+		t.Errorf("info was logged at level warn: %s", buf.String())
+	}
+}
+
+func TestTraceIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.LoggingConfig{Level: "info", Format: "json"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "op")
+	defer span.End()
+	logger.With("user", "alice").InfoContext(ctx, "traced")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["trace_id"] != span.SpanContext().TraceID().String() {
+		t.Errorf("trace_id = %v, want %v", record["trace_id"], span.SpanContext().TraceID())
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	if _, err := New(config.LoggingConfig{Level: "loud", Format: "json"}, &bytes.Buffer{}); err == nil {
+		t.Error("accepted an invalid level")
+	}
+	if _, err := New(config.LoggingConfig{Level: "info", Format: "xml"}, &bytes.Buffer{}); err == nil {
+		t.Error("accepted an invalid format")
+	}
+}
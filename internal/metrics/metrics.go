@@ -0,0 +1,231 @@
+// Package metrics exposes the server's Prometheus metrics. Every label has a
+// small, fixed set of values: nothing derived from usernames, room IDs or
+// message content is ever used as a label.
+package metrics
+
+import (
+	"net/http"
+	"time"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chat"
+
+// Metrics owns a registry with every collector the server exports. It
+// implements websocket.Metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	framesReceived  *prometheus.CounterVec
+	upgradeFailures *prometheus.CounterVec
+	loopLatency     *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+	redisErrors     prometheus.Counter
+}
+
+var _ websocket.Metrics = (*Metrics)(nil)
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		framesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "frames_received_total",
+			Help:      "Frames read from clients, by frame type.",
+		}, []string{"type"}),
+
+		upgradeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upgrade_failures_total",
+			Help:      "WebSocket connection attempts that were rejected, by reason.",
+		}, []string{"reason"}),
+
+		loopLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "hub",
+			Name:      "loop_latency_seconds",
+			Help:      "Time a room or session loop spent handling one event.",
+			Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+		}, []string{"actor"}),
+
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Latency of repository calls, by repository and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"repository", "method"}),
+
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "query_errors_total",
+			Help:      "Repository calls that returned an error, by repository and method.",
+		}, []string{"repository", "method"}),
+
+		redisErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "redis",
+			Name:      "errors_total",
+			Help:      "Redis commands that failed.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.framesReceived,
+		m.upgradeFailures,
+		m.loopLatency,
+		m.queryDuration,
+		m.queryErrors,
+		m.redisErrors,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+func (m *Metrics) FrameReceived(frameType string) {
+	m.framesReceived.WithLabelValues(frameType).Inc()
+}
+
+func (m *Metrics) UpgradeFailed(reason string) {
+	m.upgradeFailures.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) EventHandled(actor string, d time.Duration) {
+	m.loopLatency.WithLabelValues(actor).Observe(d.Seconds())
+}
+
+// observeQuery records one repository call started at start.
+func (m *Metrics) observeQuery(repository, method string, start time.Time, err error) {
+	m.queryDuration.WithLabelValues(repository, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.queryErrors.WithLabelValues(repository, method).Inc()
+	}
+}
+
+// RegisterHub exports the state hub already tracks: connected clients,
+// loaded rooms and the slow-consumer counters.
+func (m *Metrics) RegisterHub(hub *websocket.Hub) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "connected_clients",
+			Help:      "Sessions registered with the hub, including detached ones awaiting resumption.",
+		}, func() float64 {
+			return float64(hub.ClientCount())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rooms_loaded",
+			Help:      "Room actors running in the hub.",
+		}, func() float64 {
+			return float64(hub.RoomCount())
+		}),
+		&hubCollector{hub: hub},
+	)
+}
+
+var sendBufferDropsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "send_buffer_drops_total"),
+	"Frames affected by a full client send buffer, by the slow-consumer action taken.",
+	[]string{"action"}, nil,
+)
+
+var hubWritesLostDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "hub", "writes_lost_total"),
+	"Database writes queued by the hub that were lost, by reason: dropped before the attempt or failed.",
+	[]string{"reason"}, nil,
+)
+
+type hubCollector struct {
+	hub *websocket.Hub
+}
+
+func (c *hubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sendBufferDropsDesc
+	ch <- hubWritesLostDesc
+}
+
+func (c *hubCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.hub.SlowConsumerStats()
+	for action, n := range map[string]uint64{
+		"drop_oldest": stats.DroppedOldest,
+		"drop_newest": stats.DroppedNewest,
+		"disconnect":  stats.Disconnected,
+		"spill":       stats.Spilled,
+	} {
+		ch <- prometheus.MustNewConstMetric(sendBufferDropsDesc, prometheus.CounterValue, float64(n), action)
+	}
+
+	persist := c.hub.PersistStats()
+	ch <- prometheus.MustNewConstMetric(hubWritesLostDesc, prometheus.CounterValue, float64(persist.Dropped), "dropped")
+	ch <- prometheus.MustNewConstMetric(hubWritesLostDesc, prometheus.CounterValue, float64(persist.Failed), "failed")
+}
+
+var (
+	persistedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "persistence", "messages_total"),
+		"Messages handled by the write-behind writer, by outcome.",
+		[]string{"outcome"}, nil,
+	)
+	persistRetriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "persistence", "retries_total"),
+		"Batch writes that were retried.",
+		nil, nil,
+	)
+	persistBatchesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "persistence", "batches_total"),
+		"Batches written to the database.",
+		nil, nil,
+	)
+	persistQueueDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "persistence", "queue_depth"),
+		"Messages waiting to be written.",
+		nil, nil,
+	)
+)
+
+// RegisterMessageWriter exports the write-behind writer's statistics.
+func (m *Metrics) RegisterMessageWriter(w *repository.MessageWriter) {
+	m.registry.MustRegister(&writerCollector{writer: w})
+}
+
+type writerCollector struct {
+	writer *repository.MessageWriter
+}
+
+func (c *writerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- persistedDesc
+	ch <- persistRetriesDesc
+	ch <- persistBatchesDesc
+	ch <- persistQueueDesc
+}
+
+func (c *writerCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.writer.Stats()
+	for outcome, n := range map[string]uint64{
+		"enqueued": stats.Enqueued,
+		"written":  stats.Written,
+		"dropped":  stats.Dropped,
+		"failed":   stats.Failed,
+		"rejected": stats.Rejected,
+	} {
+		ch <- prometheus.MustNewConstMetric(persistedDesc, prometheus.CounterValue, float64(n), outcome)
+	}
+	ch <- prometheus.MustNewConstMetric(persistRetriesDesc, prometheus.CounterValue, float64(stats.Retries))
+	ch <- prometheus.MustNewConstMetric(persistBatchesDesc, prometheus.CounterValue, float64(stats.Batches))
+	ch <- prometheus.MustNewConstMetric(persistQueueDesc, prometheus.GaugeValue, float64(stats.QueueDepth))
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/repository/memory"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func expectLine(t *testing.T, body, line string) {
+	t.Helper()
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return
+		}
+	}
+	t.Errorf("missing %q in scrape", line)
+}
+
+func TestHookMetrics(t *testing.T) {
+	m := New()
+	m.FrameReceived(websocket.FrameTypePrivateChat)
+	m.FrameReceived(websocket.FrameTypePrivateChat)
+	m.UpgradeFailed(websocket.UpgradeFailureMissingUsername)
+	m.EventHandled("room", time.Millisecond)
+
+	body := scrape(t, m)
+	expectLine(t, body, `chat_frames_received_total{type="private_chat"} 2`)
+	expectLine(t, body, `chat_upgrade_failures_total{reason="missing_username"} 1`)
+	expectLine(t, body, `chat_hub_loop_latency_seconds_count{actor="room"} 1`)
+}
+
+type failingRooms struct {
+	domain.RoomRepository
+}
+
+func (failingRooms) FindRoomByID(ctx context.Context, id int) (*domain.Room, error) {
+	return nil, errors.New("boom")
+}
+
+func TestInstrumentedRepositories(t *testing.T) {
+	m := New()
+	ctx := context.Background()
+
+	users := m.InstrumentUsers(memory.NewUserRepository())
+	if err := users.Save(ctx, &domain.User{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	rooms := m.InstrumentRooms(failingRooms{memory.NewRoomRepository()})
+	if _, err := rooms.FindRoomByID(ctx, 1); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	body := scrape(t, m)
+	expectLine(t, body, `chat_db_query_duration_seconds_count{method="Save",repository="user"} 1`)
+	expectLine(t, body, `chat_db_query_duration_seconds_count{method="FindRoomByID",repository="room"} 1`)
+	expectLine(t, body, `chat_db_query_errors_total{method="FindRoomByID",repository="room"} 1`)
+	if strings.Contains(body, `chat_db_query_errors_total{method="Save"`) {
+		t.Error("successful Save was counted as an error")
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"context"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+// InstrumentUsers wraps repo so every call is timed.
+func (m *Metrics) InstrumentUsers(repo domain.UserRepository) domain.UserRepository {
+	return &userRepository{repo: repo, m: m}
+}
+
+// InstrumentMessages wraps repo so every call is timed.
+func (m *Metrics) InstrumentMessages(repo domain.MessageRepository) domain.MessageRepository {
+	return &messageRepository{repo: repo, m: m}
+}
+
+// InstrumentRooms wraps repo so every call is timed.
+func (m *Metrics) InstrumentRooms(repo domain.RoomRepository) domain.RoomRepository {
+	return &roomRepository{repo: repo, m: m}
+}
+
+// track starts timing a repository call; the returned function records it
+// with the call's final error:
+//
+//	defer r.m.track("user", "Save")(&err)
+func (m *Metrics) track(repository, method string) func(err *error) {
+	start := time.Now()
+	return func(err *error) {
+		m.observeQuery(repository, method, start, *err)
+	}
+}
+
+type userRepository struct {
+	repo domain.UserRepository
+	m    *Metrics
+}
+
+func (r *userRepository) Save(ctx context.Context, user *domain.User) (err error) {
+	defer r.m.track("user", "Save")(&err)
+	return r.repo.Save(ctx, user)
+}
+
+func (r *userRepository) FindByUsername(ctx context.Context, username string) (user *domain.User, err error) {
+	defer r.m.track("user", "FindByUsername")(&err)
+	return r.repo.FindByUsername(ctx, username)
+}
+
+func (r *userRepository) FindAll(ctx context.Context) (users []domain.User, err error) {
+	defer r.m.track("user", "FindAll")(&err)
+	return r.repo.FindAll(ctx)
+}
+
+type messageRepository struct {
+	repo domain.MessageRepository
+	m    *Metrics
+}
+
+func (r *messageRepository) SavePrivateMessage(ctx context.Context, msg *domain.Message) (err error) {
+	defer r.m.track("message", "SavePrivateMessage")(&err)
+	return r.repo.SavePrivateMessage(ctx, msg)
+}
+
+func (r *messageRepository) SaveGroupMessage(ctx context.Context, msg *domain.Message) (err error) {
+	defer r.m.track("message", "SaveGroupMessage")(&err)
+	return r.repo.SaveGroupMessage(ctx, msg)
+}
+
+func (r *messageRepository) SaveMessages(ctx context.Context, msgs []*domain.Message) (err error) {
+	defer r.m.track("message", "SaveMessages")(&err)
+	return r.repo.SaveMessages(ctx, msgs)
+}
+
+func (r *messageRepository) GetPrivateMessages(ctx context.Context, from, to string, limit int) (messages []domain.Message, err error) {
+	defer r.m.track("message", "GetPrivateMessages")(&err)
+	return r.repo.GetPrivateMessages(ctx, from, to, limit)
+}
+
+func (r *messageRepository) GetGroupMessages(ctx context.Context, roomID int, limit int) (messages []domain.Message, err error) {
+	defer r.m.track("message", "GetGroupMessages")(&err)
+	return r.repo.GetGroupMessages(ctx, roomID, limit)
+}
+
+func (r *messageRepository) GetContacts(ctx context.Context, username string) (contacts []string, err error) {
+	defer r.m.track("message", "GetContacts")(&err)
+	return r.repo.GetContacts(ctx, username)
+}
+
+type roomRepository struct {
+	repo domain.RoomRepository
+	m    *Metrics
+}
+
+func (r *roomRepository) SaveRoom(ctx context.Context, room *domain.Room) (err error) {
+	defer r.m.track("room", "SaveRoom")(&err)
+	return r.repo.SaveRoom(ctx, room)
+}
+
+func (r *roomRepository) FindRoomByID(ctx context.Context, id int) (room *domain.Room, err error) {
+	defer r.m.track("room", "FindRoomByID")(&err)
+	return r.repo.FindRoomByID(ctx, id)
+}
+
+func (r *roomRepository) AddMember(ctx context.Context, member *domain.RoomMember) (err error) {
+	defer r.m.track("room", "AddMember")(&err)
+	return r.repo.AddMember(ctx, member)
+}
+
+func (r *roomRepository) RemoveMember(ctx context.Context, roomID int, username string) (err error) {
+	defer r.m.track("room", "RemoveMember")(&err)
+	return r.repo.RemoveMember(ctx, roomID, username)
+}
+
+func (r *roomRepository) SetTopic(ctx context.Context, roomID int, topic string) (err error) {
+	defer r.m.track("room", "SetTopic")(&err)
+	return r.repo.SetTopic(ctx, roomID, topic)
+}
+
+func (r *roomRepository) GetAllRooms(ctx context.Context) (rooms []*domain.Room, err error) {
+	defer r.m.track("room", "GetAllRooms")(&err)
+	return r.repo.GetAllRooms(ctx)
+}
+
+func (r *roomRepository) GetRoomMembers(ctx context.Context, roomID int) (members []domain.RoomMember, err error) {
+	defer r.m.track("room", "GetRoomMembers")(&err)
+	return r.repo.GetRoomMembers(ctx, roomID)
+}
+
+func (r *roomRepository) GetUserRooms(ctx context.Context, username string) (rooms []domain.Room, err error) {
+	defer r.m.track("room", "GetUserRooms")(&err)
+	return r.repo.GetUserRooms(ctx, username)
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+type BotRepository struct {
+	db *sql.DB
+}
+
+func NewBotRepository(db *sql.DB) *BotRepository {
+	return &BotRepository{db: db}
+}
+
+func (r *BotRepository) SaveBot(ctx context.Context, bot *domain.Bot) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO users (username, created_at, updated_at)
+		VALUES ($1, $2, $2)
+	`, bot.Username, bot.CreatedAt)
+	if err != nil {
+		return constraintError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO bots (username, owner, created_at)
+		VALUES ($1, $2, $3)
+	`, bot.Username, bot.Owner, bot.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *BotRepository) FindBot(ctx context.Context, username string) (*domain.Bot, error) {
+	query := `
+		SELECT username, owner, created_at
+		FROM bots
+		WHERE username = $1
+	`
+	var bot domain.Bot
+	err := r.db.QueryRowContext(ctx, query, username).Scan(&bot.Username, &bot.Owner, &bot.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &bot, nil
+}
+
+func (r *BotRepository) GetOwnedBots(ctx context.Context, owner string) ([]domain.Bot, error) {
+	query := `
+		SELECT username, owner, created_at
+		FROM bots
+		WHERE owner = $1
+		ORDER BY username
+	`
+	rows, err := r.db.QueryContext(ctx, query, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bots []domain.Bot
+	for rows.Next() {
+		var bot domain.Bot
+		if err := rows.Scan(&bot.Username, &bot.Owner, &bot.CreatedAt); err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, rows.Err()
+}
+
+func (r *BotRepository) SaveAPIKey(ctx context.Context, key *domain.APIKey) error {
+	query := `
+		INSERT INTO api_keys (username, prefix, key_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		key.Username,
+		key.Prefix,
+		key.KeyHash,
+		key.CreatedAt,
+	).Scan(&key.ID)
+}
+
+func (r *BotRepository) FindAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	query := `
+		SELECT id, username, prefix, key_hash, created_at, revoked_at
+		FROM api_keys
+		WHERE key_hash = $1
+	`
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+func (r *BotRepository) GetAPIKeys(ctx context.Context, username string) ([]domain.APIKey, error) {
+	query := `
+		SELECT id, username, prefix, key_hash, created_at, revoked_at
+		FROM api_keys
+		WHERE username = $1
+		ORDER BY id
+	`
+	rows, err := r.db.QueryContext(ctx, query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *BotRepository) RevokeAPIKey(ctx context.Context, id int, revokedAt time.Time) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = $2
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, query, id, revokedAt)
+	return err
+}
+
+func scanAPIKey(row scanner) (*domain.APIKey, error) {
+	var key domain.APIKey
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.Username,
+		&key.Prefix,
+		&key.KeyHash,
+		&key.CreatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"websocket_try3/internal/domain"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// constraintError wraps err with domain.ErrConstraint if Postgres rejected
+// the statement for its data: integrity constraint violations (class 23) and
+// data exceptions (class 22).
+func constraintError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "23") || strings.HasPrefix(pgErr.Code, "22")) {
+		return fmt.Errorf("%w: %w", domain.ErrConstraint, err)
+	}
+	return err
+}
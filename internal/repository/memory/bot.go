@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+var errUserExists = fmt.Errorf("memory: %w: user already exists", domain.ErrConstraint)
+
+// BotRepository saves bots into users, since every bot is a user too.
+type BotRepository struct {
+	users *UserRepository
+
+	mu        sync.RWMutex
+	nextKeyID int
+	bots      map[string]domain.Bot
+	keys      []domain.APIKey
+}
+
+func NewBotRepository(users *UserRepository) *BotRepository {
+	return &BotRepository{users: users, bots: make(map[string]domain.Bot)}
+}
+
+func (r *BotRepository) SaveBot(ctx context.Context, bot *domain.Bot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Unlike Save, saving a bot never updates an existing user
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+	if _, ok := r.users.users[bot.Username]; ok {
+		return errUserExists
+	}
+	r.users.users[bot.Username] = domain.User{
+		Username:  bot.Username,
+		Bot:       true,
+		CreatedAt: bot.CreatedAt,
+		UpdatedAt: bot.CreatedAt,
+	}
+	r.bots[bot.Username] = *bot
+	return nil
+}
+
+func (r *BotRepository) FindBot(ctx context.Context, username string) (*domain.Bot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	bot, ok := r.bots[username]
+	if !ok {
+		return nil, nil
+	}
+	return &bot, nil
+}
+
+func (r *BotRepository) GetOwnedBots(ctx context.Context, owner string) ([]domain.Bot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var bots []domain.Bot
+	for _, bot := range r.bots {
+		if bot.Owner == owner {
+			bots = append(bots, bot)
+		}
+	}
+	sort.Slice(bots, func(i, j int) bool {
+		return bots[i].Username < bots[j].Username
+	})
+	return bots, nil
+}
+
+func (r *BotRepository) SaveAPIKey(ctx context.Context, key *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextKeyID++
+	key.ID = r.nextKeyID
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *BotRepository) FindAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.KeyHash == keyHash {
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *BotRepository) GetAPIKeys(ctx context.Context, username string) ([]domain.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var keys []domain.APIKey
+	for _, key := range r.keys {
+		if key.Username == username {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *BotRepository) RevokeAPIKey(ctx context.Context, id int, revokedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.keys {
+		if r.keys[i].ID == id && r.keys[i].RevokedAt == nil {
+			r.keys[i].RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
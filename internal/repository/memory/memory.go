@@ -0,0 +1,328 @@
+// Package memory implements the domain repositories in process memory. It is
+// meant for tests, demos and local development: nothing survives a restart.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"websocket_try3/internal/domain"
+)
+
+type UserRepository struct {
+	mu    sync.RWMutex
+	users map[string]domain.User
+}
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{users: make(map[string]domain.User)}
+}
+
+func (r *UserRepository) Save(ctx context.Context, user *domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Like the Postgres upsert, an existing user only gets updated_at refreshed
+	if existing, ok := r.users[user.Username]; ok {
+		existing.UpdatedAt = user.UpdatedAt
+		r.users[user.Username] = existing
+		return nil
+	}
+	r.users[user.Username] = *user
+	return nil
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[username]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]domain.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+type MessageRepository struct {
+	mu       sync.RWMutex
+	nextID   int
+	messages []domain.Message
+}
+
+func NewMessageRepository() *MessageRepository {
+	return &MessageRepository{}
+}
+
+func (r *MessageRepository) SavePrivateMessage(ctx context.Context, msg *domain.Message) error {
+	saved := *msg
+	saved.Type = "private"
+	return r.SaveMessages(ctx, []*domain.Message{&saved})
+}
+
+func (r *MessageRepository) SaveGroupMessage(ctx context.Context, msg *domain.Message) error {
+	saved := *msg
+	saved.Type = "group"
+	return r.SaveMessages(ctx, []*domain.Message{&saved})
+}
+
+func (r *MessageRepository) SaveMessages(ctx context.Context, msgs []*domain.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, msg := range msgs {
+		r.nextID++
+		saved := *msg
+		saved.ID = r.nextID
+		r.messages = append(r.messages, saved)
+	}
+	return nil
+}
+
+func (r *MessageRepository) GetPrivateMessages(ctx context.Context, from, to string, limit int) ([]domain.Message, error) {
+	return r.latest(ctx, limit, func(msg *domain.Message) bool {
+		return msg.Type == "private" &&
+			(msg.From == from && msg.To == to || msg.From == to && msg.To == from)
+	})
+}
+
+func (r *MessageRepository) GetGroupMessages(ctx context.Context, roomID int, limit int) ([]domain.Message, error) {
+	return r.latest(ctx, limit, func(msg *domain.Message) bool {
+		return msg.Type == "group" && msg.GroupID == roomID
+	})
+}
+
+// latest returns the newest limit messages matching match, oldest first.
+func (r *MessageRepository) latest(ctx context.Context, limit int, match func(msg *domain.Message) bool) ([]domain.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var messages []domain.Message
+	for i := range r.messages {
+		if match(&r.messages[i]) {
+			messages = append(messages, r.messages[i])
+		}
+	}
+
+	// Messages can be saved out of order by a write-behind writer
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages, nil
+}
+
+func (r *MessageRepository) GetContacts(ctx context.Context, username string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var contacts []string
+	for _, msg := range r.messages {
+		if msg.Type != "private" {
+			continue
+		}
+
+		var contact string
+		switch username {
+		case msg.From:
+			contact = msg.To
+		case msg.To:
+			contact = msg.From
+		default:
+			continue
+		}
+		if !seen[contact] {
+			seen[contact] = true
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts, nil
+}
+
+type RoomRepository struct {
+	mu      sync.RWMutex
+	nextID  int
+	rooms   map[int]domain.Room
+	members map[int]map[string]domain.RoomMember
+}
+
+func NewRoomRepository() *RoomRepository {
+	return &RoomRepository{
+		rooms:   make(map[int]domain.Room),
+		members: make(map[int]map[string]domain.RoomMember),
+	}
+}
+
+func (r *RoomRepository) SaveRoom(ctx context.Context, room *domain.Room) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	room.ID = r.nextID
+	r.rooms[room.ID] = *room
+	return nil
+}
+
+func (r *RoomRepository) FindRoomByID(ctx context.Context, id int) (*domain.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	room, ok := r.rooms[id]
+	if !ok {
+		return nil, nil
+	}
+	return &room, nil
+}
+
+func (r *RoomRepository) AddMember(ctx context.Context, member *domain.RoomMember) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members, ok := r.members[member.RoomID]
+	if !ok {
+		members = make(map[string]domain.RoomMember)
+		r.members[member.RoomID] = members
+	}
+	if _, ok := members[member.Username]; !ok {
+		members[member.Username] = *member
+	}
+	return nil
+}
+
+func (r *RoomRepository) RemoveMember(ctx context.Context, roomID int, username string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.members[roomID], username)
+	return nil
+}
+
+func (r *RoomRepository) SetTopic(ctx context.Context, roomID int, topic string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if room, ok := r.rooms[roomID]; ok {
+		room.Topic = topic
+		r.rooms[roomID] = room
+	}
+	return nil
+}
+
+func (r *RoomRepository) GetAllRooms(ctx context.Context) ([]*domain.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rooms := make([]*domain.Room, 0, len(r.rooms))
+	for _, room := range r.rooms {
+		room := room
+		rooms = append(rooms, &room)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms, nil
+}
+
+func (r *RoomRepository) GetRoomMembers(ctx context.Context, roomID int) ([]domain.RoomMember, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	members := make([]domain.RoomMember, 0, len(r.members[roomID]))
+	for _, member := range r.members[roomID] {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+	return members, nil
+}
+
+func (r *RoomRepository) GetUserRooms(ctx context.Context, username string) ([]domain.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var rooms []domain.Room
+	for id, members := range r.members {
+		if _, ok := members[username]; ok {
+			if room, ok := r.rooms[id]; ok {
+				rooms = append(rooms, room)
+			}
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms, nil
+}
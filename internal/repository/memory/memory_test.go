@@ -0,0 +1,19 @@
+package memory
+
+import (
+	"testing"
+	"websocket_try3/internal/repository/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		users := NewUserRepository()
+		return repotest.Repositories{
+			Users:    users,
+			Messages: NewMessageRepository(),
+			Rooms:    NewRoomRepository(),
+			Webhooks: NewWebhookRepository(),
+			Bots:     NewBotRepository(users),
+		}
+	})
+}
@@ -0,0 +1,274 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+type WebhookRepository struct {
+	mu             sync.RWMutex
+	nextWebhookID  int
+	nextDeliveryID int
+	webhooks       map[int]domain.Webhook
+	deliveries     map[int]domain.WebhookDelivery
+	deadLetters    []domain.WebhookDeadLetter
+	incoming       []domain.IncomingWebhook
+}
+
+func NewWebhookRepository() *WebhookRepository {
+	return &WebhookRepository{
+		webhooks:   make(map[int]domain.Webhook),
+		deliveries: make(map[int]domain.WebhookDelivery),
+	}
+}
+
+func (r *WebhookRepository) SaveWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextWebhookID++
+	webhook.ID = r.nextWebhookID
+	saved := *webhook
+	saved.Events = slices.Clone(webhook.Events)
+	r.webhooks[webhook.ID] = saved
+	return nil
+}
+
+func (r *WebhookRepository) FindWebhook(ctx context.Context, id int) (*domain.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhook, ok := r.webhooks[id]
+	if !ok {
+		return nil, nil
+	}
+	webhook.Events = slices.Clone(webhook.Events)
+	return &webhook, nil
+}
+
+func (r *WebhookRepository) GetRoomWebhooks(ctx context.Context, roomID int) ([]domain.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var webhooks []domain.Webhook
+	for _, webhook := range r.webhooks {
+		if webhook.RoomID == roomID {
+			webhook.Events = slices.Clone(webhook.Events)
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.webhooks, id)
+	return nil
+}
+
+func (r *WebhookRepository) SaveDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextDeliveryID++
+	delivery.ID = r.nextDeliveryID
+	r.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.deliveries[delivery.ID]; ok {
+		r.deliveries[delivery.ID] = *delivery
+	}
+	return nil
+}
+
+func (r *WebhookRepository) FindDelivery(ctx context.Context, id int) (*domain.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	delivery, ok := r.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	return &delivery, nil
+}
+
+func (r *WebhookRepository) GetDeliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var deliveries []domain.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if filter.WebhookID != 0 && delivery.WebhookID != filter.WebhookID {
+			continue
+		}
+		if filter.Status != "" && delivery.Status != filter.Status {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if filter.Limit > 0 && len(deliveries) > filter.Limit {
+		deliveries = deliveries[:filter.Limit]
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookRepository) DeadLetter(ctx context.Context, delivery *domain.WebhookDelivery, failedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delivery.Status = domain.DeliveryDead
+	delivery.UpdatedAt = failedAt
+	r.deliveries[delivery.ID] = *delivery
+	r.deadLetters = append(r.deadLetters, domain.WebhookDeadLetter{
+		ID:         len(r.deadLetters) + 1,
+		DeliveryID: delivery.ID,
+		WebhookID:  delivery.WebhookID,
+		Event:      delivery.Event,
+		Payload:    delivery.Payload,
+		Attempts:   delivery.Attempts,
+		LastError:  delivery.LastError,
+		FailedAt:   failedAt,
+	})
+	return nil
+}
+
+func (r *WebhookRepository) GetDeadLetters(ctx context.Context, limit int) ([]domain.WebhookDeadLetter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	letters := make([]domain.WebhookDeadLetter, 0, len(r.deadLetters))
+	for i := len(r.deadLetters) - 1; i >= 0; i-- {
+		if limit > 0 && len(letters) == limit {
+			break
+		}
+		letters = append(letters, r.deadLetters[i])
+	}
+	return letters, nil
+}
+
+func (r *WebhookRepository) SaveIncomingWebhook(ctx context.Context, webhook *domain.IncomingWebhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook.ID = len(r.incoming) + 1
+	r.incoming = append(r.incoming, *webhook)
+	return nil
+}
+
+func (r *WebhookRepository) FindIncomingWebhook(ctx context.Context, id int) (*domain.IncomingWebhook, error) {
+	return r.findIncoming(ctx, func(webhook domain.IncomingWebhook) bool { return webhook.ID == id })
+}
+
+func (r *WebhookRepository) FindIncomingWebhookByToken(ctx context.Context, tokenHash string) (*domain.IncomingWebhook, error) {
+	return r.findIncoming(ctx, func(webhook domain.IncomingWebhook) bool { return webhook.TokenHash == tokenHash })
+}
+
+func (r *WebhookRepository) findIncoming(ctx context.Context, match func(domain.IncomingWebhook) bool) (*domain.IncomingWebhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, webhook := range r.incoming {
+		if match(webhook) {
+			return &webhook, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *WebhookRepository) GetRoomIncomingWebhooks(ctx context.Context, roomID int) ([]domain.IncomingWebhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var webhooks []domain.IncomingWebhook
+	for _, webhook := range r.incoming {
+		if webhook.RoomID == roomID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) RevokeIncomingWebhook(ctx context.Context, id int, revokedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.incoming {
+		if r.incoming[i].ID == id && r.incoming[i].RevokedAt == nil {
+			r.incoming[i].RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
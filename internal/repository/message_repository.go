@@ -2,7 +2,10 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"websocket_try3/internal/domain"
 )
 
@@ -14,14 +17,15 @@ func NewMessageRepository(db *sql.DB) *MessageRepository {
 	return &MessageRepository{db: db}
 }
 
-func (r *MessageRepository) SavePrivateMessage(msg *domain.Message) error {
+func (r *MessageRepository) SavePrivateMessage(ctx context.Context, msg *domain.Message) error {
 
 	query := `
 		INSERT INTO messages (
 			from_user, to_user, content, type, created_at
 		) VALUES ($1, $2, $3, $4, $5)
 	`
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		msg.From,
 		msg.To,
@@ -37,14 +41,15 @@ func (r *MessageRepository) SavePrivateMessage(msg *domain.Message) error {
 	return nil
 }
 
-func (r *MessageRepository) SaveGroupMessage(msg *domain.Message) error {
+func (r *MessageRepository) SaveGroupMessage(ctx context.Context, msg *domain.Message) error {
 
 	query := `
 		INSERT INTO messages (
 			from_user, content, type, group_id, created_at
 		) VALUES ($1, $2, $3, $4, $5)
 	`
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		msg.From,
 		msg.Content,
@@ -58,17 +63,47 @@ func (r *MessageRepository) SaveGroupMessage(msg *domain.Message) error {
 	return nil
 }
 
-func (r *MessageRepository) GetPrivateMessages(from, to string, limit int) ([]domain.Message, error) {
+// SaveMessages writes private and group messages with a single multi-row insert
+func (r *MessageRepository) SaveMessages(ctx context.Context, msgs []*domain.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var query strings.Builder
+	query.WriteString(`
+		INSERT INTO messages (
+			from_user, to_user, content, type, group_id, created_at
+		) VALUES `)
+
+	args := make([]any, 0, len(msgs)*6)
+	for i, msg := range msgs {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6)
+
+		toUser := sql.NullString{String: msg.To, Valid: msg.To != ""}
+		groupID := sql.NullInt64{Int64: int64(msg.GroupID), Valid: msg.GroupID != 0}
+		args = append(args, msg.From, toUser, msg.Content, msg.Type, groupID, msg.CreatedAt)
+	}
+
+	_, err := r.db.ExecContext(ctx, query.String(), args...)
+	return constraintError(err)
+}
+
+func (r *MessageRepository) GetPrivateMessages(ctx context.Context, from, to string, limit int) ([]domain.Message, error) {
 	query := `
 		SELECT id, from_user, to_user, content, type, created_at
 		FROM messages
 		WHERE type = 'private' AND (
 			(from_user = $1 AND to_user = $2) OR 
 			(from_user = $2 AND to_user = $1)
+		)
 		ORDER BY created_at DESC
 		LIMIT $3
 	`
-	rows, err := r.db.Query(query, from, to, limit)
+	rows, err := r.db.QueryContext(ctx, query, from, to, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +138,7 @@ func (r *MessageRepository) GetPrivateMessages(from, to string, limit int) ([]do
 	return messages, nil
 }
 
-func (r *MessageRepository) GetGroupMessages(roomID int, limit int) ([]domain.Message, error) {
+func (r *MessageRepository) GetGroupMessages(ctx context.Context, roomID int, limit int) ([]domain.Message, error) {
 	query := `
 		SELECT id, from_user, content, type, group_id, created_at
 		FROM messages
@@ -111,7 +146,7 @@ func (r *MessageRepository) GetGroupMessages(roomID int, limit int) ([]domain.Me
 		ORDER BY created_at DESC
 		LIMIT $2
 	`
-	rows, err := r.db.Query(query, roomID, limit)
+	rows, err := r.db.QueryContext(ctx, query, roomID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -145,3 +180,31 @@ func (r *MessageRepository) GetGroupMessages(roomID int, limit int) ([]domain.Me
 
 	return messages, nil
 }
+
+func (r *MessageRepository) GetContacts(ctx context.Context, username string) ([]string, error) {
+	query := `
+		SELECT DISTINCT CASE WHEN from_user = $1 THEN to_user ELSE from_user END
+		FROM messages
+		WHERE type = 'private' AND (from_user = $1 OR to_user = $1)
+	`
+	rows, err := r.db.QueryContext(ctx, query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []string
+	for rows.Next() {
+		var contact string
+		if err := rows.Scan(&contact); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("websocket_try3/internal/repository")
+
+var (
+	ErrQueueFull    = errors.New("message queue is full")
+	ErrWriterClosed = errors.New("message writer is closed")
+)
+
+type MessageWriterConfig struct {
+	// BatchSize is the number of messages that triggers a flush
+	BatchSize int
+	// FlushInterval is the longest a message waits in the buffer
+	FlushInterval time.Duration
+	// QueueSize bounds the number of messages waiting to be written
+	QueueSize int
+	// MaxRetries is how often a failed batch is retried before it is dropped.
+	// Batches rejected with domain.ErrConstraint are not retried but written
+	// row by row, so only the offending messages are lost
+	MaxRetries int
+	// RetryBackoff is the initial delay between retries, doubled on every
+	// attempt up to MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// WriteTimeout bounds every attempt at writing a batch
+	WriteTimeout time.Duration
+}
+
+func DefaultMessageWriterConfig() MessageWriterConfig {
+	return MessageWriterConfig{
+		BatchSize:     500,
+		FlushInterval: 100 * time.Millisecond,
+		QueueSize:     10000,
+		MaxRetries:    5,
+		RetryBackoff:  100 * time.Millisecond,
+		MaxBackoff:    5 * time.Second,
+		WriteTimeout:  5 * time.Second,
+	}
+}
+
+type MessageWriterStats struct {
+	Enqueued uint64
+	Written  uint64
+	Dropped  uint64
+	Failed   uint64
+	// Rejected messages violated a constraint, see domain.ErrConstraint
+	Rejected   uint64
+	Retries    uint64
+	Batches    uint64
+	QueueDepth int
+}
+
+// MessageWriter is a write-behind decorator for a MessageRepository. Saves are
+// queued and written in batches through SaveMessages on a background
+// goroutine; reads go straight to the wrapped repository and may lag behind
+// writes by up to FlushInterval. A save only uses its context to decide
+// whether to queue the message: once queued, the write is bounded by
+// WriteTimeout instead, so a caller going away doesn't lose its message.
+// The batch write is traced with a link to the span of every save in it.
+type MessageWriter struct {
+	domain.MessageRepository
+
+	config MessageWriterConfig
+	queue  chan queuedMessage
+
+	// mu guards closed so that no message is queued after Close starts draining
+	mu     sync.RWMutex
+	closed bool
+	quit   chan struct{}
+	done   chan struct{}
+
+	enqueued atomic.Uint64
+	written  atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+	rejected atomic.Uint64
+	retries  atomic.Uint64
+	batches  atomic.Uint64
+}
+
+type queuedMessage struct {
+	msg  *domain.Message
+	span trace.SpanContext
+}
+
+func NewMessageWriter(repo domain.MessageRepository, config MessageWriterConfig) *MessageWriter {
+	defaults := DefaultMessageWriterConfig()
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaults.RetryBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaults.MaxBackoff
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaults.WriteTimeout
+	}
+
+	w := &MessageWriter{
+		MessageRepository: repo,
+		config:            config,
+		queue:             make(chan queuedMessage, config.QueueSize),
+		quit:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *MessageWriter) SavePrivateMessage(ctx context.Context, msg *domain.Message) error {
+	return w.enqueue(ctx, msg)
+}
+
+func (w *MessageWriter) SaveGroupMessage(ctx context.Context, msg *domain.Message) error {
+	return w.enqueue(ctx, msg)
+}
+
+func (w *MessageWriter) SaveMessages(ctx context.Context, msgs []*domain.Message) error {
+	for _, msg := range msgs {
+		if err := w.enqueue(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *MessageWriter) Stats() MessageWriterStats {
+	return MessageWriterStats{
+		Enqueued:   w.enqueued.Load(),
+		Written:    w.written.Load(),
+		Dropped:    w.dropped.Load(),
+		Failed:     w.failed.Load(),
+		Rejected:   w.rejected.Load(),
+		Retries:    w.retries.Load(),
+		Batches:    w.batches.Load(),
+		QueueDepth: len(w.queue),
+	}
+}
+
+// Close stops accepting messages and waits until everything queued so far has
+// been written or ctx expires.
+func (w *MessageWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.quit)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *MessageWriter) enqueue(ctx context.Context, msg *domain.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.queue <- queuedMessage{msg: msg, span: trace.SpanContextFromContext(ctx)}:
+		w.enqueued.Add(1)
+		return nil
+	default:
+		w.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+func (w *MessageWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]queuedMessage, 0, w.config.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			w.flush(batch)
+			batch = make([]queuedMessage, 0, w.config.BatchSize)
+		}
+	}
+
+	for {
+		select {
+		case msg := <-w.queue:
+			batch = append(batch, msg)
+			if len(batch) >= w.config.BatchSize {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+
+		case <-w.quit:
+			// Nothing can be queued any more, so draining until empty is final
+			for {
+				select {
+				case msg := <-w.queue:
+					batch = append(batch, msg)
+					if len(batch) >= w.config.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *MessageWriter) flush(queued []queuedMessage) {
+	batch := make([]*domain.Message, len(queued))
+	var links []trace.Link
+	for i, q := range queued {
+		batch[i] = q.msg
+		if q.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: q.span})
+		}
+	}
+
+	ctx, span := tracer.Start(context.Background(), "MessageWriter.flush",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("messages", len(batch))),
+	)
+	var err error
+	defer tracing.End(span, &err)
+
+	err = w.write(ctx, span, batch)
+	if errors.Is(err, domain.ErrConstraint) && len(batch) > 1 {
+		// One bad message fails the whole insert, so find it by writing the
+		// messages one at a time
+		span.AddEvent("write one by one")
+		err = nil
+		for _, msg := range batch {
+			if msgErr := w.write(ctx, span, []*domain.Message{msg}); msgErr != nil {
+				err = msgErr
+			}
+		}
+	}
+}
+
+// write saves batch, retrying on errors that may be transient, and counts the
+// outcome.
+func (w *MessageWriter) write(ctx context.Context, span trace.Span, batch []*domain.Message) error {
+	backoff := w.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, w.config.WriteTimeout)
+		err := w.MessageRepository.SaveMessages(attemptCtx, batch)
+		cancel()
+		switch {
+		case err == nil:
+			w.written.Add(uint64(len(batch)))
+			w.batches.Add(1)
+			return nil
+
+		case errors.Is(err, domain.ErrConstraint):
+			if len(batch) == 1 {
+				w.rejected.Add(1)
+				slog.ErrorContext(ctx, "message rejected", "from", batch[0].From, "type", batch[0].Type, "err", err)
+			}
+			return err
+
+		case attempt >= w.config.MaxRetries:
+			w.failed.Add(uint64(len(batch)))
+			slog.ErrorContext(ctx, "dropping messages", "messages", len(batch), "attempts", attempt+1, "err", err)
+			return err
+		}
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt+1)))
+
+		w.retries.Add(1)
+		slog.WarnContext(ctx, "write messages failed, retrying", "messages", len(batch), "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, w.config.MaxBackoff)
+	}
+}
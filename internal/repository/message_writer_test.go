@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+// stubMessageRepository simulates a database round trip per call
+type stubMessageRepository struct {
+	domain.MessageRepository
+
+	latency  time.Duration
+	failures int
+	// stuck makes every write hang until its context is done
+	stuck bool
+	// poison fails every write containing a message with this content as
+	// violating a constraint
+	poison string
+
+	mu    sync.Mutex
+	saved int
+}
+
+func (r *stubMessageRepository) SaveGroupMessage(ctx context.Context, msg *domain.Message) error {
+	return r.SaveMessages(ctx, []*domain.Message{msg})
+}
+
+func (r *stubMessageRepository) SaveMessages(ctx context.Context, msgs []*domain.Message) error {
+	time.Sleep(r.latency)
+	if r.stuck {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, msg := range msgs {
+		if r.poison != "" && msg.Content == r.poison {
+			return fmt.Errorf("%w: foreign key", domain.ErrConstraint)
+		}
+	}
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("connection reset")
+	}
+	r.saved += len(msgs)
+	return nil
+}
+
+func (r *stubMessageRepository) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.saved
+}
+
+func newTestMessage(i int) *domain.Message {
+	return &domain.Message{
+		From:      "alice",
+		Content:   "hello",
+		Type:      "group",
+		GroupID:   1 + i%10,
+		CreatedAt: time.Now(),
+	}
+}
+
+func TestMessageWriterDrainsOnClose(t *testing.T) {
+	repo := &stubMessageRepository{}
+	writer := NewMessageWriter(repo, MessageWriterConfig{
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+	})
+
+	for i := 0; i < 250; i++ {
+		if err := writer.SaveGroupMessage(context.Background(), newTestMessage(i)); err != nil {
+			t.Fatalf("SaveGroupMessage: %v", err)
+		}
+	}
+
+	if err := writer.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := repo.count(); got != 250 {
+		t.Fatalf("saved %d messages, want 250", got)
+	}
+	if err := writer.SaveGroupMessage(context.Background(), newTestMessage(0)); !errors.Is(err, ErrWriterClosed) {
+		t.Fatalf("SaveGroupMessage after Close = %v, want ErrWriterClosed", err)
+	}
+}
+
+func TestMessageWriterRetries(t *testing.T) {
+	repo := &stubMessageRepository{failures: 2}
+	writer := NewMessageWriter(repo, MessageWriterConfig{
+		BatchSize:    10,
+		RetryBackoff: time.Millisecond,
+		MaxRetries:   3,
+	})
+
+	for i := 0; i < 10; i++ {
+		writer.SaveGroupMessage(context.Background(), newTestMessage(i))
+	}
+	writer.Close(context.Background())
+
+	stats := writer.Stats()
+	if stats.Written != 10 || stats.Retries != 2 || stats.Failed != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMessageWriterQueueFull(t *testing.T) {
+	repo := &stubMessageRepository{latency: 50 * time.Millisecond}
+	writer := NewMessageWriter(repo, MessageWriterConfig{
+		BatchSize: 1,
+		QueueSize: 1,
+	})
+	defer writer.Close(context.Background())
+
+	var full bool
+	for i := 0; i < 10; i++ {
+		if errors.Is(writer.SaveGroupMessage(context.Background(), newTestMessage(i)), ErrQueueFull) {
+			full = true
+		}
+	}
+	if !full || writer.Stats().Dropped == 0 {
+		t.Fatalf("expected ErrQueueFull, stats: %+v", writer.Stats())
+	}
+}
+
+func TestMessageWriterWriteTimeout(t *testing.T) {
+	repo := &stubMessageRepository{stuck: true}
+	writer := NewMessageWriter(repo, MessageWriterConfig{
+		BatchSize:     10,
+		FlushInterval: time.Millisecond,
+		MaxRetries:    1,
+		RetryBackoff:  time.Millisecond,
+		WriteTimeout:  10 * time.Millisecond,
+	})
+
+	// The caller going away must not affect a message that was already queued
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := writer.SaveGroupMessage(ctx, newTestMessage(0)); err != nil {
+		t.Fatalf("SaveGroupMessage: %v", err)
+	}
+	cancel()
+	if err := writer.SaveGroupMessage(ctx, newTestMessage(1)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SaveGroupMessage with cancelled context = %v, want context.Canceled", err)
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), time.Second)
+	defer closeCancel()
+	if err := writer.Close(closeCtx); err != nil {
+		t.Fatalf("Close against a stuck database: %v", err)
+	}
+
+	stats := writer.Stats()
+	if stats.Failed != 1 || stats.Retries != 1 {
+		t.Fatalf("got %d failed and %d retries, want 1 and 1", stats.Failed, stats.Retries)
+	}
+}
+
+// TestMessageWriterRejectsPoisonMessage checks that a message violating a
+// constraint is dropped on its own, without retries, and the rest of its
+// batch is still written.
+func TestMessageWriterRejectsPoisonMessage(t *testing.T) {
+	repo := &stubMessageRepository{poison: "poison"}
+	writer := NewMessageWriter(repo, MessageWriterConfig{
+		BatchSize:    10,
+		RetryBackoff: time.Millisecond,
+	})
+
+	for i := 0; i < 10; i++ {
+		msg := newTestMessage(i)
+		if i == 4 {
+			msg.Content = "poison"
+		}
+		writer.SaveGroupMessage(context.Background(), msg)
+	}
+	writer.Close(context.Background())
+
+	stats := writer.Stats()
+	if stats.Written != 9 || stats.Rejected != 1 || stats.Failed != 0 || stats.Retries != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if got := repo.count(); got != 9 {
+		t.Fatalf("saved %d messages, want 9", got)
+	}
+}
+
+// BenchmarkDirectSave is the baseline: one round trip per message.
+func BenchmarkDirectSave(b *testing.B) {
+	repo := &stubMessageRepository{latency: 100 * time.Microsecond}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.SaveGroupMessage(context.Background(), newTestMessage(i))
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
+
+func BenchmarkMessageWriter(b *testing.B) {
+	repo := &stubMessageRepository{latency: 100 * time.Microsecond}
+	writer := NewMessageWriter(repo, MessageWriterConfig{
+		QueueSize: b.N + 1,
+	})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writer.SaveGroupMessage(context.Background(), newTestMessage(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := writer.Close(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+
+	if got := repo.count(); got != b.N {
+		b.Fatalf("saved %d messages, want %d", got, b.N)
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msgs/s")
+}
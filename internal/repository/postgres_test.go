@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+	"websocket_try3/internal/config"
+	"websocket_try3/internal/repository/repotest"
+)
+
+var conformanceSchemas atomic.Int64
+
+// TestPostgresConformance runs the repository conformance suite against a
+// real database. It is skipped unless POSTGRES_TEST_DSN is set, e.g. to
+// "host=localhost user=postgres password=postgres dbname=chat_test sslmode=disable".
+// Every subtest gets a schema of its own, which is dropped afterwards.
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		admin, err := sql.Open("pgx", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { admin.Close() })
+
+		schema := fmt.Sprintf("conformance_%d_%d", os.Getpid(), conformanceSchemas.Add(1))
+		if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		})
+
+		db, err := sql.Open("pgx", dsn+" search_path="+schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		migrator, err := config.NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		return repotest.Repositories{
+			Users:    NewUserRepository(db),
+			Messages: NewMessageRepository(db),
+			Rooms:    NewRoomRepository(db),
+			Webhooks: NewWebhookRepository(db),
+			Bots:     NewBotRepository(db),
+		}
+	})
+}
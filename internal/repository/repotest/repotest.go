@@ -0,0 +1,854 @@
+// Package repotest is the conformance suite for implementations of the domain
+// repository interfaces. Every backend runs it from its own tests, so they
+// all behave the same way as far as the usecase can tell.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+type Repositories struct {
+	Users    domain.UserRepository
+	Messages domain.MessageRepository
+	Rooms    domain.RoomRepository
+	Webhooks domain.WebhookRepository
+	Bots     domain.BotRepository
+}
+
+// Run runs the suite. newRepos must return repositories backed by a fresh,
+// empty store on every call.
+func Run(t *testing.T, newRepos func(t *testing.T) Repositories) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repos Repositories)
+	}{
+		{"UserSaveAndFind", testUserSaveAndFind},
+		{"UserSaveKeepsCreatedAt", testUserSaveKeepsCreatedAt},
+		{"UserFindAll", testUserFindAll},
+		{"RoomSaveAndFind", testRoomSaveAndFind},
+		{"RoomGetAll", testRoomGetAll},
+		{"RoomMembers", testRoomMembers},
+		{"RoomRemoveMember", testRoomRemoveMember},
+		{"RoomTopic", testRoomTopic},
+		{"PrivateMessages", testPrivateMessages},
+		{"GroupMessages", testGroupMessages},
+		{"SaveMessages", testSaveMessages},
+		{"Contacts", testContacts},
+		{"Webhooks", testWebhooks},
+		{"WebhookDeliveries", testWebhookDeliveries},
+		{"WebhookDeadLetters", testWebhookDeadLetters},
+		{"IncomingWebhooks", testIncomingWebhooks},
+		{"Bots", testBots},
+		{"APIKeys", testAPIKeys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepos(t))
+		})
+	}
+}
+
+// base is a fixed point in time with second precision, which every backend
+// can store and return exactly.
+var base = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return base.Add(time.Duration(seconds) * time.Second)
+}
+
+func mustSaveUsers(t *testing.T, repos Repositories, usernames ...string) {
+	t.Helper()
+	for _, username := range usernames {
+		user := &domain.User{Username: username, CreatedAt: base, UpdatedAt: base}
+		if err := repos.Users.Save(context.Background(), user); err != nil {
+			t.Fatalf("Save(%s): %v", username, err)
+		}
+	}
+}
+
+func mustSaveRoom(t *testing.T, repos Repositories, name, creator string) *domain.Room {
+	t.Helper()
+	room := &domain.Room{Name: name, CreatedBy: creator, CreatedAt: base}
+	if err := repos.Rooms.SaveRoom(context.Background(), room); err != nil {
+		t.Fatalf("SaveRoom(%s): %v", name, err)
+	}
+	return room
+}
+
+func assertTime(t *testing.T, what string, got, want time.Time) {
+	t.Helper()
+	if !got.Equal(want) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func testUserSaveAndFind(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	user, err := repos.Users.FindByUsername(ctx, "alice")
+	if err != nil || user != nil {
+		t.Fatalf("FindByUsername of unknown user = %v, %v; want nil, nil", user, err)
+	}
+
+	mustSaveUsers(t, repos, "alice")
+
+	user, err = repos.Users.FindByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.Username != "alice" {
+		t.Fatalf("FindByUsername = %+v, want alice", user)
+	}
+	assertTime(t, "CreatedAt", user.CreatedAt, base)
+	assertTime(t, "UpdatedAt", user.UpdatedAt, base)
+}
+
+func testUserSaveKeepsCreatedAt(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice")
+
+	// Saving again only refreshes updated_at
+	again := &domain.User{Username: "alice", CreatedAt: at(60), UpdatedAt: at(60)}
+	if err := repos.Users.Save(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repos.Users.FindByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTime(t, "CreatedAt", user.CreatedAt, base)
+	assertTime(t, "UpdatedAt", user.UpdatedAt, at(60))
+}
+
+func testUserFindAll(t *testing.T, repos Repositories) {
+	mustSaveUsers(t, repos, "carol", "alice", "bob")
+
+	users, err := repos.Users.FindAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	if fmt.Sprint(names) != "[alice bob carol]" {
+		t.Fatalf("FindAll = %v, want [alice bob carol] in order", names)
+	}
+}
+
+func testRoomSaveAndFind(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice")
+
+	first := mustSaveRoom(t, repos, "general", "alice")
+	second := mustSaveRoom(t, repos, "random", "alice")
+	if first.ID == 0 || second.ID == 0 || first.ID == second.ID {
+		t.Fatalf("SaveRoom assigned IDs %d and %d, want distinct non-zero IDs", first.ID, second.ID)
+	}
+
+	room, err := repos.Rooms.FindRoomByID(ctx, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room == nil || room.Name != "random" || room.CreatedBy != "alice" {
+		t.Fatalf("FindRoomByID = %+v, want random by alice", room)
+	}
+	assertTime(t, "CreatedAt", room.CreatedAt, base)
+
+	room, err = repos.Rooms.FindRoomByID(ctx, second.ID+100)
+	if err != nil || room != nil {
+		t.Fatalf("FindRoomByID of unknown room = %v, %v; want nil, nil", room, err)
+	}
+}
+
+func testRoomGetAll(t *testing.T, repos Repositories) {
+	mustSaveUsers(t, repos, "alice")
+	mustSaveRoom(t, repos, "random", "alice")
+	mustSaveRoom(t, repos, "general", "alice")
+
+	rooms, err := repos.Rooms.GetAllRooms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 || rooms[0].Name != "general" || rooms[1].Name != "random" {
+		t.Fatalf("GetAllRooms returned %d rooms, want general and random ordered by name", len(rooms))
+	}
+}
+
+func testRoomMembers(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice", "bob")
+	general := mustSaveRoom(t, repos, "general", "alice")
+	random := mustSaveRoom(t, repos, "random", "alice")
+
+	add := func(room *domain.Room, username string, joined time.Time) {
+		t.Helper()
+		member := &domain.RoomMember{RoomID: room.ID, Username: username, JoinedAt: joined}
+		if err := repos.Rooms.AddMember(ctx, member); err != nil {
+			t.Fatalf("AddMember(%d, %s): %v", room.ID, username, err)
+		}
+	}
+	add(general, "bob", at(2))
+	add(general, "alice", at(1))
+	add(random, "alice", at(3))
+	// Adding an existing member is a no-op
+	add(general, "bob", at(10))
+
+	members, err := repos.Rooms.GetRoomMembers(ctx, general.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].Username != "alice" || members[1].Username != "bob" {
+		t.Fatalf("GetRoomMembers = %+v, want alice then bob ordered by join time", members)
+	}
+	assertTime(t, "JoinedAt", members[1].JoinedAt, at(2))
+	if members[0].RoomID != general.ID {
+		t.Errorf("RoomID = %d, want %d", members[0].RoomID, general.ID)
+	}
+
+	rooms, err := repos.Rooms.GetUserRooms(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 || rooms[0].Name != "general" || rooms[1].Name != "random" {
+		t.Fatalf("GetUserRooms(alice) = %+v, want general and random ordered by name", rooms)
+	}
+
+	rooms, err = repos.Rooms.GetUserRooms(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != general.ID {
+		t.Fatalf("GetUserRooms(bob) = %+v, want general", rooms)
+	}
+}
+
+func testRoomRemoveMember(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice", "bob")
+	room := mustSaveRoom(t, repos, "general", "alice")
+	for _, username := range []string{"alice", "bob"} {
+		if err := repos.Rooms.AddMember(ctx, &domain.RoomMember{RoomID: room.ID, Username: username, JoinedAt: base}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repos.Rooms.RemoveMember(ctx, room.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	// Removing someone who isn't a member is a no-op
+	if err := repos.Rooms.RemoveMember(ctx, room.ID, "bob"); err != nil {
+		t.Fatalf("RemoveMember of a non-member: %v", err)
+	}
+
+	members, err := repos.Rooms.GetRoomMembers(ctx, room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Username != "alice" {
+		t.Fatalf("GetRoomMembers = %+v, want only alice", members)
+	}
+	rooms, err := repos.Rooms.GetUserRooms(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 0 {
+		t.Fatalf("GetUserRooms(bob) = %+v, want none", rooms)
+	}
+}
+
+func testRoomTopic(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice")
+	room := mustSaveRoom(t, repos, "general", "alice")
+	if err := repos.Rooms.AddMember(ctx, &domain.RoomMember{RoomID: room.ID, Username: "alice", JoinedAt: base}); err != nil {
+		t.Fatal(err)
+	}
+
+	topic := func() string {
+		t.Helper()
+		found, err := repos.Rooms.FindRoomByID(ctx, room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found.Topic
+	}
+	if got := topic(); got != "" {
+		t.Fatalf("Topic of a new room = %q, want none", got)
+	}
+
+	if err := repos.Rooms.SetTopic(ctx, room.ID, "release planning"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Rooms.SetTopic(ctx, room.ID, "release day"); err != nil {
+		t.Fatal(err)
+	}
+	if got := topic(); got != "release day" {
+		t.Errorf("Topic = %q, want the latest one", got)
+	}
+	all, err := repos.Rooms.GetAllRooms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Topic != "release day" {
+		t.Errorf("GetAllRooms = %+v, want the topic", all)
+	}
+	rooms, err := repos.Rooms.GetUserRooms(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].Topic != "release day" {
+		t.Errorf("GetUserRooms = %+v, want the topic", rooms)
+	}
+
+	if err := repos.Rooms.SetTopic(ctx, room.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := topic(); got != "" {
+		t.Errorf("Topic after clearing = %q, want none", got)
+	}
+}
+
+func testPrivateMessages(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice", "bob", "carol")
+
+	save := func(from, to, content string, sent time.Time) {
+		t.Helper()
+		msg := &domain.Message{From: from, To: to, Content: content, Type: "private", CreatedAt: sent}
+		if err := repos.Messages.SavePrivateMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("alice", "bob", "1", at(1))
+	save("bob", "alice", "2", at(2))
+	save("alice", "carol", "other", at(3))
+	save("alice", "bob", "3", at(4))
+
+	// The latest limit messages in either direction, oldest first
+	messages, err := repos.Messages.GetPrivateMessages(ctx, "bob", "alice", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Content != "2" || messages[1].Content != "3" {
+		t.Fatalf("GetPrivateMessages = %+v, want 2 then 3", messages)
+	}
+
+	msg := messages[1]
+	if msg.ID == 0 || msg.From != "alice" || msg.To != "bob" || msg.Type != "private" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	assertTime(t, "CreatedAt", msg.CreatedAt, at(4))
+}
+
+func testGroupMessages(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice", "bob")
+	general := mustSaveRoom(t, repos, "general", "alice")
+	random := mustSaveRoom(t, repos, "random", "alice")
+
+	save := func(from string, room *domain.Room, content string, sent time.Time) {
+		t.Helper()
+		msg := &domain.Message{From: from, Content: content, Type: "group", GroupID: room.ID, CreatedAt: sent}
+		if err := repos.Messages.SaveGroupMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("alice", general, "1", at(1))
+	save("bob", general, "2", at(2))
+	save("bob", random, "other", at(3))
+	save("alice", general, "3", at(4))
+
+	messages, err := repos.Messages.GetGroupMessages(ctx, general.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Content != "2" || messages[1].Content != "3" {
+		t.Fatalf("GetGroupMessages = %+v, want 2 then 3", messages)
+	}
+
+	msg := messages[0]
+	if msg.ID == 0 || msg.From != "bob" || msg.GroupID != general.ID || msg.Type != "group" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	assertTime(t, "CreatedAt", msg.CreatedAt, at(2))
+}
+
+func testSaveMessages(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice", "bob")
+	general := mustSaveRoom(t, repos, "general", "alice")
+
+	if err := repos.Messages.SaveMessages(ctx, nil); err != nil {
+		t.Fatalf("SaveMessages(nil): %v", err)
+	}
+
+	err := repos.Messages.SaveMessages(ctx, []*domain.Message{
+		{From: "alice", To: "bob", Content: "private", Type: "private", CreatedAt: at(1)},
+		{From: "bob", Content: "group", Type: "group", GroupID: general.ID, CreatedAt: at(2)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private, err := repos.Messages.GetPrivateMessages(ctx, "alice", "bob", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(private) != 1 || private[0].Content != "private" {
+		t.Fatalf("GetPrivateMessages = %+v, want the batched private message", private)
+	}
+
+	group, err := repos.Messages.GetGroupMessages(ctx, general.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group) != 1 || group[0].Content != "group" {
+		t.Fatalf("GetGroupMessages = %+v, want the batched group message", group)
+	}
+}
+
+func testContacts(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice", "bob", "carol", "dave")
+	general := mustSaveRoom(t, repos, "general", "alice")
+
+	err := repos.Messages.SaveMessages(ctx, []*domain.Message{
+		{From: "alice", To: "bob", Content: "hi", Type: "private", CreatedAt: at(1)},
+		{From: "bob", To: "alice", Content: "hi", Type: "private", CreatedAt: at(2)},
+		{From: "carol", To: "alice", Content: "hi", Type: "private", CreatedAt: at(3)},
+		{From: "bob", To: "carol", Content: "hi", Type: "private", CreatedAt: at(4)},
+		// Group messages don't make contacts
+		{From: "dave", Content: "hi", Type: "group", GroupID: general.ID, CreatedAt: at(5)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contacts, err := repos.Messages.GetContacts(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(contacts)
+	if fmt.Sprint(contacts) != "[bob carol]" {
+		t.Fatalf("GetContacts(alice) = %v, want [bob carol]", contacts)
+	}
+
+	contacts, err = repos.Messages.GetContacts(ctx, "dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 0 {
+		t.Fatalf("GetContacts(dave) = %v, want none", contacts)
+	}
+}
+
+func mustSaveDelivery(t *testing.T, repos Repositories, webhookID int, event string, created time.Time) *domain.WebhookDelivery {
+	t.Helper()
+	delivery := &domain.WebhookDelivery{
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       []byte(`{"event":"` + event + `"}`),
+		Status:        domain.DeliveryPending,
+		NextAttemptAt: created,
+		CreatedAt:     created,
+		UpdatedAt:     created,
+	}
+	if err := repos.Webhooks.SaveDelivery(context.Background(), delivery); err != nil {
+		t.Fatalf("SaveDelivery(%s): %v", event, err)
+	}
+	return delivery
+}
+
+func testWebhooks(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice")
+	general := mustSaveRoom(t, repos, "general", "alice")
+	random := mustSaveRoom(t, repos, "random", "alice")
+
+	save := func(room *domain.Room, url string, events ...string) *domain.Webhook {
+		t.Helper()
+		webhook := &domain.Webhook{
+			RoomID:    room.ID,
+			URL:       url,
+			Secret:    "secret",
+			Events:    events,
+			CreatedBy: "alice",
+			CreatedAt: base,
+		}
+		if err := repos.Webhooks.SaveWebhook(ctx, webhook); err != nil {
+			t.Fatalf("SaveWebhook(%s): %v", url, err)
+		}
+		return webhook
+	}
+	first := save(general, "http://a.example", domain.EventMessagePosted, domain.EventMemberJoined)
+	second := save(general, "http://b.example", domain.EventRoomUpdated)
+	save(random, "http://c.example", domain.EventMemberLeft)
+	if first.ID == 0 || first.ID == second.ID {
+		t.Fatalf("SaveWebhook assigned IDs %d and %d, want distinct non-zero IDs", first.ID, second.ID)
+	}
+
+	webhook, err := repos.Webhooks.FindWebhook(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook == nil || webhook.URL != "http://a.example" || webhook.Secret != "secret" || webhook.CreatedBy != "alice" {
+		t.Fatalf("FindWebhook = %+v, want http://a.example by alice", webhook)
+	}
+	if fmt.Sprint(webhook.Events) != "[message.posted member.joined]" {
+		t.Errorf("Events = %v, want [message.posted member.joined]", webhook.Events)
+	}
+	assertTime(t, "CreatedAt", webhook.CreatedAt, base)
+
+	webhooks, err := repos.Webhooks.GetRoomWebhooks(ctx, general.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 2 || webhooks[0].ID != first.ID || webhooks[1].ID != second.ID {
+		t.Fatalf("GetRoomWebhooks = %+v, want the two general webhooks in order", webhooks)
+	}
+
+	if err := repos.Webhooks.DeleteWebhook(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	webhook, err = repos.Webhooks.FindWebhook(ctx, first.ID)
+	if err != nil || webhook != nil {
+		t.Fatalf("FindWebhook of deleted webhook = %v, %v; want nil, nil", webhook, err)
+	}
+	webhooks, err = repos.Webhooks.GetRoomWebhooks(ctx, general.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 1 || webhooks[0].ID != second.ID {
+		t.Fatalf("GetRoomWebhooks after delete = %+v, want only the second webhook", webhooks)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	first := mustSaveDelivery(t, repos, 1, domain.EventMessagePosted, at(1))
+	second := mustSaveDelivery(t, repos, 1, domain.EventMemberJoined, at(2))
+	other := mustSaveDelivery(t, repos, 2, domain.EventMessagePosted, at(3))
+	if first.ID == 0 || first.ID == second.ID {
+		t.Fatalf("SaveDelivery assigned IDs %d and %d, want distinct non-zero IDs", first.ID, second.ID)
+	}
+
+	first.Status = domain.DeliveryDelivered
+	first.Attempts = 2
+	first.LastError = "timeout"
+	first.ResponseStatus = 204
+	first.UpdatedAt = at(10)
+	if err := repos.Webhooks.UpdateDelivery(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, err := repos.Webhooks.FindDelivery(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery == nil || delivery.Status != domain.DeliveryDelivered || delivery.Attempts != 2 ||
+		delivery.LastError != "timeout" || delivery.ResponseStatus != 204 {
+		t.Fatalf("FindDelivery = %+v, want the updated delivery", delivery)
+	}
+	if string(delivery.Payload) != `{"event":"message.posted"}` {
+		t.Errorf("Payload = %s", delivery.Payload)
+	}
+	assertTime(t, "CreatedAt", delivery.CreatedAt, at(1))
+	assertTime(t, "UpdatedAt", delivery.UpdatedAt, at(10))
+
+	delivery, err = repos.Webhooks.FindDelivery(ctx, other.ID+100)
+	if err != nil || delivery != nil {
+		t.Fatalf("FindDelivery of unknown delivery = %v, %v; want nil, nil", delivery, err)
+	}
+
+	ids := func(filter domain.DeliveryFilter) string {
+		t.Helper()
+		deliveries, err := repos.Webhooks.GetDeliveries(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, delivery := range deliveries {
+			ids = append(ids, delivery.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+	if got, want := ids(domain.DeliveryFilter{}), fmt.Sprint([]int{other.ID, second.ID, first.ID}); got != want {
+		t.Errorf("GetDeliveries() = %s, want %s newest first", got, want)
+	}
+	if got, want := ids(domain.DeliveryFilter{WebhookID: 1}), fmt.Sprint([]int{second.ID, first.ID}); got != want {
+		t.Errorf("GetDeliveries(webhook 1) = %s, want %s", got, want)
+	}
+	if got, want := ids(domain.DeliveryFilter{Status: domain.DeliveryPending}), fmt.Sprint([]int{other.ID, second.ID}); got != want {
+		t.Errorf("GetDeliveries(pending) = %s, want %s", got, want)
+	}
+	if got, want := ids(domain.DeliveryFilter{Limit: 1}), fmt.Sprint([]int{other.ID}); got != want {
+		t.Errorf("GetDeliveries(limit 1) = %s, want %s", got, want)
+	}
+}
+
+func testWebhookDeadLetters(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+
+	first := mustSaveDelivery(t, repos, 1, domain.EventMessagePosted, at(1))
+	second := mustSaveDelivery(t, repos, 1, domain.EventMemberLeft, at(2))
+	for _, delivery := range []*domain.WebhookDelivery{first, second} {
+		delivery.Attempts = 3
+		delivery.LastError = "status 500"
+		delivery.ResponseStatus = 500
+		if err := repos.Webhooks.DeadLetter(ctx, delivery, at(20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delivery, err := repos.Webhooks.FindDelivery(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != domain.DeliveryDead || delivery.Attempts != 3 || delivery.ResponseStatus != 500 {
+		t.Fatalf("FindDelivery = %+v, want a dead delivery after 3 attempts", delivery)
+	}
+
+	letters, err := repos.Webhooks.GetDeadLetters(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 || letters[0].DeliveryID != second.ID || letters[1].DeliveryID != first.ID {
+		t.Fatalf("GetDeadLetters = %+v, want both deliveries newest first", letters)
+	}
+	letter := letters[1]
+	if letter.WebhookID != 1 || letter.Event != domain.EventMessagePosted || letter.Attempts != 3 ||
+		letter.LastError != "status 500" || string(letter.Payload) != `{"event":"message.posted"}` {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+	assertTime(t, "FailedAt", letter.FailedAt, at(20))
+
+	letters, err = repos.Webhooks.GetDeadLetters(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].DeliveryID != second.ID {
+		t.Fatalf("GetDeadLetters(1) = %+v, want the newest dead letter", letters)
+	}
+}
+
+func testIncomingWebhooks(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice")
+	general := mustSaveRoom(t, repos, "general", "alice")
+	random := mustSaveRoom(t, repos, "random", "alice")
+
+	save := func(room *domain.Room, username, tokenHash string) *domain.IncomingWebhook {
+		t.Helper()
+		webhook := &domain.IncomingWebhook{
+			RoomID:    room.ID,
+			Username:  username,
+			TokenHash: tokenHash,
+			RateLimit: 30,
+			CreatedBy: "alice",
+			CreatedAt: base,
+		}
+		if err := repos.Webhooks.SaveIncomingWebhook(ctx, webhook); err != nil {
+			t.Fatalf("SaveIncomingWebhook(%s): %v", username, err)
+		}
+		return webhook
+	}
+	first := save(general, "deploys", "hash-1")
+	second := save(general, "alerts", "hash-2")
+	save(random, "builds", "hash-3")
+	if first.ID == 0 || first.ID == second.ID {
+		t.Fatalf("SaveIncomingWebhook assigned IDs %d and %d, want distinct non-zero IDs", first.ID, second.ID)
+	}
+
+	webhook, err := repos.Webhooks.FindIncomingWebhook(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook == nil || webhook.Username != "deploys" || webhook.TokenHash != "hash-1" ||
+		webhook.RateLimit != 30 || webhook.CreatedBy != "alice" || webhook.RevokedAt != nil {
+		t.Fatalf("FindIncomingWebhook = %+v, want deploys by alice", webhook)
+	}
+	assertTime(t, "CreatedAt", webhook.CreatedAt, base)
+
+	webhook, err = repos.Webhooks.FindIncomingWebhookByToken(ctx, "hash-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook == nil || webhook.ID != second.ID {
+		t.Fatalf("FindIncomingWebhookByToken = %+v, want alerts", webhook)
+	}
+	webhook, err = repos.Webhooks.FindIncomingWebhookByToken(ctx, "unknown")
+	if err != nil || webhook != nil {
+		t.Fatalf("FindIncomingWebhookByToken(unknown) = %v, %v; want nil, nil", webhook, err)
+	}
+
+	if err := repos.Webhooks.RevokeIncomingWebhook(ctx, first.ID, at(60)); err != nil {
+		t.Fatal(err)
+	}
+	// Revoking again keeps the first revocation
+	if err := repos.Webhooks.RevokeIncomingWebhook(ctx, first.ID, at(120)); err != nil {
+		t.Fatal(err)
+	}
+	webhook, err = repos.Webhooks.FindIncomingWebhookByToken(ctx, "hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook == nil || webhook.RevokedAt == nil {
+		t.Fatalf("FindIncomingWebhookByToken of revoked webhook = %+v, want it with RevokedAt", webhook)
+	}
+	assertTime(t, "RevokedAt", *webhook.RevokedAt, at(60))
+
+	webhooks, err := repos.Webhooks.GetRoomIncomingWebhooks(ctx, general.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 2 || webhooks[0].ID != first.ID || webhooks[1].ID != second.ID {
+		t.Fatalf("GetRoomIncomingWebhooks = %+v, want the two general webhooks in order", webhooks)
+	}
+	if webhooks[0].RevokedAt == nil || webhooks[1].RevokedAt != nil {
+		t.Errorf("GetRoomIncomingWebhooks = %+v, want only the first revoked", webhooks)
+	}
+}
+
+func testBots(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice", "bob")
+
+	save := func(username, owner string) {
+		t.Helper()
+		bot := &domain.Bot{Username: username, Owner: owner, CreatedAt: at(60)}
+		if err := repos.Bots.SaveBot(ctx, bot); err != nil {
+			t.Fatalf("SaveBot(%s): %v", username, err)
+		}
+	}
+	save("standup", "alice")
+	save("oncall", "alice")
+	save("builds", "bob")
+
+	// A bot cannot take a username that is already in use
+	if err := repos.Bots.SaveBot(ctx, &domain.Bot{Username: "bob", Owner: "alice", CreatedAt: base}); !errors.Is(err, domain.ErrConstraint) {
+		t.Errorf("SaveBot(bob) = %v, want ErrConstraint for an existing user", err)
+	}
+
+	bot, err := repos.Bots.FindBot(ctx, "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot == nil || bot.Owner != "alice" {
+		t.Fatalf("FindBot(standup) = %+v, want it owned by alice", bot)
+	}
+	assertTime(t, "CreatedAt", bot.CreatedAt, at(60))
+	bot, err = repos.Bots.FindBot(ctx, "alice")
+	if err != nil || bot != nil {
+		t.Fatalf("FindBot(alice) = %v, %v; want nil, nil", bot, err)
+	}
+
+	user, err := repos.Users.FindByUsername(ctx, "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || !user.Bot {
+		t.Fatalf("FindByUsername(standup) = %+v, want a bot user", user)
+	}
+	assertTime(t, "user CreatedAt", user.CreatedAt, at(60))
+
+	users, err := repos.Users.FindAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, user := range users {
+		got = append(got, fmt.Sprintf("%s:%t", user.Username, user.Bot))
+	}
+	want := []string{"alice:false", "bob:false", "builds:true", "oncall:true", "standup:true"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("FindAll = %v, want %v", got, want)
+	}
+
+	bots, err := repos.Bots.GetOwnedBots(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bots) != 2 || bots[0].Username != "oncall" || bots[1].Username != "standup" {
+		t.Fatalf("GetOwnedBots(alice) = %+v, want oncall and standup", bots)
+	}
+	bots, err = repos.Bots.GetOwnedBots(ctx, "carol")
+	if err != nil || len(bots) != 0 {
+		t.Fatalf("GetOwnedBots(carol) = %v, %v; want none", bots, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, repos Repositories) {
+	ctx := context.Background()
+	mustSaveUsers(t, repos, "alice")
+	for _, username := range []string{"standup", "oncall"} {
+		if err := repos.Bots.SaveBot(ctx, &domain.Bot{Username: username, Owner: "alice", CreatedAt: base}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save := func(username, prefix, keyHash string) *domain.APIKey {
+		t.Helper()
+		key := &domain.APIKey{Username: username, Prefix: prefix, KeyHash: keyHash, CreatedAt: base}
+		if err := repos.Bots.SaveAPIKey(ctx, key); err != nil {
+			t.Fatalf("SaveAPIKey(%s): %v", prefix, err)
+		}
+		return key
+	}
+	first := save("standup", "bot_1", "hash-1")
+	second := save("standup", "bot_2", "hash-2")
+	save("oncall", "bot_3", "hash-3")
+	if first.ID == 0 || first.ID == second.ID {
+		t.Fatalf("SaveAPIKey assigned IDs %d and %d, want distinct non-zero IDs", first.ID, second.ID)
+	}
+
+	key, err := repos.Bots.FindAPIKeyByHash(ctx, "hash-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil || key.ID != second.ID || key.Username != "standup" || key.Prefix != "bot_2" || key.RevokedAt != nil {
+		t.Fatalf("FindAPIKeyByHash(hash-2) = %+v, want the second standup key", key)
+	}
+	assertTime(t, "CreatedAt", key.CreatedAt, base)
+	key, err = repos.Bots.FindAPIKeyByHash(ctx, "unknown")
+	if err != nil || key != nil {
+		t.Fatalf("FindAPIKeyByHash(unknown) = %v, %v; want nil, nil", key, err)
+	}
+
+	if err := repos.Bots.RevokeAPIKey(ctx, first.ID, at(60)); err != nil {
+		t.Fatal(err)
+	}
+	// Revoking again keeps the first revocation
+	if err := repos.Bots.RevokeAPIKey(ctx, first.ID, at(120)); err != nil {
+		t.Fatal(err)
+	}
+	key, err = repos.Bots.FindAPIKeyByHash(ctx, "hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil || key.RevokedAt == nil {
+		t.Fatalf("FindAPIKeyByHash of revoked key = %+v, want it with RevokedAt", key)
+	}
+	assertTime(t, "RevokedAt", *key.RevokedAt, at(60))
+
+	keys, err := repos.Bots.GetAPIKeys(ctx, "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != first.ID || keys[1].ID != second.ID {
+		t.Fatalf("GetAPIKeys(standup) = %+v, want its two keys in order", keys)
+	}
+	if keys[0].RevokedAt == nil || keys[1].RevokedAt != nil {
+		t.Errorf("GetAPIKeys(standup) = %+v, want only the first revoked", keys)
+	}
+}
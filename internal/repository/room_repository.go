@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"websocket_try3/internal/domain"
 )
@@ -13,13 +14,14 @@ func NewRoomRepository(db *sql.DB) *RoomRepository {
 	return &RoomRepository{db: db}
 }
 
-func (r *RoomRepository) SaveRoom(room *domain.Room) error {
+func (r *RoomRepository) SaveRoom(ctx context.Context, room *domain.Room) error {
 	query := `
 		INSERT INTO rooms (name, created_by, created_at)
 		VALUES ($1, $2, $3)
 		RETURNING id
 	`
-	err := r.db.QueryRow(
+	err := r.db.QueryRowContext(
+		ctx,
 		query,
 		room.Name,
 		room.CreatedBy,
@@ -28,13 +30,14 @@ func (r *RoomRepository) SaveRoom(room *domain.Room) error {
 	return err
 }
 
-func (r *RoomRepository) FindRoomByID(id int) (*domain.Room, error) {
+func (r *RoomRepository) FindRoomByID(ctx context.Context, id int) (*domain.Room, error) {
 	query := `
-		SELECT id, name, created_by, created_at
-		FROM rooms
-		WHERE id = $1
+		SELECT r.id, r.name, r.created_by, r.created_at, COALESCE(t.topic, '')
+		FROM rooms r
+		LEFT JOIN room_topics t ON t.room_id = r.id
+		WHERE r.id = $1
 	`
-	row := r.db.QueryRow(query, id)
+	row := r.db.QueryRowContext(ctx, query, id)
 
 	var room domain.Room
 	err := row.Scan(
@@ -42,6 +45,7 @@ func (r *RoomRepository) FindRoomByID(id int) (*domain.Room, error) {
 		&room.Name,
 		&room.CreatedBy,
 		&room.CreatedAt,
+		&room.Topic,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -53,13 +57,14 @@ func (r *RoomRepository) FindRoomByID(id int) (*domain.Room, error) {
 	return &room, nil
 }
 
-func (r *RoomRepository) AddMember(member *domain.RoomMember) error {
+func (r *RoomRepository) AddMember(ctx context.Context, member *domain.RoomMember) error {
 	query := `
 		INSERT INTO room_members (room_id, username, joined_at)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (room_id, username) DO NOTHING
 	`
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		member.RoomID,
 		member.Username,
@@ -68,9 +73,37 @@ func (r *RoomRepository) AddMember(member *domain.RoomMember) error {
 	return err
 }
 
-func (r *RoomRepository) GetAllRooms() ([]*domain.Room, error) {
-	SQL := "SELECT id, name, created_by, created_at FROM rooms ORDER BY name"
-	rows, err := r.db.Query(SQL)
+func (r *RoomRepository) RemoveMember(ctx context.Context, roomID int, username string) error {
+	query := `
+		DELETE FROM room_members
+		WHERE room_id = $1 AND username = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, roomID, username)
+	return err
+}
+
+func (r *RoomRepository) SetTopic(ctx context.Context, roomID int, topic string) error {
+	if topic == "" {
+		_, err := r.db.ExecContext(ctx, "DELETE FROM room_topics WHERE room_id = $1", roomID)
+		return err
+	}
+	query := `
+		INSERT INTO room_topics (room_id, topic)
+		VALUES ($1, $2)
+		ON CONFLICT (room_id) DO UPDATE SET topic = EXCLUDED.topic
+	`
+	_, err := r.db.ExecContext(ctx, query, roomID, topic)
+	return err
+}
+
+func (r *RoomRepository) GetAllRooms(ctx context.Context) ([]*domain.Room, error) {
+	SQL := `
+		SELECT r.id, r.name, r.created_by, r.created_at, COALESCE(t.topic, '')
+		FROM rooms r
+		LEFT JOIN room_topics t ON t.room_id = r.id
+		ORDER BY r.name
+	`
+	rows, err := r.db.QueryContext(ctx, SQL)
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +117,7 @@ func (r *RoomRepository) GetAllRooms() ([]*domain.Room, error) {
 			&room.Name,
 			&room.CreatedBy,
 			&room.CreatedAt,
+			&room.Topic,
 		)
 		if err != nil {
 			return nil, err
@@ -93,14 +127,14 @@ func (r *RoomRepository) GetAllRooms() ([]*domain.Room, error) {
 	return rooms, nil
 }
 
-func (r *RoomRepository) GetRoomMembers(roomID int) ([]domain.RoomMember, error) {
+func (r *RoomRepository) GetRoomMembers(ctx context.Context, roomID int) ([]domain.RoomMember, error) {
 	query := `
 		SELECT room_id, username, joined_at
 		FROM room_members
 		WHERE room_id = $1
 		ORDER BY joined_at
 	`
-	rows, err := r.db.Query(query, roomID)
+	rows, err := r.db.QueryContext(ctx, query, roomID)
 	if err != nil {
 		return nil, err
 	}
@@ -127,15 +161,16 @@ func (r *RoomRepository) GetRoomMembers(roomID int) ([]domain.RoomMember, error)
 	return members, nil
 }
 
-func (r *RoomRepository) GetUserRooms(username string) ([]domain.Room, error) {
+func (r *RoomRepository) GetUserRooms(ctx context.Context, username string) ([]domain.Room, error) {
 	query := `
-		SELECT r.id, r.name, r.created_by, r.created_at
+		SELECT r.id, r.name, r.created_by, r.created_at, COALESCE(t.topic, '')
 		FROM rooms r
 		JOIN room_members rm ON r.id = rm.room_id
+		LEFT JOIN room_topics t ON t.room_id = r.id
 		WHERE rm.username = $1
 		ORDER BY r.name
 	`
-	rows, err := r.db.Query(query, username)
+	rows, err := r.db.QueryContext(ctx, query, username)
 	if err != nil {
 		return nil, err
 	}
@@ -149,6 +184,7 @@ func (r *RoomRepository) GetUserRooms(username string) ([]domain.Room, error) {
 			&room.Name,
 			&room.CreatedBy,
 			&room.CreatedAt,
+			&room.Topic,
 		)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,118 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+type BotRepository struct {
+	db *sql.DB
+}
+
+func NewBotRepository(db *sql.DB) *BotRepository {
+	return &BotRepository{db: db}
+}
+
+func (r *BotRepository) SaveBot(ctx context.Context, bot *domain.Bot) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO users (username, created_at, updated_at) VALUES (?1, ?2, ?2)",
+		bot.Username, bot.CreatedAt.UTC())
+	if err != nil {
+		return constraintError(err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO bots (username, owner, created_at) VALUES (?, ?, ?)",
+		bot.Username, bot.Owner, bot.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *BotRepository) FindBot(ctx context.Context, username string) (*domain.Bot, error) {
+	bots, err := r.queryBots(ctx, "WHERE username = ?", username)
+	if err != nil || len(bots) == 0 {
+		return nil, err
+	}
+	return &bots[0], nil
+}
+
+func (r *BotRepository) GetOwnedBots(ctx context.Context, owner string) ([]domain.Bot, error) {
+	return r.queryBots(ctx, "WHERE owner = ? ORDER BY username", owner)
+}
+
+func (r *BotRepository) queryBots(ctx context.Context, where string, args ...any) ([]domain.Bot, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT username, owner, created_at FROM bots "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bots []domain.Bot
+	for rows.Next() {
+		var bot domain.Bot
+		if err := rows.Scan(&bot.Username, &bot.Owner, &bot.CreatedAt); err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, rows.Err()
+}
+
+func (r *BotRepository) SaveAPIKey(ctx context.Context, key *domain.APIKey) error {
+	query := `
+		INSERT INTO api_keys (username, prefix, key_hash, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query, key.Username, key.Prefix, key.KeyHash,
+		key.CreatedAt.UTC()).Scan(&key.ID)
+}
+
+func (r *BotRepository) FindAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	keys, err := r.queryAPIKeys(ctx, "WHERE key_hash = ?", keyHash)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return &keys[0], nil
+}
+
+func (r *BotRepository) GetAPIKeys(ctx context.Context, username string) ([]domain.APIKey, error) {
+	return r.queryAPIKeys(ctx, "WHERE username = ? ORDER BY id", username)
+}
+
+func (r *BotRepository) queryAPIKeys(ctx context.Context, where string, args ...any) ([]domain.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, username, prefix, key_hash, created_at, revoked_at FROM api_keys "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		var key domain.APIKey
+		var revokedAt sql.NullTime
+		err := rows.Scan(&key.ID, &key.Username, &key.Prefix, &key.KeyHash, &key.CreatedAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *BotRepository) RevokeAPIKey(ctx context.Context, id int, revokedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", revokedAt.UTC(), id)
+	return err
+}
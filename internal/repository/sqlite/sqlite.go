@@ -0,0 +1,393 @@
+// Package sqlite implements the domain repositories on SQLite, for running
+// the server without Postgres. The schema mirrors the Postgres migrations and
+// is created when the database is opened. Timestamps are stored as UTC text,
+// which keeps them in chronological order when sorted.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"websocket_try3/internal/domain"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//go:embed schema.sql
+var schema string
+
+// constraintError wraps err with domain.ErrConstraint if SQLite rejected the
+// statement for violating a constraint.
+func constraintError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT {
+		return fmt.Errorf("%w: %w", domain.ErrConstraint, err)
+	}
+	return err
+}
+
+// Open opens the SQLite database at path, creating it and its schema if
+// needed. ":memory:" opens a private in-memory database.
+func Open(ctx context.Context, path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer; one connection also keeps an in-memory
+	// database alive and shared
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create sqlite schema: %v", err)
+	}
+	return db, nil
+}
+
+type UserRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) Save(ctx context.Context, user *domain.User) error {
+	query := `
+		INSERT INTO users (username, created_at, updated_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (username) DO UPDATE
+		SET updated_at = ?3
+	`
+	_, err := r.db.ExecContext(ctx, query, user.Username, user.CreatedAt.UTC(), user.UpdatedAt.UTC())
+	return err
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*domain.User, error) {
+	query := `
+		SELECT u.username, b.username IS NOT NULL, u.created_at, u.updated_at
+		FROM users u
+		LEFT JOIN bots b ON b.username = u.username
+		WHERE u.username = ?
+	`
+	var user domain.User
+	err := r.db.QueryRowContext(ctx, query, username).Scan(
+		&user.Username,
+		&user.Bot,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]domain.User, error) {
+	query := `
+		SELECT u.username, b.username IS NOT NULL, u.created_at, u.updated_at
+		FROM users u
+		LEFT JOIN bots b ON b.username = u.username
+		ORDER BY u.username
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []domain.User
+	for rows.Next() {
+		var user domain.User
+		if err := rows.Scan(&user.Username, &user.Bot, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+type MessageRepository struct {
+	db *sql.DB
+}
+
+func NewMessageRepository(db *sql.DB) *MessageRepository {
+	return &MessageRepository{db: db}
+}
+
+func (r *MessageRepository) SavePrivateMessage(ctx context.Context, msg *domain.Message) error {
+	saved := *msg
+	saved.Type = "private"
+	return r.SaveMessages(ctx, []*domain.Message{&saved})
+}
+
+func (r *MessageRepository) SaveGroupMessage(ctx context.Context, msg *domain.Message) error {
+	saved := *msg
+	saved.Type = "group"
+	return r.SaveMessages(ctx, []*domain.Message{&saved})
+}
+
+// SaveMessages writes private and group messages with a single multi-row insert
+func (r *MessageRepository) SaveMessages(ctx context.Context, msgs []*domain.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var query strings.Builder
+	query.WriteString(`
+		INSERT INTO messages (
+			from_user, to_user, content, type, group_id, created_at
+		) VALUES `)
+
+	args := make([]any, 0, len(msgs)*6)
+	for i, msg := range msgs {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(?, ?, ?, ?, ?, ?)")
+
+		toUser := sql.NullString{String: msg.To, Valid: msg.To != ""}
+		groupID := sql.NullInt64{Int64: int64(msg.GroupID), Valid: msg.GroupID != 0}
+		args = append(args, msg.From, toUser, msg.Content, msg.Type, groupID, msg.CreatedAt.UTC())
+	}
+
+	_, err := r.db.ExecContext(ctx, query.String(), args...)
+	return constraintError(err)
+}
+
+func (r *MessageRepository) GetPrivateMessages(ctx context.Context, from, to string, limit int) ([]domain.Message, error) {
+	query := `
+		SELECT id, from_user, to_user, content, type, 0, created_at
+		FROM messages
+		WHERE type = 'private' AND (
+			(from_user = ?1 AND to_user = ?2) OR
+			(from_user = ?2 AND to_user = ?1)
+		)
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?3
+	`
+	return r.query(ctx, query, from, to, limit)
+}
+
+func (r *MessageRepository) GetGroupMessages(ctx context.Context, roomID int, limit int) ([]domain.Message, error) {
+	query := `
+		SELECT id, from_user, '', content, type, group_id, created_at
+		FROM messages
+		WHERE type = 'group' AND group_id = ?1
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?2
+	`
+	return r.query(ctx, query, roomID, limit)
+}
+
+// query scans messages selected newest first and returns them oldest first.
+func (r *MessageRepository) query(ctx context.Context, query string, args ...any) ([]domain.Message, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []domain.Message
+	for rows.Next() {
+		var msg domain.Message
+		err := rows.Scan(
+			&msg.ID,
+			&msg.From,
+			&msg.To,
+			&msg.Content,
+			&msg.Type,
+			&msg.GroupID,
+			&msg.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (r *MessageRepository) GetContacts(ctx context.Context, username string) ([]string, error) {
+	query := `
+		SELECT DISTINCT CASE WHEN from_user = ?1 THEN to_user ELSE from_user END
+		FROM messages
+		WHERE type = 'private' AND (from_user = ?1 OR to_user = ?1)
+	`
+	rows, err := r.db.QueryContext(ctx, query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []string
+	for rows.Next() {
+		var contact string
+		if err := rows.Scan(&contact); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+type RoomRepository struct {
+	db *sql.DB
+}
+
+func NewRoomRepository(db *sql.DB) *RoomRepository {
+	return &RoomRepository{db: db}
+}
+
+func (r *RoomRepository) SaveRoom(ctx context.Context, room *domain.Room) error {
+	query := `
+		INSERT INTO rooms (name, created_by, created_at)
+		VALUES (?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query, room.Name, room.CreatedBy, room.CreatedAt.UTC()).Scan(&room.ID)
+}
+
+func (r *RoomRepository) FindRoomByID(ctx context.Context, id int) (*domain.Room, error) {
+	query := `
+		SELECT r.id, r.name, r.created_by, r.created_at, COALESCE(t.topic, '')
+		FROM rooms r
+		LEFT JOIN room_topics t ON t.room_id = r.id
+		WHERE r.id = ?
+	`
+	var room domain.Room
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&room.ID,
+		&room.Name,
+		&room.CreatedBy,
+		&room.CreatedAt,
+		&room.Topic,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+func (r *RoomRepository) AddMember(ctx context.Context, member *domain.RoomMember) error {
+	query := `
+		INSERT INTO room_members (room_id, username, joined_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (room_id, username) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, member.RoomID, member.Username, member.JoinedAt.UTC())
+	return err
+}
+
+func (r *RoomRepository) RemoveMember(ctx context.Context, roomID int, username string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM room_members WHERE room_id = ? AND username = ?", roomID, username)
+	return err
+}
+
+func (r *RoomRepository) SetTopic(ctx context.Context, roomID int, topic string) error {
+	if topic == "" {
+		_, err := r.db.ExecContext(ctx, "DELETE FROM room_topics WHERE room_id = ?", roomID)
+		return err
+	}
+	query := `
+		INSERT INTO room_topics (room_id, topic)
+		VALUES (?, ?)
+		ON CONFLICT (room_id) DO UPDATE SET topic = excluded.topic
+	`
+	_, err := r.db.ExecContext(ctx, query, roomID, topic)
+	return err
+}
+
+func (r *RoomRepository) GetAllRooms(ctx context.Context) ([]*domain.Room, error) {
+	query := `
+		SELECT r.id, r.name, r.created_by, r.created_at, COALESCE(t.topic, '')
+		FROM rooms r
+		LEFT JOIN room_topics t ON t.room_id = r.id
+		ORDER BY r.name
+	`
+	rooms, err := r.queryRooms(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*domain.Room, len(rooms))
+	for i := range rooms {
+		result[i] = &rooms[i]
+	}
+	return result, nil
+}
+
+func (r *RoomRepository) GetRoomMembers(ctx context.Context, roomID int) ([]domain.RoomMember, error) {
+	query := `
+		SELECT room_id, username, joined_at
+		FROM room_members
+		WHERE room_id = ?
+		ORDER BY joined_at
+	`
+	rows, err := r.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []domain.RoomMember
+	for rows.Next() {
+		var member domain.RoomMember
+		if err := rows.Scan(&member.RoomID, &member.Username, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (r *RoomRepository) GetUserRooms(ctx context.Context, username string) ([]domain.Room, error) {
+	query := `
+		SELECT r.id, r.name, r.created_by, r.created_at, COALESCE(t.topic, '')
+		FROM rooms r
+		JOIN room_members rm ON r.id = rm.room_id
+		LEFT JOIN room_topics t ON t.room_id = r.id
+		WHERE rm.username = ?
+		ORDER BY r.name
+	`
+	return r.queryRooms(ctx, query, username)
+}
+
+func (r *RoomRepository) queryRooms(ctx context.Context, query string, args ...any) ([]domain.Room, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []domain.Room
+	for rows.Next() {
+		var room domain.Room
+		if err := rows.Scan(&room.ID, &room.Name, &room.CreatedBy, &room.CreatedAt, &room.Topic); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
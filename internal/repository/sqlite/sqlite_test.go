@@ -0,0 +1,43 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/repository/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		db, err := Open(context.Background(), filepath.Join(t.TempDir(), "chat.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return repotest.Repositories{
+			Users:    NewUserRepository(db),
+			Messages: NewMessageRepository(db),
+			Rooms:    NewRoomRepository(db),
+			Webhooks: NewWebhookRepository(db),
+			Bots:     NewBotRepository(db),
+		}
+	})
+}
+
+func TestSaveMessagesConstraint(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(ctx, filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	msg := &domain.Message{From: "nobody", To: "alice", Content: "hi", Type: "private", CreatedAt: time.Now()}
+	if err := NewMessageRepository(db).SaveMessages(ctx, []*domain.Message{msg}); !errors.Is(err, domain.ErrConstraint) {
+		t.Fatalf("SaveMessages from an unknown user = %v, want ErrConstraint", err)
+	}
+}
@@ -0,0 +1,258 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+type WebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) SaveWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	query := `
+		INSERT INTO webhooks (room_id, url, secret, events, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query, webhook.RoomID, webhook.URL, webhook.Secret,
+		strings.Join(webhook.Events, ","), webhook.CreatedBy, webhook.CreatedAt.UTC()).Scan(&webhook.ID)
+}
+
+func (r *WebhookRepository) FindWebhook(ctx context.Context, id int) (*domain.Webhook, error) {
+	webhooks, err := r.queryWebhooks(ctx, "WHERE id = ?", id)
+	if err != nil || len(webhooks) == 0 {
+		return nil, err
+	}
+	return &webhooks[0], nil
+}
+
+func (r *WebhookRepository) GetRoomWebhooks(ctx context.Context, roomID int) ([]domain.Webhook, error) {
+	return r.queryWebhooks(ctx, "WHERE room_id = ? ORDER BY id", roomID)
+}
+
+func (r *WebhookRepository) queryWebhooks(ctx context.Context, where string, args ...any) ([]domain.Webhook, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, room_id, url, secret, events, created_by, created_at FROM webhooks "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []domain.Webhook
+	for rows.Next() {
+		var webhook domain.Webhook
+		var events string
+		err := rows.Scan(&webhook.ID, &webhook.RoomID, &webhook.URL, &webhook.Secret, &events,
+			&webhook.CreatedBy, &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id)
+	return err
+}
+
+func (r *WebhookRepository) SaveDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, last_error,
+			response_status, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query, delivery.WebhookID, delivery.Event, string(delivery.Payload),
+		delivery.Status, delivery.Attempts, delivery.LastError, delivery.ResponseStatus,
+		delivery.NextAttemptAt.UTC(), delivery.CreatedAt.UTC(), delivery.UpdatedAt.UTC()).Scan(&delivery.ID)
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = ?2, attempts = ?3, last_error = ?4, response_status = ?5,
+			next_attempt_at = ?6, updated_at = ?7
+		WHERE id = ?1
+	`
+	_, err := r.db.ExecContext(ctx, query, delivery.ID, delivery.Status, delivery.Attempts, delivery.LastError,
+		delivery.ResponseStatus, delivery.NextAttemptAt.UTC(), delivery.UpdatedAt.UTC())
+	return err
+}
+
+func (r *WebhookRepository) FindDelivery(ctx context.Context, id int) (*domain.WebhookDelivery, error) {
+	deliveries, err := r.queryDeliveries(ctx, "WHERE id = ?", id)
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+func (r *WebhookRepository) GetDeliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	return r.queryDeliveries(ctx, `
+		WHERE (?1 = 0 OR webhook_id = ?1) AND (?2 = '' OR status = ?2)
+		ORDER BY id DESC
+		LIMIT ?3
+	`, filter.WebhookID, filter.Status, limit)
+}
+
+func (r *WebhookRepository) queryDeliveries(ctx context.Context, where string, args ...any) ([]domain.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, webhook_id, event, payload, status, attempts, last_error, response_status,
+			next_attempt_at, created_at, updated_at
+		FROM webhook_deliveries `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var delivery domain.WebhookDelivery
+		var payload string
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status,
+			&delivery.Attempts, &delivery.LastError, &delivery.ResponseStatus, &delivery.NextAttemptAt,
+			&delivery.CreatedAt, &delivery.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = []byte(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *WebhookRepository) DeadLetter(ctx context.Context, delivery *domain.WebhookDelivery, failedAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	delivery.Status = domain.DeliveryDead
+	delivery.UpdatedAt = failedAt
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?2, attempts = ?3, last_error = ?4, response_status = ?5, updated_at = ?6
+		WHERE id = ?1
+	`, delivery.ID, delivery.Status, delivery.Attempts, delivery.LastError, delivery.ResponseStatus, failedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_dead_letters (delivery_id, webhook_id, event, payload, attempts, last_error, failed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, delivery.ID, delivery.WebhookID, delivery.Event, string(delivery.Payload), delivery.Attempts,
+		delivery.LastError, failedAt.UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *WebhookRepository) GetDeadLetters(ctx context.Context, limit int) ([]domain.WebhookDeadLetter, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, delivery_id, webhook_id, event, payload, attempts, last_error, failed_at
+		FROM webhook_dead_letters
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []domain.WebhookDeadLetter
+	for rows.Next() {
+		var letter domain.WebhookDeadLetter
+		var payload string
+		err := rows.Scan(&letter.ID, &letter.DeliveryID, &letter.WebhookID, &letter.Event, &payload,
+			&letter.Attempts, &letter.LastError, &letter.FailedAt)
+		if err != nil {
+			return nil, err
+		}
+		letter.Payload = []byte(payload)
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+func (r *WebhookRepository) SaveIncomingWebhook(ctx context.Context, webhook *domain.IncomingWebhook) error {
+	query := `
+		INSERT INTO incoming_webhooks (room_id, username, token_hash, rate_limit, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query, webhook.RoomID, webhook.Username, webhook.TokenHash,
+		webhook.RateLimit, webhook.CreatedBy, webhook.CreatedAt.UTC()).Scan(&webhook.ID)
+}
+
+func (r *WebhookRepository) FindIncomingWebhook(ctx context.Context, id int) (*domain.IncomingWebhook, error) {
+	webhooks, err := r.queryIncomingWebhooks(ctx, "WHERE id = ?", id)
+	if err != nil || len(webhooks) == 0 {
+		return nil, err
+	}
+	return &webhooks[0], nil
+}
+
+func (r *WebhookRepository) FindIncomingWebhookByToken(ctx context.Context, tokenHash string) (*domain.IncomingWebhook, error) {
+	webhooks, err := r.queryIncomingWebhooks(ctx, "WHERE token_hash = ?", tokenHash)
+	if err != nil || len(webhooks) == 0 {
+		return nil, err
+	}
+	return &webhooks[0], nil
+}
+
+func (r *WebhookRepository) GetRoomIncomingWebhooks(ctx context.Context, roomID int) ([]domain.IncomingWebhook, error) {
+	return r.queryIncomingWebhooks(ctx, "WHERE room_id = ? ORDER BY id", roomID)
+}
+
+func (r *WebhookRepository) queryIncomingWebhooks(ctx context.Context, where string, args ...any) ([]domain.IncomingWebhook, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, room_id, username, token_hash, rate_limit, created_by, created_at, revoked_at
+		FROM incoming_webhooks `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []domain.IncomingWebhook
+	for rows.Next() {
+		var webhook domain.IncomingWebhook
+		var revokedAt sql.NullTime
+		err := rows.Scan(&webhook.ID, &webhook.RoomID, &webhook.Username, &webhook.TokenHash,
+			&webhook.RateLimit, &webhook.CreatedBy, &webhook.CreatedAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			webhook.RevokedAt = &revokedAt.Time
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r *WebhookRepository) RevokeIncomingWebhook(ctx context.Context, id int, revokedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE incoming_webhooks SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", revokedAt.UTC(), id)
+	return err
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"websocket_try3/internal/domain"
 )
@@ -13,14 +14,15 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) Save(user *domain.User) error {
+func (r *UserRepository) Save(ctx context.Context, user *domain.User) error {
 	query := `
 		INSERT INTO users (username, created_at, updated_at) 
 		VALUES ($1, $2, $3)
 		ON CONFLICT (username) DO UPDATE 
 		SET updated_at = $3
 	`
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		user.Username,
 		user.CreatedAt,
@@ -29,17 +31,19 @@ func (r *UserRepository) Save(user *domain.User) error {
 	return err
 }
 
-func (r *UserRepository) FindByUsername(username string) (*domain.User, error) {
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*domain.User, error) {
 	query := `
-		SELECT username, created_at, updated_at 
-		FROM users 
-		WHERE username = $1
+		SELECT u.username, b.username IS NOT NULL, u.created_at, u.updated_at
+		FROM users u
+		LEFT JOIN bots b ON b.username = u.username
+		WHERE u.username = $1
 	`
-	row := r.db.QueryRow(query, username)
+	row := r.db.QueryRowContext(ctx, query, username)
 
 	var user domain.User
 	err := row.Scan(
 		&user.Username,
+		&user.Bot,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -53,13 +57,14 @@ func (r *UserRepository) FindByUsername(username string) (*domain.User, error) {
 	return &user, nil
 }
 
-func (r *UserRepository) FindAll() ([]domain.User, error) {
+func (r *UserRepository) FindAll(ctx context.Context) ([]domain.User, error) {
 	query := `
-		SELECT username, created_at, updated_at 
-		FROM users
-		ORDER BY username
+		SELECT u.username, b.username IS NOT NULL, u.created_at, u.updated_at
+		FROM users u
+		LEFT JOIN bots b ON b.username = u.username
+		ORDER BY u.username
 	`
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -70,6 +75,7 @@ func (r *UserRepository) FindAll() ([]domain.User, error) {
 		var user domain.User
 		err := rows.Scan(
 			&user.Username,
+			&user.Bot,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
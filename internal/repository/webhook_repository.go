@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+	"websocket_try3/internal/domain"
+)
+
+type WebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) SaveWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	query := `
+		INSERT INTO webhooks (room_id, url, secret, events, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		webhook.RoomID,
+		webhook.URL,
+		webhook.Secret,
+		strings.Join(webhook.Events, ","),
+		webhook.CreatedBy,
+		webhook.CreatedAt,
+	).Scan(&webhook.ID)
+}
+
+func (r *WebhookRepository) FindWebhook(ctx context.Context, id int) (*domain.Webhook, error) {
+	query := `
+		SELECT id, room_id, url, secret, events, created_by, created_at
+		FROM webhooks
+		WHERE id = $1
+	`
+	webhook, err := scanWebhook(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return webhook, err
+}
+
+func (r *WebhookRepository) GetRoomWebhooks(ctx context.Context, roomID int) ([]domain.Webhook, error) {
+	query := `
+		SELECT id, room_id, url, secret, events, created_by, created_at
+		FROM webhooks
+		WHERE room_id = $1
+		ORDER BY id
+	`
+	rows, err := r.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []domain.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	return err
+}
+
+func (r *WebhookRepository) SaveDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, last_error,
+			response_status, next_attempt_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		delivery.WebhookID,
+		delivery.Event,
+		string(delivery.Payload),
+		delivery.Status,
+		delivery.Attempts,
+		delivery.LastError,
+		delivery.ResponseStatus,
+		delivery.NextAttemptAt,
+		delivery.CreatedAt,
+		delivery.UpdatedAt,
+	).Scan(&delivery.ID)
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_error = $4, response_status = $5,
+			next_attempt_at = $6, updated_at = $7
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		delivery.ID,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.LastError,
+		delivery.ResponseStatus,
+		delivery.NextAttemptAt,
+		delivery.UpdatedAt,
+	)
+	return err
+}
+
+func (r *WebhookRepository) FindDelivery(ctx context.Context, id int) (*domain.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event, payload, status, attempts, last_error, response_status,
+			next_attempt_at, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE id = $1
+	`
+	delivery, err := scanDelivery(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+func (r *WebhookRepository) GetDeliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, event, payload, status, attempts, last_error, response_status,
+			next_attempt_at, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE ($1 = 0 OR webhook_id = $1) AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+	`
+	if filter.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(filter.Limit)
+	}
+	rows, err := r.db.QueryContext(ctx, query, filter.WebhookID, filter.Status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *WebhookRepository) DeadLetter(ctx context.Context, delivery *domain.WebhookDelivery, failedAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	delivery.Status = domain.DeliveryDead
+	delivery.UpdatedAt = failedAt
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_error = $4, response_status = $5, updated_at = $6
+		WHERE id = $1
+	`, delivery.ID, delivery.Status, delivery.Attempts, delivery.LastError, delivery.ResponseStatus, failedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_dead_letters (delivery_id, webhook_id, event, payload, attempts, last_error, failed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, delivery.ID, delivery.WebhookID, delivery.Event, string(delivery.Payload), delivery.Attempts, delivery.LastError, failedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *WebhookRepository) GetDeadLetters(ctx context.Context, limit int) ([]domain.WebhookDeadLetter, error) {
+	query := `
+		SELECT id, delivery_id, webhook_id, event, payload, attempts, last_error, failed_at
+		FROM webhook_dead_letters
+		ORDER BY id DESC
+	`
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []domain.WebhookDeadLetter
+	for rows.Next() {
+		var letter domain.WebhookDeadLetter
+		var payload string
+		err := rows.Scan(
+			&letter.ID,
+			&letter.DeliveryID,
+			&letter.WebhookID,
+			&letter.Event,
+			&payload,
+			&letter.Attempts,
+			&letter.LastError,
+			&letter.FailedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		letter.Payload = []byte(payload)
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+func (r *WebhookRepository) SaveIncomingWebhook(ctx context.Context, webhook *domain.IncomingWebhook) error {
+	query := `
+		INSERT INTO incoming_webhooks (room_id, username, token_hash, rate_limit, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		webhook.RoomID,
+		webhook.Username,
+		webhook.TokenHash,
+		webhook.RateLimit,
+		webhook.CreatedBy,
+		webhook.CreatedAt,
+	).Scan(&webhook.ID)
+}
+
+func (r *WebhookRepository) FindIncomingWebhook(ctx context.Context, id int) (*domain.IncomingWebhook, error) {
+	query := `
+		SELECT id, room_id, username, token_hash, rate_limit, created_by, created_at, revoked_at
+		FROM incoming_webhooks
+		WHERE id = $1
+	`
+	webhook, err := scanIncomingWebhook(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return webhook, err
+}
+
+func (r *WebhookRepository) FindIncomingWebhookByToken(ctx context.Context, tokenHash string) (*domain.IncomingWebhook, error) {
+	query := `
+		SELECT id, room_id, username, token_hash, rate_limit, created_by, created_at, revoked_at
+		FROM incoming_webhooks
+		WHERE token_hash = $1
+	`
+	webhook, err := scanIncomingWebhook(r.db.QueryRowContext(ctx, query, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return webhook, err
+}
+
+func (r *WebhookRepository) GetRoomIncomingWebhooks(ctx context.Context, roomID int) ([]domain.IncomingWebhook, error) {
+	query := `
+		SELECT id, room_id, username, token_hash, rate_limit, created_by, created_at, revoked_at
+		FROM incoming_webhooks
+		WHERE room_id = $1
+		ORDER BY id
+	`
+	rows, err := r.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []domain.IncomingWebhook
+	for rows.Next() {
+		webhook, err := scanIncomingWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r *WebhookRepository) RevokeIncomingWebhook(ctx context.Context, id int, revokedAt time.Time) error {
+	query := `
+		UPDATE incoming_webhooks
+		SET revoked_at = $2
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, query, id, revokedAt)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row scanner) (*domain.Webhook, error) {
+	var webhook domain.Webhook
+	var events string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.RoomID,
+		&webhook.URL,
+		&webhook.Secret,
+		&events,
+		&webhook.CreatedBy,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Events = strings.Split(events, ",")
+	return &webhook, nil
+}
+
+func scanDelivery(row scanner) (*domain.WebhookDelivery, error) {
+	var delivery domain.WebhookDelivery
+	var payload string
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.LastError,
+		&delivery.ResponseStatus,
+		&delivery.NextAttemptAt,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = []byte(payload)
+	return &delivery, nil
+}
+
+func scanIncomingWebhook(row scanner) (*domain.IncomingWebhook, error) {
+	var webhook domain.IncomingWebhook
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.RoomID,
+		&webhook.Username,
+		&webhook.TokenHash,
+		&webhook.RateLimit,
+		&webhook.CreatedBy,
+		&webhook.CreatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		webhook.RevokedAt = &revokedAt.Time
+	}
+	return &webhook, nil
+}
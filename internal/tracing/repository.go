@@ -0,0 +1,157 @@
+package tracing
+
+import (
+	"context"
+	"websocket_try3/internal/domain"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("websocket_try3/internal/tracing")
+
+// InstrumentUsers wraps repo so every call gets a client span. system names
+// the storage behind it, such as "postgresql" or "sqlite".
+func InstrumentUsers(repo domain.UserRepository, system string) domain.UserRepository {
+	return &userRepository{repo: repo, span: spanner(system)}
+}
+
+// InstrumentMessages wraps repo so every call gets a client span.
+func InstrumentMessages(repo domain.MessageRepository, system string) domain.MessageRepository {
+	return &messageRepository{repo: repo, span: spanner(system)}
+}
+
+// InstrumentRooms wraps repo so every call gets a client span.
+func InstrumentRooms(repo domain.RoomRepository, system string) domain.RoomRepository {
+	return &roomRepository{repo: repo, span: spanner(system)}
+}
+
+type spanner string
+
+func (system spanner) start(ctx context.Context, repository, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, repository+"."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", string(system)),
+			attribute.String("db.operation.name", method),
+		),
+	)
+}
+
+type userRepository struct {
+	repo domain.UserRepository
+	span spanner
+}
+
+func (r *userRepository) Save(ctx context.Context, user *domain.User) (err error) {
+	ctx, span := r.span.start(ctx, "UserRepository", "Save")
+	defer End(span, &err)
+	return r.repo.Save(ctx, user)
+}
+
+func (r *userRepository) FindByUsername(ctx context.Context, username string) (user *domain.User, err error) {
+	ctx, span := r.span.start(ctx, "UserRepository", "FindByUsername")
+	defer End(span, &err)
+	return r.repo.FindByUsername(ctx, username)
+}
+
+func (r *userRepository) FindAll(ctx context.Context) (users []domain.User, err error) {
+	ctx, span := r.span.start(ctx, "UserRepository", "FindAll")
+	defer End(span, &err)
+	return r.repo.FindAll(ctx)
+}
+
+type messageRepository struct {
+	repo domain.MessageRepository
+	span spanner
+}
+
+func (r *messageRepository) SavePrivateMessage(ctx context.Context, msg *domain.Message) (err error) {
+	ctx, span := r.span.start(ctx, "MessageRepository", "SavePrivateMessage")
+	defer End(span, &err)
+	return r.repo.SavePrivateMessage(ctx, msg)
+}
+
+func (r *messageRepository) SaveGroupMessage(ctx context.Context, msg *domain.Message) (err error) {
+	ctx, span := r.span.start(ctx, "MessageRepository", "SaveGroupMessage")
+	defer End(span, &err)
+	return r.repo.SaveGroupMessage(ctx, msg)
+}
+
+func (r *messageRepository) SaveMessages(ctx context.Context, msgs []*domain.Message) (err error) {
+	ctx, span := r.span.start(ctx, "MessageRepository", "SaveMessages")
+	defer End(span, &err)
+	return r.repo.SaveMessages(ctx, msgs)
+}
+
+func (r *messageRepository) GetPrivateMessages(ctx context.Context, from, to string, limit int) (messages []domain.Message, err error) {
+	ctx, span := r.span.start(ctx, "MessageRepository", "GetPrivateMessages")
+	defer End(span, &err)
+	return r.repo.GetPrivateMessages(ctx, from, to, limit)
+}
+
+func (r *messageRepository) GetGroupMessages(ctx context.Context, roomID int, limit int) (messages []domain.Message, err error) {
+	ctx, span := r.span.start(ctx, "MessageRepository", "GetGroupMessages")
+	defer End(span, &err)
+	return r.repo.GetGroupMessages(ctx, roomID, limit)
+}
+
+func (r *messageRepository) GetContacts(ctx context.Context, username string) (contacts []string, err error) {
+	ctx, span := r.span.start(ctx, "MessageRepository", "GetContacts")
+	defer End(span, &err)
+	return r.repo.GetContacts(ctx, username)
+}
+
+type roomRepository struct {
+	repo domain.RoomRepository
+	span spanner
+}
+
+func (r *roomRepository) SaveRoom(ctx context.Context, room *domain.Room) (err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "SaveRoom")
+	defer End(span, &err)
+	return r.repo.SaveRoom(ctx, room)
+}
+
+func (r *roomRepository) FindRoomByID(ctx context.Context, id int) (room *domain.Room, err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "FindRoomByID")
+	defer End(span, &err)
+	return r.repo.FindRoomByID(ctx, id)
+}
+
+func (r *roomRepository) AddMember(ctx context.Context, member *domain.RoomMember) (err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "AddMember")
+	defer End(span, &err)
+	return r.repo.AddMember(ctx, member)
+}
+
+func (r *roomRepository) RemoveMember(ctx context.Context, roomID int, username string) (err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "RemoveMember")
+	defer End(span, &err)
+	return r.repo.RemoveMember(ctx, roomID, username)
+}
+
+func (r *roomRepository) SetTopic(ctx context.Context, roomID int, topic string) (err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "SetTopic")
+	defer End(span, &err)
+	return r.repo.SetTopic(ctx, roomID, topic)
+}
+
+func (r *roomRepository) GetAllRooms(ctx context.Context) (rooms []*domain.Room, err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "GetAllRooms")
+	defer End(span, &err)
+	return r.repo.GetAllRooms(ctx)
+}
+
+func (r *roomRepository) GetRoomMembers(ctx context.Context, roomID int) (members []domain.RoomMember, err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "GetRoomMembers")
+	defer End(span, &err)
+	return r.repo.GetRoomMembers(ctx, roomID)
+}
+
+func (r *roomRepository) GetUserRooms(ctx context.Context, username string) (rooms []domain.Room, err error) {
+	ctx, span := r.span.start(ctx, "RoomRepository", "GetUserRooms")
+	defer End(span, &err)
+	return r.repo.GetUserRooms(ctx, username)
+}
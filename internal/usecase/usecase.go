@@ -1,31 +1,93 @@
 package usecase
 
 import (
+	"context"
+	"errors"
 	"time"
 	"websocket_try3/internal/domain"
+	"websocket_try3/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("websocket_try3/internal/usecase")
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrRoomNotFound = errors.New("room not found")
 )
 
 type WebSocketUsecase struct {
 	userRepo    domain.UserRepository
 	messageRepo domain.MessageRepository
 	roomRepo    domain.RoomRepository
+	config      Config
+}
+
+type Config struct {
+	// Timeout bounds every operation, including all the repository calls it
+	// makes, on top of any deadline the caller's context already has
+	Timeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout: 5 * time.Second,
+	}
 }
 
 func NewWebSocketUsecase(
 	userRepo domain.UserRepository,
 	messageRepo domain.MessageRepository,
 	roomRepo domain.RoomRepository,
+	config Config,
 ) *WebSocketUsecase {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig().Timeout
+	}
+
 	return &WebSocketUsecase{
 		userRepo:    userRepo,
 		messageRepo: messageRepo,
 		roomRepo:    roomRepo,
+		config:      config,
+	}
+}
+
+func (u *WebSocketUsecase) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, u.config.Timeout)
+}
+
+func (u *WebSocketUsecase) requireUser(ctx context.Context, username string) error {
+	user, err := u.userRepo.FindByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (u *WebSocketUsecase) requireRoom(ctx context.Context, roomID int) (*domain.Room, error) {
+	room, err := u.roomRepo.FindRoomByID(ctx, roomID)
+	if err != nil {
+		return nil, err
 	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	return room, nil
 }
 
 // User registration and management
-func (u *WebSocketUsecase) RegisterUser(username string) error {
-	existingUser, err := u.userRepo.FindByUsername(username)
+func (u *WebSocketUsecase) RegisterUser(ctx context.Context, username string) (err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.RegisterUser")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	existingUser, err := u.userRepo.FindByUsername(ctx, username)
 	if err != nil {
 		return err
 	}
@@ -42,25 +104,36 @@ func (u *WebSocketUsecase) RegisterUser(username string) error {
 		user.CreatedAt = existingUser.CreatedAt
 	}
 
-	return u.userRepo.Save(user)
+	return u.userRepo.Save(ctx, user)
 }
 
-func (u *WebSocketUsecase) GetUser(username string) (*domain.User, error) {
-	return u.userRepo.FindByUsername(username)
+func (u *WebSocketUsecase) GetUser(ctx context.Context, username string) (*domain.User, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.userRepo.FindByUsername(ctx, username)
 }
 
-func (u *WebSocketUsecase) ListOnlineUsers() ([]domain.User, error) {
-	return u.userRepo.FindAll()
+func (u *WebSocketUsecase) ListOnlineUsers(ctx context.Context) ([]domain.User, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.userRepo.FindAll(ctx)
 }
 
 // Message handling
-func (u *WebSocketUsecase) SendPrivateMessage(sender, recipient, content string) error {
+func (u *WebSocketUsecase) SendPrivateMessage(ctx context.Context, sender, recipient, content string) (err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.SendPrivateMessage")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
 	// Validasi pengirim dan penerima
-	if _, err := u.userRepo.FindByUsername(sender); err != nil {
+	if err := u.requireUser(ctx, sender); err != nil {
 		return err
 	}
 
-	if _, err := u.userRepo.FindByUsername(recipient); err != nil {
+	if err := u.requireUser(ctx, recipient); err != nil {
 		return err
 	}
 
@@ -72,16 +145,21 @@ func (u *WebSocketUsecase) SendPrivateMessage(sender, recipient, content string)
 		CreatedAt: time.Now(),
 	}
 
-	return u.messageRepo.SavePrivateMessage(message)
+	return u.messageRepo.SavePrivateMessage(ctx, message)
 }
 
-func (u *WebSocketUsecase) SendGroupMessage(sender string, roomID int, content string) error {
+func (u *WebSocketUsecase) SendGroupMessage(ctx context.Context, sender string, roomID int, content string) (err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.SendGroupMessage")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
 	// Validasi pengirim dan room
-	if _, err := u.userRepo.FindByUsername(sender); err != nil {
+	if err := u.requireUser(ctx, sender); err != nil {
 		return err
 	}
 
-	if _, err := u.roomRepo.FindRoomByID(roomID); err != nil {
+	if _, err := u.requireRoom(ctx, roomID); err != nil {
 		return err
 	}
 
@@ -93,21 +171,52 @@ func (u *WebSocketUsecase) SendGroupMessage(sender string, roomID int, content s
 		CreatedAt: time.Now(),
 	}
 
-	return u.messageRepo.SaveGroupMessage(message)
+	return u.messageRepo.SaveGroupMessage(ctx, message)
+}
+
+// StoreMessages saves messages whose users and rooms the caller has already
+// checked, with a single repository call. The hub uses it for the messages
+// it routed, which only ever come from registered users.
+func (u *WebSocketUsecase) StoreMessages(ctx context.Context, msgs []*domain.Message) (err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.StoreMessages")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.messageRepo.SaveMessages(ctx, msgs)
+}
+
+func (u *WebSocketUsecase) GetPrivateMessageHistory(ctx context.Context, user1, user2 string, limit int) ([]domain.Message, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.messageRepo.GetPrivateMessages(ctx, user1, user2, limit)
 }
 
-func (u *WebSocketUsecase) GetPrivateMessageHistory(user1, user2 string, limit int) ([]domain.Message, error) {
-	return u.messageRepo.GetPrivateMessages(user1, user2, limit)
+func (u *WebSocketUsecase) GetGroupMessageHistory(ctx context.Context, roomID int, limit int) ([]domain.Message, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.messageRepo.GetGroupMessages(ctx, roomID, limit)
 }
 
-func (u *WebSocketUsecase) GetGroupMessageHistory(roomID int, limit int) ([]domain.Message, error) {
-	return u.messageRepo.GetGroupMessages(roomID, limit)
+// ListContacts returns every user the given user has exchanged private messages with
+func (u *WebSocketUsecase) ListContacts(ctx context.Context, username string) ([]string, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.messageRepo.GetContacts(ctx, username)
 }
 
 // Room management
-func (u *WebSocketUsecase) CreateRoom(roomName, creator string) (*domain.Room, error) {
+func (u *WebSocketUsecase) CreateRoom(ctx context.Context, roomName, creator string) (_ *domain.Room, err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.CreateRoom")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
 	// Validasi creator
-	if _, err := u.userRepo.FindByUsername(creator); err != nil {
+	if err := u.requireUser(ctx, creator); err != nil {
 		return nil, err
 	}
 
@@ -117,25 +226,30 @@ func (u *WebSocketUsecase) CreateRoom(roomName, creator string) (*domain.Room, e
 		CreatedAt: time.Now(),
 	}
 
-	if err := u.roomRepo.SaveRoom(room); err != nil {
+	if err := u.roomRepo.SaveRoom(ctx, room); err != nil {
 		return nil, err
 	}
 
 	// Otomatis tambahkan creator sebagai member
-	if err := u.AddRoomMember(room.ID, creator); err != nil {
+	if err := u.AddRoomMember(ctx, room.ID, creator); err != nil {
 		return nil, err
 	}
 
 	return room, nil
 }
 
-func (u *WebSocketUsecase) AddRoomMember(roomID int, username string) error {
+func (u *WebSocketUsecase) AddRoomMember(ctx context.Context, roomID int, username string) (err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.AddRoomMember")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
 	// Validasi user dan room
-	if _, err := u.userRepo.FindByUsername(username); err != nil {
+	if err := u.requireUser(ctx, username); err != nil {
 		return err
 	}
 
-	if _, err := u.roomRepo.FindRoomByID(roomID); err != nil {
+	if _, err := u.requireRoom(ctx, roomID); err != nil {
 		return err
 	}
 
@@ -145,20 +259,66 @@ func (u *WebSocketUsecase) AddRoomMember(roomID int, username string) error {
 		JoinedAt: time.Now(),
 	}
 
-	return u.roomRepo.AddMember(member)
+	return u.roomRepo.AddMember(ctx, member)
+}
+
+func (u *WebSocketUsecase) RemoveRoomMember(ctx context.Context, roomID int, username string) (err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.RemoveRoomMember")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := u.requireRoom(ctx, roomID); err != nil {
+		return err
+	}
+
+	return u.roomRepo.RemoveMember(ctx, roomID, username)
+}
+
+// SetRoomTopic sets the topic of roomID and returns the updated room. An
+// empty topic clears it.
+func (u *WebSocketUsecase) SetRoomTopic(ctx context.Context, roomID int, topic string) (_ *domain.Room, err error) {
+	ctx, span := tracer.Start(ctx, "WebSocketUsecase.SetRoomTopic")
+	defer tracing.End(span, &err)
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	room, err := u.requireRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.roomRepo.SetTopic(ctx, roomID, topic); err != nil {
+		return nil, err
+	}
+	room.Topic = topic
+	return room, nil
+}
+
+func (u *WebSocketUsecase) ListAllRooms(ctx context.Context) ([]*domain.Room, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.roomRepo.GetAllRooms(ctx)
 }
 
-func (u *WebSocketUsecase) ListAllRooms() ([]*domain.Room, error) {
-	return u.roomRepo.GetAllRooms()
+func (u *WebSocketUsecase) GetRoom(ctx context.Context, roomID int) (*domain.Room, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.roomRepo.FindRoomByID(ctx, roomID)
 }
 
-func (u *WebSocketUsecase) GetRoomInfo(roomID int) (*domain.Room, []domain.RoomMember, error) {
-	room, err := u.roomRepo.FindRoomByID(roomID)
+func (u *WebSocketUsecase) GetRoomInfo(ctx context.Context, roomID int) (*domain.Room, []domain.RoomMember, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	room, err := u.requireRoom(ctx, roomID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	members, err := u.roomRepo.GetRoomMembers(roomID)
+	members, err := u.roomRepo.GetRoomMembers(ctx, roomID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -166,10 +326,16 @@ func (u *WebSocketUsecase) GetRoomInfo(roomID int) (*domain.Room, []domain.RoomM
 	return room, members, nil
 }
 
-func (u *WebSocketUsecase) GetRoomMembers(roomID int) ([]domain.RoomMember, error) {
-	return u.roomRepo.GetRoomMembers(roomID)
+func (u *WebSocketUsecase) GetRoomMembers(ctx context.Context, roomID int) ([]domain.RoomMember, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.roomRepo.GetRoomMembers(ctx, roomID)
 }
 
-func (u *WebSocketUsecase) ListUserRooms(username string) ([]domain.Room, error) {
-	return u.roomRepo.GetUserRooms(username)
+func (u *WebSocketUsecase) ListUserRooms(ctx context.Context, username string) ([]domain.Room, error) {
+	ctx, cancel := u.withTimeout(ctx)
+	defer cancel()
+
+	return u.roomRepo.GetUserRooms(ctx, username)
 }
@@ -0,0 +1,276 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"websocket_try3/internal/delivery/websocket"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/tracing"
+	"websocket_try3/internal/usecase"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxUsernameLength bounds the usernames incoming webhooks post as.
+const maxUsernameLength = 64
+
+var (
+	ErrUsernameTaken = errors.New("username belongs to a user")
+	// ErrUsernameNotAllowed is returned when a post names someone other
+	// than the webhook's username
+	ErrUsernameNotAllowed = errors.New("webhook cannot post as another username")
+)
+
+// RateLimitError is returned when an incoming webhook posts faster than its
+// rate limit allows.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+type IncomingConfig struct {
+	// RateLimit is how many messages a minute a webhook may post unless it
+	// was created with a limit of its own
+	RateLimit int
+	// Burst is how many messages a webhook may post at once
+	Burst int
+	// DefaultUsername is who webhooks created without a username post as
+	DefaultUsername string
+}
+
+func DefaultIncomingConfig() IncomingConfig {
+	return IncomingConfig{
+		RateLimit:       60,
+		Burst:           10,
+		DefaultUsername: "webhook",
+	}
+}
+
+// Incoming posts the messages sent to incoming webhooks into their rooms,
+// through the same path as messages posted over the API. Anyone holding a
+// webhook's token can post, so every webhook has a rate limit of its own and
+// can be revoked.
+type Incoming struct {
+	repo domain.WebhookRepository
+	// bots is nil when there is no storage for bots
+	bots    domain.BotRepository
+	hub     *websocket.Hub
+	usecase *usecase.WebSocketUsecase
+	config  IncomingConfig
+
+	mu      sync.Mutex
+	buckets map[int]*bucket
+}
+
+// bucket is a token bucket holding up to Burst posts, refilled at the
+// webhook's rate limit.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewIncoming(repo domain.WebhookRepository, bots domain.BotRepository, hub *websocket.Hub, usecase *usecase.WebSocketUsecase, config IncomingConfig) *Incoming {
+	defaults := DefaultIncomingConfig()
+	if config.RateLimit <= 0 {
+		config.RateLimit = defaults.RateLimit
+	}
+	if config.Burst <= 0 {
+		config.Burst = defaults.Burst
+	}
+	if config.DefaultUsername == "" {
+		config.DefaultUsername = defaults.DefaultUsername
+	}
+	return &Incoming{
+		repo:    repo,
+		bots:    bots,
+		hub:     hub,
+		usecase: usecase,
+		config:  config,
+		buckets: make(map[int]*bucket),
+	}
+}
+
+// Create adds an incoming webhook to roomID that posts as username, at most
+// rateLimit messages a minute. Zero values use the configured defaults. The
+// returned token is what the webhook's URL carries; only its hash is kept.
+func (in *Incoming) Create(ctx context.Context, roomID int, username string, rateLimit int, createdBy string) (*domain.IncomingWebhook, string, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		username = in.config.DefaultUsername
+	}
+	if err := validUsername(username); err != nil {
+		return nil, "", err
+	}
+	if rateLimit < 0 {
+		return nil, "", fmt.Errorf("%w: rate_limit must not be negative", ErrInvalidWebhook)
+	}
+	if rateLimit == 0 {
+		rateLimit = in.config.RateLimit
+	}
+	if err := in.claim(ctx, username, createdBy); err != nil {
+		return nil, "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	webhook := &domain.IncomingWebhook{
+		RoomID:    roomID,
+		Username:  username,
+		TokenHash: hashToken(token),
+		RateLimit: rateLimit,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if err := in.repo.SaveIncomingWebhook(ctx, webhook); err != nil {
+		return nil, "", err
+	}
+	return webhook, token, nil
+}
+
+func (in *Incoming) List(ctx context.Context, roomID int) ([]domain.IncomingWebhook, error) {
+	return in.repo.GetRoomIncomingWebhooks(ctx, roomID)
+}
+
+// Revoke stops incoming webhook id of roomID from posting. Revoked webhooks
+// are kept, so they still show who created them.
+func (in *Incoming) Revoke(ctx context.Context, roomID, id int) error {
+	webhook, err := in.repo.FindIncomingWebhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if webhook == nil || webhook.RoomID != roomID || webhook.RevokedAt != nil {
+		return ErrWebhookNotFound
+	}
+	if err := in.repo.RevokeIncomingWebhook(ctx, id, time.Now()); err != nil {
+		return err
+	}
+
+	in.mu.Lock()
+	delete(in.buckets, id)
+	in.mu.Unlock()
+	return nil
+}
+
+// Post posts text into the room of the webhook token belongs to, as the
+// webhook's username. A post may name that username, as payloads written for
+// other chat services do, but no other: the token is the only credential, so
+// it must not let anyone post as, or register, other users. Unknown and
+// revoked tokens are both ErrWebhookNotFound.
+func (in *Incoming) Post(ctx context.Context, token, username, text string) (_ websocket.Message, err error) {
+	ctx, span := tracer.Start(ctx, "webhook.incoming")
+	defer tracing.End(span, &err)
+
+	webhook, err := in.repo.FindIncomingWebhookByToken(ctx, hashToken(token))
+	if err != nil {
+		return websocket.Message{}, err
+	}
+	if webhook == nil || webhook.RevokedAt != nil {
+		return websocket.Message{}, ErrWebhookNotFound
+	}
+	span.SetAttributes(
+		attribute.Int("webhook.id", webhook.ID),
+		attribute.Int("chat.room.id", webhook.RoomID),
+	)
+
+	if username = strings.TrimSpace(username); username != "" && username != webhook.Username {
+		return websocket.Message{}, fmt.Errorf("%w: %s", ErrUsernameNotAllowed, username)
+	}
+	if wait, ok := in.allow(webhook, time.Now()); !ok {
+		return websocket.Message{}, &RateLimitError{RetryAfter: wait}
+	}
+
+	return in.hub.PostGroupMessage(ctx, webhook.Username, webhook.RoomID, text)
+}
+
+// claim makes username the identity a new webhook of createdBy posts as.
+// Names of existing users are refused, so no person is impersonated. A new
+// name is saved as a bot owned by createdBy, so connecting with just the name
+// is refused too, see bot.Service.Authenticate. Such a bot, one without API
+// keys, can be shared by the webhooks of its owner; the default username is
+// shared by every webhook. Without bot storage the name is saved as a user.
+func (in *Incoming) claim(ctx context.Context, username, createdBy string) error {
+	if in.bots == nil {
+		user, err := in.usecase.GetUser(ctx, username)
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			return fmt.Errorf("%w: %s", ErrUsernameTaken, username)
+		}
+		return in.usecase.RegisterUser(ctx, username)
+	}
+
+	bot, err := in.bots.FindBot(ctx, username)
+	if err != nil {
+		return err
+	}
+	if bot != nil {
+		if bot.Owner != createdBy && username != in.config.DefaultUsername {
+			return fmt.Errorf("%w: %s", ErrUsernameTaken, username)
+		}
+		keys, err := in.bots.GetAPIKeys(ctx, username)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			return fmt.Errorf("%w: %s", ErrUsernameTaken, username)
+		}
+		return nil
+	}
+
+	err = in.bots.SaveBot(ctx, &domain.Bot{Username: username, Owner: createdBy, CreatedAt: time.Now()})
+	if errors.Is(err, domain.ErrConstraint) {
+		return fmt.Errorf("%w: %s", ErrUsernameTaken, username)
+	}
+	return err
+}
+
+// allow takes a post from webhook's bucket. If the bucket is empty it
+// returns how long until it holds one again.
+func (in *Incoming) allow(webhook *domain.IncomingWebhook, now time.Time) (time.Duration, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	burst := float64(in.config.Burst)
+	perSecond := float64(webhook.RateLimit) / 60
+
+	b, ok := in.buckets[webhook.ID]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		in.buckets[webhook.ID] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+func validUsername(username string) error {
+	if len(username) > maxUsernameLength {
+		return fmt.Errorf("%w: username exceeds %d bytes", ErrInvalidWebhook, maxUsernameLength)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, RFC 6598. Like the
+// private ranges it is only reachable from inside the network.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// internalAddr reports whether ip is an address webhooks must not target:
+// loopback, private, link-local (which includes cloud metadata endpoints
+// such as 169.254.169.254), unspecified or multicast.
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// checkHost returns an error if host, a name or an IP address, is or
+// resolves to an internal address. The check at registration only gives
+// early feedback: a name can resolve differently later, so the dialer checks
+// again, see guardedClient.
+func checkHost(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if internalAddr(ip) {
+			return fmt.Errorf("%s is an internal address", host)
+		}
+		return nil
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if internalAddr(ip) {
+			return fmt.Errorf("%s resolves to internal address %s", host, ip)
+		}
+	}
+	return nil
+}
+
+// guardedClient returns a client that refuses to connect to internal
+// addresses. The check runs on the address actually dialed, so it also
+// covers redirects and names that resolve differently than at registration.
+// Proxies from the environment are ignored, since the client would only see
+// the proxy's address.
+func guardedClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if internalAddr(addrPort.Addr()) {
+				return fmt.Errorf("refusing to connect to internal address %s", addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,542 @@
+// Package webhook delivers room events to the URLs rooms register.
+//
+// Every event a room's webhooks subscribe to is stored as a pending delivery
+// per webhook and POSTed by a pool of workers. A delivery that fails is
+// retried with exponential backoff until it succeeds or runs out of attempts,
+// when it is moved to the dead letters. Pending deliveries survive a restart:
+// Start picks them up again. Deliveries are at least once, so receivers should
+// use the X-Webhook-Delivery header to discard duplicates.
+//
+// Incoming webhooks work the other way round: they post the messages sent to
+// their URL into a room.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("websocket_try3/internal/webhook")
+
+var (
+	ErrInvalidWebhook   = errors.New("invalid webhook")
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+type Config struct {
+	// Workers is the number of deliveries attempted concurrently
+	Workers int
+	// QueueSize bounds the events waiting to be turned into deliveries;
+	// events beyond it are dropped
+	QueueSize int
+	// MaxAttempts is how often a delivery is attempted before it is dead
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled on every
+	// attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds every attempt, and every storage call made for one
+	Timeout time.Duration
+	// AllowPrivateTargets lets webhooks target loopback, private and
+	// link-local addresses. Only meant for development and tests: rooms
+	// register webhooks, and anybody can join a room.
+	AllowPrivateTargets bool
+	// Client defaults to a client with Timeout that, unless
+	// AllowPrivateTargets is set, refuses to connect to those addresses
+	Client *http.Client
+	// Logger defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Workers:        4,
+		QueueSize:      1024,
+		MaxAttempts:    8,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Minute,
+		Timeout:        10 * time.Second,
+	}
+}
+
+// Envelope is the body of every delivery.
+type Envelope struct {
+	Event      string    `json:"event"`
+	RoomID     int       `json:"room_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// Dispatcher implements websocket.Events by delivering room events to the
+// room's webhooks.
+type Dispatcher struct {
+	repo   domain.WebhookRepository
+	config Config
+	log    *slog.Logger
+
+	events chan queuedEvent
+	jobs   chan int
+
+	// mu guards closed and timers, so that nothing is queued or scheduled
+	// after Close starts draining
+	mu     sync.Mutex
+	closed bool
+	timers map[int]*time.Timer
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type queuedEvent struct {
+	envelope Envelope
+	span     trace.SpanContext
+}
+
+func New(repo domain.WebhookRepository, config Config) *Dispatcher {
+	defaults := DefaultConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaults.InitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaults.MaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.Client == nil {
+		if config.AllowPrivateTargets {
+			config.Client = &http.Client{Timeout: config.Timeout}
+		} else {
+			config.Client = guardedClient(config.Timeout)
+		}
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	return &Dispatcher{
+		repo:   repo,
+		config: config,
+		log:    config.Logger,
+		events: make(chan queuedEvent, config.QueueSize),
+		jobs:   make(chan int, config.QueueSize),
+		timers: make(map[int]*time.Timer),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Start starts the workers and schedules the deliveries left pending by a
+// previous run.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	pending, err := d.repo.GetDeliveries(ctx, domain.DeliveryFilter{Status: domain.DeliveryPending})
+	if err != nil {
+		return fmt.Errorf("load pending webhook deliveries: %w", err)
+	}
+
+	for range d.config.Workers {
+		d.wg.Add(1)
+		go d.work()
+	}
+	for _, delivery := range pending {
+		d.schedule(delivery.ID, delivery.NextAttemptAt)
+	}
+	if len(pending) > 0 {
+		d.log.InfoContext(ctx, "resuming webhook deliveries", "deliveries", len(pending))
+	}
+	return nil
+}
+
+// Close stops the workers and waits for the attempts in progress, or until
+// ctx expires. Events still queued are stored as pending deliveries for the
+// next Start.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.quit)
+		for id, timer := range d.timers {
+			timer.Stop()
+			delete(d.timers, id)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		for {
+			select {
+			case event := <-d.events:
+				d.store(event)
+			default:
+				close(done)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RoomEvent queues an event for the webhooks of roomID. It never blocks: when
+// the queue is full the event is dropped.
+func (d *Dispatcher) RoomEvent(ctx context.Context, roomID int, event string, data any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	queued := queuedEvent{
+		envelope: Envelope{Event: event, RoomID: roomID, OccurredAt: time.Now().UTC(), Data: data},
+		span:     trace.SpanContextFromContext(ctx),
+	}
+	select {
+	case d.events <- queued:
+	default:
+		d.log.WarnContext(ctx, "webhook queue is full, dropping event", "event", event, "room_id", roomID)
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case event := <-d.events:
+			for _, id := range d.store(event) {
+				d.attempt(id)
+			}
+		case id := <-d.jobs:
+			d.attempt(id)
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// store saves a pending delivery of event for every webhook subscribed to it
+// and returns their IDs.
+func (d *Dispatcher) store(event queuedEvent) []int {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+	if event.span.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, event.span)
+	}
+	log := d.log.With("event", event.envelope.Event, "room_id", event.envelope.RoomID)
+
+	webhooks, err := d.repo.GetRoomWebhooks(ctx, event.envelope.RoomID)
+	if err != nil {
+		log.ErrorContext(ctx, "load webhooks", "err", err)
+		return nil
+	}
+
+	var payload []byte
+	var ids []int
+	for _, webhook := range webhooks {
+		if !subscribed(webhook, event.envelope.Event) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event.envelope); err != nil {
+				log.ErrorContext(ctx, "marshal webhook payload", "err", err)
+				return nil
+			}
+		}
+
+		now := time.Now()
+		delivery := &domain.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         event.envelope.Event,
+			Payload:       payload,
+			Status:        domain.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := d.repo.SaveDelivery(ctx, delivery); err != nil {
+			log.ErrorContext(ctx, "save webhook delivery", "webhook_id", webhook.ID, "err", err)
+			continue
+		}
+		ids = append(ids, delivery.ID)
+	}
+	return ids
+}
+
+func subscribed(webhook domain.Webhook, event string) bool {
+	return len(webhook.Events) == 0 || slices.Contains(webhook.Events, event)
+}
+
+// schedule queues delivery id for an attempt at the given time.
+func (d *Dispatcher) schedule(id int, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+	if timer, ok := d.timers[id]; ok {
+		timer.Stop()
+	}
+	d.timers[id] = time.AfterFunc(time.Until(at), func() {
+		d.mu.Lock()
+		delete(d.timers, id)
+		d.mu.Unlock()
+
+		select {
+		case d.jobs <- id:
+		case <-d.quit:
+		}
+	})
+}
+
+// attempt makes the next attempt at delivery id. The storage calls before
+// and after the request each get Timeout, like the request itself.
+func (d *Dispatcher) attempt(id int) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+	log := d.log.With("delivery_id", id)
+
+	delivery, err := d.repo.FindDelivery(ctx, id)
+	if err != nil {
+		log.ErrorContext(ctx, "load webhook delivery", "err", err)
+		return
+	}
+	if delivery == nil || delivery.Status != domain.DeliveryPending {
+		return
+	}
+	log = log.With("webhook_id", delivery.WebhookID, "event", delivery.Event)
+
+	webhook, err := d.repo.FindWebhook(ctx, delivery.WebhookID)
+	if err != nil {
+		log.ErrorContext(ctx, "load webhook", "err", err)
+		d.schedule(id, time.Now().Add(d.config.InitialBackoff))
+		return
+	}
+	if webhook == nil {
+		delivery.LastError = "webhook was deleted"
+		if err := d.repo.DeadLetter(ctx, delivery, time.Now()); err != nil {
+			log.ErrorContext(ctx, "dead letter webhook delivery", "err", err)
+		}
+		return
+	}
+
+	status, err := d.post(context.Background(), webhook, delivery)
+	ctx, cancel = context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.UpdatedAt = now
+	if err == nil {
+		delivery.Status = domain.DeliveryDelivered
+		delivery.LastError = ""
+		if err := d.repo.UpdateDelivery(ctx, delivery); err != nil {
+			log.ErrorContext(ctx, "update webhook delivery", "err", err)
+		}
+		return
+	}
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= d.config.MaxAttempts {
+		log.WarnContext(ctx, "webhook delivery failed for good", "attempts", delivery.Attempts, "err", err)
+		if err := d.repo.DeadLetter(ctx, delivery, now); err != nil {
+			log.ErrorContext(ctx, "dead letter webhook delivery", "err", err)
+		}
+		return
+	}
+
+	delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	log.DebugContext(ctx, "webhook delivery failed, retrying", "attempts", delivery.Attempts,
+		"next_attempt_at", delivery.NextAttemptAt, "err", err)
+	if err := d.repo.UpdateDelivery(ctx, delivery); err != nil {
+		log.ErrorContext(ctx, "update webhook delivery", "err", err)
+	}
+	d.schedule(id, delivery.NextAttemptAt)
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.config.InitialBackoff
+	for i := 1; i < attempts && backoff < d.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, d.config.MaxBackoff)
+}
+
+// post makes one attempt at delivery and returns the response status, if
+// there was a response.
+func (d *Dispatcher) post(ctx context.Context, webhook *domain.Webhook, delivery *domain.WebhookDelivery) (status int, err error) {
+	ctx, span := tracer.Start(ctx, "webhook.deliver", trace.WithAttributes(
+		attribute.Int("webhook.id", webhook.ID),
+		attribute.Int("webhook.delivery.id", delivery.ID),
+		attribute.String("webhook.event", delivery.Event),
+		attribute.Int("webhook.attempt", delivery.Attempts+1),
+	))
+	defer tracing.End(span, &err)
+
+	ctx, cancel := context.WithTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, delivery.Payload))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := d.config.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Register adds a webhook for roomID. Without events it receives every room
+// event. The returned webhook carries the generated secret. URLs on internal
+// addresses are rejected unless Config.AllowPrivateTargets is set.
+func (d *Dispatcher) Register(ctx context.Context, roomID int, rawURL string, events []string, createdBy string) (*domain.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	for _, event := range events {
+		if !slices.Contains(domain.RoomEvents, event) {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+	}
+	if len(events) == 0 {
+		events = domain.RoomEvents
+	}
+	if !d.config.AllowPrivateTargets {
+		if err := checkHost(ctx, u.Hostname()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	webhook := &domain.Webhook{
+		RoomID:    roomID,
+		URL:       u.String(),
+		Secret:    hex.EncodeToString(secret),
+		Events:    slices.Clone(events),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if err := d.repo.SaveWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (d *Dispatcher) List(ctx context.Context, roomID int) ([]domain.Webhook, error) {
+	return d.repo.GetRoomWebhooks(ctx, roomID)
+}
+
+// Delete removes webhook id of roomID. Its pending deliveries end up in the
+// dead letters.
+func (d *Dispatcher) Delete(ctx context.Context, roomID, id int) error {
+	webhook, err := d.repo.FindWebhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if webhook == nil || webhook.RoomID != roomID {
+		return ErrWebhookNotFound
+	}
+	return d.repo.DeleteWebhook(ctx, id)
+}
+
+func (d *Dispatcher) Deliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	return d.repo.GetDeliveries(ctx, filter)
+}
+
+func (d *Dispatcher) DeadLetters(ctx context.Context, limit int) ([]domain.WebhookDeadLetter, error) {
+	return d.repo.GetDeadLetters(ctx, limit)
+}
+
+// Replay delivers the payload of delivery id again, as a new delivery with
+// attempts of its own.
+func (d *Dispatcher) Replay(ctx context.Context, id int) (*domain.WebhookDelivery, error) {
+	original, err := d.repo.FindDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, ErrDeliveryNotFound
+	}
+
+	now := time.Now()
+	delivery := &domain.WebhookDelivery{
+		WebhookID:     original.WebhookID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        domain.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := d.repo.SaveDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	d.schedule(delivery.ID, now)
+	return delivery, nil
+}
@@ -0,0 +1,482 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"websocket_try3/internal/domain"
+	"websocket_try3/internal/repository/memory"
+	"websocket_try3/internal/usecase"
+)
+
+// receiver records the deliveries it gets and answers with status, which
+// tests may change on the fly.
+type receiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	status     int
+	deliveries []receivedDelivery
+}
+
+type receivedDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.deliveries = append(r.deliveries, receivedDelivery{header: req.Header.Clone(), body: body})
+		status := r.status
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() []receivedDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedDelivery(nil), r.deliveries...)
+}
+
+// newTestDispatcher starts a dispatcher that may deliver to the receivers,
+// which run on localhost.
+func newTestDispatcher(t *testing.T, repo domain.WebhookRepository, config Config) *Dispatcher {
+	config.AllowPrivateTargets = true
+	d := New(repo, config)
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		d.Close(ctx)
+	})
+	return d
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func deliveryStatus(t *testing.T, repo domain.WebhookRepository, id int) *domain.WebhookDelivery {
+	t.Helper()
+	delivery, err := repo.FindDelivery(context.Background(), id)
+	if err != nil || delivery == nil {
+		t.Fatalf("FindDelivery(%d) = %v, %v", id, delivery, err)
+	}
+	return delivery
+}
+
+func TestDispatcherDeliversSignedEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewWebhookRepository()
+	d := newTestDispatcher(t, repo, Config{})
+
+	all := newReceiver(t, http.StatusNoContent)
+	messages := newReceiver(t, http.StatusNoContent)
+	webhook, err := d.Register(ctx, 1, all.URL, nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Register(ctx, 1, messages.URL, []string{domain.EventMessagePosted}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	other := newReceiver(t, http.StatusNoContent)
+	if _, err := d.Register(ctx, 2, other.URL, nil, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	d.RoomEvent(ctx, 1, domain.EventMemberJoined, map[string]string{"username": "bob"})
+	d.RoomEvent(ctx, 1, domain.EventMessagePosted, map[string]string{"content": "hi"})
+
+	waitFor(t, "deliveries", func() bool {
+		return len(all.received()) == 2 && len(messages.received()) == 1
+	})
+	if n := len(other.received()); n != 0 {
+		t.Errorf("another room's webhook got %d deliveries", n)
+	}
+
+	for _, got := range all.received() {
+		h := got.header
+		err := Verify(webhook.Secret, h.Get(HeaderTimestamp), h.Get(HeaderSignature), got.body, time.Minute)
+		if err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+		if h.Get(HeaderDelivery) == "" || h.Get("Content-Type") != "application/json" {
+			t.Errorf("missing headers: %v", h)
+		}
+
+		var envelope struct {
+			Event  string          `json:"event"`
+			RoomID int             `json:"room_id"`
+			Data   json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(got.body, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Event != h.Get(HeaderEvent) || envelope.RoomID != 1 {
+			t.Errorf("envelope %s for %s event", got.body, h.Get(HeaderEvent))
+		}
+	}
+
+	got := messages.received()[0]
+	if got.header.Get(HeaderEvent) != domain.EventMessagePosted {
+		t.Errorf("message webhook got %s, want only %s", got.header.Get(HeaderEvent), domain.EventMessagePosted)
+	}
+	if Verify(webhook.Secret, got.header.Get(HeaderTimestamp), got.header.Get(HeaderSignature), got.body, 0) == nil {
+		t.Error("another webhook's delivery verified with the first webhook's secret")
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewWebhookRepository()
+	d := newTestDispatcher(t, repo, Config{InitialBackoff: 20 * time.Millisecond, MaxAttempts: 5})
+
+	recv := newReceiver(t, http.StatusServiceUnavailable)
+	if _, err := d.Register(ctx, 1, recv.URL, nil, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	d.RoomEvent(ctx, 1, domain.EventMessagePosted, "hi")
+
+	waitFor(t, "two failed attempts", func() bool { return len(recv.received()) >= 2 })
+	recv.setStatus(http.StatusOK)
+	waitFor(t, "the delivery", func() bool {
+		deliveries, _ := repo.GetDeliveries(ctx, domain.DeliveryFilter{Status: domain.DeliveryDelivered})
+		return len(deliveries) == 1
+	})
+
+	received := recv.received()
+	if received[0].header.Get(HeaderDelivery) != received[len(received)-1].header.Get(HeaderDelivery) {
+		t.Error("retries were sent as a different delivery")
+	}
+	deliveries, _ := repo.GetDeliveries(ctx, domain.DeliveryFilter{})
+	if len(deliveries) != 1 || deliveries[0].Attempts != len(received) || deliveries[0].ResponseStatus != http.StatusOK {
+		t.Fatalf("deliveries = %+v, want one delivery after %d attempts", deliveries, len(received))
+	}
+}
+
+func TestDispatcherDeadLettersAndReplays(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewWebhookRepository()
+	d := newTestDispatcher(t, repo, Config{InitialBackoff: time.Millisecond, MaxAttempts: 3})
+
+	recv := newReceiver(t, http.StatusInternalServerError)
+	if _, err := d.Register(ctx, 1, recv.URL, nil, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	d.RoomEvent(ctx, 1, domain.EventMemberLeft, "bob")
+
+	var letters []domain.WebhookDeadLetter
+	waitFor(t, "the dead letter", func() bool {
+		letters, _ = d.DeadLetters(ctx, 0)
+		return len(letters) == 1
+	})
+	if len(recv.received()) != 3 {
+		t.Errorf("receiver got %d attempts, want 3", len(recv.received()))
+	}
+	letter := letters[0]
+	if letter.Attempts != 3 || letter.LastError != "status 500" || letter.Event != domain.EventMemberLeft {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+	if delivery := deliveryStatus(t, repo, letter.DeliveryID); delivery.Status != domain.DeliveryDead {
+		t.Errorf("delivery status = %s, want dead", delivery.Status)
+	}
+
+	recv.setStatus(http.StatusAccepted)
+	replay, err := d.Replay(ctx, letter.DeliveryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ID == letter.DeliveryID {
+		t.Fatal("Replay reused the dead delivery")
+	}
+	waitFor(t, "the replay", func() bool {
+		return deliveryStatus(t, repo, replay.ID).Status == domain.DeliveryDelivered
+	})
+	received := recv.received()
+	if string(received[len(received)-1].body) != string(received[0].body) {
+		t.Error("replay sent a different payload")
+	}
+
+	if _, err := d.Replay(ctx, 1000); !errors.Is(err, ErrDeliveryNotFound) {
+		t.Errorf("Replay of unknown delivery = %v, want ErrDeliveryNotFound", err)
+	}
+}
+
+func TestDispatcherDeadLettersDeletedWebhooks(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewWebhookRepository()
+
+	// Not started, so Close stores the queued event as a pending delivery
+	first := New(repo, Config{AllowPrivateTargets: true})
+	webhook, err := first.Register(ctx, 1, "http://127.0.0.1:1/", nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.RoomEvent(ctx, 1, domain.EventRoomUpdated, "topic")
+	if err := first.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Delete(ctx, 2, webhook.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Fatalf("Delete from another room = %v, want ErrWebhookNotFound", err)
+	}
+	if err := first.Delete(ctx, 1, webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDispatcher(t, repo, Config{})
+	var letters []domain.WebhookDeadLetter
+	waitFor(t, "the dead letter", func() bool {
+		letters, _ = d.DeadLetters(ctx, 0)
+		return len(letters) == 1
+	})
+	if letters[0].LastError != "webhook was deleted" || letters[0].Attempts != 0 {
+		t.Errorf("unexpected dead letter %+v", letters[0])
+	}
+}
+
+func TestDispatcherResumesPendingDeliveries(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewWebhookRepository()
+	recv := newReceiver(t, http.StatusNoContent)
+
+	// Events queued when the previous run stopped are stored for the next one
+	first := New(repo, Config{AllowPrivateTargets: true})
+	if _, err := first.Register(ctx, 1, recv.URL, nil, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	first.RoomEvent(ctx, 1, domain.EventMessagePosted, "hi")
+	if err := first.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := repo.GetDeliveries(ctx, domain.DeliveryFilter{Status: domain.DeliveryPending})
+	if len(pending) != 1 {
+		t.Fatalf("pending deliveries = %+v, want the queued event", pending)
+	}
+
+	newTestDispatcher(t, repo, Config{})
+	waitFor(t, "the resumed delivery", func() bool {
+		return deliveryStatus(t, repo, pending[0].ID).Status == domain.DeliveryDelivered
+	})
+	if n := len(recv.received()); n != 1 {
+		t.Errorf("receiver got %d deliveries, want 1", n)
+	}
+}
+
+func TestRegisterValidates(t *testing.T) {
+	d := New(memory.NewWebhookRepository(), Config{})
+	tests := []struct {
+		url    string
+		events []string
+	}{
+		{"ftp://example.com/hook", nil},
+		{"/hook", nil},
+		{"http://", nil},
+		{"https://203.0.113.10/hook", []string{"message.deleted"}},
+	}
+	for _, tt := range tests {
+		_, err := d.Register(context.Background(), 1, tt.url, tt.events, "alice")
+		if !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("Register(%q, %v) = %v, want ErrInvalidWebhook", tt.url, tt.events, err)
+		}
+	}
+
+	webhook, err := d.Register(context.Background(), 1, "https://203.0.113.10/hook", nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.Secret) != 64 || len(webhook.Events) != len(domain.RoomEvents) {
+		t.Errorf("Register = %+v, want a 32 byte secret and every event", webhook)
+	}
+}
+
+func TestRegisterRejectsInternalTargets(t *testing.T) {
+	d := New(memory.NewWebhookRepository(), Config{})
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+		"http://100.64.0.1/hook",
+	} {
+		if _, err := d.Register(context.Background(), 1, url, nil, "alice"); !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("Register(%q) = %v, want ErrInvalidWebhook", url, err)
+		}
+	}
+
+	allowed := New(memory.NewWebhookRepository(), Config{AllowPrivateTargets: true})
+	if _, err := allowed.Register(context.Background(), 1, "http://127.0.0.1:8080/hook", nil, "alice"); err != nil {
+		t.Errorf("Register with AllowPrivateTargets = %v", err)
+	}
+}
+
+func TestDispatcherRefusesToDialInternalTargets(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewWebhookRepository()
+	recv := newReceiver(t, http.StatusNoContent)
+
+	// Registered while allowed, as if the name resolved elsewhere back then
+	webhook, err := New(repo, Config{AllowPrivateTargets: true}).Register(ctx, 1, recv.URL, nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(repo, Config{MaxAttempts: 1})
+	if err := d.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close(ctx) })
+	d.RoomEvent(ctx, 1, domain.EventMessagePosted, "hi")
+
+	var letters []domain.WebhookDeadLetter
+	waitFor(t, "the dead letter", func() bool {
+		letters, _ = d.DeadLetters(ctx, 0)
+		return len(letters) == 1
+	})
+	if letters[0].WebhookID != webhook.ID || !strings.Contains(letters[0].LastError, "internal address") {
+		t.Errorf("dead letter = %+v, want a refused connection", letters[0])
+	}
+	if n := len(recv.received()); n != 0 {
+		t.Errorf("receiver got %d deliveries, want none", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := New(memory.NewWebhookRepository(), Config{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range want {
+		if got := d.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestIncomingClaimsUsernames(t *testing.T) {
+	ctx := context.Background()
+	users := memory.NewUserRepository()
+	bots := memory.NewBotRepository(users)
+	wsUsecase := usecase.NewWebSocketUsecase(users, memory.NewMessageRepository(), memory.NewRoomRepository(), usecase.Config{})
+	in := NewIncoming(memory.NewWebhookRepository(), bots, nil, wsUsecase, IncomingConfig{})
+	for _, username := range []string{"alice", "carol"} {
+		if err := users.Save(ctx, &domain.User{Username: username}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// carol is in no room, but she is still a person
+	if _, _, err := in.Create(ctx, 1, "carol", 0, "alice"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Create as an existing user = %v, want ErrUsernameTaken", err)
+	}
+
+	if _, _, err := in.Create(ctx, 1, "deploys", 0, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if bot, _ := bots.FindBot(ctx, "deploys"); bot == nil || bot.Owner != "alice" {
+		t.Errorf("deploys = %+v, want a bot owned by alice", bot)
+	}
+	if _, _, err := in.Create(ctx, 2, "deploys", 0, "alice"); err != nil {
+		t.Errorf("Create as the creator's webhook bot = %v", err)
+	}
+	if _, _, err := in.Create(ctx, 2, "deploys", 0, "carol"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Create as another creator's webhook bot = %v, want ErrUsernameTaken", err)
+	}
+
+	// The default username is shared
+	if _, _, err := in.Create(ctx, 1, "", 0, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := in.Create(ctx, 2, "", 0, "carol"); err != nil {
+		t.Errorf("Create with the default username by another creator = %v", err)
+	}
+}
+
+func TestIncomingRateLimit(t *testing.T) {
+	in := NewIncoming(memory.NewWebhookRepository(), nil, nil, nil, IncomingConfig{Burst: 2})
+	slow := &domain.IncomingWebhook{ID: 1, RateLimit: 30}
+	fast := &domain.IncomingWebhook{ID: 2, RateLimit: 600}
+	now := time.Now()
+
+	for i := range 2 {
+		if _, ok := in.allow(slow, now); !ok {
+			t.Fatalf("post %d within the burst was limited", i+1)
+		}
+	}
+	wait, ok := in.allow(slow, now)
+	if ok || wait != 2*time.Second {
+		t.Fatalf("allow after the burst = %v, %v; want a 2s wait", wait, ok)
+	}
+	if _, ok := in.allow(fast, now); !ok {
+		t.Error("another webhook shares the exhausted bucket")
+	}
+
+	if _, ok := in.allow(slow, now.Add(time.Second)); ok {
+		t.Error("allowed before the bucket refilled")
+	}
+	if _, ok := in.allow(slow, now.Add(2*time.Second)); !ok {
+		t.Error("still limited after the bucket refilled")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"message.posted"}`)
+	now := time.Now().Unix()
+	timestamp := func(offset int64) string { return strconv.FormatInt(now+offset, 10) }
+
+	signature := Sign("secret", timestamp(0), body)
+	if err := Verify("secret", timestamp(0), signature, body, time.Minute); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+	}{
+		{"wrong secret", "other", timestamp(0), signature, body},
+		{"tampered body", "secret", timestamp(0), signature, []byte(`{}`)},
+		{"tampered timestamp", "secret", timestamp(1), signature, body},
+		{"missing prefix", "secret", timestamp(0), signature[len("sha256="):], body},
+		{"stale", "secret", timestamp(-120), Sign("secret", timestamp(-120), body), body},
+	}
+	for _, tt := range tests {
+		if err := Verify(tt.secret, tt.timestamp, tt.signature, tt.body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: Verify = %v, want ErrInvalidSignature", tt.name, err)
+		}
+	}
+}
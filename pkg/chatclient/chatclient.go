@@ -0,0 +1,453 @@
+// Package chatclient is a client for the chat server's WebSocket protocol,
+// for bots and other programs taking part in the chat.
+//
+//	c := chatclient.New(chatclient.Options{
+//		URL:    "ws://localhost:8080/ws",
+//		APIKey: os.Getenv("BOT_API_KEY"),
+//	})
+//	c.OnGroupMessage(func(m chatclient.Message) {
+//		c.SendGroup(m.GroupID, "you said: "+m.Content)
+//	})
+//	err := c.Run(ctx)
+//
+// Run keeps the client connected until its context is done: when the
+// connection drops it reconnects with backoff and resumes the session, so
+// frames sent in between are replayed rather than lost. Callbacks run one at
+// a time on Run's goroutine and must not block for long; the Send methods
+// may be called from any goroutine.
+package chatclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrNotConnected = errors.New("chatclient: not connected")
+	// ErrReplaced is returned by Run when another connection resumed the
+	// client's session, e.g. a second instance of the same bot
+	ErrReplaced = errors.New("chatclient: session resumed by another connection")
+)
+
+// RejectedError is returned by Run when the server refuses the connection
+// for a reason reconnecting won't fix, such as an invalid API key.
+type RejectedError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("chatclient: connection rejected with %d: %s", e.StatusCode, e.Message)
+}
+
+type Options struct {
+	// URL is the server's WebSocket endpoint, e.g. ws://localhost:8080/ws
+	URL string
+	// APIKey authenticates a bot. Without it the client connects as
+	// Username, which only works for users that aren't bots.
+	APIKey   string
+	Username string
+	// Header is sent with every connection attempt
+	Header http.Header
+	// Dialer defaults to websocket.DefaultDialer
+	Dialer *websocket.Dialer
+	// MinBackoff is the delay before the first reconnection attempt,
+	// doubled on every failed attempt up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Logger defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// Message is a chat message the client received.
+type Message struct {
+	// Type is "group_chat" or "private_chat"
+	Type    string
+	From    string
+	To      string
+	Content string
+	// GroupID is the room of a group message
+	GroupID int
+	// Meta is added by the server's interceptors, if any
+	Meta map[string]string
+}
+
+// Session describes a connection the client established.
+type Session struct {
+	ID string
+	// Resumed is set if the connection resumed the previous session, in which
+	// case frames missed while disconnected are replayed
+	Resumed bool
+	// Resync is set if the previous session could not be resumed and frames
+	// may have been missed
+	Resync bool
+}
+
+// frame is any frame the server sends.
+type frame struct {
+	Type             string            `json:"type"`
+	Seq              uint64            `json:"seq"`
+	From             string            `json:"from"`
+	To               string            `json:"to"`
+	Content          string            `json:"content"`
+	GroupID          int               `json:"group_id"`
+	SessionID        string            `json:"session_id"`
+	Resumed          bool              `json:"resumed"`
+	Resync           bool              `json:"resync"`
+	Username         string            `json:"username"`
+	OnlineUsers      []string          `json:"online_users"`
+	Dropped          int               `json:"dropped"`
+	ReconnectAfterMs int64             `json:"reconnect_after_ms"`
+	Code             string            `json:"code"`
+	Meta             map[string]string `json:"meta"`
+}
+
+// outgoing is any frame the client sends.
+type outgoing struct {
+	Type    string `json:"type"`
+	To      string `json:"to,omitempty"`
+	Content string `json:"content"`
+	GroupID int    `json:"group_id,omitempty"`
+}
+
+type Client struct {
+	options Options
+	log     *slog.Logger
+
+	onConnect        func(Session)
+	onGroupMessage   func(Message)
+	onPrivateMessage func(Message)
+	onStatus         func(string)
+	onRejected       func(code, text string)
+	onPresence       func(username string, online bool)
+	onGap            func(dropped int)
+
+	// writeMu serializes writes to conn, which mu guards
+	writeMu   sync.Mutex
+	mu        sync.Mutex
+	conn      *websocket.Conn
+	sessionID string
+	lastSeq   uint64
+	online    map[string]bool
+}
+
+func New(options Options) *Client {
+	defaults := DefaultOptions()
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = defaults.MinBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaults.MaxBackoff
+	}
+	if options.Dialer == nil {
+		options.Dialer = websocket.DefaultDialer
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+	return &Client{
+		options: options,
+		log:     options.Logger.With("component", "chatclient"),
+		online:  make(map[string]bool),
+	}
+}
+
+// The On methods set the callbacks for the frames the client receives. They
+// must be called before Run.
+
+// OnConnect is called whenever a connection is established.
+func (c *Client) OnConnect(fn func(Session)) { c.onConnect = fn }
+
+func (c *Client) OnGroupMessage(fn func(Message)) { c.onGroupMessage = fn }
+
+func (c *Client) OnPrivateMessage(fn func(Message)) { c.onPrivateMessage = fn }
+
+// OnStatus is called with the content of status frames, which the server
+// sends e.g. in reply to CreateRoom and JoinRoom.
+func (c *Client) OnStatus(fn func(text string)) { c.onStatus = fn }
+
+// OnRejected is called instead of OnStatus for status frames telling the
+// client that the server rejected one of its messages, with the reason's
+// code, e.g. "muted".
+func (c *Client) OnRejected(fn func(code, text string)) { c.onRejected = fn }
+
+// OnPresence is called when a user the client shares a room or private
+// messages with comes online or goes offline.
+func (c *Client) OnPresence(fn func(username string, online bool)) { c.onPresence = fn }
+
+// OnGap is called when the server dropped frames because the client read
+// them too slowly.
+func (c *Client) OnGap(fn func(dropped int)) { c.onGap = fn }
+
+// Online returns the users the client knows to be online, sorted.
+func (c *Client) Online() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	users := make([]string, 0, len(c.online))
+	for username := range c.online {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Run connects and handles frames until ctx is done, reconnecting whenever
+// the connection drops. It returns ctx.Err(), ErrReplaced or a
+// *RejectedError.
+func (c *Client) Run(ctx context.Context) error {
+	backoff := c.options.MinBackoff
+	for {
+		connected, wait, err := c.runOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var rejected *RejectedError
+		if errors.As(err, &rejected) || errors.Is(err, ErrReplaced) {
+			return err
+		}
+
+		if connected {
+			backoff = c.options.MinBackoff
+		}
+		if wait == 0 {
+			// Jitter keeps bots from reconnecting in lockstep
+			wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+			backoff = min(backoff*2, c.options.MaxBackoff)
+		}
+		c.log.Info("disconnected, reconnecting", "err", err, "after", wait)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runOnce connects and reads frames until the connection fails. If the
+// server asked for a delay before reconnecting it returns that, too.
+func (c *Client) runOnce(ctx context.Context) (connected bool, wait time.Duration, err error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer conn.Close()
+
+	// Unblock the read below when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Text == "session_resumed" {
+				return true, 0, ErrReplaced
+			}
+			return true, wait, err
+		}
+
+		// The server may coalesce several frames into one message, one per
+		// line
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var f frame
+			if err := json.Unmarshal(line, &f); err != nil {
+				c.log.Warn("invalid frame", "frame", string(line), "err", err)
+				continue
+			}
+			if f.Type == "server_going_away" {
+				wait = time.Duration(f.ReconnectAfterMs) * time.Millisecond
+			}
+			c.handle(f)
+		}
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u, err := url.Parse(c.options.URL)
+	if err != nil {
+		return nil, &RejectedError{Message: err.Error()}
+	}
+	query := u.Query()
+	if c.options.Username != "" {
+		query.Set("username", c.options.Username)
+	}
+	c.mu.Lock()
+	if c.sessionID != "" {
+		query.Set("session_id", c.sessionID)
+		query.Set("last_seq", strconv.FormatUint(c.lastSeq, 10))
+	}
+	c.mu.Unlock()
+	u.RawQuery = query.Encode()
+
+	header := c.options.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if c.options.APIKey != "" {
+		header.Set("Authorization", "Bearer "+c.options.APIKey)
+	}
+
+	conn, resp, err := c.options.Dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return nil, &RejectedError{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(body))}
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *Client) handle(f frame) {
+	if f.Seq > 0 {
+		c.mu.Lock()
+		c.lastSeq = f.Seq
+		c.mu.Unlock()
+	}
+
+	switch f.Type {
+	case "session":
+		c.mu.Lock()
+		c.sessionID = f.SessionID
+		c.lastSeq = f.Seq
+		c.mu.Unlock()
+		if c.onConnect != nil {
+			c.onConnect(Session{ID: f.SessionID, Resumed: f.Resumed, Resync: f.Resync})
+		}
+	case "group_chat", "private_chat":
+		m := Message{Type: f.Type, From: f.From, To: f.To, Content: f.Content, GroupID: f.GroupID, Meta: f.Meta}
+		if f.Type == "group_chat" && c.onGroupMessage != nil {
+			c.onGroupMessage(m)
+		}
+		if f.Type == "private_chat" && c.onPrivateMessage != nil {
+			c.onPrivateMessage(m)
+		}
+	case "status":
+		if f.Code != "" && c.onRejected != nil {
+			c.onRejected(f.Code, f.Content)
+		} else if c.onStatus != nil {
+			c.onStatus(f.Content)
+		}
+	case "presence_snapshot":
+		online := make(map[string]bool, len(f.OnlineUsers))
+		for _, username := range f.OnlineUsers {
+			online[username] = true
+		}
+		c.mu.Lock()
+		previous := c.online
+		c.online = online
+		c.mu.Unlock()
+		for username := range previous {
+			if !online[username] {
+				c.presence(username, false)
+			}
+		}
+		for username := range online {
+			if !previous[username] {
+				c.presence(username, true)
+			}
+		}
+	case "presence_joined", "presence_left":
+		joined := f.Type == "presence_joined"
+		c.mu.Lock()
+		changed := c.online[f.Username] != joined
+		if joined {
+			c.online[f.Username] = true
+		} else {
+			delete(c.online, f.Username)
+		}
+		c.mu.Unlock()
+		// presence_joined may be repeated
+		if changed {
+			c.presence(f.Username, joined)
+		}
+	case "gap":
+		if c.onGap != nil {
+			c.onGap(f.Dropped)
+		}
+	}
+}
+
+func (c *Client) presence(username string, online bool) {
+	if c.onPresence != nil {
+		c.onPresence(username, online)
+	}
+}
+
+// SendGroup posts content to room roomID, which the client must be a member
+// of.
+func (c *Client) SendGroup(roomID int, content string) error {
+	return c.send(outgoing{Type: "group_chat", GroupID: roomID, Content: content})
+}
+
+func (c *Client) SendPrivate(to, content string) error {
+	return c.send(outgoing{Type: "private_chat", To: to, Content: content})
+}
+
+// CreateRoom creates a room called name; the server replies with a status
+// frame.
+func (c *Client) CreateRoom(name string) error {
+	return c.send(outgoing{Type: "create_room", Content: name})
+}
+
+// JoinRoom joins room roomID, so the client receives its messages from then
+// on, across reconnections.
+func (c *Client) JoinRoom(roomID int) error {
+	// The server insists on content even for joins
+	return c.send(outgoing{Type: "join_room", GroupID: roomID, Content: "join"})
+}
+
+// send writes f to the current connection. Frames are not queued while the
+// client is disconnected.
+func (c *Client) send(f outgoing) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, data)
+}